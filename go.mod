@@ -6,6 +6,7 @@ require (
 	github.com/charmbracelet/huh v0.8.0
 	github.com/charmbracelet/lipgloss v1.1.0
 	github.com/nwaples/rardecode v1.1.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,10 +1,22 @@
 package archiver
 
 import (
+	"fmt"
+	"io"
+
 	"zipprine/internal/models"
 )
 
 func Compress(config *models.CompressConfig) error {
+	if config.Password != "" && config.ArchiveType != models.ZIP {
+		return fmt.Errorf("encryption is only supported for ZIP archives")
+	}
+	if config.SplitSize > 0 {
+		if err := checkSplit(config); err != nil {
+			return err
+		}
+	}
+
 	switch config.ArchiveType {
 	case models.ZIP:
 		return createZip(config)
@@ -22,6 +34,18 @@ func Compress(config *models.CompressConfig) error {
 }
 
 func Extract(config *models.ExtractConfig) error {
+	if config.Recursive {
+		return extractRecursive(config)
+	}
+	return extractArchive(config)
+}
+
+// extractArchive extracts a single archive, leaving nested archives as files
+func extractArchive(config *models.ExtractConfig) error {
+	if config.ArchivePath == StdioPath {
+		return extractStdin(config)
+	}
+
 	switch config.ArchiveType {
 	case models.ZIP:
 		return extractZip(config)
@@ -36,4 +60,35 @@ func Extract(config *models.ExtractConfig) error {
 	default:
 		return nil
 	}
-}
\ No newline at end of file
+}
+
+// VerifyArchive reads every file in an archive to the end, so truncated data
+// and checksum mismatches surface, and returns how many files it checked
+func VerifyArchive(path string, archiveType models.ArchiveType) (int, error) {
+	if archiveType == "" || archiveType == models.AUTO {
+		detected, err := DetectArchiveType(path)
+		if err != nil {
+			return 0, err
+		}
+		archiveType = detected
+	}
+
+	files := 0
+	err := walkArchive(path, archiveType, func(entry *archiveEntry) error {
+		if !entry.IsRegular() {
+			return nil
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		files++
+		return nil
+	})
+	return files, err
+}
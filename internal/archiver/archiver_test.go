@@ -1,9 +1,14 @@
 package archiver
 
 import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"zipprine/internal/models"
 )
@@ -249,3 +254,81 @@ func BenchmarkCompressZip(b *testing.B) {
 		os.Remove(outputPath)
 	}
 }
+
+func TestVerifyArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	createTestFiles(t, sourceDir)
+
+	zipPath := filepath.Join(tmpDir, "test.zip")
+	if err := Compress(&models.CompressConfig{SourcePath: sourceDir, OutputPath: zipPath, ArchiveType: models.ZIP, Threads: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := VerifyArchive(zipPath, models.AUTO)
+	if err != nil || files != 3 {
+		t.Fatalf("VerifyArchive() = %d, %v; want 3 files", files, err)
+	}
+
+	// Damage an entry's data so its checksum no longer matches
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, _ := r.File[0].DataOffset()
+	r.Close()
+	data, _ := os.ReadFile(zipPath)
+	data[offset+2] ^= 0xff
+	os.WriteFile(zipPath, data, 0644)
+	if _, err := VerifyArchive(zipPath, models.ZIP); err == nil {
+		t.Error("VerifyArchive() should fail on damaged data")
+	}
+}
+
+func TestCompressReproducible(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	for _, archiveType := range []models.ArchiveType{models.ZIP, models.TARGZ, models.TAR} {
+		t.Run(string(archiveType), func(t *testing.T) {
+			var outputs [2][]byte
+			for i := range outputs {
+				// A fresh copy of the tree gets new timestamps each time
+				sourceDir := filepath.Join(tmpDir, fmt.Sprintf("%s-source%d", archiveType, i))
+				createTestFiles(t, sourceDir)
+				old := time.Now().Add(-time.Duration(i+1) * time.Hour)
+				os.Chtimes(filepath.Join(sourceDir, "test1.txt"), old, old)
+
+				outputPath := filepath.Join(tmpDir, fmt.Sprintf("%s-%d.out", archiveType, i))
+				err := Compress(&models.CompressConfig{
+					SourcePath:       sourceDir,
+					OutputPath:       outputPath,
+					ArchiveType:      archiveType,
+					CompressionLevel: 6,
+					Threads:          i + 1,
+					Reproducible:     true,
+					Progress:         io.Discard,
+				})
+				if err != nil {
+					t.Fatalf("Compress() failed: %v", err)
+				}
+				if outputs[i], err = os.ReadFile(outputPath); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(outputs[0], outputs[1]) {
+				t.Error("reproducible archives of the same files differ")
+			}
+		})
+	}
+
+	zipPath := filepath.Join(tmpDir, "ZIP-0.out")
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := r.File[0].Modified.Unix(); got != 1700000000 {
+		t.Errorf("entry modified at %d, want $SOURCE_DATE_EPOCH", got)
+	}
+}
@@ -1,191 +1,416 @@
 package archiver
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"sync"
+	"time"
 
 	"zipprine/internal/models"
 )
 
-// BatchCompressConfig holds configuration for batch compression operations
-type BatchCompressConfig struct {
-	Configs     []*models.CompressConfig
-	Parallel    bool
-	MaxWorkers  int
-	OnProgress  func(index int, total int, filename string)
-	OnError     func(index int, filename string, err error)
-	OnComplete  func(index int, filename string)
+// ErrorPolicy decides what a batch does after a job fails
+type ErrorPolicy int
+
+const (
+	// ContinueOnError runs every job whose dependencies succeeded
+	ContinueOnError ErrorPolicy = iota
+	// FailFast starts no new jobs after the first failure; jobs already
+	// running are allowed to finish
+	FailFast
+)
+
+// Batch job outcomes
+const (
+	BatchSucceeded = "succeeded"
+	BatchFailed    = "failed"
+	BatchSkipped   = "skipped"
+)
+
+var (
+	// ErrDependencyFailed marks a job skipped because a job it depends on did not succeed
+	ErrDependencyFailed = errors.New("dependency failed")
+	// ErrBatchStopped marks a job skipped because an earlier job failed under FailFast
+	ErrBatchStopped = errors.New("batch stopped after a failure")
+)
+
+// BatchJob is one unit of work for RunBatch
+type BatchJob struct {
+	Name      string // shown to callbacks and kept in the result
+	DependsOn []int  // indexes of jobs that must succeed before this one starts
+	Cost      int64  // bytes the job works through, counted against BatchOptions.Budget
+	// Run is called once per attempt and writes its log to out, which is
+	// safe for concurrent use
+	Run func(out io.Writer) error
 }
 
-// BatchCompress compresses multiple sources in batch
-func BatchCompress(batchConfig *BatchCompressConfig) []error {
-	if batchConfig.MaxWorkers <= 0 {
-		batchConfig.MaxWorkers = 4
-	}
-
-	errors := make([]error, len(batchConfig.Configs))
-	
-	if !batchConfig.Parallel {
-		// Sequential processing
-		for i, config := range batchConfig.Configs {
-			if batchConfig.OnProgress != nil {
-				batchConfig.OnProgress(i+1, len(batchConfig.Configs), config.OutputPath)
-			}
-			
-			err := Compress(config)
-			errors[i] = err
-			
-			if err != nil && batchConfig.OnError != nil {
-				batchConfig.OnError(i, config.OutputPath, err)
-			} else if batchConfig.OnComplete != nil {
-				batchConfig.OnComplete(i, config.OutputPath)
-			}
+// BatchOptions controls how RunBatch schedules its jobs
+type BatchOptions struct {
+	Parallel   bool
+	MaxWorkers int // concurrent jobs when Parallel is set; defaults to 4
+	Retries    int // extra attempts for a failing job
+	Policy     ErrorPolicy
+	// Budget caps the summed Cost of the jobs running at once; 0 is
+	// unlimited. A job costing more than the budget runs on its own.
+	Budget int64
+	// Output receives the job logs; nil is stdout. In Ordered mode each
+	// job's log is buffered and written in job order, together with its
+	// OnError or OnComplete, once every earlier job has been reported.
+	Output  io.Writer
+	Ordered bool
+	// Callbacks are called one at a time from the goroutine running
+	// RunBatch. OnStart and OnProgress fire as a job starts; a job ends with
+	// exactly one of OnError (failed or skipped) and OnComplete.
+	OnStart    func(index int, name string)
+	OnProgress func(index int, total int, name string)
+	OnError    func(index int, name string, err error)
+	OnComplete func(index int, name string)
+}
+
+// syncWriter serializes writes from concurrent jobs
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// batchReporter delivers job logs and outcome callbacks, holding them back
+// in Ordered mode until every earlier job has been reported
+type batchReporter struct {
+	opts    *BatchOptions
+	results []*BatchResult
+	out     *syncWriter
+	logs    []*bytes.Buffer // per-job logs in Ordered mode
+	settled []bool
+	next    int // first job not yet reported in Ordered mode
+}
+
+// jobOutput returns the writer job i logs to
+func (r *batchReporter) jobOutput(i int) io.Writer {
+	if r.logs != nil {
+		return &syncWriter{w: r.logs[i]}
+	}
+	return r.out
+}
+
+// settle records that job i has its final status and reports whatever is
+// now due
+func (r *batchReporter) settle(i int) {
+	if !r.opts.Ordered {
+		r.report(i)
+		return
+	}
+	r.settled[i] = true
+	for r.next < len(r.settled) && r.settled[r.next] {
+		r.out.Write(r.logs[r.next].Bytes())
+		r.logs[r.next] = nil
+		r.report(r.next)
+		r.next++
+	}
+}
+
+func (r *batchReporter) report(i int) {
+	result := r.results[i]
+	if result.Err != nil {
+		if r.opts.OnError != nil {
+			r.opts.OnError(i, result.Name, result.Err)
 		}
-		return errors
-	}
-
-	// Parallel processing with worker pool
-	var wg sync.WaitGroup
-	jobs := make(chan int, len(batchConfig.Configs))
-	
-	// Start workers
-	for w := 0; w < batchConfig.MaxWorkers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for i := range jobs {
-				config := batchConfig.Configs[i]
-				
-				if batchConfig.OnProgress != nil {
-					batchConfig.OnProgress(i+1, len(batchConfig.Configs), config.OutputPath)
+	} else if r.opts.OnComplete != nil {
+		r.opts.OnComplete(i, result.Name)
+	}
+}
+
+// BatchResult is the outcome of one job
+type BatchResult struct {
+	Index    int
+	Name     string
+	Status   string
+	Err      error
+	Attempts int
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Failed reports whether the job ran and failed, or was skipped
+func (r *BatchResult) Failed() bool {
+	return r.Status != BatchSucceeded
+}
+
+// RunBatch runs jobs in index order as far as their dependencies and the
+// worker and byte budgets allow, and returns one result per job. The error is
+// only set when the dependencies are invalid, in which case nothing runs.
+func RunBatch(jobs []*BatchJob, opts *BatchOptions) ([]*BatchResult, error) {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	if err := checkDependencies(jobs); err != nil {
+		return nil, err
+	}
+
+	workers := 1
+	if opts.Parallel {
+		workers = opts.MaxWorkers
+		if workers <= 0 {
+			workers = 4
+		}
+	}
+
+	results := make([]*BatchResult, len(jobs))
+	for i, job := range jobs {
+		results[i] = &BatchResult{Index: i, Name: job.Name}
+	}
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	reporter := &batchReporter{opts: opts, results: results, out: &syncWriter{w: output}}
+	if opts.Ordered {
+		reporter.settled = make([]bool, len(jobs))
+		reporter.logs = make([]*bytes.Buffer, len(jobs))
+		for i := range reporter.logs {
+			reporter.logs[i] = &bytes.Buffer{}
+		}
+	}
+
+	done := make(chan int)
+	running := 0
+	var inFlight int64
+	stopped := false
+	remaining := len(jobs)
+
+	for remaining > 0 {
+		// Settle jobs that can never run, then start what fits
+		for i, job := range jobs {
+			result := results[i]
+			if result.Status != "" || !result.Start.IsZero() {
+				continue
+			}
+			ready := true
+			for _, dep := range job.DependsOn {
+				switch results[dep].Status {
+				case BatchSucceeded:
+				case "":
+					ready = false
+				default:
+					result.Status = BatchSkipped
+					result.Err = fmt.Errorf("%w: %s", ErrDependencyFailed, jobs[dep].Name)
 				}
-				
-				err := Compress(config)
-				errors[i] = err
-				
-				if err != nil && batchConfig.OnError != nil {
-					batchConfig.OnError(i, config.OutputPath, err)
-				} else if batchConfig.OnComplete != nil {
-					batchConfig.OnComplete(i, config.OutputPath)
+			}
+			if stopped && result.Status == "" {
+				result.Status, result.Err = BatchSkipped, ErrBatchStopped
+			}
+			if result.Status != "" {
+				remaining--
+				reporter.settle(i)
+				continue
+			}
+			if !ready || running >= workers {
+				continue
+			}
+			if opts.Budget > 0 && running > 0 && inFlight+job.Cost > opts.Budget {
+				continue
+			}
+
+			running++
+			inFlight += job.Cost
+			result.Start = time.Now()
+			if opts.OnStart != nil {
+				opts.OnStart(i, job.Name)
+			}
+			if opts.OnProgress != nil {
+				opts.OnProgress(i+1, len(jobs), job.Name)
+			}
+			go func(i int, job *BatchJob, result *BatchResult, out io.Writer) {
+				for result.Attempts <= opts.Retries {
+					result.Attempts++
+					if result.Err = job.Run(out); result.Err == nil {
+						break
+					}
 				}
+				result.Duration = time.Since(result.Start)
+				done <- i
+			}(i, job, result, reporter.jobOutput(i))
+		}
+		if running == 0 {
+			// Skipping a job can settle one listed earlier; go round again
+			continue
+		}
+
+		i := <-done
+		running--
+		inFlight -= jobs[i].Cost
+		remaining--
+		result := results[i]
+		if result.Err != nil {
+			result.Status = BatchFailed
+			if opts.Policy == FailFast {
+				stopped = true
 			}
-		}()
+		} else {
+			result.Status = BatchSucceeded
+		}
+		reporter.settle(i)
+	}
+	return results, nil
+}
+
+// checkDependencies rejects unknown indexes and cycles
+func checkDependencies(jobs []*BatchJob) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(jobs))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("batch job %q depends on itself through a cycle", jobs[i].Name)
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, dep := range jobs[i].DependsOn {
+			if dep < 0 || dep >= len(jobs) {
+				return fmt.Errorf("batch job %q depends on unknown job %d", jobs[i].Name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range jobs {
+		if err := visit(i); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// BatchCompressConfig holds configuration for batch compression operations
+type BatchCompressConfig struct {
+	Configs    []*models.CompressConfig
+	Parallel   bool
+	MaxWorkers int
+	DependsOn  map[int][]int // config index → indexes that must be compressed first
+	Retries    int
+	Policy     ErrorPolicy
+	Budget     int64     // bytes of source data compressed at once; 0 is unlimited
+	Output     io.Writer // receives the per-file progress of every job; nil is stdout
+	Ordered    bool      // print each archive's file list in one piece, in config order
+	OnStart    func(index int, filename string)
+	OnProgress func(index int, total int, filename string)
+	OnError    func(index int, filename string, err error)
+	OnComplete func(index int, filename string)
+}
+
+// BatchCompress compresses multiple sources in batch
+func BatchCompress(batchConfig *BatchCompressConfig) ([]*BatchResult, error) {
+	jobs := make([]*BatchJob, len(batchConfig.Configs))
+	for i, config := range batchConfig.Configs {
+		jobs[i] = &BatchJob{
+			Name:      config.OutputPath,
+			DependsOn: batchConfig.DependsOn[i],
+			Run: func(out io.Writer) error {
+				return Compress(withCompressProgress(config, out))
+			},
+		}
+		if batchConfig.Budget > 0 {
+			jobs[i].Cost = sourceSize(config)
+		}
+	}
+	return RunBatch(jobs, &BatchOptions{
+		Parallel:   batchConfig.Parallel,
+		MaxWorkers: batchConfig.MaxWorkers,
+		Retries:    batchConfig.Retries,
+		Policy:     batchConfig.Policy,
+		Budget:     batchConfig.Budget,
+		Output:     batchConfig.Output,
+		Ordered:    batchConfig.Ordered,
+		OnStart:    batchConfig.OnStart,
+		OnProgress: batchConfig.OnProgress,
+		OnError:    batchConfig.OnError,
+		OnComplete: batchConfig.OnComplete,
+	})
+}
 
-	// Send jobs
-	for i := range batchConfig.Configs {
-		jobs <- i
+// withCompressProgress returns a copy of config that logs to out, unless it
+// already has somewhere to log or streams the archive to stdout
+func withCompressProgress(config *models.CompressConfig, out io.Writer) *models.CompressConfig {
+	if config.Progress != nil || config.OutputPath == StdioPath {
+		return config
 	}
-	close(jobs)
-	
-	wg.Wait()
-	return errors
+	c := *config
+	c.Progress = out
+	return &c
 }
 
 // BatchExtractConfig holds configuration for batch extraction operations
 type BatchExtractConfig struct {
-	Configs     []*models.ExtractConfig
-	Parallel    bool
-	MaxWorkers  int
-	OnProgress  func(index int, total int, filename string)
-	OnError     func(index int, filename string, err error)
-	OnComplete  func(index int, filename string)
+	Configs    []*models.ExtractConfig
+	Parallel   bool
+	MaxWorkers int
+	DependsOn  map[int][]int // config index → indexes that must be extracted first
+	Retries    int
+	Policy     ErrorPolicy
+	Budget     int64     // bytes of archive data extracted at once; 0 is unlimited
+	Output     io.Writer // receives the per-file progress of every job; nil is stdout
+	Ordered    bool      // print each archive's file list in one piece, in config order
+	OnStart    func(index int, filename string)
+	OnProgress func(index int, total int, filename string)
+	OnError    func(index int, filename string, err error)
+	OnComplete func(index int, filename string)
+}
+
+// withExtractProgress returns a copy of config that logs to out, unless it
+// already has somewhere to log
+func withExtractProgress(config *models.ExtractConfig, out io.Writer) *models.ExtractConfig {
+	if config.Progress != nil {
+		return config
+	}
+	c := *config
+	c.Progress = out
+	return &c
 }
 
 // BatchExtract extracts multiple archives in batch
-func BatchExtract(batchConfig *BatchExtractConfig) []error {
-	if batchConfig.MaxWorkers <= 0 {
-		batchConfig.MaxWorkers = 4
-	}
-
-	errors := make([]error, len(batchConfig.Configs))
-	
-	if !batchConfig.Parallel {
-		// Sequential processing
-		for i, config := range batchConfig.Configs {
-			if batchConfig.OnProgress != nil {
-				batchConfig.OnProgress(i+1, len(batchConfig.Configs), config.ArchivePath)
-			}
-			
-			err := Extract(config)
-			errors[i] = err
-			
-			if err != nil && batchConfig.OnError != nil {
-				batchConfig.OnError(i, config.ArchivePath, err)
-			} else if batchConfig.OnComplete != nil {
-				batchConfig.OnComplete(i, config.ArchivePath)
-			}
+func BatchExtract(batchConfig *BatchExtractConfig) ([]*BatchResult, error) {
+	jobs := make([]*BatchJob, len(batchConfig.Configs))
+	for i, config := range batchConfig.Configs {
+		jobs[i] = &BatchJob{
+			Name:      config.ArchivePath,
+			DependsOn: batchConfig.DependsOn[i],
+			Run: func(out io.Writer) error {
+				return Extract(withExtractProgress(config, out))
+			},
 		}
-		return errors
-	}
-
-	// Parallel processing with worker pool
-	var wg sync.WaitGroup
-	jobs := make(chan int, len(batchConfig.Configs))
-	
-	// Start workers
-	for w := 0; w < batchConfig.MaxWorkers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for i := range jobs {
-				config := batchConfig.Configs[i]
-				
-				if batchConfig.OnProgress != nil {
-					batchConfig.OnProgress(i+1, len(batchConfig.Configs), config.ArchivePath)
-				}
-				
-				err := Extract(config)
-				errors[i] = err
-				
-				if err != nil && batchConfig.OnError != nil {
-					batchConfig.OnError(i, config.ArchivePath, err)
-				} else if batchConfig.OnComplete != nil {
-					batchConfig.OnComplete(i, config.ArchivePath)
-				}
-			}
-		}()
-	}
-
-	// Send jobs
-	for i := range batchConfig.Configs {
-		jobs <- i
-	}
-	close(jobs)
-	
-	wg.Wait()
-	return errors
-}
-
-// ConvertArchive converts an archive from one format to another
-func ConvertArchive(sourcePath, destPath string, sourceType, destType models.ArchiveType) error {
-	// Create temporary directory for extraction
-	tmpDir := destPath + ".tmp"
-	
-	// Extract source archive
-	extractConfig := &models.ExtractConfig{
-		ArchivePath:   sourcePath,
-		DestPath:      tmpDir,
-		ArchiveType:   sourceType,
-		OverwriteAll:  true,
-		PreservePerms: true,
-	}
-	
-	if err := Extract(extractConfig); err != nil {
-		return fmt.Errorf("failed to extract source archive: %w", err)
-	}
-	
-	// Compress to destination format
-	compressConfig := &models.CompressConfig{
-		SourcePath:       tmpDir,
-		OutputPath:       destPath,
-		ArchiveType:      destType,
-		CompressionLevel: 5,
-	}
-	
-	if err := Compress(compressConfig); err != nil {
-		return fmt.Errorf("failed to create destination archive: %w", err)
-	}
-	
-	return nil
+		if stat, err := os.Stat(config.ArchivePath); err == nil && batchConfig.Budget > 0 {
+			jobs[i].Cost = stat.Size()
+		}
+	}
+	return RunBatch(jobs, &BatchOptions{
+		Parallel:   batchConfig.Parallel,
+		MaxWorkers: batchConfig.MaxWorkers,
+		Retries:    batchConfig.Retries,
+		Policy:     batchConfig.Policy,
+		Budget:     batchConfig.Budget,
+		Output:     batchConfig.Output,
+		Ordered:    batchConfig.Ordered,
+		OnStart:    batchConfig.OnStart,
+		OnProgress: batchConfig.OnProgress,
+		OnError:    batchConfig.OnError,
+		OnComplete: batchConfig.OnComplete,
+	})
 }
@@ -1,9 +1,16 @@
 package archiver
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"zipprine/internal/models"
 )
@@ -47,12 +54,15 @@ func TestBatchCompressSequential(t *testing.T) {
 		MaxWorkers: 2,
 	}
 
-	errors := BatchCompress(batchConfig)
+	results, err := BatchCompress(batchConfig)
+	if err != nil {
+		t.Fatalf("BatchCompress() failed: %v", err)
+	}
 
 	// Check for errors
-	for i, err := range errors {
-		if err != nil {
-			t.Errorf("Batch compress failed for config %d: %v", i, err)
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("Batch compress failed for config %d: %v", i, result.Err)
 		}
 	}
 
@@ -113,12 +123,15 @@ func TestBatchCompressParallel(t *testing.T) {
 		MaxWorkers: 2,
 	}
 
-	errors := BatchCompress(batchConfig)
+	results, err := BatchCompress(batchConfig)
+	if err != nil {
+		t.Fatalf("BatchCompress() failed: %v", err)
+	}
 
 	// Check for errors
-	for i, err := range errors {
-		if err != nil {
-			t.Errorf("Batch compress failed for config %d: %v", i, err)
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("Batch compress failed for config %d: %v", i, result.Err)
 		}
 	}
 
@@ -190,12 +203,15 @@ func TestBatchExtract(t *testing.T) {
 		MaxWorkers: 2,
 	}
 
-	errors := BatchExtract(batchConfig)
+	results, err := BatchExtract(batchConfig)
+	if err != nil {
+		t.Fatalf("BatchExtract() failed: %v", err)
+	}
 
 	// Check for errors
-	for i, err := range errors {
-		if err != nil {
-			t.Errorf("Batch extract failed for config %d: %v", i, err)
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("Batch extract failed for config %d: %v", i, result.Err)
 		}
 	}
 
@@ -208,6 +224,304 @@ func TestBatchExtract(t *testing.T) {
 	}
 }
 
+func TestRunBatchDependenciesAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	attempts := 0
+	job := func(name string, run func() error) *BatchJob {
+		return &BatchJob{Name: name, Run: func(io.Writer) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return run()
+		}}
+	}
+
+	jobs := []*BatchJob{
+		job("package", func() error { return nil }),
+		job("flaky", func() error {
+			if attempts++; attempts < 3 {
+				return errors.New("temporary failure")
+			}
+			return nil
+		}),
+		job("broken", func() error { return errors.New("disk full") }),
+		job("upload", func() error { return nil }),
+		job("after-broken", func() error { return nil }),
+	}
+	// upload waits for package and flaky even though it is listed first
+	jobs[0].DependsOn = []int{3}
+	jobs[3].DependsOn = []int{1}
+	jobs[4].DependsOn = []int{2}
+
+	results, err := RunBatch(jobs, &BatchOptions{Parallel: true, MaxWorkers: 3, Retries: 2})
+	if err != nil {
+		t.Fatalf("RunBatch() failed: %v", err)
+	}
+
+	want := []string{BatchSucceeded, BatchSucceeded, BatchFailed, BatchSucceeded, BatchSkipped}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("%s: status %q, want %q (err %v)", result.Name, result.Status, want[i], result.Err)
+		}
+	}
+	if results[1].Attempts != 3 || results[2].Attempts != 3 {
+		t.Errorf("attempts = %d and %d, want 3 and 3", results[1].Attempts, results[2].Attempts)
+	}
+	if !errors.Is(results[4].Err, ErrDependencyFailed) {
+		t.Errorf("skipped job error = %v, want ErrDependencyFailed", results[4].Err)
+	}
+	if results[3].Start.Before(results[1].Start.Add(results[1].Duration)) {
+		t.Error("upload started before flaky finished")
+	}
+
+	position := map[string]int{}
+	for i, name := range order {
+		position[name] = i
+	}
+	if position["package"] < position["upload"] {
+		t.Errorf("jobs ran out of dependency order: %v", order)
+	}
+}
+
+func TestRunBatchFailFast(t *testing.T) {
+	var ran []int
+	jobs := make([]*BatchJob, 4)
+	for i := range jobs {
+		jobs[i] = &BatchJob{Name: fmt.Sprintf("job%d", i), Run: func(io.Writer) error {
+			ran = append(ran, i)
+			if i == 1 {
+				return errors.New("boom")
+			}
+			return nil
+		}}
+	}
+
+	results, err := RunBatch(jobs, &BatchOptions{Policy: FailFast})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 {
+		t.Errorf("ran jobs %v, want only 0 and 1", ran)
+	}
+	for _, result := range results[2:] {
+		if result.Status != BatchSkipped || !errors.Is(result.Err, ErrBatchStopped) {
+			t.Errorf("%s: status %q err %v, want skipped", result.Name, result.Status, result.Err)
+		}
+	}
+}
+
+func TestRunBatchBudget(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, peak int64
+	jobs := make([]*BatchJob, 6)
+	for i := range jobs {
+		cost := int64(40)
+		if i == 2 {
+			cost = 500 // more than the whole budget; runs alone
+		}
+		jobs[i] = &BatchJob{Name: fmt.Sprintf("job%d", i), Cost: cost, Run: func(io.Writer) error {
+			mu.Lock()
+			inFlight += cost
+			if cost <= 100 {
+				peak = max(peak, inFlight)
+			} else if inFlight != cost {
+				t.Errorf("an oversized job ran alongside others")
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			inFlight -= cost
+			mu.Unlock()
+			return nil
+		}}
+	}
+
+	results, err := RunBatch(jobs, &BatchOptions{Parallel: true, MaxWorkers: 6, Budget: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Failed() {
+			t.Errorf("%s failed: %v", result.Name, result.Err)
+		}
+	}
+	if peak > 100 {
+		t.Errorf("%d bytes were in flight, budget is 100", peak)
+	}
+}
+
+func TestRunBatchInvalidDependencies(t *testing.T) {
+	noop := func() error { return nil }
+	tests := []struct {
+		name string
+		deps [][]int
+		want string
+	}{
+		{"cycle", [][]int{{1}, {2}, {0}}, "cycle"},
+		{"self", [][]int{{0}}, "cycle"},
+		{"unknown", [][]int{{5}}, "unknown job 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := false
+			var jobs []*BatchJob
+			for i, deps := range tt.deps {
+				jobs = append(jobs, &BatchJob{Name: fmt.Sprint(i), DependsOn: deps, Run: func(io.Writer) error { ran = true; return noop() }})
+			}
+			_, err := RunBatch(jobs, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("RunBatch() error = %v, want one containing %q", err, tt.want)
+			}
+			if ran {
+				t.Error("no job should run when the dependencies are invalid")
+			}
+		})
+	}
+}
+
+func TestRunBatchCallbacks(t *testing.T) {
+	// The callbacks share state without locking; -race reports any overlap
+	const total = 20
+	var events []string
+	started := map[int]bool{}
+	jobs := make([]*BatchJob, total)
+	for i := range jobs {
+		jobs[i] = &BatchJob{Name: fmt.Sprintf("job%d", i), Run: func(io.Writer) error {
+			time.Sleep(time.Duration(total-i) * 100 * time.Microsecond)
+			if i%3 == 0 {
+				return errors.New("boom")
+			}
+			return nil
+		}}
+	}
+
+	results, err := RunBatch(jobs, &BatchOptions{
+		Parallel:   true,
+		MaxWorkers: 8,
+		OnStart: func(index int, name string) {
+			started[index] = true
+			events = append(events, "start "+name)
+		},
+		OnProgress: func(index, total int, name string) {
+			events = append(events, "progress "+name)
+		},
+		OnComplete: func(index int, name string) {
+			if !started[index] {
+				t.Errorf("%s completed before it started", name)
+			}
+			events = append(events, "complete "+name)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed jobs must not be reported as complete when OnError is unset
+	completed := 0
+	for _, event := range events {
+		if strings.HasPrefix(event, "complete ") {
+			completed++
+		}
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	if failed != 7 || completed != total-failed {
+		t.Errorf("%d jobs failed and %d completed, want 7 and %d", failed, completed, total-7)
+	}
+	if len(started) != total {
+		t.Errorf("OnStart fired for %d jobs, want %d", len(started), total)
+	}
+}
+
+func TestRunBatchOrdered(t *testing.T) {
+	var out bytes.Buffer
+	var reported []int
+	jobs := make([]*BatchJob, 6)
+	for i := range jobs {
+		jobs[i] = &BatchJob{Name: fmt.Sprintf("job%d", i), Run: func(w io.Writer) error {
+			// Later jobs finish first and write in several pieces
+			fmt.Fprintf(w, "job%d: first\n", i)
+			time.Sleep(time.Duration(len(jobs)-i) * time.Millisecond)
+			fmt.Fprintf(w, "job%d: second\n", i)
+			if i == 2 {
+				return errors.New("boom")
+			}
+			return nil
+		}}
+	}
+	jobs[4].DependsOn = []int{2}
+
+	record := func(index int, _ string) { reported = append(reported, index) }
+	_, err := RunBatch(jobs, &BatchOptions{
+		Parallel:   true,
+		MaxWorkers: 6,
+		Ordered:    true,
+		Output:     &out,
+		OnError:    func(index int, name string, _ error) { record(index, name) },
+		OnComplete: record,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want strings.Builder
+	for i := range jobs {
+		if i != 4 {
+			fmt.Fprintf(&want, "job%d: first\njob%d: second\n", i, i)
+		}
+	}
+	if out.String() != want.String() {
+		t.Errorf("ordered output:\n%s\nwant:\n%s", out.String(), want.String())
+	}
+	if fmt.Sprint(reported) != "[0 1 2 3 4 5]" {
+		t.Errorf("jobs reported in order %v, want job order", reported)
+	}
+}
+
+func TestBatchCompressOrderedOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	var configs []*models.CompressConfig
+	for i := range 3 {
+		dir := filepath.Join(tmpDir, fmt.Sprintf("source%d", i))
+		createTestFiles(t, dir)
+		configs = append(configs, &models.CompressConfig{
+			SourcePath:  dir,
+			OutputPath:  filepath.Join(tmpDir, fmt.Sprintf("archive%d.zip", i)),
+			ArchiveType: models.ZIP,
+		})
+	}
+
+	var out bytes.Buffer
+	lines := 0
+	results, err := BatchCompress(&BatchCompressConfig{
+		Configs:    configs,
+		Parallel:   true,
+		MaxWorkers: 3,
+		Ordered:    true,
+		Output:     &out,
+		OnComplete: func(index int, filename string) {
+			// Each archive's file list arrives just before its completion
+			if got := strings.Count(out.String(), "\n"); got != lines+3 {
+				t.Errorf("%s: %d new log lines before completion, want 3", filename, got-lines)
+			}
+			lines = strings.Count(out.String(), "\n")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Failed() {
+			t.Errorf("%s: %v", result.Name, result.Err)
+		}
+	}
+}
+
 func TestConvertArchive(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "zipprine-convert-test-*")
 	if err != nil {
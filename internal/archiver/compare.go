@@ -26,29 +26,18 @@ type DifferentFile struct {
 
 // CompareArchives compares two archives and returns differences
 func CompareArchives(path1, path2 string, type1, type2 models.ArchiveType) (*ComparisonResult, error) {
-	// Analyze both archives
-	info1, err := AnalyzeArchive(path1, type1)
+	// List every entry of both archives; the analysis only keeps the first
+	// hundred, which is not enough for large archives
+	files1, err := archiveFiles(path1, type1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze first archive: %w", err)
 	}
 
-	info2, err := AnalyzeArchive(path2, type2)
+	files2, err := archiveFiles(path2, type2)
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze second archive: %w", err)
 	}
 
-	// Create maps for quick lookup
-	files1 := make(map[string]models.FileInfo)
-	files2 := make(map[string]models.FileInfo)
-
-	for _, f := range info1.Files {
-		files1[f.Name] = f
-	}
-
-	for _, f := range info2.Files {
-		files2[f.Name] = f
-	}
-
 	result := &ComparisonResult{
 		OnlyInFirst:  []string{},
 		OnlyInSecond: []string{},
@@ -104,6 +93,30 @@ func CompareArchives(path1, path2 string, type1, type2 models.ArchiveType) (*Com
 	return result, nil
 }
 
+// archiveFiles maps the name of every entry in an archive to its details
+func archiveFiles(path string, archiveType models.ArchiveType) (map[string]models.FileInfo, error) {
+	switch archiveType {
+	case models.ZIP, models.TARGZ, models.TAR:
+	default:
+		return nil, fmt.Errorf("unsupported archive type: %s", archiveType)
+	}
+
+	files := make(map[string]models.FileInfo)
+	err := walkArchive(path, archiveType, func(entry *archiveEntry) error {
+		files[entry.Name] = models.FileInfo{
+			Name:    entry.Name,
+			Size:    entry.Size,
+			IsDir:   entry.IsDir(),
+			ModTime: entry.ModTime.Format("2006-01-02 15:04:05"),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
 // AnalyzeArchive analyzes an archive and returns information about it
 func AnalyzeArchive(path string, archiveType models.ArchiveType) (*models.ArchiveInfo, error) {
 	switch archiveType {
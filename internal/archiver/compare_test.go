@@ -1,6 +1,7 @@
 package archiver
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -215,3 +216,44 @@ func containsMiddle(s, substr string) bool {
 	}
 	return false
 }
+
+func TestCompareArchivesBeyondListing(t *testing.T) {
+	tmpDir := t.TempDir()
+	small := filepath.Join(tmpDir, "small.txt")
+	large := filepath.Join(tmpDir, "large.txt")
+	os.WriteFile(small, []byte("a"), 0644)
+	os.WriteFile(large, []byte("bbb"), 0644)
+
+	// Differences past the first hundred entries must still be found
+	build := func(name string, count int, changed int) string {
+		var sources []models.SourceMapping
+		for i := 0; i < count; i++ {
+			source := small
+			if i == changed {
+				source = large
+			}
+			sources = append(sources, models.SourceMapping{Path: source, Prefix: fmt.Sprintf("f%03d.txt", i)})
+		}
+		path := filepath.Join(tmpDir, name)
+		if err := Compress(&models.CompressConfig{Sources: sources, OutputPath: path, ArchiveType: models.ZIP}); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		return path
+	}
+	archive1 := build("first.zip", 150, -1)
+	archive2 := build("second.zip", 151, 140)
+
+	result, err := CompareArchives(archive1, archive2, models.ZIP, models.ZIP)
+	if err != nil {
+		t.Fatalf("Failed to compare archives: %v", err)
+	}
+	if len(result.InBoth) != 150 {
+		t.Errorf("Expected 150 files in both, got: %d", len(result.InBoth))
+	}
+	if len(result.OnlyInSecond) != 1 || result.OnlyInSecond[0] != "f150.txt" {
+		t.Errorf("Expected f150.txt in OnlyInSecond, got: %v", result.OnlyInSecond)
+	}
+	if len(result.Different) != 1 || result.Different[0].Name != "f140.txt" || result.Different[0].Size2 != 3 {
+		t.Errorf("Expected f140.txt to differ, got: %+v", result.Different)
+	}
+}
@@ -0,0 +1,305 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"zipprine/internal/models"
+)
+
+// ConvertArchive converts an archive from one format to another
+func ConvertArchive(sourcePath, destPath string, sourceType, destType models.ArchiveType) error {
+	return Convert(&models.ConvertConfig{
+		SourcePath: sourcePath,
+		DestPath:   destPath,
+		SourceType: sourceType,
+		DestType:   destType,
+	})
+}
+
+// Convert streams every entry of the source archive straight into a new
+// archive of the destination type, without extracting anything to disk.
+// Modes, modification times and links are kept, and ZIP entries going into
+// a ZIP are copied without being recompressed.
+func Convert(config *models.ConvertConfig) error {
+	sourceType := config.SourceType
+	if sourceType == "" || sourceType == models.AUTO {
+		detected, err := DetectArchiveType(config.SourcePath)
+		if err != nil {
+			return fmt.Errorf("failed to detect source archive type: %w", err)
+		}
+		sourceType = detected
+	}
+
+	destType := config.DestType
+	if destType == "" || destType == models.AUTO {
+		detected, ok := DetectArchiveTypeByExtension(config.DestPath)
+		if !ok {
+			return fmt.Errorf("cannot infer archive type from %s", config.DestPath)
+		}
+		destType = detected
+	}
+
+	if sameFile(config.SourcePath, config.DestPath) {
+		return fmt.Errorf("source and destination are the same file")
+	}
+
+	outFile, err := createOutput(config.DestPath)
+	if err != nil {
+		return err
+	}
+
+	err = convertEntries(config, sourceType, destType, outFile)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if config.DestPath != StdioPath {
+			os.Remove(config.DestPath)
+		}
+		return err
+	}
+	return nil
+}
+
+func convertEntries(config *models.ConvertConfig, sourceType, destType models.ArchiveType, w io.Writer) error {
+	writer, err := newEntryWriter(w, destType, config.CompressionLevel)
+	if err != nil {
+		return err
+	}
+
+	progress := config.Progress
+	if progress == nil {
+		progress = progressOut(config.DestPath)
+	}
+	err = walkArchive(config.SourcePath, sourceType, func(entry *archiveEntry) error {
+		if !entry.IsDir() {
+			fmt.Fprintf(progress, "  → %s\n", entry.Name)
+		}
+		return writer.WriteEntry(entry)
+	})
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// entryWriter adds streamed archive entries to a new archive
+type entryWriter interface {
+	WriteEntry(entry *archiveEntry) error
+	Close() error
+}
+
+func newEntryWriter(w io.Writer, archiveType models.ArchiveType, level int) (entryWriter, error) {
+	if level <= 0 {
+		level = gzip.DefaultCompression
+	}
+
+	switch archiveType {
+	case models.ZIP:
+		return &zipEntryWriter{zw: newZipWriter(w, level)}, nil
+	case models.TAR:
+		return &tarEntryWriter{tw: tar.NewWriter(w)}, nil
+	case models.TARGZ:
+		gzWriter, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return &tarEntryWriter{tw: tar.NewWriter(gzWriter), gz: gzWriter}, nil
+	case models.GZIP:
+		gzWriter, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipEntryWriter{gz: gzWriter}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive type: %s", archiveType)
+	}
+}
+
+type zipEntryWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipEntryWriter) WriteEntry(entry *archiveEntry) error {
+	// Entries that are already ZIP members keep their compressed data
+	if entry.zipFile != nil {
+		return w.zw.Copy(entry.zipFile)
+	}
+
+	if entry.HardLink {
+		fmt.Fprintf(os.Stderr, "  ⚠ Skipping hard link %s (not supported in ZIP)\n", entry.Name)
+		return nil
+	}
+
+	header := &zip.FileHeader{
+		Name:     strings.TrimSuffix(entry.Name, "/"),
+		Modified: entry.ModTime,
+		Method:   zip.Deflate,
+	}
+	header.SetMode(entry.Mode)
+
+	var content io.Reader
+	switch {
+	case entry.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+	case entry.Mode&fs.ModeSymlink != 0:
+		// ZIP stores a symlink's target as its contents
+		header.Method = zip.Store
+		content = strings.NewReader(entry.Linkname)
+	case entry.IsRegular():
+		rc, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		content = rc
+	default:
+		fmt.Fprintf(os.Stderr, "  ⚠ Skipping special file %s\n", entry.Name)
+		return nil
+	}
+
+	fw, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if content != nil {
+		_, err = io.Copy(fw, content)
+	}
+	return err
+}
+
+func (w *zipEntryWriter) Close() error {
+	return w.zw.Close()
+}
+
+type tarEntryWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (w *tarEntryWriter) WriteEntry(entry *archiveEntry) error {
+	header, err := tarHeaderFor(entry)
+	if err != nil {
+		return err
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	// Only regular files carry data; directories and links have a zero size
+	if header.Size == 0 {
+		return nil
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w.tw, rc)
+	return err
+}
+
+func (w *tarEntryWriter) Close() error {
+	err := w.tw.Close()
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	return err
+}
+
+// tarHeaderFor returns the tar header for an entry, reusing the original one
+// when the entry comes from a tar archive
+func tarHeaderFor(entry *archiveEntry) (*tar.Header, error) {
+	if entry.tarHeader != nil {
+		header := *entry.tarHeader
+		return &header, nil
+	}
+
+	header := &tar.Header{
+		Name:     strings.TrimSuffix(entry.Name, "/"),
+		Mode:     int64(entry.Mode.Perm()),
+		ModTime:  entry.ModTime,
+		Linkname: entry.Linkname,
+	}
+	if entry.Mode&fs.ModeSetuid != 0 {
+		header.Mode |= 04000
+	}
+	if entry.Mode&fs.ModeSetgid != 0 {
+		header.Mode |= 02000
+	}
+	if entry.Mode&fs.ModeSticky != 0 {
+		header.Mode |= 01000
+	}
+
+	switch {
+	case entry.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+	case entry.Mode&fs.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+	case entry.IsRegular():
+		header.Typeflag = tar.TypeReg
+		header.Size = entry.Size
+	default:
+		return nil, fmt.Errorf("unsupported entry type for %s", entry.Name)
+	}
+	return header, nil
+}
+
+// gzipEntryWriter compresses the single regular file a GZIP stream can hold
+type gzipEntryWriter struct {
+	gz      *gzip.Writer
+	written bool
+}
+
+func (w *gzipEntryWriter) WriteEntry(entry *archiveEntry) error {
+	if entry.IsDir() {
+		return nil
+	}
+	if !entry.IsRegular() || w.written {
+		return fmt.Errorf("GZIP holds a single file; cannot add %s", entry.Name)
+	}
+	w.written = true
+
+	w.gz.Name = path.Base(entry.Name)
+	w.gz.ModTime = entry.ModTime
+
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w.gz, rc)
+	return err
+}
+
+func (w *gzipEntryWriter) Close() error {
+	return w.gz.Close()
+}
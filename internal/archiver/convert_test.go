@@ -0,0 +1,238 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zipprine/internal/models"
+)
+
+var convertModTime = time.Date(2021, 3, 4, 5, 6, 8, 0, time.UTC)
+
+// writeLinkTarGz builds a tar.gz holding a directory, a file and a symlink
+func writeLinkTarGz(t *testing.T, path string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gzWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzWriter)
+
+	headers := []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "app/", Mode: 0755, ModTime: convertModTime},
+		{Typeflag: tar.TypeReg, Name: "app/run.sh", Mode: 0755, ModTime: convertModTime, Size: 9},
+		{Typeflag: tar.TypeSymlink, Name: "app/latest", Linkname: "run.sh", Mode: 0777, ModTime: convertModTime},
+	}
+	for _, header := range headers {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tarWriter.Write([]byte("#!/bin/sh"))
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertPreservesMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "source.tar.gz")
+	writeLinkTarGz(t, source)
+
+	zipPath := filepath.Join(tmpDir, "out.zip")
+	if err := ConvertArchive(source, zipPath, models.AUTO, models.ZIP); err != nil {
+		t.Fatalf("ConvertArchive() error = %v", err)
+	}
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	files := map[string]*zip.File{}
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+
+	if f := files["app/"]; f == nil || !f.Mode().IsDir() {
+		t.Errorf("directory entry missing or not a directory")
+	}
+
+	script := files["app/run.sh"]
+	if script == nil {
+		t.Fatal("app/run.sh missing")
+	}
+	if script.Mode().Perm() != 0755 {
+		t.Errorf("app/run.sh mode = %v, want 0755", script.Mode().Perm())
+	}
+	if !script.Modified.Equal(convertModTime) {
+		t.Errorf("app/run.sh mtime = %v, want %v", script.Modified, convertModTime)
+	}
+
+	link := files["app/latest"]
+	if link == nil || link.Mode()&fs.ModeSymlink == 0 {
+		t.Fatal("app/latest is not stored as a symlink")
+	}
+	target, err := readZipSymlink(link)
+	if err != nil || target != "run.sh" {
+		t.Errorf("symlink target = %q, %v, want run.sh", target, err)
+	}
+
+	// And back again into a tar
+	tarPath := filepath.Join(tmpDir, "back.tar")
+	if err := ConvertArchive(zipPath, tarPath, models.AUTO, models.TAR); err != nil {
+		t.Fatalf("ConvertArchive() error = %v", err)
+	}
+
+	headers := map[string]*tar.Header{}
+	err = walkArchive(tarPath, models.TAR, func(entry *archiveEntry) error {
+		headers[entry.Name] = entry.tarHeader
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h := headers["app/latest"]; h == nil || h.Typeflag != tar.TypeSymlink || h.Linkname != "run.sh" {
+		t.Errorf("app/latest header = %+v, want symlink to run.sh", h)
+	}
+	if h := headers["app/run.sh"]; h == nil || !h.ModTime.Equal(convertModTime) || h.Mode != 0755 {
+		t.Errorf("app/run.sh header = %+v, want mode 0755 and original mtime", h)
+	}
+}
+
+func TestConvertZipToZipCopiesRaw(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "source.zip")
+
+	file, err := os.Create(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	stored, _ := zw.CreateHeader(&zip.FileHeader{Name: "stored.txt", Method: zip.Store})
+	stored.Write([]byte(strings.Repeat("a", 1000)))
+	deflated, _ := zw.CreateHeader(&zip.FileHeader{Name: "deflated.txt", Method: zip.Deflate})
+	deflated.Write([]byte(strings.Repeat("b", 1000)))
+	zw.Close()
+	file.Close()
+
+	dest := filepath.Join(tmpDir, "dest.zip")
+	if err := Convert(&models.ConvertConfig{
+		SourcePath:       source,
+		DestPath:         dest,
+		DestType:         models.ZIP,
+		CompressionLevel: 9,
+	}); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	src, _ := zip.OpenReader(source)
+	defer src.Close()
+	dst, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if len(dst.File) != len(src.File) {
+		t.Fatalf("got %d entries, want %d", len(dst.File), len(src.File))
+	}
+	for i, f := range dst.File {
+		want := src.File[i]
+		if f.Name != want.Name || f.Method != want.Method ||
+			f.CompressedSize64 != want.CompressedSize64 || f.CRC32 != want.CRC32 {
+			t.Errorf("entry %s was not copied raw", f.Name)
+		}
+	}
+}
+
+func TestConvertGzip(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "notes.txt")
+	os.WriteFile(source, []byte("some notes"), 0644)
+
+	gzPath := filepath.Join(tmpDir, "notes.txt.gz")
+	if err := Compress(&models.CompressConfig{
+		SourcePath:       source,
+		OutputPath:       gzPath,
+		ArchiveType:      models.GZIP,
+		CompressionLevel: 6,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tarPath := filepath.Join(tmpDir, "notes.tar")
+	if err := ConvertArchive(gzPath, tarPath, models.AUTO, models.TAR); err != nil {
+		t.Fatalf("ConvertArchive() error = %v", err)
+	}
+
+	rc, err := OpenEntry(tarPath, "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	if string(data) != "some notes" {
+		t.Errorf("converted contents = %q", data)
+	}
+
+	// A GZIP destination only takes one file
+	zipPath := filepath.Join(tmpDir, "many.zip")
+	createTestFiles(t, filepath.Join(tmpDir, "many"))
+	Compress(&models.CompressConfig{
+		SourcePath:  filepath.Join(tmpDir, "many"),
+		OutputPath:  zipPath,
+		ArchiveType: models.ZIP,
+	})
+	badPath := filepath.Join(tmpDir, "many.gz")
+	if err := ConvertArchive(zipPath, badPath, models.ZIP, models.GZIP); err == nil {
+		t.Error("expected an error converting several files to GZIP")
+	}
+	if _, err := os.Stat(badPath); !os.IsNotExist(err) {
+		t.Error("partial output was left behind after a failed conversion")
+	}
+}
+
+func TestConvertLeavesNoTempFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "source.tar.gz")
+	writeLinkTarGz(t, source)
+
+	dest := filepath.Join(tmpDir, "dest.zip")
+	if err := ConvertArchive(source, dest, models.TARGZ, models.ZIP); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("unexpected files after conversion: %v", names)
+	}
+
+	if err := ConvertArchive(dest, dest, models.ZIP, models.ZIP); err == nil {
+		t.Error("expected an error converting an archive onto itself")
+	}
+}
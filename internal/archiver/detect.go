@@ -1,6 +1,8 @@
 package archiver
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"io"
@@ -13,36 +15,90 @@ import (
 
 func DetectArchiveType(path string) (models.ArchiveType, error) {
 	// First, try by extension
+	if archiveType, ok := DetectArchiveTypeByExtension(path); ok {
+		return archiveType, nil
+	}
+
+	// Try by magic bytes
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	archiveType, err := DetectArchiveTypeFromReader(bufio.NewReaderSize(file, sniffSize))
+	if err != nil || archiveType != models.AUTO {
+		return archiveType, err
+	}
+
+	// A ZIP with data in front of it, such as a self-extracting stub, is
+	// only recognisable by its end records
+	if hasZipEnd(file) {
+		return models.ZIP, nil
+	}
+	return models.AUTO, nil
+}
+
+// hasZipEnd reports whether a file ends with the end records of a
+// single-volume ZIP, following the ZIP64 locator when there is one
+func hasZipEnd(file *os.File) bool {
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	end, err := readZipEnd(file, stat.Size(), func(disk uint32) (int64, error) {
+		if disk != 0 {
+			return 0, zip.ErrFormat
+		}
+		return 0, nil
+	})
+	if err != nil || end.disk != 0 || end.entries != end.diskEntries {
+		return false
+	}
+	// Every entry needs a directory record, and the directory has to fit
+	return end.cdSize >= end.entries*zipDirectoryHeaderLen && end.cdSize < uint64(stat.Size())
+}
+
+// sniffSize is how much of a stream is buffered for magic byte detection
+const sniffSize = 64 * 1024
+
+// DetectArchiveTypeByExtension maps well-known archive extensions to their
+// type without touching the file, so it also works for paths not yet created
+func DetectArchiveTypeByExtension(path string) (models.ArchiveType, bool) {
+	// Volumes of a split archive
+	if zipSplitSuffix.MatchString(path) {
+		return models.ZIP, true
+	}
+	if strings.HasSuffix(path, ".000") {
+		path = segmentBase(path)
+	}
+
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
 	case ".zip":
-		return models.ZIP, nil
+		return models.ZIP, true
 	case ".gz":
 		if strings.HasSuffix(strings.ToLower(path), ".tar.gz") {
-			return models.TARGZ, nil
+			return models.TARGZ, true
 		}
-		return models.GZIP, nil
+		return models.GZIP, true
 	case ".tar":
-		return models.TAR, nil
+		return models.TAR, true
 	case ".tgz":
-		return models.TARGZ, nil
+		return models.TARGZ, true
 	case ".rar":
-		return models.RAR, nil
-	}
-
-	// Try by magic bytes
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
+		return models.RAR, true
 	}
-	defer file.Close()
+	return models.AUTO, false
+}
 
-	header := make([]byte, 512)
-	n, err := file.Read(header)
-	if err != nil && err != io.EOF {
+// DetectArchiveTypeFromReader detects the archive type from magic bytes
+// without consuming the stream, so the same reader can be used for extraction
+func DetectArchiveTypeFromReader(r *bufio.Reader) (models.ArchiveType, error) {
+	header, err := r.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
 		return "", err
 	}
-	header = header[:n]
 
 	// ZIP magic: PK (0x504B)
 	if len(header) >= 2 && header[0] == 0x50 && header[1] == 0x4B {
@@ -51,13 +107,14 @@ func DetectArchiveType(path string) (models.ArchiveType, error) {
 
 	// GZIP magic: 0x1F 0x8B
 	if len(header) >= 2 && header[0] == 0x1F && header[1] == 0x8B {
-		// Check if it's a tar.gz by trying to decompress and check for tar header
-		file.Seek(0, 0)
-		gzReader, err := gzip.NewReader(file)
+		// Check if it's a tar.gz by decompressing the buffered prefix and
+		// looking for a tar header
+		buffered, _ := r.Peek(r.Size())
+		gzReader, err := gzip.NewReader(bytes.NewReader(buffered))
 		if err == nil {
 			defer gzReader.Close()
 			tarHeader := make([]byte, 512)
-			if n, _ := gzReader.Read(tarHeader); n >= 257 {
+			if n, _ := io.ReadFull(gzReader, tarHeader); n >= 262 {
 				// TAR magic: "ustar" at offset 257
 				if bytes.Equal(tarHeader[257:262], []byte("ustar")) {
 					return models.TARGZ, nil
@@ -81,36 +138,40 @@ func DetectArchiveType(path string) (models.ArchiveType, error) {
 }
 
 func Analyze(path string) (*models.ArchiveInfo, error) {
+	return AnalyzeWithPassword(path, "")
+}
+
+// AnalyzeWithPassword analyzes an archive, checking that password opens it
+// when the archive is encrypted
+func AnalyzeWithPassword(path, password string) (*models.ArchiveInfo, error) {
 	archiveType, err := DetectArchiveType(path)
 	if err != nil {
 		return nil, err
 	}
 
+	var info *models.ArchiveInfo
 	switch archiveType {
 	case models.ZIP:
-		return analyzeZip(path)
+		info, err = analyzeZipWithPassword(path, password)
 	case models.TARGZ:
-		return analyzeTar(path, true)
+		info, err = analyzeTar(path, true)
 	case models.TAR:
-		return analyzeTar(path, false)
+		info, err = analyzeTar(path, false)
 	case models.RAR:
-		return analyzeRar(path)
+		info, err = analyzeRarWithPassword(path, password)
 	case models.GZIP:
 		// For GZIP, provide basic file info
-		file, err := os.Open(path)
-		if err != nil {
-			return nil, err
+		info = &models.ArchiveInfo{
+			Type:      models.GZIP,
+			FileCount: 1,
+			Files:     []models.FileInfo{},
 		}
-		defer file.Close()
-
-		fileStat, _ := file.Stat()
-		return &models.ArchiveInfo{
-			Type:           models.GZIP,
-			CompressedSize: fileStat.Size(),
-			FileCount:      1,
-			Files:          []models.FileInfo{},
-		}, nil
+		err = measureArchive(path, info)
 	default:
 		return nil, nil
 	}
-}
\ No newline at end of file
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
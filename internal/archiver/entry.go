@@ -0,0 +1,214 @@
+package archiver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"zipprine/internal/models"
+
+	"github.com/nwaples/rardecode"
+)
+
+// ErrEntryNotFound is returned when the requested member is not in the archive
+var ErrEntryNotFound = errors.New("entry not found in archive")
+
+// OpenEntry opens a single member of an archive for reading without extracting
+// anything to disk. ZIP archives are read through the central directory, while
+// tar and RAR archives are scanned sequentially until the entry is found.
+func OpenEntry(path, name string) (io.ReadCloser, error) {
+	archiveType, err := DetectArchiveType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name = cleanEntryName(name)
+
+	switch archiveType {
+	case models.ZIP:
+		return openZipEntry(path, name)
+	case models.TARGZ:
+		return openTarEntry(path, name, true)
+	case models.TAR:
+		return openTarEntry(path, name, false)
+	case models.GZIP:
+		return openGzipEntry(path, name)
+	case models.RAR:
+		return openRarEntry(path, name)
+	default:
+		return nil, fmt.Errorf("unsupported archive type: %s", archiveType)
+	}
+}
+
+// ListEntries returns every entry of an archive in storage order. Unlike the
+// analysis, which keeps the first hundred, the list is complete.
+func ListEntries(path string) ([]models.FileInfo, error) {
+	archiveType, err := DetectArchiveType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []models.FileInfo{}
+	err = walkArchive(path, archiveType, func(entry *archiveEntry) error {
+		files = append(files, models.FileInfo{
+			Name:      entry.Name,
+			Size:      entry.Size,
+			IsDir:     entry.IsDir(),
+			ModTime:   entry.ModTime.Format("2006-01-02 15:04:05"),
+			Encrypted: entry.zipFile != nil && zipEncryption(entry.zipFile) != "",
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// cleanEntryName normalizes an entry name so "./a/b", "/a/b" and "a/b" all match
+func cleanEntryName(name string) string {
+	name = filepath.ToSlash(name)
+	name = strings.TrimPrefix(name, "./")
+	return strings.TrimPrefix(name, "/")
+}
+
+// entryReader ties the lifetime of an entry stream to the resources backing it
+type entryReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (e *entryReader) Close() error {
+	var firstErr error
+	for i := len(e.closers) - 1; i >= 0; i-- {
+		if err := e.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func openZipEntry(path, name string) (io.ReadCloser, error) {
+	r, err := openZipArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range r.File {
+		if cleanEntryName(f.Name) != name || f.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := openZipFile(f, "")
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return &entryReader{Reader: rc, closers: []io.Closer{r, rc}}, nil
+	}
+
+	r.Close()
+	return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, name)
+}
+
+func openTarEntry(path, name string, isGzipped bool) (io.ReadCloser, error) {
+	file, err := openArchiveFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	closers := []io.Closer{file}
+	var src io.Reader = file
+	if isGzipped {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		closers = append(closers, gzReader)
+		src = gzReader
+	}
+
+	tarReader := tar.NewReader(src)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closeAll(closers)
+			return nil, err
+		}
+
+		if header.Typeflag == tar.TypeReg && cleanEntryName(header.Name) == name {
+			return &entryReader{Reader: tarReader, closers: closers}, nil
+		}
+	}
+
+	closeAll(closers)
+	return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, name)
+}
+
+func openGzipEntry(path, name string) (io.ReadCloser, error) {
+	file, err := openArchiveFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// A gzip stream holds a single member, named either in its header or
+	// after the archive itself without the .gz suffix
+	base := segmentBase(path)
+	baseName := strings.TrimSuffix(filepath.Base(base), filepath.Ext(base))
+	if name != baseName && (gzReader.Name == "" || name != gzReader.Name) {
+		gzReader.Close()
+		file.Close()
+		return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, name)
+	}
+
+	return &entryReader{Reader: gzReader, closers: []io.Closer{file, gzReader}}, nil
+}
+
+func openRarEntry(path, name string) (io.ReadCloser, error) {
+	if encrypted, _ := IsEncrypted(path, models.RAR); encrypted {
+		return nil, ErrPasswordRequired
+	}
+
+	reader, err := rardecode.OpenReader(path, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RAR file: %w", err)
+	}
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("failed to read RAR entry: %w", rarError(err, nil, ""))
+		}
+
+		if !header.IsDir && cleanEntryName(header.Name) == name {
+			return &entryReader{Reader: reader, closers: []io.Closer{reader}}, nil
+		}
+	}
+
+	reader.Close()
+	return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, name)
+}
+
+func closeAll(closers []io.Closer) {
+	for i := len(closers) - 1; i >= 0; i-- {
+		closers[i].Close()
+	}
+}
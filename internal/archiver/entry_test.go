@@ -0,0 +1,119 @@
+package archiver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+func TestOpenEntry(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	if err := os.Mkdir(sourceDir, 0755); err != nil {
+		t.Fatalf("Failed to create source dir: %v", err)
+	}
+	createTestFiles(t, sourceDir)
+
+	testCases := []struct {
+		name        string
+		archiveType models.ArchiveType
+		ext         string
+	}{
+		{"zip", models.ZIP, ".zip"},
+		{"tar", models.TAR, ".tar"},
+		{"tar.gz", models.TARGZ, ".tar.gz"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			archivePath := filepath.Join(tmpDir, "test"+tc.ext)
+			err := Compress(&models.CompressConfig{
+				SourcePath:       sourceDir,
+				OutputPath:       archivePath,
+				ArchiveType:      tc.archiveType,
+				CompressionLevel: 5,
+			})
+			if err != nil {
+				t.Fatalf("Compress failed: %v", err)
+			}
+
+			rc, err := OpenEntry(archivePath, "subdir/test3.txt")
+			if err != nil {
+				t.Fatalf("OpenEntry failed: %v", err)
+			}
+			content, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("Failed to read entry: %v", err)
+			}
+			if string(content) != "Nested file" {
+				t.Errorf("Entry content mismatch: got %q, want %q", string(content), "Nested file")
+			}
+
+			// Leading "./" should resolve to the same entry
+			rc, err = OpenEntry(archivePath, "./test1.txt")
+			if err != nil {
+				t.Fatalf("OpenEntry with ./ prefix failed: %v", err)
+			}
+			rc.Close()
+
+			_, err = OpenEntry(archivePath, "missing.txt")
+			if !errors.Is(err, ErrEntryNotFound) {
+				t.Errorf("Expected ErrEntryNotFound, got %v", err)
+			}
+
+			files, err := ListEntries(archivePath)
+			if err != nil {
+				t.Fatalf("ListEntries failed: %v", err)
+			}
+			sizes := map[string]int64{}
+			for _, f := range files {
+				if !f.IsDir {
+					sizes[f.Name] = f.Size
+				}
+			}
+			if len(sizes) != 3 || sizes["subdir/test3.txt"] != int64(len("Nested file")) {
+				t.Errorf("ListEntries() files = %v", sizes)
+			}
+		})
+	}
+}
+
+func TestOpenEntryGzip(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sourceFile := filepath.Join(tmpDir, "notes.txt")
+	os.WriteFile(sourceFile, []byte("gzip content"), 0644)
+
+	gzPath := filepath.Join(tmpDir, "notes.txt.gz")
+	if err := createGzip(&models.CompressConfig{
+		SourcePath:       sourceFile,
+		OutputPath:       gzPath,
+		CompressionLevel: 5,
+	}); err != nil {
+		t.Fatalf("createGzip failed: %v", err)
+	}
+
+	rc, err := OpenEntry(gzPath, "notes.txt")
+	if err != nil {
+		t.Fatalf("OpenEntry failed: %v", err)
+	}
+	defer rc.Close()
+
+	content, _ := io.ReadAll(rc)
+	if string(content) != "gzip content" {
+		t.Errorf("Entry content mismatch: got %q, want %q", string(content), "gzip content")
+	}
+}
+
+func TestOpenEntryNonExistentArchive(t *testing.T) {
+	_, err := OpenEntry("/nonexistent/archive.zip", "file.txt")
+	if err == nil {
+		t.Error("Expected error for non-existent archive, got nil")
+	}
+}
@@ -0,0 +1,616 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"zipprine/internal/models"
+)
+
+// OpenFS returns the contents of a ZIP, tar, tar.gz, gzip or RAR archive as
+// a read-only file system that also implements fs.ReadDirFS, fs.StatFS and
+// fs.ReadLinkFS. Nothing is read until it is first used; the whole archive
+// is then indexed once. Entries of ZIP and uncompressed tar archives are
+// read in place, while those of compressed tar and RAR archives are found
+// by streaming the archive up to the entry. The first time such an entry is
+// opened it is kept in a temporary file, so opening it again or seeking back
+// does not decompress it again; once fsSpoolLimit bytes are kept, further
+// entries are streamed on every open.
+//
+// The returned FS is also an io.Closer; closing it releases the archive and
+// removes the temporary files.
+func OpenFS(path string) (fs.FS, error) {
+	archiveType, err := DetectArchiveType(path)
+	if err != nil {
+		return nil, err
+	}
+	switch archiveType {
+	case models.ZIP, models.TAR, models.TARGZ, models.GZIP, models.RAR:
+	default:
+		return nil, fmt.Errorf("unsupported archive type: %s", archiveType)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return &archiveFS{path: path, archiveType: archiveType}, nil
+}
+
+// maxLinkHops bounds how many symlinks are followed to resolve one name
+const maxLinkHops = 40
+
+// fsSpoolLimit bounds the disk space an archiveFS uses for the entries of
+// compressed archives it keeps after their first read
+const fsSpoolLimit = 1 << 30
+
+// archiveFS serves the entries of an archive through io/fs
+type archiveFS struct {
+	path        string
+	archiveType models.ArchiveType
+
+	once   sync.Once
+	err    error
+	nodes  map[string]*fsNode
+	closer io.Closer
+	data   io.ReaderAt // the archive itself, for entries read in place
+
+	spoolMu   sync.Mutex
+	spoolDir  string
+	spooled   map[*fsNode]*spooledEntry
+	spoolUsed int64
+}
+
+// spooledEntry is the decompressed copy of an entry, made once by the first
+// open and shared by the later ones
+type spooledEntry struct {
+	once sync.Once
+	file *os.File
+	err  error
+}
+
+// fsNode is one file or directory of the tree built from the entries.
+// Directories missing from the archive are filled in.
+type fsNode struct {
+	name     string // full slash-separated path, "." for the root
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	link     string // symlink target, or hard link target as a full path
+	hardLink bool
+	children []*fsNode
+
+	ordinal int       // position in the archive, for finding the entry again
+	offset  int64     // start of the data in an uncompressed tar; -1 otherwise
+	zipFile *zip.File // set for ZIP entries, which are read in place
+}
+
+func (n *fsNode) isSymlink() bool {
+	return n.mode&fs.ModeSymlink != 0
+}
+
+func (fsys *archiveFS) Close() error {
+	fsys.spoolMu.Lock()
+	for _, s := range fsys.spooled {
+		if s.file != nil {
+			s.file.Close()
+		}
+	}
+	if fsys.spoolDir != "" {
+		os.RemoveAll(fsys.spoolDir)
+	}
+	fsys.spooled, fsys.spoolDir = nil, ""
+	fsys.spoolMu.Unlock()
+
+	if fsys.closer != nil {
+		return fsys.closer.Close()
+	}
+	return nil
+}
+
+// index builds the tree on first use
+func (fsys *archiveFS) index() error {
+	fsys.once.Do(func() {
+		fsys.nodes = map[string]*fsNode{
+			".": {name: ".", mode: fs.ModeDir | 0755, offset: -1},
+		}
+		fsys.err = fsys.scan()
+		if fsys.err != nil {
+			fsys.Close()
+			return
+		}
+		for _, n := range fsys.nodes {
+			slices.SortFunc(n.children, func(a, b *fsNode) int { return strings.Compare(a.name, b.name) })
+			// A hard link takes the size of the file it points to
+			if n.hardLink {
+				if target := fsys.nodes[n.link]; target != nil && target.mode.IsRegular() {
+					n.size = target.size
+				}
+			}
+		}
+	})
+	return fsys.err
+}
+
+func (fsys *archiveFS) scan() error {
+	ordinal := 0
+	add := func(entry *archiveEntry) *fsNode {
+		n := fsys.add(entry, ordinal)
+		ordinal++
+		return n
+	}
+
+	switch fsys.archiveType {
+	case models.ZIP:
+		r, err := openZipArchive(fsys.path)
+		if err != nil {
+			return err
+		}
+		fsys.closer = r
+		for _, f := range r.File {
+			entry := &archiveEntry{
+				Name:    f.Name,
+				Mode:    f.Mode(),
+				ModTime: f.Modified,
+				Size:    int64(f.UncompressedSize64),
+				zipFile: f,
+			}
+			if entry.Mode&fs.ModeSymlink != 0 {
+				target, err := readZipSymlink(f)
+				if err != nil {
+					return err
+				}
+				entry.Linkname = target
+			}
+			add(entry)
+		}
+		return nil
+
+	case models.TAR:
+		// A plain tar file keeps entry data contiguous, so the offsets seen
+		// while indexing are enough to read entries later
+		if volumes, err := segmentVolumes(fsys.path); err == nil && volumes == nil {
+			file, err := os.Open(fsys.path)
+			if err != nil {
+				return err
+			}
+			fsys.closer, fsys.data = file, file
+			reader := &offsetReader{r: file}
+			return walkTar(tar.NewReader(reader), func(entry *archiveEntry) error {
+				if n := add(entry); n != nil && entry.IsRegular() && !tarIsSparse(entry.tarHeader) {
+					n.offset = reader.pos
+				}
+				return nil
+			})
+		}
+	}
+
+	return walkArchive(fsys.path, fsys.archiveType, func(entry *archiveEntry) error {
+		add(entry)
+		return nil
+	})
+}
+
+// add places an entry in the tree, creating missing parent directories.
+// Entries with names that cannot be represented are left out, and a later
+// entry of the same name replaces an earlier one, as on extraction.
+func (fsys *archiveFS) add(entry *archiveEntry, ordinal int) *fsNode {
+	name := path.Clean(cleanEntryName(entry.Name))
+	if name == "." || !fs.ValidPath(name) {
+		return nil
+	}
+	parent := fsys.mkdirAll(path.Dir(name))
+	if parent == nil {
+		return nil
+	}
+
+	n := &fsNode{
+		name:     name,
+		mode:     entry.Mode,
+		size:     entry.Size,
+		modTime:  entry.ModTime,
+		link:     entry.Linkname,
+		hardLink: entry.HardLink,
+		ordinal:  ordinal,
+		offset:   -1,
+		zipFile:  entry.zipFile,
+	}
+	if n.hardLink {
+		n.link = path.Clean(cleanEntryName(entry.Linkname))
+		n.mode = entry.Mode &^ fs.ModeType
+	}
+	if n.mode.IsDir() || n.isSymlink() {
+		n.size = 0
+	}
+
+	if old := fsys.nodes[name]; old != nil {
+		// A directory entry after its contents keeps them
+		if old.mode.IsDir() && n.mode.IsDir() {
+			old.mode, old.modTime = n.mode, n.modTime
+			return old
+		}
+		parent.children = slices.DeleteFunc(parent.children, func(c *fsNode) bool { return c == old })
+		fsys.forget(old)
+	}
+	fsys.nodes[name] = n
+	parent.children = append(parent.children, n)
+	return n
+}
+
+// mkdirAll returns the directory node for name, creating it and its parents
+// as needed. It returns nil when a file is in the way.
+func (fsys *archiveFS) mkdirAll(name string) *fsNode {
+	if n := fsys.nodes[name]; n != nil {
+		if !n.mode.IsDir() {
+			return nil
+		}
+		return n
+	}
+	parent := fsys.mkdirAll(path.Dir(name))
+	if parent == nil {
+		return nil
+	}
+	n := &fsNode{name: name, mode: fs.ModeDir | 0755, offset: -1}
+	fsys.nodes[name] = n
+	parent.children = append(parent.children, n)
+	return n
+}
+
+// forget removes a node and everything below it from the index
+func (fsys *archiveFS) forget(n *fsNode) {
+	delete(fsys.nodes, n.name)
+	for _, c := range n.children {
+		fsys.forget(c)
+	}
+}
+
+// lookup finds the node for a valid name, following symlinks in every
+// component and, when follow is set, in the last one. Links may not point
+// outside the archive.
+func (fsys *archiveFS) lookup(op, name string, follow bool) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if err := fsys.index(); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	var parts []string
+	if name != "." {
+		parts = strings.Split(name, "/")
+	}
+	cur, hops := fsys.nodes["."], 0
+	for i := 0; i < len(parts); i++ {
+		if !cur.mode.IsDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		n := fsys.nodes[path.Join(cur.name, parts[i])]
+		if n == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if !n.isSymlink() || (i == len(parts)-1 && !follow) {
+			cur = n
+			continue
+		}
+
+		if hops++; hops > maxLinkHops {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		target := path.Join(path.Dir(n.name), n.link)
+		if path.IsAbs(n.link) || !fs.ValidPath(target) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		// Start over from the root with the target in place of the link
+		rest := parts[i+1:]
+		parts = nil
+		if target != "." {
+			parts = strings.Split(target, "/")
+		}
+		parts = append(parts, rest...)
+		cur, i = fsys.nodes["."], -1
+	}
+	return cur, nil
+}
+
+func (fsys *archiveFS) Open(name string) (fs.File, error) {
+	n, err := fsys.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := &fsFileInfo{name: path.Base(name), node: n}
+	if n.mode.IsDir() {
+		return &fsDir{fsys: fsys, info: info}, nil
+	}
+	return &fsFile{fsys: fsys, info: info}, nil
+}
+
+func (fsys *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsys.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &fsFileInfo{name: path.Base(name), node: n}, nil
+}
+
+func (fsys *archiveFS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := fsys.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &fsFileInfo{name: path.Base(name), node: n}, nil
+}
+
+func (fsys *archiveFS) ReadLink(name string) (string, error) {
+	n, err := fsys.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !n.isSymlink() {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.link, nil
+}
+
+func (fsys *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := fsys.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return dirEntries(n.children), nil
+}
+
+func dirEntries(nodes []*fsNode) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(nodes))
+	for i, c := range nodes {
+		entries[i] = &fsFileInfo{name: path.Base(c.name), node: c}
+	}
+	return entries
+}
+
+// openEntry opens the contents of a file node
+func (fsys *archiveFS) openEntry(n *fsNode) (io.ReadCloser, error) {
+	if n.hardLink {
+		target := fsys.nodes[n.link]
+		if target == nil || !target.mode.IsRegular() || target.hardLink {
+			return nil, fmt.Errorf("%s: hard link target %s not found", n.name, n.link)
+		}
+		n = target
+	}
+
+	switch {
+	case n.zipFile != nil:
+		return openZipFile(n.zipFile, "")
+	case n.offset >= 0:
+		return io.NopCloser(io.NewSectionReader(fsys.data, n.offset, n.size)), nil
+	}
+
+	if s := fsys.spool(n); s != nil && s.err == nil {
+		return io.NopCloser(io.NewSectionReader(s.file, 0, n.size)), nil
+	}
+	return fsys.streamEntry(n), nil
+}
+
+// spool returns the decompressed copy of an entry, making it on first use.
+// It returns nil when the entry does not fit in what is left of
+// fsSpoolLimit.
+func (fsys *archiveFS) spool(n *fsNode) *spooledEntry {
+	fsys.spoolMu.Lock()
+	s := fsys.spooled[n]
+	if s == nil {
+		if fsys.spoolUsed+n.size > fsSpoolLimit {
+			fsys.spoolMu.Unlock()
+			return nil
+		}
+		if fsys.spoolDir == "" {
+			dir, err := os.MkdirTemp("", "zipprine-fs-*")
+			if err != nil {
+				fsys.spoolMu.Unlock()
+				return nil
+			}
+			fsys.spoolDir, fsys.spooled = dir, map[*fsNode]*spooledEntry{}
+		}
+		s = &spooledEntry{}
+		fsys.spooled[n] = s
+		fsys.spoolUsed += n.size
+	}
+	dir := fsys.spoolDir
+	fsys.spoolMu.Unlock()
+
+	s.once.Do(func() {
+		file, err := os.CreateTemp(dir, "entry-*")
+		if err != nil {
+			s.err = err
+			return
+		}
+		rc := fsys.streamEntry(n)
+		_, err = io.Copy(file, io.LimitReader(rc, n.size))
+		rc.Close()
+		if err != nil {
+			file.Close()
+			s.err = err
+			return
+		}
+		s.file = file
+	})
+	return s
+}
+
+// streamEntry reads an entry by streaming the archive up to it in the
+// background; closing the reader stops the walk
+func (fsys *archiveFS) streamEntry(n *fsNode) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		ordinal := 0
+		err := walkArchive(fsys.path, fsys.archiveType, func(entry *archiveEntry) error {
+			if ordinal++; ordinal-1 != n.ordinal {
+				return nil
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			if _, err := io.Copy(pw, rc); err != nil {
+				return err
+			}
+			return errFound
+		})
+		if errors.Is(err, errFound) {
+			err = nil
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// errFound stops a walk once the wanted entry has been read
+var errFound = errors.New("entry found")
+
+// fsFileInfo describes a node under the name it was looked up by
+type fsFileInfo struct {
+	name string
+	node *fsNode
+}
+
+func (i *fsFileInfo) Name() string               { return i.name }
+func (i *fsFileInfo) Size() int64                { return i.node.size }
+func (i *fsFileInfo) Mode() fs.FileMode          { return i.node.mode }
+func (i *fsFileInfo) ModTime() time.Time         { return i.node.modTime }
+func (i *fsFileInfo) IsDir() bool                { return i.node.mode.IsDir() }
+func (i *fsFileInfo) Sys() any                   { return nil }
+func (i *fsFileInfo) Type() fs.FileMode          { return i.node.mode.Type() }
+func (i *fsFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i *fsFileInfo) String() string             { return fs.FormatFileInfo(i) }
+
+// fsFile is an open file. It is opened on the first read, and seeking
+// back reopens it, since most entries can only be read from the start.
+// Reopening a spooled entry only starts another read of its copy.
+type fsFile struct {
+	fsys   *archiveFS
+	info   *fsFileInfo
+	rc     io.ReadCloser
+	pos    int64 // read position of rc
+	offset int64 // position the next read starts at
+	closed bool
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	return f.info, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	if f.offset >= f.info.node.size {
+		return 0, io.EOF
+	}
+
+	if f.rc != nil && f.offset < f.pos {
+		f.rc.Close()
+		f.rc = nil
+	}
+	if f.rc == nil {
+		rc, err := f.fsys.openEntry(f.info.node)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+		f.rc, f.pos = rc, 0
+	}
+	if f.offset > f.pos {
+		n, err := io.CopyN(io.Discard, f.rc, f.offset-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+	}
+
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.node.size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *fsFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+// fsDir is an open directory
+type fsDir struct {
+	fsys   *archiveFS
+	info   *fsFileInfo
+	offset int
+	closed bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.info.name, Err: fs.ErrClosed}
+	}
+	return d.info, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.info.name, Err: fs.ErrClosed}
+	}
+	children := d.info.node.children[d.offset:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		children = children[:min(count, len(children))]
+	}
+	d.offset += len(children)
+	return dirEntries(children), nil
+}
+
+func (d *fsDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.info.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
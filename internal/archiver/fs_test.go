@@ -0,0 +1,182 @@
+package archiver
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"zipprine/internal/models"
+)
+
+func TestOpenFS(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	createTestFiles(t, sourceDir)
+
+	tests := []struct {
+		name        string
+		archiveType models.ArchiveType
+		ext         string
+	}{
+		{"zip", models.ZIP, ".zip"},
+		{"tar", models.TAR, ".tar"},
+		{"tar.gz", models.TARGZ, ".tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := filepath.Join(tmpDir, "test"+tt.ext)
+			if err := Compress(&models.CompressConfig{
+				SourcePath:  sourceDir,
+				OutputPath:  archivePath,
+				ArchiveType: tt.archiveType,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			fsys, err := OpenFS(archivePath)
+			if err != nil {
+				t.Fatalf("OpenFS() failed: %v", err)
+			}
+			defer fsys.(io.Closer).Close()
+
+			if err := fstest.TestFS(fsys, "test1.txt", "test2.go", "subdir/test3.txt"); err != nil {
+				t.Fatal(err)
+			}
+			data, err := fs.ReadFile(fsys, "subdir/test3.txt")
+			if err != nil || string(data) != "Nested file" {
+				t.Errorf("ReadFile() = %q, %v", data, err)
+			}
+			if _, err := fsys.Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Open() of a missing file: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenFSRar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docs.rar")
+	content := strings.Repeat("rar content\n", 50)
+	writeRar5Volumes(t, []string{path}, "docs/readme.txt", content)
+
+	fsys, err := OpenFS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "docs/readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := fs.ReadFile(fsys, "docs/readme.txt")
+	if string(data) != content {
+		t.Errorf("read %d bytes, want %d", len(data), len(content))
+	}
+}
+
+func TestOpenFSSpoolsCompressedEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	createTestFiles(t, sourceDir)
+
+	archivePath := filepath.Join(tmpDir, "test.tar.gz")
+	if err := Compress(&models.CompressConfig{
+		SourcePath:  sourceDir,
+		OutputPath:  archivePath,
+		ArchiveType: models.TARGZ,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := OpenFS(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(fsys, "subdir/test3.txt"); err != nil || string(data) != "Nested file" {
+		t.Fatalf("ReadFile() = %q, %v", data, err)
+	}
+
+	// With the archive gone, only the kept copy can serve the entry again
+	os.Remove(archivePath)
+	f, err := fsys.Open("subdir/test3.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := f.(io.ReadSeeker)
+	io.ReadAll(rs)
+	rs.Seek(7, io.SeekStart)
+	if rest, err := io.ReadAll(rs); err != nil || string(rest) != "file" {
+		t.Errorf("read after seeking back = %q, %v", rest, err)
+	}
+	f.Close()
+
+	spoolDir := fsys.(*archiveFS).spoolDir
+	fsys.(io.Closer).Close()
+	if _, err := os.Stat(spoolDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("spooled entries left behind in %s: %v", spoolDir, err)
+	}
+}
+
+func TestOpenFSLinksAndOddNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "odd.tar.gz")
+	file, _ := os.Create(path)
+	gz, _ := newGzipWriter(file, &models.CompressConfig{Threads: 1}, nil)
+	tw := tar.NewWriter(gz)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, h := range []*tar.Header{
+		{Name: "./a/b.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5, ModTime: modTime},
+		{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4, ModTime: modTime},
+		{Name: "a/b.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 6, ModTime: modTime},
+		{Name: "a/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: modTime},
+		{Name: "hard", Typeflag: tar.TypeLink, Linkname: "a/b.txt", ModTime: modTime},
+		{Name: "soft", Typeflag: tar.TypeSymlink, Linkname: "a", ModTime: modTime},
+		{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "loop", ModTime: modTime},
+		{Name: "out", Typeflag: tar.TypeSymlink, Linkname: "../../etc", ModTime: modTime},
+	} {
+		tw.WriteHeader(h)
+		tw.Write([]byte("later!"[:h.Size]))
+	}
+	tw.Close()
+	gz.Close()
+	file.Close()
+
+	fsys, err := OpenFS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The later a/b.txt replaces the first, the directory entry keeps its
+	// contents, and the hard link reads the file it points to
+	for _, name := range []string{"a/b.txt", "hard", "soft/b.txt"} {
+		if data, err := fs.ReadFile(fsys, name); err != nil || string(data) != "later!" {
+			t.Errorf("ReadFile(%q) = %q, %v", name, data, err)
+		}
+	}
+	if info, err := fs.Stat(fsys, "a"); err != nil || info.Mode() != fs.ModeDir|0700 {
+		t.Errorf("Stat(a) = %v, %v", info, err)
+	}
+	if target, err := fsys.(fs.ReadLinkFS).ReadLink("soft"); err != nil || target != "a" {
+		t.Errorf("ReadLink(soft) = %q, %v", target, err)
+	}
+
+	entries, _ := fs.ReadDir(fsys, ".")
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != "a,hard,loop,out,soft" {
+		t.Errorf("ReadDir(.) = %v", names)
+	}
+
+	for _, name := range []string{"loop", "out", "../evil.txt", "evil.txt"} {
+		if _, err := fsys.Open(name); err == nil {
+			t.Errorf("Open(%q) should fail", name)
+		}
+	}
+}
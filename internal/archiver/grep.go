@@ -0,0 +1,200 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"runtime"
+	"sync/atomic"
+
+	"zipprine/internal/models"
+)
+
+// binarySniffSize is how much of an entry is checked for NUL bytes to tell
+// binary files from text, as grep does
+const binarySniffSize = 8000
+
+// grepMaxLine caps how much of a line is kept and matched, so a huge entry
+// without newlines cannot take unbounded memory. The rest of a longer line is
+// read and dropped.
+const grepMaxLine = 1 << 20
+
+// Grep searches the entries of archives for lines matching a regular
+// expression and writes each match to out as archive:entry:line:text.
+// Archives are searched in parallel on the batch engine and their matches
+// are written in the order the archives were given. An archive that cannot
+// be searched does not stop the others; its error is returned along with
+// the number of matching lines.
+func Grep(config *models.GrepConfig, out io.Writer) (int, error) {
+	pattern := config.Pattern
+	if config.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pattern: %w", err)
+	}
+	for _, glob := range config.Entries {
+		if _, err := path.Match(glob, ""); err != nil {
+			return 0, fmt.Errorf("invalid entry pattern %q: %w", glob, err)
+		}
+	}
+
+	var matches atomic.Int64
+	jobs := make([]*BatchJob, len(config.Archives))
+	for i, archive := range config.Archives {
+		jobs[i] = &BatchJob{
+			Name: archive,
+			Run: func(w io.Writer) error {
+				g := &grepper{config: config, re: re, out: w, limits: defaultExtractLimits()}
+				err := g.searchFile(archive, archive+":", 0)
+				matches.Add(g.matches)
+				return err
+			},
+		}
+	}
+
+	threads := config.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	results, err := RunBatch(jobs, &BatchOptions{
+		Parallel:   true,
+		MaxWorkers: threads,
+		Output:     out,
+		Ordered:    true,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	for _, result := range results {
+		if result.Failed() {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Name, result.Err))
+		}
+	}
+	return int(matches.Load()), errors.Join(errs...)
+}
+
+// grepper searches one archive given to Grep, including what is nested in
+// it. The nested archives it spools count against limits.
+type grepper struct {
+	config  *models.GrepConfig
+	re      *regexp.Regexp
+	out     io.Writer
+	limits  *models.ExtractLimits
+	matches int64
+}
+
+// searchFile searches every entry of an archive on disk. Matches are shown
+// as prefix followed by the entry name.
+func (g *grepper) searchFile(archivePath, prefix string, depth int) error {
+	archiveType, err := DetectArchiveType(archivePath)
+	if err != nil {
+		return err
+	}
+	return walkArchive(archivePath, archiveType, func(entry *archiveEntry) error {
+		if !entry.IsRegular() {
+			return nil
+		}
+		if entry.zipFile != nil && entry.zipFile.Flags&0x1 != 0 {
+			fmt.Fprintf(stderr, "  ⚠ Skipping encrypted entry %s%s\n", prefix, entry.Name)
+			return nil
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			fmt.Fprintf(stderr, "  ⚠ Skipping unreadable entry %s%s: %v\n", prefix, entry.Name, err)
+			return nil
+		}
+		defer rc.Close()
+		if err := g.searchEntry(bufio.NewReaderSize(rc, sniffSize), entry.Name, prefix, depth); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		return nil
+	})
+}
+
+func (g *grepper) searchEntry(r *bufio.Reader, name, prefix string, depth int) error {
+	if g.config.Nested && depth < maxNestedDepth {
+		if archiveType, _ := DetectArchiveTypeFromReader(r); archiveType != models.AUTO {
+			return g.searchNested(r, name, prefix+name+"/", depth+1)
+		}
+	}
+
+	if !g.wanted(name) {
+		return nil
+	}
+	if !g.config.Binary {
+		head, _ := r.Peek(binarySniffSize)
+		if bytes.IndexByte(head, 0) >= 0 {
+			return nil
+		}
+	}
+
+	var line []byte
+	for lineNo := 1; ; lineNo++ {
+		var err error
+		line, err = readLine(r, line[:0])
+		if len(line) > 0 {
+			line = bytes.TrimRight(line, "\r\n")
+			if g.re.Match(line) {
+				g.matches++
+				fmt.Fprintf(g.out, "%s%s:%d:%s\n", prefix, name, lineNo, line)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine appends the next line of r to line, keeping at most grepMaxLine
+// bytes of it
+func readLine(r *bufio.Reader, line []byte) ([]byte, error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if room := grepMaxLine - len(line); room > 0 {
+			line = append(line, chunk[:min(len(chunk), room)]...)
+		}
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// searchNested spools an archive found inside another to a temporary file
+// and searches it
+func (g *grepper) searchNested(r io.Reader, name, prefix string, depth int) error {
+	nestedPath, cleanup, err := spoolNested(r, name, g.limits)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return g.searchFile(nestedPath, prefix, depth)
+}
+
+// wanted reports whether an entry matches the entry globs, by its full
+// path or by its base name
+func (g *grepper) wanted(name string) bool {
+	if len(g.config.Entries) == 0 {
+		return true
+	}
+	name = cleanEntryName(name)
+	for _, glob := range g.config.Entries {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
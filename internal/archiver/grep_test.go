@@ -0,0 +1,139 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+func TestGrep(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(tmpDir, "src", filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+	write("app.log", "start\nERROR disk full\nok\r\nerror: retry\n")
+	write("logs/worker.txt", "worker ERROR timeout")
+	write("core.bin", "ERROR\x00\x01binary")
+
+	// A log bundle inside the bundle
+	inner := filepath.Join(tmpDir, "inner")
+	os.MkdirAll(inner, 0755)
+	os.WriteFile(filepath.Join(inner, "db.log"), []byte("db ERROR locked\n"), 0644)
+	if err := Compress(&models.CompressConfig{
+		SourcePath:  inner,
+		OutputPath:  filepath.Join(tmpDir, "src", "nested.tar.gz"),
+		ArchiveType: models.TARGZ,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := filepath.Join(tmpDir, "first.zip")
+	second := filepath.Join(tmpDir, "second.tar")
+	for path, archiveType := range map[string]models.ArchiveType{first: models.ZIP, second: models.TAR} {
+		if err := Compress(&models.CompressConfig{
+			SourcePath:  filepath.Join(tmpDir, "src"),
+			OutputPath:  path,
+			ArchiveType: archiveType,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		config models.GrepConfig
+		want   []string
+	}{
+		{
+			name:   "regex",
+			config: models.GrepConfig{Archives: []string{first}, Pattern: "ERROR (disk|timeout)"},
+			want:   []string{first + ":app.log:2:ERROR disk full", first + ":logs/worker.txt:1:worker ERROR timeout"},
+		},
+		{
+			name:   "ignore_case_and_entry_glob",
+			config: models.GrepConfig{Archives: []string{first}, Pattern: "error", IgnoreCase: true, Entries: []string{"*.log"}},
+			want:   []string{first + ":app.log:2:ERROR disk full", first + ":app.log:4:error: retry"},
+		},
+		{
+			name:   "binary_and_nested",
+			config: models.GrepConfig{Archives: []string{first}, Pattern: "^(db )?ERROR", Nested: true, Binary: true},
+			want:   []string{first + ":app.log:2:ERROR disk full", first + ":core.bin:1:ERROR\x00\x01binary", first + ":nested.tar.gz/db.log:1:db ERROR locked"},
+		},
+		{
+			name:   "archives_in_order",
+			config: models.GrepConfig{Archives: []string{second, first}, Pattern: "timeout", Threads: 2},
+			want:   []string{second + ":logs/worker.txt:1:worker ERROR timeout", first + ":logs/worker.txt:1:worker ERROR timeout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := Grep(&tt.config, &out)
+			if err != nil {
+				t.Fatalf("Grep() failed: %v", err)
+			}
+			got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			// Entry order within an archive is storage order, which may differ by format
+			if n != len(tt.want) || !slices.Equal(slices.Sorted(slices.Values(got)), slices.Sorted(slices.Values(tt.want))) {
+				t.Errorf("Grep() = %d matches:\n%s\nwant:\n%s", n, out.String(), strings.Join(tt.want, "\n"))
+			}
+			if tt.name == "archives_in_order" && got[0] != tt.want[0] {
+				t.Errorf("matches are not in archive order: %q", got)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	n, err := Grep(&models.GrepConfig{Archives: []string{filepath.Join(tmpDir, "missing.zip"), first}, Pattern: "timeout"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "missing.zip") || n != 1 {
+		t.Errorf("Grep() with a missing archive = %d, %v", n, err)
+	}
+	if _, err := Grep(&models.GrepConfig{Archives: []string{first}, Pattern: "("}, &out); err == nil {
+		t.Error("Grep() accepted an invalid pattern")
+	}
+}
+
+func TestGrepSkipsEncryptedAndCapsLines(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := filepath.Join(tmpDir, "mixed.zip")
+
+	file, _ := os.Create(zipPath)
+	zw := zip.NewWriter(file)
+	writeZipCryptoEntry(t, zw, "secret.txt", "hunter2", []byte("needle in the vault\n"))
+	fw, _ := zw.Create("huge.txt")
+	fw.Write([]byte("needle" + strings.Repeat("x", 3*grepMaxLine) + "\nneedle again\n"))
+	zw.Close()
+	file.Close()
+
+	var warnings bytes.Buffer
+	oldErr := stderr
+	stderr = &warnings
+	t.Cleanup(func() { stderr = oldErr })
+
+	var out bytes.Buffer
+	n, err := Grep(&models.GrepConfig{Archives: []string{zipPath}, Pattern: "^needle"}, &out)
+	if err != nil {
+		t.Fatalf("Grep() failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if n != 2 || len(lines) != 2 {
+		t.Fatalf("Grep() = %d matches, %d lines; want 2", n, len(lines))
+	}
+	if prefix := zipPath + ":huge.txt:1:"; len(lines[0]) != len(prefix)+grepMaxLine {
+		t.Errorf("long line printed as %d bytes; want %d", len(lines[0]), len(prefix)+grepMaxLine)
+	}
+	if want := zipPath + ":huge.txt:2:needle again"; lines[1] != want {
+		t.Errorf("second match = %q; want %q", lines[1], want)
+	}
+	if !strings.Contains(warnings.String(), "secret.txt") {
+		t.Errorf("no warning about the encrypted entry: %q", warnings.String())
+	}
+}
@@ -0,0 +1,814 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"zipprine/internal/models"
+)
+
+// errCannotAppend means a ZIP's layout does not allow appending in place and
+// the archive has to be rewritten instead
+var errCannotAppend = errors.New("archive cannot be appended in place")
+
+// pendingFile is a file or directory from disk waiting to be written to an archive
+type pendingFile struct {
+	path string
+	name string
+	info os.FileInfo
+}
+
+// modifyPlan records which existing entries are dropped and which files are written
+type modifyPlan struct {
+	remove map[string]bool
+	write  []pendingFile
+}
+
+func (p *modifyPlan) empty() bool {
+	return len(p.remove) == 0 && len(p.write) == 0
+}
+
+// AddToArchive adds files to an existing archive, replacing entries that
+// have the same name
+func AddToArchive(config *models.UpdateConfig) error {
+	addConfig := *config
+	addConfig.OnlyNewer = false
+	return modifyArchive(&addConfig, nil)
+}
+
+// UpdateArchive adds new files to an existing archive and replaces existing
+// entries only when the file on disk is newer or different
+func UpdateArchive(config *models.UpdateConfig) error {
+	updateConfig := *config
+	updateConfig.OnlyNewer = true
+	return modifyArchive(&updateConfig, nil)
+}
+
+// DeleteFromArchive removes the named entries from an archive. Naming a
+// directory removes everything inside it.
+func DeleteFromArchive(archivePath string, archiveType models.ArchiveType, names []string) error {
+	return modifyArchive(&models.UpdateConfig{
+		ArchivePath: archivePath,
+		ArchiveType: archiveType,
+	}, names)
+}
+
+func modifyArchive(config *models.UpdateConfig, deletes []string) error {
+	archiveType := config.ArchiveType
+	if archiveType == models.AUTO || archiveType == "" {
+		detected, err := DetectArchiveType(config.ArchivePath)
+		if err != nil {
+			return err
+		}
+		archiveType = detected
+	}
+
+	files, err := collectSources(config)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(deletes))
+	for i, name := range deletes {
+		keys[i] = entryKey(name)
+	}
+
+	switch archiveType {
+	case models.ZIP:
+		return modifyZip(config, files, keys)
+	case models.TAR:
+		return modifyTar(config, files, keys, false)
+	case models.TARGZ:
+		return modifyTar(config, files, keys, true)
+	default:
+		return fmt.Errorf("modifying %s archives is not supported", archiveType)
+	}
+}
+
+// collectSources lists the files selected by an update, leaving out the
+// archive itself in case it lives inside one of the sources
+func collectSources(config *models.UpdateConfig) ([]pendingFile, error) {
+	if len(config.Sources) == 0 {
+		return nil, nil
+	}
+
+	archiveInfo, err := os.Stat(config.ArchivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	compressConfig := &models.CompressConfig{
+		Sources:      config.Sources,
+		ExcludePaths: config.ExcludePaths,
+		IncludePaths: config.IncludePaths,
+		UseGitignore: config.UseGitignore,
+	}
+
+	var files []pendingFile
+	err = walkSources(compressConfig, func(path, name string, info os.FileInfo) error {
+		if name == "." || os.SameFile(info, archiveInfo) {
+			return nil
+		}
+		files = append(files, pendingFile{path: path, name: name, info: info})
+		return nil
+	})
+	return files, err
+}
+
+// entryKey normalizes an entry name for comparisons between archive members
+// and files on disk
+func entryKey(name string) string {
+	return strings.TrimSuffix(cleanEntryName(name), "/")
+}
+
+// buildPlan decides what happens to each existing entry, given the keys of
+// the entries in the archive. changed reports whether a file on disk differs
+// from the entry with the same key.
+func buildPlan(files []pendingFile, deletes []string, keys []string, onlyNewer, withDirs bool,
+	changed func(key string, file pendingFile) bool) (*modifyPlan, error) {
+
+	plan := &modifyPlan{remove: map[string]bool{}}
+
+	existing := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		existing[key] = true
+	}
+
+	for _, file := range files {
+		key := entryKey(file.name)
+		if file.info.IsDir() {
+			if withDirs && !existing[key] {
+				plan.write = append(plan.write, file)
+			}
+			continue
+		}
+
+		if existing[key] {
+			if onlyNewer && !changed(key, file) {
+				continue
+			}
+			plan.remove[key] = true
+		}
+		plan.write = append(plan.write, file)
+	}
+
+	for _, name := range deletes {
+		matched := false
+		for _, key := range keys {
+			if key == name || strings.HasPrefix(key, name+"/") {
+				plan.remove[key] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, name)
+		}
+	}
+
+	return plan, nil
+}
+
+// fileIsNewer reports whether a file on disk differs in size from an entry or
+// was modified after it
+func fileIsNewer(info os.FileInfo, size int64, modTime int64) bool {
+	return info.Size() != size || info.ModTime().Unix() > modTime
+}
+
+func modifyZip(config *models.UpdateConfig, files []pendingFile, deletes []string) error {
+	file, err := os.OpenFile(config.ArchivePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]*zip.File, len(zr.File))
+	keys := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		key := entryKey(f.Name)
+		entries[key] = f
+		keys = append(keys, key)
+	}
+
+	plan, err := buildPlan(files, deletes, keys, config.OnlyNewer, false, func(key string, pf pendingFile) bool {
+		f := entries[key]
+		if fileIsNewer(pf.info, int64(f.UncompressedSize64), f.Modified.Unix()) {
+			return true
+		}
+		crc, err := fileCRC32(pf.path)
+		return err != nil || crc != f.CRC32
+	})
+	if err != nil {
+		return err
+	}
+	if plan.empty() {
+		fmt.Println("  ✓ Archive is already up to date")
+		return nil
+	}
+
+	if len(plan.remove) == 0 {
+		err := appendZip(file, zr, stat.Size(), plan.write, config.CompressionLevel)
+		if !errors.Is(err, errCannotAppend) {
+			return err
+		}
+	}
+
+	tmpPath, err := rewriteZip(config.ArchivePath, zr, plan, config.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return replaceFile(tmpPath, config.ArchivePath)
+}
+
+func fileCRC32(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), nil
+}
+
+// appendZip writes new entries where the central directory used to start,
+// then writes the old directory records, the new ones and a fresh end record.
+// Existing entry data is never rewritten.
+func appendZip(file *os.File, zr *zip.Reader, size int64, write []pendingFile, level int) (err error) {
+	dirOffset, dirSize, err := locateZipDirectory(file, size)
+	if err != nil {
+		return err
+	}
+
+	// The directory and end records are overwritten by the new entries, so
+	// keep them to put back if writing fails
+	tail := make([]byte, size-dirOffset)
+	if _, err := file.ReadAt(tail, dirOffset); err != nil {
+		return err
+	}
+	oldDir := tail[:dirSize]
+	if len(zr.File) > 0 && binary.LittleEndian.Uint32(oldDir) != zipDirectorySignature {
+		return errCannotAppend
+	}
+
+	if err := openSources(write); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, restoreTail(file, dirOffset, tail))
+		}
+	}()
+
+	if _, err := file.Seek(dirOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	zipWriter := newZipWriter(file, level)
+	zipWriter.SetOffset(dirOffset)
+	for _, pf := range write {
+		fmt.Printf("  → %s\n", pf.name)
+		if err := writeZipFile(zipWriter, pf.path, pf.name, pf.info); err != nil {
+			return err
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+
+	// Read back the directory records zip.Writer produced for the new entries
+	end, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(end); err != nil {
+		return err
+	}
+	newDirOffset, newDirSize, err := locateZipDirectory(file, end)
+	if err != nil {
+		return err
+	}
+	newDir := make([]byte, newDirSize)
+	if _, err := file.ReadAt(newDir, newDirOffset); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(newDirOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := file.Write(oldDir); err != nil {
+		return err
+	}
+	if _, err := file.Write(newDir); err != nil {
+		return err
+	}
+	entries := uint64(len(zr.File) + len(write))
+	if err := writeZipDirectoryEnd(file, entries, newDirOffset, dirSize+newDirSize, zr.Comment); err != nil {
+		return err
+	}
+
+	end, err = file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return file.Truncate(end)
+}
+
+// rewriteZip copies the entries that are kept into a temporary archive
+// without recompressing them, then writes the new files
+func rewriteZip(archivePath string, zr *zip.Reader, plan *modifyPlan, level int) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(archivePath), ".zipprine-*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	err = func() error {
+		zipWriter := newZipWriter(tmp, level)
+		for _, f := range zr.File {
+			if plan.remove[entryKey(f.Name)] {
+				fmt.Printf("  ✗ %s\n", f.Name)
+				continue
+			}
+			if err := zipWriter.Copy(f); err != nil {
+				return err
+			}
+		}
+
+		for _, pf := range plan.write {
+			fmt.Printf("  → %s\n", pf.name)
+			if err := writeZipFile(zipWriter, pf.path, pf.name, pf.info); err != nil {
+				return err
+			}
+		}
+
+		if err := zipWriter.SetComment(zr.Comment); err != nil {
+			return err
+		}
+		if err := zipWriter.Close(); err != nil {
+			return err
+		}
+		return tmp.Close()
+	}()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// ZIP record signatures
+const (
+	zipDirectorySignature      = 0x02014b50
+	zipDirectoryEndSignature   = 0x06054b50
+	zip64DirectoryEndSignature = 0x06064b50
+	zip64LocatorSignature      = 0x07064b50
+)
+
+const (
+	zipDirectoryEndLen   = 22
+	zip64DirectoryEndLen = 56
+	zip64LocatorLen      = 20
+)
+
+// locateZipDirectory finds the central directory from the end records,
+// following the ZIP64 locator when the classic record is saturated
+func locateZipDirectory(r io.ReaderAt, size int64) (offset, length int64, err error) {
+	tailLen := int64(zipDirectoryEndLen + 65535 + zip64LocatorLen)
+	if tailLen > size {
+		tailLen = size
+	}
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+
+	endPos := -1
+	for i := len(tail) - zipDirectoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != zipDirectoryEndSignature {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if i+zipDirectoryEndLen+commentLen == len(tail) {
+			endPos = i
+			break
+		}
+	}
+	if endPos < 0 {
+		return 0, 0, errCannotAppend
+	}
+
+	end := tail[endPos:]
+	entries := binary.LittleEndian.Uint16(end[10:])
+	length = int64(binary.LittleEndian.Uint32(end[12:]))
+	offset = int64(binary.LittleEndian.Uint32(end[16:]))
+	recordsStart := size - tailLen + int64(endPos)
+
+	if entries == 0xFFFF || length == 0xFFFFFFFF || offset == 0xFFFFFFFF {
+		if endPos < zip64LocatorLen {
+			return 0, 0, errCannotAppend
+		}
+		locator := tail[endPos-zip64LocatorLen : endPos]
+		if binary.LittleEndian.Uint32(locator) != zip64LocatorSignature {
+			return 0, 0, errCannotAppend
+		}
+		zip64EndOffset := int64(binary.LittleEndian.Uint64(locator[8:]))
+
+		zip64End := make([]byte, zip64DirectoryEndLen)
+		if _, err := r.ReadAt(zip64End, zip64EndOffset); err != nil {
+			return 0, 0, err
+		}
+		if binary.LittleEndian.Uint32(zip64End) != zip64DirectoryEndSignature {
+			return 0, 0, errCannotAppend
+		}
+		length = int64(binary.LittleEndian.Uint64(zip64End[40:]))
+		offset = int64(binary.LittleEndian.Uint64(zip64End[48:]))
+		recordsStart = zip64EndOffset
+	}
+
+	// Anything between the directory and the end records (or a directory
+	// that does not start where it claims) means the offsets are not plain
+	if offset+length != recordsStart {
+		return 0, 0, errCannotAppend
+	}
+	return offset, length, nil
+}
+
+// writeZipDirectoryEnd writes the end of central directory record, preceded
+// by the ZIP64 record and locator when the values do not fit in 16/32 bits
+func writeZipDirectoryEnd(w io.Writer, entries uint64, dirOffset, dirSize int64, comment string) error {
+	needZip64 := entries >= 0xFFFF || dirSize >= 0xFFFFFFFF || dirOffset >= 0xFFFFFFFF
+
+	var buf []byte
+	if needZip64 {
+		zip64End := make([]byte, zip64DirectoryEndLen)
+		binary.LittleEndian.PutUint32(zip64End[0:], zip64DirectoryEndSignature)
+		binary.LittleEndian.PutUint64(zip64End[4:], zip64DirectoryEndLen-12)
+		binary.LittleEndian.PutUint16(zip64End[12:], 45) // version made by
+		binary.LittleEndian.PutUint16(zip64End[14:], 45) // version needed
+		binary.LittleEndian.PutUint64(zip64End[24:], entries)
+		binary.LittleEndian.PutUint64(zip64End[32:], entries)
+		binary.LittleEndian.PutUint64(zip64End[40:], uint64(dirSize))
+		binary.LittleEndian.PutUint64(zip64End[48:], uint64(dirOffset))
+
+		locator := make([]byte, zip64LocatorLen)
+		binary.LittleEndian.PutUint32(locator[0:], zip64LocatorSignature)
+		binary.LittleEndian.PutUint64(locator[8:], uint64(dirOffset+dirSize))
+		binary.LittleEndian.PutUint32(locator[16:], 1) // total disks
+
+		buf = append(buf, zip64End...)
+		buf = append(buf, locator...)
+	}
+
+	end := make([]byte, zipDirectoryEndLen)
+	binary.LittleEndian.PutUint32(end[0:], zipDirectoryEndSignature)
+	binary.LittleEndian.PutUint16(end[8:], uint16(min(entries, 0xFFFF)))
+	binary.LittleEndian.PutUint16(end[10:], uint16(min(entries, 0xFFFF)))
+	binary.LittleEndian.PutUint32(end[12:], uint32(min(uint64(dirSize), 0xFFFFFFFF)))
+	binary.LittleEndian.PutUint32(end[16:], uint32(min(uint64(dirOffset), 0xFFFFFFFF)))
+	binary.LittleEndian.PutUint16(end[20:], uint16(len(comment)))
+	buf = append(buf, end...)
+	buf = append(buf, comment...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// offsetReader tracks the read position of a seekable file so tar entry
+// boundaries can be located. It stays seekable so tar.Reader skips entry data.
+type offsetReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.pos += int64(n)
+	return n, err
+}
+
+func (o *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.r.Seek(offset, whence)
+	if err == nil {
+		o.pos = pos
+	}
+	return pos, err
+}
+
+// tarHeaderOnly reports whether entries of this type carry no data blocks
+func tarHeaderOnly(typeflag byte) bool {
+	switch typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return true
+	}
+	return false
+}
+
+func tarIsSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// scanTar reads every header of a tar archive. For uncompressed archives it
+// also returns the offset where the end-of-archive trailer starts.
+func scanTar(path string, isGzipped bool) (map[string]*tar.Header, []string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer file.Close()
+
+	reader := &offsetReader{r: file}
+	var src io.Reader = reader
+	if isGzipped {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		defer gzReader.Close()
+		src = gzReader
+	}
+
+	headers := map[string]*tar.Header{}
+	var keys []string
+	var trailer int64
+
+	tarReader := tar.NewReader(src)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		key := entryKey(header.Name)
+		headers[key] = header
+		keys = append(keys, key)
+
+		if isGzipped {
+			continue
+		}
+		if tarIsSparse(header) {
+			if _, err := io.Copy(io.Discard, tarReader); err != nil {
+				return nil, nil, 0, err
+			}
+			trailer = roundToBlock(reader.pos)
+			continue
+		}
+		size := header.Size
+		if tarHeaderOnly(header.Typeflag) {
+			size = 0
+		}
+		trailer = reader.pos + roundToBlock(size)
+	}
+
+	return headers, keys, trailer, nil
+}
+
+func roundToBlock(n int64) int64 {
+	const blockSize = 512
+	return (n + blockSize - 1) / blockSize * blockSize
+}
+
+func modifyTar(config *models.UpdateConfig, files []pendingFile, deletes []string, isGzipped bool) error {
+	headers, keys, trailer, err := scanTar(config.ArchivePath, isGzipped)
+	if err != nil {
+		return err
+	}
+
+	plan, err := buildPlan(files, deletes, keys, config.OnlyNewer, true, func(key string, pf pendingFile) bool {
+		header := headers[key]
+		return fileIsNewer(pf.info, header.Size, header.ModTime.Unix())
+	})
+	if err != nil {
+		return err
+	}
+	if plan.empty() {
+		fmt.Println("  ✓ Archive is already up to date")
+		return nil
+	}
+
+	if !isGzipped && len(plan.remove) == 0 {
+		return appendTar(config.ArchivePath, trailer, plan.write)
+	}
+	return rewriteTar(config.ArchivePath, isGzipped, plan, config.CompressionLevel)
+}
+
+// appendTar writes new entries over the end-of-archive trailer of an
+// uncompressed tar and terminates it again
+func appendTar(archivePath string, trailer int64, write []pendingFile) (err error) {
+	file, err := os.OpenFile(archivePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	// Keep the trailer to put back if writing fails
+	tail := make([]byte, max(stat.Size()-trailer, 0))
+	if _, err := file.ReadAt(tail, trailer); err != nil {
+		return err
+	}
+
+	if err := openSources(write); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, restoreTail(file, trailer, tail))
+		}
+	}()
+
+	if _, err := file.Seek(trailer, io.SeekStart); err != nil {
+		return err
+	}
+
+	tarWriter := tar.NewWriter(file)
+	for _, pf := range write {
+		if !pf.info.IsDir() {
+			fmt.Printf("  → %s\n", pf.name)
+		}
+		if err := writeTarEntry(tarWriter, pf.path, pf.name, pf.info); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+
+	end, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return file.Truncate(end)
+}
+
+// openSources checks that every file to be appended can be opened, before
+// an in-place append starts overwriting the end of the archive
+func openSources(write []pendingFile) error {
+	for _, pf := range write {
+		if pf.info.IsDir() {
+			continue
+		}
+		f, err := openSourceFile(pf.path, pf.info)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// restoreTail writes back the end of an archive that a failed in-place
+// append overwrote, leaving the archive as it was
+func restoreTail(file *os.File, offset int64, tail []byte) error {
+	if _, err := file.WriteAt(tail, offset); err != nil {
+		return fmt.Errorf("failed to restore the archive: %w", err)
+	}
+	return file.Truncate(offset + int64(len(tail)))
+}
+
+// rewriteTar streams the kept entries into a temporary archive, appends the
+// new files and replaces the original
+func rewriteTar(archivePath string, isGzipped bool, plan *modifyPlan, level int) error {
+	src, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(archivePath), ".zipprine-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		var in io.Reader = src
+		var out io.Writer = tmp
+		var gzWriter *gzip.Writer
+		if isGzipped {
+			gzReader, err := gzip.NewReader(src)
+			if err != nil {
+				return err
+			}
+			defer gzReader.Close()
+			in = gzReader
+
+			if level <= 0 {
+				level = gzip.DefaultCompression
+			}
+			gzWriter, err = gzip.NewWriterLevel(tmp, level)
+			if err != nil {
+				return err
+			}
+			out = gzWriter
+		}
+
+		tarReader := tar.NewReader(in)
+		tarWriter := tar.NewWriter(out)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			if plan.remove[entryKey(header.Name)] {
+				fmt.Printf("  ✗ %s\n", header.Name)
+				continue
+			}
+
+			// Sparse entries are written back expanded as regular files,
+			// without the PAX records that described the old sparse map
+			if tarIsSparse(header) {
+				header.Typeflag = tar.TypeReg
+				for key := range header.PAXRecords {
+					if strings.HasPrefix(key, "GNU.sparse.") {
+						delete(header.PAXRecords, key)
+					}
+				}
+			}
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tarWriter, tarReader); err != nil {
+				return err
+			}
+		}
+
+		for _, pf := range plan.write {
+			if !pf.info.IsDir() {
+				fmt.Printf("  → %s\n", pf.name)
+			}
+			if err := writeTarEntry(tarWriter, pf.path, pf.name, pf.info); err != nil {
+				return err
+			}
+		}
+
+		if err := tarWriter.Close(); err != nil {
+			return err
+		}
+		if gzWriter != nil {
+			if err := gzWriter.Close(); err != nil {
+				return err
+			}
+		}
+		return tmp.Close()
+	}()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	src.Close()
+	return replaceFile(tmp.Name(), archivePath)
+}
+
+// replaceFile moves a rewritten archive over the original, keeping its mode
+func replaceFile(tmpPath, archivePath string) error {
+	if info, err := os.Stat(archivePath); err == nil {
+		os.Chmod(tmpPath, info.Mode())
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
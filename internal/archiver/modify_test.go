@@ -0,0 +1,412 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"zipprine/internal/models"
+)
+
+// archiveContents reads every regular entry of an archive into a map
+func archiveContents(t *testing.T, archivePath string) map[string]string {
+	t.Helper()
+
+	info, err := Analyze(archivePath)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	contents := map[string]string{}
+	for _, f := range info.Files {
+		if f.IsDir || f.Name == "." {
+			continue
+		}
+		rc, err := OpenEntry(archivePath, f.Name)
+		if err != nil {
+			t.Fatalf("OpenEntry(%q) failed: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %q: %v", f.Name, err)
+		}
+		contents[f.Name] = string(data)
+	}
+	return contents
+}
+
+func createModifyFixture(t *testing.T, archiveType models.ArchiveType, ext string) (string, string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	createTestFiles(t, sourceDir)
+
+	archivePath := filepath.Join(tmpDir, "archive"+ext)
+	if err := Compress(&models.CompressConfig{
+		SourcePath:       sourceDir,
+		OutputPath:       archivePath,
+		ArchiveType:      archiveType,
+		CompressionLevel: 5,
+	}); err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	return tmpDir, archivePath
+}
+
+var modifyFormats = []struct {
+	name        string
+	archiveType models.ArchiveType
+	ext         string
+}{
+	{"zip", models.ZIP, ".zip"},
+	{"tar", models.TAR, ".tar"},
+	{"tar.gz", models.TARGZ, ".tar.gz"},
+}
+
+func TestAddToArchive(t *testing.T) {
+	for _, tc := range modifyFormats {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, archivePath := createModifyFixture(t, tc.archiveType, tc.ext)
+
+			changelog := filepath.Join(tmpDir, "CHANGELOG.md")
+			os.WriteFile(changelog, []byte("# Changes"), 0644)
+
+			err := AddToArchive(&models.UpdateConfig{
+				ArchivePath:      archivePath,
+				ArchiveType:      models.AUTO,
+				Sources:          []models.SourceMapping{{Path: changelog, Prefix: "docs/CHANGELOG.md"}},
+				CompressionLevel: 5,
+			})
+			if err != nil {
+				t.Fatalf("AddToArchive failed: %v", err)
+			}
+
+			contents := archiveContents(t, archivePath)
+			expected := map[string]string{
+				"test1.txt":         "Hello World",
+				"test2.go":          "package main",
+				"subdir/test3.txt":  "Nested file",
+				"docs/CHANGELOG.md": "# Changes",
+			}
+			for name, want := range expected {
+				if got, ok := contents[name]; !ok || got != want {
+					t.Errorf("Entry %q = %q (present: %v); want %q", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAddToArchiveReplacesExisting(t *testing.T) {
+	for _, tc := range modifyFormats {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, archivePath := createModifyFixture(t, tc.archiveType, tc.ext)
+
+			replacement := filepath.Join(tmpDir, "test1.txt")
+			os.WriteFile(replacement, []byte("Replaced"), 0644)
+
+			if err := AddToArchive(&models.UpdateConfig{
+				ArchivePath: archivePath,
+				Sources:     []models.SourceMapping{{Path: replacement}},
+			}); err != nil {
+				t.Fatalf("AddToArchive failed: %v", err)
+			}
+
+			contents := archiveContents(t, archivePath)
+			if contents["test1.txt"] != "Replaced" {
+				t.Errorf("test1.txt = %q; want %q", contents["test1.txt"], "Replaced")
+			}
+			if contents["subdir/test3.txt"] != "Nested file" {
+				t.Errorf("Untouched entry changed: %q", contents["subdir/test3.txt"])
+			}
+
+			info, _ := Analyze(archivePath)
+			count := 0
+			for _, f := range info.Files {
+				if f.Name == "test1.txt" {
+					count++
+				}
+			}
+			if count != 1 {
+				t.Errorf("Expected a single test1.txt entry, found %d", count)
+			}
+		})
+	}
+}
+
+func TestUpdateArchive(t *testing.T) {
+	for _, tc := range modifyFormats {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, archivePath := createModifyFixture(t, tc.archiveType, tc.ext)
+			sourceDir := filepath.Join(tmpDir, "source")
+
+			// Nothing changed on disk, so the archive must stay byte-identical
+			before, _ := os.ReadFile(archivePath)
+			if err := UpdateArchive(&models.UpdateConfig{
+				ArchivePath: archivePath,
+				Sources:     []models.SourceMapping{{Path: sourceDir}},
+			}); err != nil {
+				t.Fatalf("UpdateArchive failed: %v", err)
+			}
+			after, _ := os.ReadFile(archivePath)
+			if !bytes.Equal(before, after) {
+				t.Error("UpdateArchive rewrote an up-to-date archive")
+			}
+
+			// A newer file and a new file are picked up
+			future := time.Now().Add(time.Hour)
+			changed := filepath.Join(sourceDir, "test2.go")
+			os.WriteFile(changed, []byte("package changed"), 0644)
+			os.Chtimes(changed, future, future)
+			os.WriteFile(filepath.Join(sourceDir, "new.txt"), []byte("new"), 0644)
+
+			if err := UpdateArchive(&models.UpdateConfig{
+				ArchivePath: archivePath,
+				Sources:     []models.SourceMapping{{Path: sourceDir}},
+			}); err != nil {
+				t.Fatalf("UpdateArchive failed: %v", err)
+			}
+
+			contents := archiveContents(t, archivePath)
+			if contents["test2.go"] != "package changed" {
+				t.Errorf("test2.go = %q; want %q", contents["test2.go"], "package changed")
+			}
+			if contents["new.txt"] != "new" {
+				t.Errorf("new.txt = %q; want %q", contents["new.txt"], "new")
+			}
+			if contents["test1.txt"] != "Hello World" {
+				t.Errorf("test1.txt = %q; want %q", contents["test1.txt"], "Hello World")
+			}
+		})
+	}
+}
+
+func TestDeleteFromArchive(t *testing.T) {
+	for _, tc := range modifyFormats {
+		t.Run(tc.name, func(t *testing.T) {
+			_, archivePath := createModifyFixture(t, tc.archiveType, tc.ext)
+
+			deletes := []string{"./subdir/", "test2.go"}
+			if err := DeleteFromArchive(archivePath, models.AUTO, deletes); err != nil {
+				t.Fatalf("DeleteFromArchive failed: %v", err)
+			}
+			if deletes[0] != "./subdir/" {
+				t.Errorf("DeleteFromArchive changed the caller's names to %v", deletes)
+			}
+
+			contents := archiveContents(t, archivePath)
+			var names []string
+			for name := range contents {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if len(names) != 1 || names[0] != "test1.txt" {
+				t.Errorf("Remaining entries = %v; want [test1.txt]", names)
+			}
+
+			err := DeleteFromArchive(archivePath, models.AUTO, []string{"missing.txt"})
+			if !errors.Is(err, ErrEntryNotFound) {
+				t.Errorf("Expected ErrEntryNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAppendZipKeepsExistingData(t *testing.T) {
+	tmpDir, archivePath := createModifyFixture(t, models.ZIP, ".zip")
+
+	before, _ := os.ReadFile(archivePath)
+	r, _ := zip.OpenReader(archivePath)
+	dataEnd, _ := r.File[len(r.File)-1].DataOffset()
+	dataEnd += int64(r.File[len(r.File)-1].CompressedSize64)
+	r.Close()
+
+	extra := filepath.Join(tmpDir, "extra.txt")
+	os.WriteFile(extra, []byte("appended"), 0644)
+	if err := AddToArchive(&models.UpdateConfig{
+		ArchivePath: archivePath,
+		Sources:     []models.SourceMapping{{Path: extra}},
+	}); err != nil {
+		t.Fatalf("AddToArchive failed: %v", err)
+	}
+
+	// Appending must leave existing entry data untouched
+	after, _ := os.ReadFile(archivePath)
+	if !bytes.Equal(before[:dataEnd], after[:dataEnd]) {
+		t.Error("Existing entry data was rewritten by an append")
+	}
+
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatalf("Appended archive is not readable: %v", err)
+	}
+	defer r.Close()
+	if len(r.File) != 4 {
+		t.Errorf("Expected 4 entries after append, got %d", len(r.File))
+	}
+}
+
+func TestFailedAppendKeepsArchive(t *testing.T) {
+	for _, tc := range modifyFormats {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, archivePath := createModifyFixture(t, tc.archiveType, tc.ext)
+			before := archiveContents(t, archivePath)
+
+			// A file larger than the directory it would overwrite, followed
+			// by one that cannot be read
+			extra := filepath.Join(tmpDir, "extra")
+			os.Mkdir(extra, 0755)
+			os.WriteFile(filepath.Join(extra, "big.bin"), bytes.Repeat([]byte("zipprine"), 25*1024), 0644)
+			socket, err := net.Listen("unix", filepath.Join(extra, "socket"))
+			if err != nil {
+				t.Skipf("unix sockets not supported: %v", err)
+			}
+			defer socket.Close()
+
+			if err := AddToArchive(&models.UpdateConfig{
+				ArchivePath: archivePath,
+				Sources:     []models.SourceMapping{{Path: extra, Prefix: "extra"}},
+			}); err == nil {
+				t.Fatal("AddToArchive with a socket succeeded")
+			}
+
+			after := archiveContents(t, archivePath)
+			if len(after) != len(before) {
+				t.Errorf("Archive has %d entries after a failed add; want %d", len(after), len(before))
+			}
+			for name, want := range before {
+				if after[name] != want {
+					t.Errorf("Entry %q = %q after a failed add; want %q", name, after[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendRestoresOnWriteError(t *testing.T) {
+	for _, tc := range modifyFormats[:2] {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, archivePath := createModifyFixture(t, tc.archiveType, tc.ext)
+			original, _ := os.ReadFile(archivePath)
+
+			big := filepath.Join(tmpDir, "big.bin")
+			os.WriteFile(big, bytes.Repeat([]byte("zipprine"), 25*1024), 0644)
+			bigInfo, _ := os.Stat(big)
+			// A directory opens fine but fails once it is read
+			write := []pendingFile{
+				{path: big, name: "big.bin", info: bigInfo},
+				{path: tmpDir, name: "unreadable.bin", info: bigInfo},
+			}
+
+			var err error
+			if tc.archiveType == models.ZIP {
+				file, _ := os.OpenFile(archivePath, os.O_RDWR, 0)
+				zr, _ := zip.NewReader(file, int64(len(original)))
+				err = appendZip(file, zr, int64(len(original)), write, 5)
+				file.Close()
+			} else {
+				_, _, trailer, _ := scanTar(archivePath, false)
+				err = appendTar(archivePath, trailer, write)
+			}
+			if err == nil {
+				t.Fatal("Append of an unreadable source succeeded")
+			}
+
+			// The archive must be byte for byte what it was
+			if got := archiveContents(t, archivePath); len(got) != 3 {
+				t.Errorf("Archive has %d entries after a failed append; want 3", len(got))
+			}
+			after, _ := os.ReadFile(archivePath)
+			if !bytes.Equal(after, original) {
+				t.Errorf("Archive is %d bytes after a failed append; want the original %d", len(after), len(original))
+			}
+		})
+	}
+}
+
+// paxRecord formats one PAX extended header record, whose length prefix
+// counts itself
+func paxRecord(key, value string) string {
+	record := " " + key + "=" + value + "\n"
+	n := len(record)
+	for len(strconv.Itoa(n)+record) != n {
+		n = len(strconv.Itoa(n) + record)
+	}
+	return strconv.Itoa(n) + record
+}
+
+func TestRewriteTarExpandsPAXSparse(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	// archive/tar cannot write sparse entries, so the PAX header is written
+	// as a regular one and retyped
+	records := paxRecord("GNU.sparse.size", "15") + paxRecord("GNU.sparse.numblocks", "1") + paxRecord("GNU.sparse.map", "10,5")
+	tw.WriteHeader(&tar.Header{Name: "PaxHeaders/sparse.bin", Mode: 0644, Size: int64(len(records)), Format: tar.FormatUSTAR})
+	tw.Write([]byte(records))
+	tw.Flush()
+	block := buf.Bytes()[:512]
+	block[156] = tar.TypeXHeader
+	copy(block[148:156], "        ")
+	var sum int
+	for _, b := range block {
+		sum += int(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	tw.WriteHeader(&tar.Header{Name: "sparse.bin", Mode: 0644, Size: 5, Format: tar.FormatUSTAR})
+	tw.Write([]byte("hello"))
+	for _, name := range []string{"keep.txt", "drop.txt"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))})
+		tw.Write([]byte(name))
+	}
+	tw.Close()
+
+	if header, err := tar.NewReader(bytes.NewReader(buf.Bytes())).Next(); err != nil || !tarIsSparse(header) {
+		t.Fatalf("Fixture is not a sparse entry: %v, %v", header, err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "sparse.tar")
+	os.WriteFile(archivePath, buf.Bytes(), 0644)
+	if err := DeleteFromArchive(archivePath, models.TAR, []string{"drop.txt"}); err != nil {
+		t.Fatalf("DeleteFromArchive failed: %v", err)
+	}
+
+	file, _ := os.Open(archivePath)
+	defer file.Close()
+	tr := tar.NewReader(file)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Rewritten archive is not readable: %v", err)
+	}
+	if header.Name != "sparse.bin" || tarIsSparse(header) {
+		t.Errorf("sparse.bin was written back as %q, type %q, records %v", header.Name, header.Typeflag, header.PAXRecords)
+	}
+	data, _ := io.ReadAll(tr)
+	if want := "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00hello"; string(data) != want {
+		t.Errorf("sparse.bin = %q; want %q", data, want)
+	}
+}
+
+func TestModifyUnsupportedType(t *testing.T) {
+	tmpDir := t.TempDir()
+	gzPath := filepath.Join(tmpDir, "file.gz")
+	os.WriteFile(gzPath, []byte{0x1f, 0x8b}, 0644)
+
+	if err := DeleteFromArchive(gzPath, models.GZIP, []string{"file"}); err == nil {
+		t.Error("Expected error for GZIP modification, got nil")
+	}
+}
@@ -0,0 +1,328 @@
+package archiver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"zipprine/internal/models"
+	"zipprine/pkg/fileutil"
+)
+
+// maxNestedDepth bounds how deeply archives inside archives are expanded,
+// analyzed or searched
+const maxNestedDepth = 8
+
+// ErrLimitExceeded is returned when an extraction passes its ExtractLimits
+var ErrLimitExceeded = errors.New("extraction limit exceeded")
+
+// limitFile counts a file against the extraction limits before it is written
+func limitFile(config *models.ExtractConfig) error {
+	if !config.Limits.AddFile() {
+		return fmt.Errorf("%w: more than %d files", ErrLimitExceeded, config.Limits.MaxFiles)
+	}
+	return nil
+}
+
+// limitedWriter counts the bytes written to w against the extraction limits
+func limitedWriter(config *models.ExtractConfig, w io.Writer) io.Writer {
+	if config.Limits == nil {
+		return w
+	}
+	return &limitWriter{w: w, limits: config.Limits}
+}
+
+type limitWriter struct {
+	w      io.Writer
+	limits *models.ExtractLimits
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if !w.limits.AddSize(int64(len(p))) {
+		return 0, fmt.Errorf("%w: more than %s", ErrLimitExceeded, fileutil.FormatBytes(w.limits.MaxSize))
+	}
+	return w.w.Write(p)
+}
+
+// defaultExtractLimits is a fresh budget of the default limits
+func defaultExtractLimits() *models.ExtractLimits {
+	return &models.ExtractLimits{MaxSize: models.DefaultMaxExtractSize, MaxFiles: models.DefaultMaxExtractFiles}
+}
+
+// extractRecursive extracts an archive and then the archives found among the
+// files it wrote, level by level. A nested archive expands into a directory
+// named after it (a GZIP file next to itself) and is removed afterwards
+// unless KeepNested is set. Without Limits the default ones apply, since
+// expanding nested archives is how archive bombs go off.
+func extractRecursive(config *models.ExtractConfig) error {
+	// Nested archives are found by listing what was extracted, which needs
+	// the archive as a file
+	if config.ArchivePath == StdioPath {
+		return fmt.Errorf("recursive extraction needs an archive file, not stdin")
+	}
+	if config.Limits == nil {
+		limited := *config
+		limited.Limits = defaultExtractLimits()
+		config = &limited
+	}
+
+	maxDepth := config.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = maxNestedDepth
+	}
+	return extractNested(config, maxDepth)
+}
+
+// extractNested extracts one archive and expands the archives in it, down
+// to depth more levels. Every level is confined to its own destination:
+// entries refused for leading outside it are reported together at the end
+// without stopping the rest, and an archive that had any is kept.
+func extractNested(config *models.ExtractConfig, depth int) error {
+	var created []string
+	if depth > 0 {
+		var err error
+		if created, err = createdArchives(config); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	if err := extractArchive(config); err != nil {
+		if !onlyUnsafePaths(err) {
+			return err
+		}
+		errs = append(errs, err)
+	}
+
+	for _, archivePath := range created {
+		if !isNestedArchive(archivePath) {
+			continue
+		}
+		archiveType, _ := DetectArchiveType(archivePath)
+		inner := *config
+		inner.ArchivePath = archivePath
+		inner.ArchiveType = archiveType
+		inner.DestPath = nestedDestPath(archivePath, archiveType)
+		inner.StripComponents = 0
+		inner.Prefix = ""
+
+		fmt.Fprintf(extractProgress(config), "  📦 Expanding: %s\n", archivePath)
+		if err := extractNested(&inner, depth-1); err != nil {
+			err = fmt.Errorf("%s: %w", archivePath, err)
+			if !onlyUnsafePaths(err) {
+				return err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if !config.KeepNested {
+			if err := os.Remove(archivePath); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// onlyUnsafePaths reports whether an extraction failed only because some
+// entries were refused with ErrUnsafePath
+func onlyUnsafePaths(err error) bool {
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			if !onlyUnsafePaths(inner) {
+				return false
+			}
+		}
+		return true
+	case interface{ Unwrap() error }:
+		return onlyUnsafePaths(e.Unwrap())
+	}
+	return err == ErrUnsafePath
+}
+
+// createdArchives lists, before extracting config, the files it is going to
+// write whose names mark them as archives. Files already at the destination
+// are left out unless they will be overwritten: extraction skips them, so
+// they are not its to expand or remove. Entries of encrypted RAR archives
+// cannot be listed without decrypting them, so archives inside those are
+// left as they are.
+func createdArchives(config *models.ExtractConfig) ([]string, error) {
+	var archives []string
+	if config.ArchiveType == models.GZIP {
+		// A GZIP file always replaces its output
+		if outPath := gzipOutputPath(config); hasArchiveExtension(outPath) {
+			archives = append(archives, outPath)
+		}
+		return archives, nil
+	}
+
+	seen := map[string]bool{}
+	err := walkArchive(config.ArchivePath, config.ArchiveType, func(entry *archiveEntry) error {
+		if !entry.IsRegular() {
+			return nil
+		}
+		// Unsafe entries are left for the extraction to report
+		destPath, ok, err := entryDestPath(config, entry.Name)
+		if err != nil || !ok || seen[destPath] || !hasArchiveExtension(destPath) {
+			return nil
+		}
+		seen[destPath] = true
+		if _, err := os.Lstat(destPath); err == nil && !config.OverwriteAll {
+			return nil
+		}
+		archives = append(archives, destPath)
+		return nil
+	})
+	if errors.Is(err, ErrPasswordRequired) {
+		return nil, nil
+	}
+	return archives, err
+}
+
+func hasArchiveExtension(file string) bool {
+	_, ok := DetectArchiveTypeByExtension(file)
+	return ok
+}
+
+// isNestedArchive reports whether an extracted file is an archive to expand.
+// Its type comes from DetectArchiveType by extension, so documents that are
+// ZIPs underneath, such as .docx or .jar, stay whole, and its content has to
+// look like an archive too. Volumes of split archives are left alone.
+func isNestedArchive(file string) bool {
+	if zipSplitSuffix.MatchString(file) || strings.HasSuffix(file, ".000") {
+		return false
+	}
+	if _, ok := DetectArchiveTypeByExtension(file); !ok {
+		return false
+	}
+	info, err := os.Lstat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	archiveType, err := DetectArchiveTypeFromReader(bufio.NewReaderSize(f, sniffSize))
+	return err == nil && archiveType != models.AUTO
+}
+
+// nestedDestPath is the directory a nested archive expands into
+func nestedDestPath(archivePath string, archiveType models.ArchiveType) string {
+	if archiveType == models.GZIP {
+		return filepath.Dir(archivePath)
+	}
+	base := filepath.Base(archivePath)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	if archiveType == models.TARGZ && strings.HasSuffix(strings.ToLower(base), ".tar.gz") {
+		stem = base[:len(base)-len(".tar.gz")]
+	}
+	if stem == "" {
+		stem = base + ".d"
+	}
+	return filepath.Join(filepath.Dir(archivePath), stem)
+}
+
+// AnalyzeNested analyzes an archive like AnalyzeWithPassword and also the
+// archives stored inside it, filling ArchiveInfo.Nested down to a fixed
+// depth. Nested archives that cannot be read without a password are skipped.
+// The copies made of nested archives count against the default extraction
+// limits as a whole.
+func AnalyzeNested(archivePath, password string) (*models.ArchiveInfo, error) {
+	info, err := AnalyzeWithPassword(archivePath, password)
+	if err != nil || info == nil {
+		return info, err
+	}
+	if err := analyzeNested(archivePath, info, password, maxNestedDepth, defaultExtractLimits()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func analyzeNested(archivePath string, info *models.ArchiveInfo, password string, depth int, limits *models.ExtractLimits) error {
+	if depth == 0 {
+		return nil
+	}
+	err := walkArchive(archivePath, info.Type, func(entry *archiveEntry) error {
+		if !entry.IsRegular() {
+			return nil
+		}
+		if _, ok := DetectArchiveTypeByExtension(entry.Name); !ok {
+			return nil
+		}
+
+		rc, err := entry.Open()
+		if errors.Is(err, ErrPasswordRequired) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		nestedPath, cleanup, err := spoolNested(rc, entry.Name, limits)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		defer cleanup()
+		if !isNestedArchive(nestedPath) {
+			return nil
+		}
+
+		nestedInfo, err := AnalyzeWithPassword(nestedPath, password)
+		if err == nil && nestedInfo != nil {
+			err = analyzeNested(nestedPath, nestedInfo, password, depth-1, limits)
+		}
+		if errors.Is(err, ErrPasswordRequired) || errors.Is(err, ErrWrongPassword) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		if info.Nested == nil {
+			info.Nested = map[string]*models.ArchiveInfo{}
+		}
+		info.Nested[entry.Name] = nestedInfo
+		return nil
+	})
+	if errors.Is(err, ErrPasswordRequired) {
+		return nil
+	}
+	return err
+}
+
+// spoolNested copies an archive found inside another to a temporary file
+// that keeps its base name, so the name still tells its type, and returns a
+// function removing it again. The copy counts against limits.
+func spoolNested(r io.Reader, name string, limits *models.ExtractLimits) (string, func(), error) {
+	if !limits.AddFile() {
+		return "", nil, fmt.Errorf("%w: more than %d files", ErrLimitExceeded, limits.MaxFiles)
+	}
+	dir, err := os.MkdirTemp("", "zipprine-nested-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	nestedPath := filepath.Join(dir, path.Base(cleanEntryName(name)))
+	file, err := os.Create(nestedPath)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	_, err = io.Copy(&limitWriter{w: file, limits: limits}, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return nestedPath, cleanup, nil
+}
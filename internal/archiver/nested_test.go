@@ -0,0 +1,261 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+// writeBundle builds outer.tar holding bundle.zip, which holds logs.tar.gz,
+// notes.txt.gz, a README and a .docx that is a ZIP underneath
+func writeBundle(t *testing.T, dir string) string {
+	t.Helper()
+	compress := func(source, output string, archiveType models.ArchiveType) {
+		if err := Compress(&models.CompressConfig{
+			SourcePath:  source,
+			OutputPath:  output,
+			ArchiveType: archiveType,
+			Progress:    io.Discard,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logs := filepath.Join(dir, "logs")
+	os.MkdirAll(logs, 0755)
+	os.WriteFile(filepath.Join(logs, "db.log"), []byte("db ERROR locked\n"), 0644)
+
+	bundle := filepath.Join(dir, "bundle")
+	os.MkdirAll(bundle, 0755)
+	os.WriteFile(filepath.Join(bundle, "README"), []byte("support bundle\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes\n"), 0644)
+	compress(logs, filepath.Join(bundle, "logs.tar.gz"), models.TARGZ)
+	compress(filepath.Join(dir, "notes.txt"), filepath.Join(bundle, "notes.txt.gz"), models.GZIP)
+	compress(logs, filepath.Join(bundle, "report.docx"), models.ZIP)
+
+	outer := filepath.Join(dir, "outer")
+	os.MkdirAll(outer, 0755)
+	compress(bundle, filepath.Join(outer, "bundle.zip"), models.ZIP)
+	compress(outer, filepath.Join(dir, "outer.tar"), models.TAR)
+	return filepath.Join(dir, "outer.tar")
+}
+
+func TestExtractRecursive(t *testing.T) {
+	archivePath := writeBundle(t, t.TempDir())
+
+	tests := []struct {
+		name    string
+		config  models.ExtractConfig
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "expand",
+			config:  models.ExtractConfig{Recursive: true},
+			present: []string{"bundle/README", "bundle/logs/db.log", "bundle/notes.txt", "bundle/report.docx"},
+			absent:  []string{"bundle.zip", "bundle/logs.tar.gz", "bundle/notes.txt.gz", "bundle/report"},
+		},
+		{
+			name:    "keep_nested",
+			config:  models.ExtractConfig{Recursive: true, KeepNested: true},
+			present: []string{"bundle.zip", "bundle/logs.tar.gz", "bundle/logs/db.log"},
+		},
+		{
+			name:    "max_depth",
+			config:  models.ExtractConfig{Recursive: true, MaxDepth: 1},
+			present: []string{"bundle/README", "bundle/logs.tar.gz"},
+			absent:  []string{"bundle.zip", "bundle/logs"},
+		},
+		{
+			name:    "not_recursive",
+			config:  models.ExtractConfig{},
+			present: []string{"bundle.zip"},
+			absent:  []string{"bundle"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			config := tt.config
+			config.ArchivePath = archivePath
+			config.DestPath = dest
+			config.ArchiveType = models.TAR
+			config.Progress = io.Discard
+			if err := Extract(&config); err != nil {
+				t.Fatalf("Extract() failed: %v", err)
+			}
+			for _, name := range tt.present {
+				if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+					t.Errorf("%s is missing: %v", name, err)
+				}
+			}
+			for _, name := range tt.absent {
+				if _, err := os.Stat(filepath.Join(dest, name)); err == nil {
+					t.Errorf("%s should not exist", name)
+				}
+			}
+		})
+	}
+
+	// The limits count every level, so the nested files push past them
+	for _, limits := range []*models.ExtractLimits{{MaxFiles: 3}, {MaxSize: 1000}} {
+		err := Extract(&models.ExtractConfig{
+			ArchivePath: archivePath,
+			DestPath:    t.TempDir(),
+			ArchiveType: models.TAR,
+			Recursive:   true,
+			Limits:      limits,
+			Progress:    io.Discard,
+		})
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("Extract() with %+v = %v; want ErrLimitExceeded", limits, err)
+		}
+	}
+	// bundle.zip alone fits both limits
+	if err := Extract(&models.ExtractConfig{
+		ArchivePath: archivePath,
+		DestPath:    t.TempDir(),
+		ArchiveType: models.TAR,
+		Limits:      &models.ExtractLimits{MaxFiles: 3, MaxSize: 2000},
+		Progress:    io.Discard,
+	}); err != nil {
+		t.Errorf("Extract() within the limits failed: %v", err)
+	}
+}
+
+func TestExtractRecursiveKeepsExistingArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := writeBundle(t, tmpDir)
+
+	// A bundle.zip already in the destination is skipped by the extraction,
+	// so it is not the extraction's to expand or remove
+	dest := t.TempDir()
+	existing := filepath.Join(dest, "bundle.zip")
+	if err := Compress(&models.CompressConfig{
+		SourcePath:  filepath.Join(tmpDir, "notes.txt"),
+		OutputPath:  existing,
+		ArchiveType: models.ZIP,
+		Progress:    io.Discard,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(existing)
+
+	if err := Extract(&models.ExtractConfig{
+		ArchivePath: archivePath,
+		DestPath:    dest,
+		ArchiveType: models.TAR,
+		Recursive:   true,
+		Progress:    io.Discard,
+	}); err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+	if after, err := os.ReadFile(existing); err != nil || string(after) != string(before) {
+		t.Errorf("existing bundle.zip was changed or removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "bundle")); err == nil {
+		t.Error("existing bundle.zip was expanded")
+	}
+}
+
+func TestExtractRecursiveConfinesNestedEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	outer := filepath.Join(tmpDir, "outer")
+	os.MkdirAll(outer, 0755)
+
+	// zipped.zip and tarred.tar each hold a safe entry and one climbing out
+	names := []string{"ok.txt", "../../escaped.txt"}
+	zipFile, _ := os.Create(filepath.Join(outer, "zipped.zip"))
+	zw := zip.NewWriter(zipFile)
+	tarFile, _ := os.Create(filepath.Join(outer, "tarred.tar"))
+	tw := tar.NewWriter(tarFile)
+	for _, name := range names {
+		w, _ := zw.Create(name)
+		w.Write([]byte(name))
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))})
+		tw.Write([]byte(name))
+	}
+	zw.Close()
+	zipFile.Close()
+	tw.Close()
+	tarFile.Close()
+
+	bundle := filepath.Join(tmpDir, "bundle.tar")
+	if err := Compress(&models.CompressConfig{SourcePath: outer, OutputPath: bundle, ArchiveType: models.TAR, Progress: io.Discard}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	dest := filepath.Join(out, "deep")
+	err := Extract(&models.ExtractConfig{
+		ArchivePath: bundle,
+		DestPath:    dest,
+		ArchiveType: models.TAR,
+		Recursive:   true,
+		Progress:    io.Discard,
+	})
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Extract() = %v; want ErrUnsafePath", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "escaped.txt")); err == nil {
+		t.Error("escaped.txt was written outside the destination")
+	}
+	for _, inner := range []string{"zipped.zip", "tarred.tar"} {
+		// The safe entry is extracted and the archive kept, as it was not
+		// expanded in full
+		if _, err := os.Stat(filepath.Join(dest, strings.TrimSuffix(inner, filepath.Ext(inner)), "ok.txt")); err != nil {
+			t.Errorf("%s: safe entry missing: %v", inner, err)
+		}
+		if _, err := os.Stat(filepath.Join(dest, inner)); err != nil {
+			t.Errorf("%s was removed: %v", inner, err)
+		}
+	}
+}
+
+func TestAnalyzeNested(t *testing.T) {
+	archivePath := writeBundle(t, t.TempDir())
+
+	info, err := AnalyzeNested(archivePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := info.Nested["bundle.zip"]
+	if bundle == nil || bundle.Type != models.ZIP {
+		t.Fatalf("Nested = %v; want bundle.zip", info.Nested)
+	}
+	logs := bundle.Nested["logs.tar.gz"]
+	if logs == nil || !slices.ContainsFunc(logs.Files, func(f models.FileInfo) bool { return f.Name == "db.log" }) {
+		t.Errorf("bundle.zip Nested = %v; want logs.tar.gz with db.log", bundle.Nested)
+	}
+	if _, ok := bundle.Nested["report.docx"]; ok {
+		t.Error("report.docx was analyzed as an archive")
+	}
+
+	if info, err := Analyze(archivePath); err != nil || info.Nested != nil {
+		t.Errorf("Analyze() filled Nested: %v, %v", info, err)
+	}
+
+	// Every level copies its nested archives against the one budget
+	for _, limits := range []struct{ size, files int64 }{{0, 2}, {1000, 0}} {
+		info, _ := Analyze(archivePath)
+		budget := &models.ExtractLimits{MaxSize: limits.size, MaxFiles: limits.files}
+		if err := analyzeNested(archivePath, info, "", maxNestedDepth, budget); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("analyzeNested() with %+v = %v; want ErrLimitExceeded", budget, err)
+		}
+
+		budget = &models.ExtractLimits{MaxSize: limits.size, MaxFiles: limits.files}
+		g := &grepper{config: &models.GrepConfig{Nested: true}, re: regexp.MustCompile("ERROR"), out: io.Discard, limits: budget}
+		if err := g.searchFile(archivePath, "", 0); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("grep --nested with %+v = %v; want ErrLimitExceeded", budget, err)
+		}
+	}
+}
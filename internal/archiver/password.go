@@ -0,0 +1,40 @@
+package archiver
+
+import (
+	"errors"
+
+	"zipprine/internal/models"
+)
+
+// Errors returned when an encrypted archive cannot be opened
+var (
+	ErrPasswordRequired = errors.New("archive is encrypted; a password is required")
+	ErrWrongPassword    = errors.New("incorrect password")
+)
+
+// IsEncrypted reports whether any entry of the archive needs a password
+func IsEncrypted(path string, archiveType models.ArchiveType) (bool, error) {
+	switch archiveType {
+	case models.ZIP:
+		r, err := openZipArchive(path)
+		if err != nil {
+			return false, err
+		}
+		defer r.Close()
+
+		for _, f := range r.File {
+			if f.Flags&0x1 != 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case models.RAR:
+		enc, err := scanRarEncryption(path)
+		if err != nil {
+			return false, err
+		}
+		return enc.Encrypted(), nil
+	default:
+		return false, nil
+	}
+}
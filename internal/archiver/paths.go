@@ -0,0 +1,171 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"zipprine/internal/models"
+	"zipprine/pkg/fileutil"
+)
+
+// sourceMappings returns the sources of a compression, falling back to the
+// single SourcePath with its contents at the archive root
+func sourceMappings(config *models.CompressConfig) []models.SourceMapping {
+	if len(config.Sources) > 0 {
+		return config.Sources
+	}
+	return []models.SourceMapping{{Path: config.SourcePath}}
+}
+
+// walkSources visits every file and directory selected for an archive,
+// passing the on-disk path and the slash-separated name it gets in the archive
+func walkSources(config *models.CompressConfig, fn func(path, name string, info os.FileInfo) error) error {
+	for _, source := range sourceMappings(config) {
+		root := filepath.Clean(source.Path)
+		prefix := strings.Trim(path.Clean("/"+filepath.ToSlash(source.Prefix)), "/")
+		filter := newSourceFilter(config, root)
+		var modTime time.Time
+		if config.Reproducible {
+			modTime = reproducibleTime()
+		}
+
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+
+			// A single-file source is filtered by its own name
+			filterPath := relPath
+			if p == root && !info.IsDir() {
+				filterPath = info.Name()
+			}
+			if !filter.Include(filterPath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			name := filepath.ToSlash(relPath)
+			switch {
+			case p == root && !info.IsDir() && prefix == "":
+				name = info.Name()
+			case prefix != "" && name == ".":
+				name = prefix
+			case prefix != "":
+				name = prefix + "/" + name
+			}
+
+			if config.Reproducible {
+				info = reproducibleInfo{info, modTime}
+			}
+			return fn(p, name, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSourceFile opens the data stored for a file selected for an archive.
+// A symlink is stored as a link, with its target as the data, and is never
+// followed, so an archive cannot pick up files from outside its sources.
+func openSourceFile(path string, info os.FileInfo) (io.ReadCloser, error) {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(target)), nil
+	case !info.Mode().IsRegular():
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return os.Open(path)
+}
+
+// reproducibleInfo hides a file's timestamps and owner, so that an entry
+// built from it depends only on its name, permissions and content
+type reproducibleInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (i reproducibleInfo) ModTime() time.Time { return i.modTime }
+func (i reproducibleInfo) Sys() any           { return nil }
+
+// reproducibleTime is the modification time of every entry in a reproducible
+// archive: $SOURCE_DATE_EPOCH when set, otherwise 1980-01-01, the earliest
+// time a ZIP entry can record
+func reproducibleTime() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// sourceSize returns the total size of the files selected for an archive
+func sourceSize(config *models.CompressConfig) int64 {
+	var total int64
+	walkSources(config, func(path, name string, info os.FileInfo) error {
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// newSourceFilter builds the include/exclude filter for one source root.
+// A .zipprineignore file is always honoured; .gitignore only on request.
+func newSourceFilter(config *models.CompressConfig, root string) *fileutil.Filter {
+	filter := fileutil.NewFilter(config.ExcludePaths, config.IncludePaths)
+
+	ignoreFiles := []string{fileutil.ZipprineIgnoreFile}
+	if config.UseGitignore {
+		ignoreFiles = append(ignoreFiles, fileutil.GitIgnoreFile)
+	}
+	filter.LoadIgnoreFiles(root, ignoreFiles...)
+
+	return filter
+}
+
+// ErrUnsafePath is returned for an archive entry that would be extracted
+// outside the destination directory
+var ErrUnsafePath = errors.New("entry path leads outside the destination")
+
+// entryDestPath maps an archive entry name to its extraction target, applying
+// StripComponents and Prefix. It reports false when the entry is stripped
+// away, and fails with ErrUnsafePath when the target, once cleaned, is not
+// inside DestPath.
+func entryDestPath(config *models.ExtractConfig, name string) (string, bool, error) {
+	name = strings.Trim(filepath.ToSlash(name), "/")
+
+	if config.StripComponents > 0 {
+		parts := strings.Split(name, "/")
+		if len(parts) <= config.StripComponents {
+			return "", false, nil
+		}
+		name = strings.Join(parts[config.StripComponents:], "/")
+	}
+
+	dest := filepath.Clean(config.DestPath)
+	target := filepath.Join(dest, filepath.FromSlash(config.Prefix), filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dest, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return target, true, nil
+}
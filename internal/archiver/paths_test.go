@@ -0,0 +1,275 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+func TestCompressMultipleSources(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	srcDir := filepath.Join(tmpDir, "src")
+	os.MkdirAll(filepath.Join(srcDir, "pkg"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "main.go"), []byte("package main"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "pkg", "lib.go"), []byte("package pkg"), 0644)
+	readme := filepath.Join(tmpDir, "README.md")
+	os.WriteFile(readme, []byte("# readme"), 0644)
+	license := filepath.Join(tmpDir, "LICENSE")
+	os.WriteFile(license, []byte("MIT"), 0644)
+
+	zipPath := filepath.Join(tmpDir, "bundle.zip")
+	err := Compress(&models.CompressConfig{
+		Sources: []models.SourceMapping{
+			{Path: srcDir + "/", Prefix: "app/src"},
+			{Path: readme, Prefix: "app/README.md"},
+			{Path: license},
+		},
+		OutputPath:       zipPath,
+		ArchiveType:      models.ZIP,
+		CompressionLevel: 5,
+	})
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to open zip: %v", err)
+	}
+	defer r.Close()
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	expected := []string{"LICENSE", "app/README.md", "app/src/main.go", "app/src/pkg/lib.go"}
+	if len(names) != len(expected) {
+		t.Fatalf("Entry names = %v; want %v", names, expected)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Entry %d = %q; want %q", i, names[i], expected[i])
+		}
+	}
+}
+
+func TestEntryDestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    string
+		strip    int
+		prefix   string
+		expected string
+		ok       bool
+		unsafe   bool
+	}{
+		{"no remapping", "a/b/c.txt", 0, "", "dest/a/b/c.txt", true, false},
+		{"strip one", "a/b/c.txt", 1, "", "dest/b/c.txt", true, false},
+		{"strip all but file", "a/b/c.txt", 2, "", "dest/c.txt", true, false},
+		{"strip everything", "a/b/c.txt", 3, "", "", false, false},
+		{"strip top-level dir entry", "a/", 1, "", "", false, false},
+		{"prefix", "a/b.txt", 0, "vendor", "dest/vendor/a/b.txt", true, false},
+		{"strip and prefix", "pkg-1.0/lib/x.go", 1, "third_party/pkg", "dest/third_party/pkg/lib/x.go", true, false},
+		{"leading dot segment", "./a/b.txt", 1, "", "dest/a/b.txt", true, false},
+		{"absolute entry", "/etc/passwd", 0, "", "dest/etc/passwd", true, false},
+		{"dot-dot staying inside", "a/../b.txt", 0, "", "dest/b.txt", true, false},
+		{"dot-dot entry", "../evil.txt", 0, "", "", false, true},
+		{"nested dot-dot entry", "a/../../evil.txt", 0, "", "", false, true},
+		{"strip exposes dot-dot", "a/../evil.txt", 1, "", "", false, true},
+		{"prefix escapes", "a.txt", 0, "../x", "", false, true},
+		{"prefix and strip escape", "pkg/../../a.txt", 1, "sub", "", false, true},
+		{"prefix climbing back in", "a.txt", 0, "x/../y", "dest/y/a.txt", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &models.ExtractConfig{DestPath: "dest", StripComponents: tt.strip, Prefix: tt.prefix}
+			result, ok, err := entryDestPath(config, tt.entry)
+			if errors.Is(err, ErrUnsafePath) != tt.unsafe {
+				t.Fatalf("entryDestPath(%q) error = %v; want unsafe %v", tt.entry, err, tt.unsafe)
+			}
+			if ok != tt.ok {
+				t.Fatalf("entryDestPath(%q) ok = %v; want %v", tt.entry, ok, tt.ok)
+			}
+			if ok && result != filepath.FromSlash(tt.expected) {
+				t.Errorf("entryDestPath(%q) = %q; want %q", tt.entry, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractRejectsUnsafePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := filepath.Join(tmpDir, "unsafe.zip")
+	zipFile, _ := os.Create(zipPath)
+	zw := zip.NewWriter(zipFile)
+	tarPath := filepath.Join(tmpDir, "unsafe.tar")
+	tarFile, _ := os.Create(tarPath)
+	tw := tar.NewWriter(tarFile)
+	for _, name := range []string{"pkg/ok.txt", "pkg/../../evil.txt"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(name))
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))})
+		tw.Write([]byte(name))
+	}
+	zw.Close()
+	zipFile.Close()
+	tw.Close()
+	tarFile.Close()
+
+	for _, tc := range []struct {
+		name   string
+		strip  int
+		prefix string
+		ok     string
+	}{
+		{"strip_components", 1, "", "ok.txt"},
+		{"prefix", 0, "../../out", ""},
+	} {
+		for archivePath, archiveType := range map[string]models.ArchiveType{zipPath: models.ZIP, tarPath: models.TAR} {
+			t.Run(tc.name+"_"+string(archiveType), func(t *testing.T) {
+				root := t.TempDir()
+				dest := filepath.Join(root, "a", "dest")
+				err := Extract(&models.ExtractConfig{
+					ArchivePath:     archivePath,
+					DestPath:        dest,
+					ArchiveType:     archiveType,
+					StripComponents: tc.strip,
+					Prefix:          tc.prefix,
+					Progress:        io.Discard,
+				})
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Errorf("Extract() = %v; want ErrUnsafePath", err)
+				}
+				if tc.ok != "" {
+					if _, err := os.Stat(filepath.Join(dest, tc.ok)); err != nil {
+						t.Errorf("safe entry was not extracted: %v", err)
+					}
+				}
+				filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+					if err == nil && !info.IsDir() && !strings.HasPrefix(path, dest+string(filepath.Separator)) {
+						t.Errorf("%s was written outside the destination", path)
+					}
+					return nil
+				})
+			})
+		}
+	}
+}
+
+func TestExtractStripComponentsAndPrefix(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	createTestFiles(t, sourceDir)
+
+	for _, tc := range []struct {
+		name        string
+		archiveType models.ArchiveType
+		ext         string
+	}{
+		{"zip", models.ZIP, ".zip"},
+		{"tar.gz", models.TARGZ, ".tar.gz"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			archivePath := filepath.Join(tmpDir, "test"+tc.ext)
+			if err := Compress(&models.CompressConfig{
+				Sources:          []models.SourceMapping{{Path: sourceDir, Prefix: "project-1.0"}},
+				OutputPath:       archivePath,
+				ArchiveType:      tc.archiveType,
+				CompressionLevel: 5,
+			}); err != nil {
+				t.Fatalf("Compress failed: %v", err)
+			}
+
+			destDir := filepath.Join(tmpDir, "dest-"+tc.name)
+			if err := Extract(&models.ExtractConfig{
+				ArchivePath:     archivePath,
+				DestPath:        destDir,
+				ArchiveType:     tc.archiveType,
+				OverwriteAll:    true,
+				StripComponents: 1,
+				Prefix:          "app",
+			}); err != nil {
+				t.Fatalf("Extract failed: %v", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(destDir, "app", "subdir", "test3.txt"))
+			if err != nil {
+				t.Fatalf("Remapped file missing: %v", err)
+			}
+			if string(content) != "Nested file" {
+				t.Errorf("Extracted content mismatch: got %q, want %q", string(content), "Nested file")
+			}
+			if _, err := os.Stat(filepath.Join(destDir, "project-1.0")); !os.IsNotExist(err) {
+				t.Error("Stripped top-level directory should not exist")
+			}
+		})
+	}
+}
+
+func TestCompressHonoursIgnoreFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "build"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "catalog.go"), []byte("package catalog"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "debug.log"), []byte("log"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "build", "out.bin"), []byte("bin"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, ".gitignore"), []byte("build/\n"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, ".zipprineignore"), []byte("*.log\n"), 0644)
+
+	for _, tc := range []struct {
+		name         string
+		useGitignore bool
+		expected     []string
+	}{
+		{"zipprineignore only", false, []string{".gitignore", ".zipprineignore", "build/out.bin", "catalog.go"}},
+		{"with gitignore", true, []string{".gitignore", ".zipprineignore", "catalog.go"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			zipPath := filepath.Join(tmpDir, tc.name+".zip")
+			if err := Compress(&models.CompressConfig{
+				SourcePath:       sourceDir,
+				OutputPath:       zipPath,
+				ArchiveType:      models.ZIP,
+				ExcludePaths:     []string{"log"},
+				UseGitignore:     tc.useGitignore,
+				CompressionLevel: 5,
+			}); err != nil {
+				t.Fatalf("Compress failed: %v", err)
+			}
+
+			r, err := zip.OpenReader(zipPath)
+			if err != nil {
+				t.Fatalf("Failed to open zip: %v", err)
+			}
+			defer r.Close()
+
+			var names []string
+			for _, f := range r.File {
+				names = append(names, f.Name)
+			}
+			sort.Strings(names)
+
+			if len(names) != len(tc.expected) {
+				t.Fatalf("Entry names = %v; want %v", names, tc.expected)
+			}
+			for i := range tc.expected {
+				if names[i] != tc.expected[i] {
+					t.Errorf("Entry %d = %q; want %q", i, names[i], tc.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package archiver
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -10,47 +13,69 @@ import (
 	"github.com/nwaples/rardecode"
 )
 
+// rarError names the missing volume of an incomplete set and maps
+// decryption failures to the typed password errors
+func rarError(err error, enc *rarEncryptionInfo, password string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrMissingVolume, filepath.Base(pathErr.Path))
+	}
+	return rarPasswordError(err, enc, password)
+}
+
 // extractRar extracts a RAR archive
 func extractRar(config *models.ExtractConfig) error {
-	file, err := os.Open(config.ArchivePath)
-	if err != nil {
-		return fmt.Errorf("failed to open RAR file: %w", err)
+	// A failed scan leaves the error to rardecode, which reports it better
+	enc, _ := scanRarEncryption(config.ArchivePath)
+	if enc != nil && enc.Encrypted() && config.Password == "" {
+		return ErrPasswordRequired
 	}
-	defer file.Close()
 
-	reader, err := rardecode.NewReader(file, "")
+	// OpenReader follows the remaining volumes of a multi-volume set
+	reader, err := rardecode.OpenReader(config.ArchivePath, config.Password)
 	if err != nil {
-		return fmt.Errorf("failed to create RAR reader: %w", err)
+		return fmt.Errorf("failed to open RAR file: %w", rarPasswordError(err, enc, config.Password))
 	}
+	defer reader.Close()
 
 	if err := os.MkdirAll(config.DestPath, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
+	var errs []error
 	for {
 		header, err := reader.Next()
 		if err != nil {
 			if err.Error() == "EOF" {
 				break
 			}
-			return fmt.Errorf("failed to read RAR entry: %w", err)
+			return fmt.Errorf("failed to read RAR entry: %w", rarError(err, enc, config.Password))
+		}
+
+		targetPath, ok, err := entryDestPath(config, header.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
 		}
 
 		if header.IsDir {
-			targetPath := filepath.Join(config.DestPath, header.Name)
 			if err := os.MkdirAll(targetPath, 0755); err != nil {
 				return fmt.Errorf("failed to create directory: %w", err)
 			}
 			continue
 		}
 
-		targetPath := filepath.Join(config.DestPath, header.Name)
-
 		if _, err := os.Stat(targetPath); err == nil && !config.OverwriteAll {
-			fmt.Printf("Skipping existing file: %s\n", header.Name)
+			fmt.Fprintf(extractProgress(config), "Skipping existing file: %s\n", header.Name)
 			continue
 		}
 
+		if err := limitFile(config); err != nil {
+			return err
+		}
 		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
 			return fmt.Errorf("failed to create parent directory: %w", err)
 		}
@@ -60,44 +85,54 @@ func extractRar(config *models.ExtractConfig) error {
 			return fmt.Errorf("failed to create file %s: %w", header.Name, err)
 		}
 
-		if _, err := outFile.ReadFrom(reader); err != nil {
+		if _, err := io.Copy(limitedWriter(config, outFile), reader); err != nil {
 			outFile.Close()
-			return fmt.Errorf("failed to write file %s: %w", header.Name, err)
+			// Don't leave garbage behind when the key was wrong
+			os.Remove(targetPath)
+			return fmt.Errorf("failed to write file %s: %w", header.Name, rarError(err, enc, config.Password))
 		}
 		outFile.Close()
 
 		// Set permissions if requested
 		if config.PreservePerms {
 			if err := os.Chmod(targetPath, header.Mode()); err != nil {
-				fmt.Printf("Warning: failed to set permissions for %s: %v\n", header.Name, err)
+				fmt.Fprintf(extractProgress(config), "Warning: failed to set permissions for %s: %v\n", header.Name, err)
 			}
 		}
 
-		fmt.Printf("Extracted: %s\n", header.Name)
+		fmt.Fprintf(extractProgress(config), "Extracted: %s\n", header.Name)
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 // analyzeRar analyzes a RAR archive and returns information about it
 func analyzeRar(path string) (*models.ArchiveInfo, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open RAR file: %w", err)
-	}
-	defer file.Close()
+	return analyzeRarWithPassword(path, "")
+}
 
-	fileStat, _ := file.Stat()
+// analyzeRarWithPassword analyzes a RAR archive, using the password for
+// encrypted entries
+func analyzeRarWithPassword(path, password string) (*models.ArchiveInfo, error) {
+	// rardecode verifies the key while reading file headers, so even a
+	// listing needs the password
+	enc, _ := scanRarEncryption(path)
+	if enc != nil && enc.Encrypted() && password == "" {
+		return nil, ErrPasswordRequired
+	}
 
-	reader, err := rardecode.NewReader(file, "")
+	reader, err := rardecode.OpenReader(path, password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create RAR reader: %w", err)
+		return nil, fmt.Errorf("failed to open RAR file: %w", rarPasswordError(err, enc, password))
 	}
+	defer reader.Close()
 
 	info := &models.ArchiveInfo{
-		Type:           models.RAR,
-		CompressedSize: fileStat.Size(),
-		Files:          []models.FileInfo{},
+		Type:  models.RAR,
+		Files: []models.FileInfo{},
+	}
+	if enc != nil {
+		info.Encryption = enc.Method
 	}
 
 	for {
@@ -106,21 +141,34 @@ func analyzeRar(path string) (*models.ArchiveInfo, error) {
 			if err.Error() == "EOF" {
 				break
 			}
-			return nil, fmt.Errorf("failed to read RAR entry: %w", err)
+			return nil, fmt.Errorf("failed to read RAR entry: %w", rarError(err, enc, password))
 		}
 
 		if !header.IsDir {
 			info.FileCount++
 			info.TotalSize += header.UnPackedSize
 			info.Files = append(info.Files, models.FileInfo{
-				Name:    header.Name,
-				Size:    header.UnPackedSize,
-				IsDir:   header.IsDir,
-				ModTime: header.ModificationTime.Format("2006-01-02 15:04:05"),
+				Name:      header.Name,
+				Size:      header.UnPackedSize,
+				IsDir:     header.IsDir,
+				ModTime:   header.ModificationTime.Format("2006-01-02 15:04:05"),
+				Encrypted: enc != nil && (enc.Headers || enc.Files[header.Name]),
 			})
 		}
 	}
 
+	// Every volume has been opened once the listing reaches the end
+	for _, volume := range reader.Volumes() {
+		stat, err := os.Stat(volume)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat RAR volume: %w", err)
+		}
+		info.CompressedSize += stat.Size()
+	}
+	if volumes := reader.Volumes(); len(volumes) > 1 {
+		info.Volumes = volumes
+	}
+
 	if info.TotalSize > 0 {
 		info.CompressionRatio = float64(info.CompressedSize) / float64(info.TotalSize)
 	}
@@ -1,8 +1,12 @@
 package archiver
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"zipprine/internal/models"
@@ -138,3 +142,126 @@ func TestAnalyzeRarNonExistentFile(t *testing.T) {
 		t.Error("Expected error for non-existent file, got nil")
 	}
 }
+
+// writeRar5Volumes stores one file split evenly across the given volumes
+func writeRar5Volumes(t *testing.T, volumes []string, name, content string) {
+	t.Helper()
+
+	const (
+		arcVolume       = 0x0001
+		arcVolumeNumber = 0x0002
+		dataNotFirst    = 0x0008
+		dataNotLast     = 0x0010
+		endNotLast      = 0x0001
+	)
+
+	chunk := (len(content) + len(volumes) - 1) / len(volumes)
+	for i, path := range volumes {
+		var out bytes.Buffer
+		out.WriteString("Rar!\x1a\x07\x01\x00")
+
+		main := []byte{1, 0}
+		if i == 0 {
+			main = binary.AppendUvarint(main, arcVolume)
+		} else {
+			main = binary.AppendUvarint(main, arcVolume|arcVolumeNumber)
+			main = binary.AppendUvarint(main, uint64(i))
+		}
+		out.Write(rar5Block(main))
+
+		var flags uint64
+		if i > 0 {
+			flags |= dataNotFirst
+		}
+		if i < len(volumes)-1 {
+			flags |= dataNotLast
+		}
+		part := content[min(i*chunk, len(content)):min((i+1)*chunk, len(content))]
+		out.Write(rar5Block(rar5FileBlock(name, []byte(content), flags, len(part), nil)))
+		out.WriteString(part)
+
+		var endFlags uint64
+		if i < len(volumes)-1 {
+			endFlags = endNotLast
+		}
+		out.Write(rar5Block(binary.AppendUvarint([]byte{rar5BlockEnd, 0}, endFlags)))
+
+		if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExtractRarMultiVolume(t *testing.T) {
+	content := strings.Repeat("multi-volume RAR content\n", 100)
+
+	tests := []struct {
+		name    string
+		volumes []string
+	}{
+		{"part_naming", []string{"set.part1.rar", "set.part2.rar", "set.part3.rar"}},
+		{"old_naming", []string{"set.rar", "set.r00", "set.r01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			var volumes []string
+			for _, v := range tt.volumes {
+				volumes = append(volumes, filepath.Join(tmpDir, v))
+			}
+			writeRar5Volumes(t, volumes, "big.txt", content)
+
+			destDir := filepath.Join(tmpDir, "dest")
+			err := Extract(&models.ExtractConfig{ArchivePath: volumes[0], DestPath: destDir, ArchiveType: models.RAR})
+			if err != nil {
+				t.Fatalf("Extract() failed: %v", err)
+			}
+			data, _ := os.ReadFile(filepath.Join(destDir, "big.txt"))
+			if string(data) != content {
+				t.Errorf("big.txt has %d bytes, want %d", len(data), len(content))
+			}
+
+			info, err := Analyze(volumes[0])
+			if err != nil {
+				t.Fatalf("Analyze() failed: %v", err)
+			}
+			var total int64
+			for _, v := range volumes {
+				stat, _ := os.Stat(v)
+				total += stat.Size()
+			}
+			if info.CompressedSize != total || len(info.Volumes) != len(volumes) {
+				t.Errorf("Analyze() size = %d over %d volumes, want %d over %d",
+					info.CompressedSize, len(info.Volumes), total, len(volumes))
+			}
+			if info.TotalSize != int64(len(content)) {
+				t.Errorf("TotalSize = %d, want %d", info.TotalSize, len(content))
+			}
+		})
+	}
+}
+
+func TestExtractRarMissingVolume(t *testing.T) {
+	tmpDir := t.TempDir()
+	volumes := []string{
+		filepath.Join(tmpDir, "set.part1.rar"),
+		filepath.Join(tmpDir, "set.part2.rar"),
+		filepath.Join(tmpDir, "set.part3.rar"),
+	}
+	writeRar5Volumes(t, volumes, "big.txt", strings.Repeat("x", 3000))
+	os.Remove(volumes[1])
+
+	err := Extract(&models.ExtractConfig{
+		ArchivePath: volumes[0],
+		DestPath:    filepath.Join(tmpDir, "dest"),
+		ArchiveType: models.RAR,
+	})
+	if !errors.Is(err, ErrMissingVolume) || !strings.Contains(err.Error(), "set.part2.rar") {
+		t.Errorf("Extract() error = %v, want missing set.part2.rar", err)
+	}
+
+	if _, err := Analyze(volumes[0]); !errors.Is(err, ErrMissingVolume) {
+		t.Errorf("Analyze() error = %v, want %v", err, ErrMissingVolume)
+	}
+}
@@ -0,0 +1,283 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// rardecode reports neither which entries are encrypted nor a typed error
+// for a bad password, so the block headers are scanned here instead
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+const (
+	// Self-extracting archives keep the signature after the stub
+	rarMaxSFXSize = 1 << 20
+
+	// RAR 1.5-4.x block types and flags
+	rar4BlockMain     = 0x73
+	rar4BlockFile     = 0x74
+	rar4BlockEnd      = 0x7b
+	rar4MainEncrypted = 0x0080
+	rar4FileEncrypted = 0x0004
+	rar4FileLarge     = 0x0100
+	rar4LongBlock     = 0x8000
+
+	// RAR 5 block types, flags and extra records
+	rar5BlockFile      = 2
+	rar5BlockEncrypt   = 4
+	rar5BlockEnd       = 5
+	rar5HasExtra       = 0x0001
+	rar5HasData        = 0x0002
+	rar5FileHasMtime   = 0x0002
+	rar5FileHasCRC32   = 0x0004
+	rar5ExtraEncrypted = 1
+)
+
+// rarEncryptionInfo describes how a RAR archive is protected
+type rarEncryptionInfo struct {
+	// Method is the cipher name, empty for an unencrypted archive
+	Method string
+	// Headers is set when file names are encrypted too (rar -hp)
+	Headers bool
+	// Files holds the names of encrypted entries when headers are readable
+	Files map[string]bool
+}
+
+// Encrypted reports whether anything in the archive needs a password
+func (e *rarEncryptionInfo) Encrypted() bool {
+	return e.Headers || len(e.Files) > 0
+}
+
+// scanRarEncryption walks the block headers of a RAR archive without
+// decompressing anything
+func scanRarEncryption(path string) (*rarEncryptionInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	prefix, _ := r.Peek(rarMaxSFXSize)
+	info := &rarEncryptionInfo{Files: map[string]bool{}}
+
+	if i := bytes.Index(prefix, rar5Signature); i >= 0 {
+		r.Discard(i + len(rar5Signature))
+		err = scanRar5(r, info)
+	} else if i := bytes.Index(prefix, rar4Signature); i >= 0 {
+		r.Discard(i + len(rar4Signature))
+		err = scanRar4(r, info)
+	} else {
+		return nil, fmt.Errorf("not a RAR archive")
+	}
+
+	// A truncated last volume still says enough about the archive
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return info, nil
+}
+
+func scanRar4(r *bufio.Reader, info *rarEncryptionInfo) error {
+	base := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(r, base); err != nil {
+			return err
+		}
+		blockType := base[2]
+		flags := binary.LittleEndian.Uint16(base[3:5])
+		size := int(binary.LittleEndian.Uint16(base[5:7]))
+		if size < len(base) {
+			return fmt.Errorf("corrupt RAR block header")
+		}
+
+		header := make([]byte, size-len(base))
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+
+		var dataSize int64
+		if flags&rar4LongBlock != 0 || blockType == rar4BlockFile {
+			if len(header) < 4 {
+				return fmt.Errorf("corrupt RAR block header")
+			}
+			dataSize = int64(binary.LittleEndian.Uint32(header))
+		}
+
+		switch blockType {
+		case rar4BlockMain:
+			if flags&rar4MainEncrypted != 0 {
+				info.Method = "AES-128"
+				info.Headers = true
+				return nil
+			}
+		case rar4BlockFile:
+			// PACK_SIZE UNP_SIZE HOST_OS FILE_CRC FTIME UNP_VER METHOD NAME_SIZE ATTR
+			if len(header) < 25 {
+				return fmt.Errorf("corrupt RAR file header")
+			}
+			nameSize := int(binary.LittleEndian.Uint16(header[19:21]))
+			offset := 25
+			if flags&rar4FileLarge != 0 {
+				if len(header) < offset+8 {
+					return fmt.Errorf("corrupt RAR file header")
+				}
+				dataSize |= int64(binary.LittleEndian.Uint32(header[offset:])) << 32
+				offset += 8
+			}
+			if len(header) < offset+nameSize {
+				return fmt.Errorf("corrupt RAR file header")
+			}
+			if flags&rar4FileEncrypted != 0 {
+				// Unicode names store the ASCII form first, NUL terminated
+				name, _, _ := strings.Cut(string(header[offset:offset+nameSize]), "\x00")
+				info.Method = "AES-128"
+				info.Files[rarEntryName(name)] = true
+			}
+		case rar4BlockEnd:
+			return nil
+		}
+
+		if _, err := r.Discard(int(dataSize)); err != nil {
+			return err
+		}
+	}
+}
+
+func scanRar5(r *bufio.Reader, info *rarEncryptionInfo) error {
+	for {
+		if _, err := r.Discard(4); err != nil { // header CRC
+			return err
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		header := make([]byte, size)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+
+		b := rar5Buffer(header)
+		blockType := b.uvarint()
+		flags := b.uvarint()
+		var extraSize, dataSize uint64
+		if flags&rar5HasExtra != 0 {
+			extraSize = b.uvarint()
+		}
+		if flags&rar5HasData != 0 {
+			dataSize = b.uvarint()
+		}
+		if uint64(len(b)) < extraSize {
+			return fmt.Errorf("corrupt RAR block header")
+		}
+		extra := rar5Buffer(b[len(b)-int(extraSize):])
+		b = b[:len(b)-int(extraSize)]
+
+		switch blockType {
+		case rar5BlockEncrypt:
+			info.Method = "AES-256"
+			info.Headers = true
+			return nil
+		case rar5BlockFile:
+			if extra.hasRecord(rar5ExtraEncrypted) {
+				info.Method = "AES-256"
+				info.Files[rarEntryName(b.rar5FileName())] = true
+			}
+		case rar5BlockEnd:
+			return nil
+		}
+
+		if _, err := r.Discard(int(dataSize)); err != nil {
+			return err
+		}
+	}
+}
+
+// rar5Buffer decodes the variable length fields of a RAR 5 header
+type rar5Buffer []byte
+
+func (b *rar5Buffer) uvarint() uint64 {
+	v, n := binary.Uvarint(*b)
+	if n <= 0 {
+		*b = nil
+		return 0
+	}
+	*b = (*b)[n:]
+	return v
+}
+
+func (b *rar5Buffer) skip(n int) {
+	if n > len(*b) {
+		n = len(*b)
+	}
+	*b = (*b)[n:]
+}
+
+// rar5FileName reads the name from the type specific part of a file header
+func (b rar5Buffer) rar5FileName() string {
+	flags := b.uvarint()
+	b.uvarint() // unpacked size
+	b.uvarint() // attributes
+	if flags&rar5FileHasMtime != 0 {
+		b.skip(4)
+	}
+	if flags&rar5FileHasCRC32 != 0 {
+		b.skip(4)
+	}
+	b.uvarint() // compression info
+	b.uvarint() // host OS
+	n := int(b.uvarint())
+	if n > len(b) {
+		return ""
+	}
+	return string(b[:n])
+}
+
+// hasRecord reports whether the extra area holds a record of the given type
+func (b rar5Buffer) hasRecord(recordType uint64) bool {
+	for len(b) > 0 {
+		size := b.uvarint()
+		if size == 0 || size > uint64(len(b)) {
+			return false
+		}
+		record := b[:size]
+		b = b[size:]
+		if record.uvarint() == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// rarEntryName normalizes a stored name the way rardecode reports it
+func rarEntryName(name string) string {
+	return strings.ReplaceAll(name, "\\", "/")
+}
+
+// rarPasswordError turns the untyped failures rardecode produces when
+// decrypting with a missing or wrong key into ErrPasswordRequired or
+// ErrWrongPassword
+func rarPasswordError(err error, enc *rarEncryptionInfo, password string) error {
+	if err == nil || enc == nil || !enc.Encrypted() {
+		return err
+	}
+	// Only decoding failures point at the key; I/O errors are reported as is
+	if !strings.HasPrefix(err.Error(), "rardecode:") && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	return ErrWrongPassword
+}
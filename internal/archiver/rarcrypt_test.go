@@ -0,0 +1,291 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+// rar5Options controls how writeRar5 protects the archive
+type rar5Options struct {
+	password       string
+	encryptHeaders bool
+	// skipCheck leaves out the password check value, so a wrong password is
+	// only noticed through the file checksum
+	skipCheck bool
+}
+
+// rar5Keys derives the RAR 5 AES key and password check value
+func rar5Keys(t *testing.T, password string, salt []byte, kdfLog int) (key, check []byte) {
+	t.Helper()
+
+	iterations := 1 << kdfLog
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The check value continues the same PBKDF2 chain for 32 more rounds
+	long, err := pbkdf2.Key(sha256.New, password, salt, iterations+32, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check = make([]byte, 8)
+	for i, v := range long {
+		check[i&7] ^= v
+	}
+	return key, check
+}
+
+func rar5Encrypt(key, iv, data []byte) []byte {
+	padded := make([]byte, (len(data)+15)/16*16)
+	copy(padded, data)
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return padded
+}
+
+// rar5Block frames a header body with its size and CRC
+func rar5Block(body []byte) []byte {
+	header := append(binary.AppendUvarint(nil, uint64(len(body))), body...)
+	block := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(header))
+	return append(block, header...)
+}
+
+// rar5FileBlock builds a file header for stored data
+func rar5FileBlock(name string, content []byte, flags uint64, dataSize int, extra []byte) []byte {
+	body := []byte{rar5BlockFile}
+	body = binary.AppendUvarint(body, rar5HasExtra|rar5HasData|flags)
+	body = binary.AppendUvarint(body, uint64(len(extra)))
+	body = binary.AppendUvarint(body, uint64(dataSize))
+	body = binary.AppendUvarint(body, rar5FileHasMtime|rar5FileHasCRC32)
+	body = binary.AppendUvarint(body, uint64(len(content)))
+	body = binary.AppendUvarint(body, 0644)
+	body = binary.LittleEndian.AppendUint32(body, 1700000000)
+	body = binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(content))
+	body = binary.AppendUvarint(body, 0) // stored
+	body = binary.AppendUvarint(body, 1) // Unix
+	body = binary.AppendUvarint(body, uint64(len(name)))
+	body = append(body, name...)
+	return append(body, extra...)
+}
+
+// writeRar5 writes a RAR 5 archive of stored (uncompressed) files
+func writeRar5(t *testing.T, path string, opts rar5Options, files map[string]string) {
+	t.Helper()
+
+	const kdfLog = 4
+	salt := bytes.Repeat([]byte{0x5a}, 16)
+	var key, check []byte
+	if opts.password != "" {
+		key, check = rar5Keys(t, opts.password, salt, kdfLog)
+	}
+
+	encryptionData := func(iv []byte) []byte {
+		var flags uint64
+		if !opts.skipCheck {
+			flags = 1
+		}
+		b := binary.AppendUvarint(nil, 0) // version
+		b = binary.AppendUvarint(b, flags)
+		b = append(b, kdfLog)
+		b = append(b, salt...)
+		b = append(b, iv...)
+		if !opts.skipCheck {
+			sum := sha256.Sum256(check)
+			b = append(b, check...)
+			b = append(b, sum[:4]...)
+		}
+		return b
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Rar!\x1a\x07\x01\x00")
+
+	headersEncrypted := false
+	ivSeed := byte(1)
+	writeBlock := func(body []byte) {
+		block := rar5Block(body)
+		if headersEncrypted {
+			iv := bytes.Repeat([]byte{ivSeed}, 16)
+			ivSeed++
+			out.Write(iv)
+			block = rar5Encrypt(key, iv, block)
+		}
+		out.Write(block)
+	}
+
+	// Main archive header
+	writeBlock([]byte{1, 0, 0})
+
+	if opts.encryptHeaders {
+		body := []byte{rar5BlockEncrypt, 0}
+		body = append(body, encryptionData(nil)...)
+		writeBlock(body)
+		headersEncrypted = true
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		content := []byte(files[name])
+		data := content
+
+		var extra []byte
+		if opts.password != "" {
+			iv := bytes.Repeat([]byte{0xa0 + ivSeed}, 16)
+			ivSeed++
+			record := append([]byte{rar5ExtraEncrypted}, encryptionData(iv)...)
+			extra = append(binary.AppendUvarint(nil, uint64(len(record))), record...)
+			data = rar5Encrypt(key, iv, content)
+		}
+
+		body := rar5FileBlock(name, content, 0, len(data), extra)
+		writeBlock(body)
+		out.Write(data)
+	}
+
+	writeBlock([]byte{rar5BlockEnd, 0, 0})
+
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var rarTestFiles = map[string]string{
+	"hello.txt":      "Hello World",
+	"docs/notes.txt": "Nested file",
+}
+
+func TestExtractRar(t *testing.T) {
+	tmpDir := t.TempDir()
+	rarPath := filepath.Join(tmpDir, "plain.rar")
+	writeRar5(t, rarPath, rar5Options{}, rarTestFiles)
+
+	if encrypted, err := IsEncrypted(rarPath, models.RAR); err != nil || encrypted {
+		t.Errorf("IsEncrypted() = %v, %v; want false", encrypted, err)
+	}
+
+	destDir := filepath.Join(tmpDir, "dest")
+	if err := Extract(&models.ExtractConfig{ArchivePath: rarPath, DestPath: destDir, ArchiveType: models.RAR}); err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(destDir, "docs", "notes.txt"))
+	if err != nil || string(data) != "Nested file" {
+		t.Errorf("notes.txt = %q, %v", data, err)
+	}
+}
+
+func TestRarPasswordErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name string
+		opts rar5Options
+	}{
+		{"files", rar5Options{password: "right"}},
+		{"headers", rar5Options{password: "right", encryptHeaders: true}},
+		{"no_check_value", rar5Options{password: "right", skipCheck: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rarPath := filepath.Join(tmpDir, tt.name+".rar")
+			writeRar5(t, rarPath, tt.opts, rarTestFiles)
+
+			if encrypted, err := IsEncrypted(rarPath, models.RAR); err != nil || !encrypted {
+				t.Errorf("IsEncrypted() = %v, %v; want true", encrypted, err)
+			}
+
+			destDir := filepath.Join(tmpDir, "dest-"+tt.name)
+			config := &models.ExtractConfig{ArchivePath: rarPath, DestPath: destDir, ArchiveType: models.RAR}
+			if err := Extract(config); !errors.Is(err, ErrPasswordRequired) {
+				t.Errorf("Extract() without password error = %v, want %v", err, ErrPasswordRequired)
+			}
+
+			config.Password = "wrong"
+			if err := Extract(config); !errors.Is(err, ErrWrongPassword) {
+				t.Errorf("Extract() with wrong password error = %v, want %v", err, ErrWrongPassword)
+			}
+			if _, err := os.Stat(filepath.Join(destDir, "hello.txt")); err == nil {
+				t.Error("wrong password left a partially extracted file behind")
+			}
+
+			config.Password = "right"
+			if err := Extract(config); err != nil {
+				t.Fatalf("Extract() failed: %v", err)
+			}
+			data, _ := os.ReadFile(filepath.Join(destDir, "hello.txt"))
+			if string(data) != "Hello World" {
+				t.Errorf("hello.txt = %q", data)
+			}
+
+			info, err := AnalyzeWithPassword(rarPath, "right")
+			if err != nil {
+				t.Fatalf("AnalyzeWithPassword() failed: %v", err)
+			}
+			if info.Encryption != "AES-256" || info.FileCount != 2 {
+				t.Errorf("AnalyzeWithPassword() = %+v, want 2 AES-256 entries", info)
+			}
+			for _, f := range info.Files {
+				if !f.Encrypted {
+					t.Errorf("%s not reported as encrypted", f.Name)
+				}
+			}
+
+			if report, err := AnalyzeReport(rarPath, "right"); err != nil || report == nil || len(report.Largest) != 2 {
+				t.Errorf("AnalyzeReport() = %+v, %v; want 2 files", report, err)
+			}
+			if report, err := AnalyzeReport(rarPath, ""); err != nil || report != nil {
+				t.Errorf("AnalyzeReport() without password = %+v, %v; want no report", report, err)
+			}
+		})
+	}
+}
+
+func TestAnalyzeRarNeedsPassword(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, encryptHeaders := range []bool{false, true} {
+		rarPath := filepath.Join(tmpDir, "secret.rar")
+		writeRar5(t, rarPath, rar5Options{password: "pw", encryptHeaders: encryptHeaders}, rarTestFiles)
+
+		if _, err := Analyze(rarPath); !errors.Is(err, ErrPasswordRequired) {
+			t.Errorf("Analyze() error = %v, want %v", err, ErrPasswordRequired)
+		}
+		if _, err := AnalyzeWithPassword(rarPath, "nope"); !errors.Is(err, ErrWrongPassword) {
+			t.Errorf("AnalyzeWithPassword() error = %v, want %v", err, ErrWrongPassword)
+		}
+	}
+}
+
+func TestScanRar4Encryption(t *testing.T) {
+	// A RAR 4 main header with the encrypted-headers flag set
+	data := []byte("Rar!\x1a\x07\x00")
+	data = append(data, 0, 0, rar4BlockMain)
+	data = binary.LittleEndian.AppendUint16(data, rar4MainEncrypted)
+	data = binary.LittleEndian.AppendUint16(data, 13)
+	data = append(data, make([]byte, 6)...)
+
+	path := filepath.Join(t.TempDir(), "old.rar")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := scanRarEncryption(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enc.Headers || enc.Method != "AES-128" {
+		t.Errorf("scanRarEncryption() = %+v, want encrypted AES-128 headers", enc)
+	}
+}
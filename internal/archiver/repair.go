@@ -0,0 +1,619 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"zipprine/internal/models"
+	"zipprine/pkg/fileutil"
+)
+
+// SalvagedEntry is one member found while repairing an archive
+type SalvagedEntry struct {
+	Name   string
+	Size   int64
+	Offset int64  // position of a ZIP entry's local header in the damaged archive
+	Reason string // why a damaged entry was dropped, or why a recovered one is incomplete
+}
+
+// RepairReport lists what a repair recovered and what it had to drop
+type RepairReport struct {
+	Type           models.ArchiveType
+	Recovered      []SalvagedEntry
+	Damaged        []SalvagedEntry
+	BytesRecovered int64
+	Summary        string
+}
+
+// WriteTo writes the summary followed by every recovered and damaged entry
+func (r *RepairReport) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	b.WriteString(r.Summary + "\n")
+	if len(r.Recovered) > 0 {
+		b.WriteString("\nRecovered:\n")
+		for _, e := range r.Recovered {
+			fmt.Fprintf(&b, "  ✓ %s (%s)", e.Name, fileutil.FormatBytes(e.Size))
+			if e.Reason != "" {
+				fmt.Fprintf(&b, " - %s", e.Reason)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(r.Damaged) > 0 {
+		b.WriteString("\nDamaged:\n")
+		for _, e := range r.Damaged {
+			if r.Type == models.ZIP {
+				fmt.Fprintf(&b, "  ✗ %s at offset %d - %s\n", e.Name, e.Offset, e.Reason)
+			} else {
+				fmt.Fprintf(&b, "  ✗ %s - %s\n", e.Name, e.Reason)
+			}
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (r *RepairReport) recovered(e SalvagedEntry) {
+	r.Recovered = append(r.Recovered, e)
+	r.BytesRecovered += e.Size
+	if e.Reason != "" {
+		fmt.Printf("  ⚠ %s (%s)\n", e.Name, e.Reason)
+	} else {
+		fmt.Printf("  ✓ %s\n", e.Name)
+	}
+}
+
+func (r *RepairReport) damaged(e SalvagedEntry) {
+	r.Damaged = append(r.Damaged, e)
+	fmt.Printf("  ✗ %s (%s)\n", e.Name, e.Reason)
+}
+
+// Repair salvages the intact members of a damaged archive into a new archive
+// of the same type. ZIP archives are rebuilt from their local file headers,
+// so a lost or truncated central directory does not matter; tar and gzip
+// streams keep everything before the point of damage. With config.Extract
+// set, the repaired archive is then extracted with it.
+func Repair(config *models.RepairConfig) (*RepairReport, error) {
+	archiveType := config.ArchiveType
+	if archiveType == "" || archiveType == models.AUTO {
+		detected, err := DetectArchiveType(config.ArchivePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect archive type: %w", err)
+		}
+		archiveType = detected
+	}
+	if sameFile(config.ArchivePath, config.OutputPath) {
+		return nil, fmt.Errorf("the repaired archive must not overwrite the damaged one")
+	}
+
+	outFile, err := os.Create(config.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{Type: archiveType}
+	switch archiveType {
+	case models.ZIP:
+		err = repairZip(config.ArchivePath, outFile, report)
+	case models.TARGZ:
+		err = repairTar(config.ArchivePath, outFile, true, report)
+	case models.TAR:
+		err = repairTar(config.ArchivePath, outFile, false, report)
+	case models.GZIP:
+		err = repairGzip(config.ArchivePath, outFile, report)
+	default:
+		err = fmt.Errorf("cannot repair %s archives", archiveType)
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(config.OutputPath)
+		return nil, err
+	}
+
+	report.Summary = fmt.Sprintf(
+		"Repair Summary:\n"+
+			"  Recovered: %d\n"+
+			"  Damaged: %d\n"+
+			"  Bytes recovered: %s",
+		len(report.Recovered),
+		len(report.Damaged),
+		fileutil.FormatBytes(report.BytesRecovered),
+	)
+
+	if config.Extract != nil {
+		extractConfig := *config.Extract
+		extractConfig.ArchivePath = config.OutputPath
+		extractConfig.ArchiveType = archiveType
+		if err := Extract(&extractConfig); err != nil {
+			return report, fmt.Errorf("failed to extract the repaired archive: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// zipLocalMagic starts every local file header
+var zipLocalMagic = []byte("PK\x03\x04")
+
+// zipDataDescriptorMagic optionally starts the data descriptor after an entry
+var zipDataDescriptorMagic = []byte("PK\x07\x08")
+
+// repairZip copies every entry whose local header and data are intact into
+// a new ZIP without recompressing it. File modes live only in the central
+// directory, so recovered entries get default permissions.
+func repairZip(path string, out io.Writer, report *RepairReport) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	zw := zip.NewWriter(out)
+	for pos := int64(0); ; {
+		at, err := findSignature(file, size, pos, zipLocalMagic)
+		if err != nil {
+			return err
+		}
+		if at < 0 {
+			break
+		}
+
+		entry, err := salvageZipEntry(file, size, at)
+		if err != nil {
+			name := "(unnamed entry)"
+			if entry != nil {
+				name = entry.header.Name
+			}
+			report.damaged(SalvagedEntry{Name: name, Offset: at, Reason: err.Error()})
+			// The header may be a false match, so look again right after it
+			pos = at + int64(len(zipLocalMagic))
+			continue
+		}
+
+		w, err := zw.CreateRaw(entry.header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(file, entry.dataStart, int64(entry.header.CompressedSize64))); err != nil {
+			return err
+		}
+		report.recovered(SalvagedEntry{Name: entry.header.Name, Size: int64(entry.header.UncompressedSize64), Offset: at})
+		pos = entry.end
+	}
+	return zw.Close()
+}
+
+// findSignature returns the offset of the first sig at or after from, or -1
+func findSignature(r io.ReaderAt, size, from int64, sig []byte) (int64, error) {
+	buf := make([]byte, 64<<10)
+	for from < size {
+		n, err := r.ReadAt(buf, from)
+		if err != nil && err != io.EOF {
+			return -1, err
+		}
+		if i := bytes.Index(buf[:n], sig); i >= 0 {
+			return from + int64(i), nil
+		}
+		if from+int64(n) >= size {
+			break
+		}
+		// Overlap the chunks so a signature on the boundary is not missed
+		from += int64(n - len(sig) + 1)
+	}
+	return -1, nil
+}
+
+// zipSalvage is an entry recovered from its local header
+type zipSalvage struct {
+	header    *zip.FileHeader
+	dataStart int64
+	end       int64 // just past the data and any data descriptor
+}
+
+// salvageZipEntry reads the entry whose local header starts at offset at and
+// checks that its data is complete. The returned salvage carries the header
+// whenever the name could be read, even if the entry turns out damaged.
+func salvageZipEntry(r io.ReaderAt, size, at int64) (*zipSalvage, error) {
+	le := binary.LittleEndian
+	local := make([]byte, zipLocalHeaderLen)
+	if _, err := r.ReadAt(local, at); err != nil {
+		return nil, fmt.Errorf("truncated local header")
+	}
+
+	nameLen, extraLen := int(le.Uint16(local[26:])), int(le.Uint16(local[28:]))
+	if nameLen == 0 {
+		return nil, fmt.Errorf("local header without a name")
+	}
+	nameExtra := make([]byte, nameLen+extraLen)
+	if _, err := r.ReadAt(nameExtra, at+zipLocalHeaderLen); err != nil {
+		return nil, fmt.Errorf("truncated local header")
+	}
+
+	header := &zip.FileHeader{
+		Name:               string(nameExtra[:nameLen]),
+		Flags:              le.Uint16(local[6:]),
+		Method:             le.Uint16(local[8:]),
+		ModifiedTime:       le.Uint16(local[10:]),
+		ModifiedDate:       le.Uint16(local[12:]),
+		CRC32:              le.Uint32(local[14:]),
+		CompressedSize64:   uint64(le.Uint32(local[18:])),
+		UncompressedSize64: uint64(le.Uint32(local[22:])),
+	}
+	entry := &zipSalvage{header: header, dataStart: at + zipLocalHeaderLen + int64(nameLen+extraLen)}
+
+	// zip.Writer adds its own ZIP64 field when the sizes need one
+	extra := nameExtra[nameLen:]
+	for len(extra) >= 4 {
+		id, n := le.Uint16(extra), int(le.Uint16(extra[2:]))
+		if 4+n > len(extra) {
+			break
+		}
+		field := extra[4 : 4+n]
+		if id == zip64ExtraID {
+			for _, v := range []*uint64{&header.UncompressedSize64, &header.CompressedSize64} {
+				if *v == 0xffffffff && len(field) >= 8 {
+					*v = le.Uint64(field)
+					field = field[8:]
+				}
+			}
+		} else {
+			header.Extra = append(header.Extra, extra[:4+n]...)
+		}
+		extra = extra[4+n:]
+	}
+
+	if strings.HasSuffix(header.Name, "/") {
+		header.SetMode(fs.ModeDir | 0755)
+	} else {
+		header.SetMode(0644)
+	}
+
+	encrypted := header.Flags&0x1 != 0
+	if header.Flags&0x8 != 0 {
+		if err := findZipDataEnd(r, size, entry, encrypted); err != nil {
+			return entry, err
+		}
+		// ZipCrypto checks the password against the modification time when
+		// sizes follow the data, so only unencrypted entries lose the flag
+		if !encrypted {
+			header.Flags &^= 0x8
+		}
+		return entry, nil
+	}
+
+	entry.end = entry.dataStart + int64(header.CompressedSize64)
+	if entry.end > size {
+		return entry, fmt.Errorf("truncated data")
+	}
+	if encrypted {
+		// Without the password the contents cannot be checked
+		return entry, nil
+	}
+	return entry, verifyZipData(io.NewSectionReader(r, entry.dataStart, int64(header.CompressedSize64)), header)
+}
+
+// verifyZipData decompresses an entry and compares its size and CRC with the
+// header. Entries in methods this package cannot read are taken as they are.
+func verifyZipData(data io.Reader, header *zip.FileHeader) error {
+	var content io.Reader
+	switch header.Method {
+	case zip.Store:
+		content = data
+	case zip.Deflate:
+		fr := flate.NewReader(data)
+		defer fr.Close()
+		content = fr
+	default:
+		return nil
+	}
+
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, content)
+	if err != nil {
+		return fmt.Errorf("corrupt data: %v", err)
+	}
+	if uint64(n) != header.UncompressedSize64 || crc.Sum32() != header.CRC32 {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+// findZipDataEnd works out the sizes of an entry that keeps them in a data
+// descriptor after its data. Deflate streams mark their own end; other
+// entries are cut at the first descriptor whose size matches.
+func findZipDataEnd(r io.ReaderAt, size int64, entry *zipSalvage, encrypted bool) error {
+	header := entry.header
+	if header.Method == zip.Deflate && !encrypted {
+		counter := &countingByteReader{r: bufio.NewReader(io.NewSectionReader(r, entry.dataStart, size-entry.dataStart))}
+		fr := flate.NewReader(counter)
+		crc := crc32.NewIEEE()
+		n, err := io.Copy(crc, fr)
+		fr.Close()
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("truncated data")
+			}
+			return fmt.Errorf("corrupt data: %v", err)
+		}
+		header.CRC32 = crc.Sum32()
+		header.CompressedSize64 = uint64(counter.n)
+		header.UncompressedSize64 = uint64(n)
+		return readZipDataDescriptor(r, entry)
+	}
+
+	le := binary.LittleEndian
+	for pos := entry.dataStart; ; pos++ {
+		at, err := findSignature(r, size, pos, zipDataDescriptorMagic)
+		if err != nil {
+			return err
+		}
+		if at < 0 {
+			return fmt.Errorf("end of data not found")
+		}
+		desc := make([]byte, 16)
+		if _, err := r.ReadAt(desc, at); err != nil {
+			return fmt.Errorf("truncated data")
+		}
+		if csize := int64(le.Uint32(desc[8:])); csize != at-entry.dataStart {
+			pos = at
+			continue
+		}
+		header.CRC32 = le.Uint32(desc[4:])
+		header.CompressedSize64 = uint64(at - entry.dataStart)
+		header.UncompressedSize64 = uint64(le.Uint32(desc[12:]))
+		entry.end = at + 16
+		if encrypted {
+			return nil
+		}
+		return verifyZipData(io.NewSectionReader(r, entry.dataStart, int64(header.CompressedSize64)), header)
+	}
+}
+
+// readZipDataDescriptor checks the descriptor after a deflated entry against
+// the sizes and CRC found by decompressing it, accepting both the 32-bit and
+// the ZIP64 layout, with or without the optional signature
+func readZipDataDescriptor(r io.ReaderAt, entry *zipSalvage) error {
+	header := entry.header
+	at := entry.dataStart + int64(header.CompressedSize64)
+	desc := make([]byte, 28)
+	n, _ := r.ReadAt(desc, at)
+	desc = desc[:n]
+
+	le := binary.LittleEndian
+	fields := desc
+	skip := int64(0)
+	if bytes.HasPrefix(fields, zipDataDescriptorMagic) {
+		fields, skip = fields[4:], 4
+	}
+	if len(fields) >= 12 && le.Uint32(fields) == header.CRC32 {
+		if uint64(le.Uint32(fields[4:])) == header.CompressedSize64 && uint64(le.Uint32(fields[8:])) == header.UncompressedSize64 {
+			entry.end = at + skip + 12
+			return nil
+		}
+		if len(fields) >= 20 && le.Uint64(fields[4:]) == header.CompressedSize64 && le.Uint64(fields[12:]) == header.UncompressedSize64 {
+			entry.end = at + skip + 20
+			return nil
+		}
+	}
+	if len(fields) < 12 {
+		return fmt.Errorf("truncated data descriptor")
+	}
+	return fmt.Errorf("checksum mismatch")
+}
+
+// countingByteReader counts what flate consumes; flate reads byte by byte
+// from an io.ByteReader, so it stops exactly at the end of the stream
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingByteReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// repairTar copies members into a new tar (or tar.gz) until the stream
+// breaks. A member is only kept when all of its data could be read.
+func repairTar(path string, out io.Writer, isGzipped bool, report *RepairReport) error {
+	file, err := openArchiveFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var src io.Reader = file
+	archiveType := models.TAR
+	if isGzipped {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("nothing to recover: %w", err)
+		}
+		defer gzReader.Close()
+		src = gzReader
+		archiveType = models.TARGZ
+	}
+
+	writer, err := newEntryWriter(out, archiveType, 0)
+	if err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(src)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.damaged(SalvagedEntry{Name: "(rest of archive)", Reason: describeStreamError(err)})
+			break
+		}
+
+		body, err := spoolEntry(tarReader, header.Size)
+		if err != nil {
+			report.damaged(SalvagedEntry{Name: header.Name, Size: header.Size, Reason: describeStreamError(err)})
+			break
+		}
+		err = writer.WriteEntry(&archiveEntry{
+			Name:      header.Name,
+			tarHeader: header,
+			open:      func() (io.ReadCloser, error) { return body, nil },
+		})
+		body.Close()
+		if err != nil {
+			writer.Close()
+			return err
+		}
+		report.recovered(SalvagedEntry{Name: header.Name, Size: header.Size})
+	}
+	return writer.Close()
+}
+
+// spoolEntry reads a member's data in full, in memory or in a temporary file
+// for large members, so that a member cut short is never written
+func spoolEntry(r io.Reader, size int64) (io.ReadCloser, error) {
+	if size <= zipSpillSize {
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, size); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(&buf), nil
+	}
+
+	spill, err := os.CreateTemp("", "zipprine-repair-*")
+	if err != nil {
+		return nil, err
+	}
+	closer := &tempFile{spill}
+	if _, err := io.CopyN(spill, r, size); err != nil {
+		closer.Close()
+		return nil, err
+	}
+	if _, err := spill.Seek(0, io.SeekStart); err != nil {
+		closer.Close()
+		return nil, err
+	}
+	return closer, nil
+}
+
+// tempFile removes itself when closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// describeStreamError turns the ways a damaged stream ends into a reason
+func describeStreamError(err error) string {
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "archive ends early"
+	case errors.Is(err, gzip.ErrChecksum):
+		return "checksum mismatch"
+	case errors.As(err, &corrupt):
+		return "corrupt compressed data"
+	default:
+		return err.Error()
+	}
+}
+
+// repairGzip recompresses whatever a damaged GZIP stream still decodes to.
+// Its single file is kept even when cut short, with the reason noted.
+func repairGzip(path string, out io.Writer, report *RepairReport) error {
+	file, err := openArchiveFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("nothing to recover: %w", err)
+	}
+	defer gzReader.Close()
+
+	name := gzReader.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(segmentBase(path)), ".gz")
+	}
+
+	writer, err := newEntryWriter(out, models.GZIP, 0)
+	if err != nil {
+		return err
+	}
+	content := &salvageReader{r: gzReader}
+	err = writer.WriteEntry(&archiveEntry{
+		Name:    name,
+		Mode:    0644,
+		ModTime: gzReader.ModTime,
+		open:    func() (io.ReadCloser, error) { return io.NopCloser(content), nil },
+	})
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := SalvagedEntry{Name: name, Size: content.n}
+	if content.err != nil {
+		entry.Reason = "incomplete: " + describeStreamError(content.err)
+	}
+	report.recovered(entry)
+	return nil
+}
+
+// salvageReader reads until r fails and then ends cleanly, keeping the error
+type salvageReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (s *salvageReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, io.EOF
+	}
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	if err != nil && err != io.EOF {
+		s.err = err
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,228 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+// truncateFile cuts n bytes off the end of a file
+func truncateFile(t *testing.T, path string, n int64) {
+	t.Helper()
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, stat.Size()-n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepairZipLostDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	// The serial writer leaves sizes to data descriptors, the parallel one
+	// puts them in the local headers
+	tests := []struct {
+		name     string
+		threads  int
+		password string
+	}{
+		{"data_descriptors", 1, ""},
+		{"sized_headers", 2, ""},
+		{"encrypted", 2, "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zipPath := filepath.Join(tmpDir, tt.name+".zip")
+			err := Compress(&models.CompressConfig{
+				SourcePath:  sourceDir,
+				OutputPath:  zipPath,
+				ArchiveType: models.ZIP,
+				Threads:     tt.threads,
+				Password:    tt.password,
+			})
+			if err != nil {
+				t.Fatalf("Compress() failed: %v", err)
+			}
+
+			// Cutting into the central directory makes the archive unreadable
+			truncateFile(t, zipPath, 40)
+			if _, err := zip.OpenReader(zipPath); err == nil {
+				t.Fatal("the truncated archive should not open")
+			}
+
+			repaired := filepath.Join(tmpDir, tt.name+"-repaired.zip")
+			destDir := filepath.Join(tmpDir, tt.name+"-dest")
+			report, err := Repair(&models.RepairConfig{
+				ArchivePath: zipPath,
+				OutputPath:  repaired,
+				Extract:     &models.ExtractConfig{DestPath: destDir, Password: tt.password},
+			})
+			if err != nil {
+				t.Fatalf("Repair() failed: %v", err)
+			}
+			if len(report.Recovered) != 3 || len(report.Damaged) != 0 {
+				t.Errorf("Repair() recovered %d and dropped %d entries, want 3 and 0", len(report.Recovered), len(report.Damaged))
+			}
+			if report.BytesRecovered != 390<<10+11 {
+				t.Errorf("BytesRecovered = %d, want %d", report.BytesRecovered, 390<<10+11)
+			}
+			checkExtracted(t, destDir, files)
+		})
+	}
+}
+
+func TestRepairZipDamagedEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	zipPath := filepath.Join(tmpDir, "damaged.zip")
+	if err := Compress(&models.CompressConfig{SourcePath: sourceDir, OutputPath: zipPath, ArchiveType: models.ZIP, Threads: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := map[string]int64{}
+	for _, f := range r.File {
+		offsets[f.Name], _ = f.DataOffset()
+	}
+	r.Close()
+
+	// Flip a byte inside nested/mid.bin and cut small.txt, the last entry, short
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offsets["nested/mid.bin"]+1000] ^= 0xff
+	data = data[:offsets["small.txt"]+2]
+	if err := os.WriteFile(zipPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repaired := filepath.Join(tmpDir, "repaired.zip")
+	destDir := filepath.Join(tmpDir, "dest")
+	report, err := Repair(&models.RepairConfig{
+		ArchivePath: zipPath,
+		OutputPath:  repaired,
+		ArchiveType: models.ZIP,
+		Extract:     &models.ExtractConfig{DestPath: destDir},
+	})
+	if err != nil {
+		t.Fatalf("Repair() failed: %v", err)
+	}
+
+	if len(report.Recovered) != 1 || report.Recovered[0].Name != "random.bin" {
+		t.Errorf("Recovered = %+v, want only random.bin", report.Recovered)
+	}
+	damaged := map[string]string{}
+	for _, e := range report.Damaged {
+		damaged[e.Name] = e.Reason
+	}
+	if damaged["nested/mid.bin"] == "" || damaged["small.txt"] != "truncated data" {
+		t.Errorf("Damaged = %+v, want nested/mid.bin and truncated small.txt", report.Damaged)
+	}
+	checkExtracted(t, destDir, map[string][]byte{"random.bin": files["random.bin"]})
+
+	var buf bytes.Buffer
+	report.WriteTo(&buf)
+	for _, want := range []string{"Recovered: 1", "Damaged: 2", "✓ random.bin", "✗ small.txt at offset"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRepairTarGzTruncated(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	// Members are stored in walk order: nested/mid.bin, random.bin, small.txt
+	archivePath := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := Compress(&models.CompressConfig{SourcePath: sourceDir, OutputPath: archivePath, ArchiveType: models.TARGZ}); err != nil {
+		t.Fatal(err)
+	}
+	truncateFile(t, archivePath, 100<<10)
+
+	repaired := filepath.Join(tmpDir, "repaired.tar.gz")
+	destDir := filepath.Join(tmpDir, "dest")
+	report, err := Repair(&models.RepairConfig{
+		ArchivePath: archivePath,
+		OutputPath:  repaired,
+		Extract:     &models.ExtractConfig{DestPath: destDir},
+	})
+	if err != nil {
+		t.Fatalf("Repair() failed: %v", err)
+	}
+
+	var recovered []string
+	for _, e := range report.Recovered {
+		recovered = append(recovered, e.Name)
+	}
+	if !strings.Contains(strings.Join(recovered, ","), "nested/mid.bin") {
+		t.Errorf("Recovered = %v, want nested/mid.bin", recovered)
+	}
+	if len(report.Damaged) != 1 || report.Damaged[0].Name != "random.bin" || report.Damaged[0].Reason != "archive ends early" {
+		t.Errorf("Damaged = %+v, want random.bin cut short", report.Damaged)
+	}
+	checkExtracted(t, destDir, map[string][]byte{"nested/mid.bin": files["nested/mid.bin"]})
+	if _, err := os.Stat(filepath.Join(destDir, "random.bin")); err == nil {
+		t.Error("the truncated random.bin should not be extracted")
+	}
+}
+
+func TestRepairGzipTruncated(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	archivePath := filepath.Join(tmpDir, "random.bin.gz")
+	if err := Compress(&models.CompressConfig{SourcePath: filepath.Join(sourceDir, "random.bin"), OutputPath: archivePath, ArchiveType: models.GZIP}); err != nil {
+		t.Fatal(err)
+	}
+	truncateFile(t, archivePath, 150<<10)
+
+	repaired := filepath.Join(tmpDir, "repaired.gz")
+	report, err := Repair(&models.RepairConfig{ArchivePath: archivePath, OutputPath: repaired})
+	if err != nil {
+		t.Fatalf("Repair() failed: %v", err)
+	}
+	if len(report.Recovered) != 1 || !strings.HasPrefix(report.Recovered[0].Reason, "incomplete") {
+		t.Fatalf("Recovered = %+v, want one incomplete file", report.Recovered)
+	}
+
+	file, err := os.Open(repaired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(gzReader)
+	if err != nil {
+		t.Fatalf("the repaired gzip is not valid: %v", err)
+	}
+	if int64(len(got)) != report.Recovered[0].Size || len(got) < 100<<10 || !bytes.HasPrefix(files["random.bin"], got) {
+		t.Errorf("recovered %d bytes that are not a prefix of the original", len(got))
+	}
+	if gzReader.Name != "random.bin" {
+		t.Errorf("repaired gzip names %q, want random.bin", gzReader.Name)
+	}
+}
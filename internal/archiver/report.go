@@ -0,0 +1,199 @@
+package archiver
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"zipprine/internal/models"
+)
+
+// reportTop is how many entries the largest and worst-compressing lists of
+// an AnalysisReport hold
+const reportTop = 10
+
+// minRatioSize keeps tiny files out of the worst-compressing list, since
+// format overhead alone makes their ratio poor
+const minRatioSize = 1024
+
+// reportMaxRead bounds how much entry data AnalyzeReport reads
+const reportMaxRead = 4 << 30
+
+// errReportFull stops the walk of an archive whose entries cannot be
+// skipped without reading them once the report has read reportMaxRead
+var errReportFull = errors.New("report read limit reached")
+
+// AnalyzeReport breaks an archive's contents down to show what takes up the
+// space. It decompresses and hashes every entry, so it is kept apart from
+// AnalyzeWithPassword and only run where the report is shown; past
+// reportMaxRead bytes the report is marked Partial.
+func AnalyzeReport(path, password string) (*models.AnalysisReport, error) {
+	archiveType, err := DetectArchiveType(path)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeReport(path, archiveType, password, reportMaxRead)
+}
+
+// compressionRatio is the percentage of size saved by compression
+func compressionRatio(size, compressed int64) float64 {
+	if size <= 0 {
+		return 0
+	}
+	return (1 - float64(compressed)/float64(size)) * 100
+}
+
+// analyzeReport reads every file of an archive once to break down where its
+// size goes and to find duplicate contents. Encrypted ZIP entries that
+// cannot be opened are counted but not hashed; an encrypted RAR without its
+// password has no report.
+// Once maxRead bytes have been read ZIP entries are only counted, while
+// other formats stop there, since skipping their entries reads them anyway.
+func analyzeReport(archivePath string, archiveType models.ArchiveType, password string, maxRead int64) (*models.AnalysisReport, error) {
+	extensions := map[string]*models.GroupStats{}
+	directories := map[string]*models.GroupStats{}
+	add := func(groups map[string]*models.GroupStats, name string, file models.FileInfo) {
+		group := groups[name]
+		if group == nil {
+			group = &models.GroupStats{Name: name}
+			groups[name] = group
+		}
+		group.Files++
+		group.Size += file.Size
+		group.CompressedSize += file.CompressedSize
+	}
+
+	// Contents are the same when both hash and size are
+	type contents struct {
+		hash string
+		size int64
+	}
+	var files []models.FileInfo
+	copies := map[contents][]string{}
+	var order []contents
+	var read int64
+	partial := false
+	err := walkArchiveWithPassword(archivePath, archiveType, password, func(entry *archiveEntry) error {
+		if !entry.IsRegular() {
+			return nil
+		}
+		if read+entry.Size > maxRead {
+			partial = true
+			if entry.zipFile == nil {
+				return errReportFull
+			}
+		}
+		name := cleanEntryName(entry.Name)
+		file := models.FileInfo{
+			Name:    name,
+			Size:    entry.Size,
+			ModTime: entry.ModTime.Format("2006-01-02 15:04:05"),
+		}
+		if entry.zipFile != nil {
+			file.CompressedSize = int64(entry.zipFile.CompressedSize64)
+			file.CompressionRatio = compressionRatio(file.Size, file.CompressedSize)
+			file.Encrypted = zipEncryption(entry.zipFile) != ""
+		}
+		files = append(files, file)
+
+		ext := strings.ToLower(path.Ext(name))
+		if ext == "" {
+			ext = "(none)"
+		}
+		add(extensions, ext, file)
+		dir, _, nested := strings.Cut(name, "/")
+		if !nested {
+			dir = "(root)"
+		}
+		add(directories, dir, file)
+
+		if read+entry.Size > maxRead {
+			return nil
+		}
+		read += entry.Size
+		if hash := hashEntry(entry, password); hash != "" && file.Size > 0 {
+			key := contents{hash, file.Size}
+			if copies[key] == nil {
+				order = append(order, key)
+			}
+			copies[key] = append(copies[key], name)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrPasswordRequired) {
+		return nil, nil
+	}
+	if err != nil && !errors.Is(err, errReportFull) {
+		return nil, err
+	}
+
+	report := &models.AnalysisReport{
+		Extensions:  sortedGroups(extensions),
+		Directories: sortedGroups(directories),
+		Partial:     partial,
+	}
+
+	largest := slices.Clone(files)
+	slices.SortStableFunc(largest, func(a, b models.FileInfo) int { return cmp.Compare(b.Size, a.Size) })
+	report.Largest = largest[:min(reportTop, len(largest))]
+
+	var worst []models.FileInfo
+	for _, file := range files {
+		if file.CompressedSize > 0 && file.Size >= minRatioSize {
+			worst = append(worst, file)
+		}
+	}
+	slices.SortStableFunc(worst, func(a, b models.FileInfo) int {
+		return cmp.Or(cmp.Compare(a.CompressionRatio, b.CompressionRatio), cmp.Compare(b.Size, a.Size))
+	})
+	report.WorstCompressed = worst[:min(reportTop, len(worst))]
+
+	for _, key := range order {
+		if names := copies[key]; len(names) > 1 {
+			report.Duplicates = append(report.Duplicates, models.DuplicateGroup{SHA256: key.hash, Size: key.size, Names: names})
+		}
+	}
+	slices.SortStableFunc(report.Duplicates, func(a, b models.DuplicateGroup) int {
+		return cmp.Compare(b.Size*int64(len(b.Names)-1), a.Size*int64(len(a.Names)-1))
+	})
+	return report, nil
+}
+
+// hashEntry returns the hex SHA-256 of an entry's contents, or "" when they
+// cannot be read
+func hashEntry(entry *archiveEntry, password string) string {
+	var rc io.ReadCloser
+	var err error
+	if entry.zipFile != nil {
+		rc, err = openZipFile(entry.zipFile, password)
+	} else {
+		rc, err = entry.Open()
+	}
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, rc); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// sortedGroups orders groups by size, largest first
+func sortedGroups(groups map[string]*models.GroupStats) []models.GroupStats {
+	list := make([]models.GroupStats, 0, len(groups))
+	for _, group := range groups {
+		list = append(list, *group)
+	}
+	slices.SortFunc(list, func(a, b models.GroupStats) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Name, b.Name))
+	})
+	return list
+}
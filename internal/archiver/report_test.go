@@ -0,0 +1,114 @@
+package archiver
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+func TestAnalyzeReport(t *testing.T) {
+	source := t.TempDir()
+	write := func(name string, data []byte) {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, data, 0644)
+	}
+	noise := make([]byte, 8*1024)
+	rand.New(rand.NewSource(1)).Read(noise)
+	config := []byte(strings.Repeat("key = value\n", 100))
+
+	write("assets/bundle.js", []byte(strings.Repeat("console.log('bloat');\n", 3000)))
+	write("assets/noise.bin", noise)
+	write("assets/config.ini", config)
+	write("backup/config.ini", config)
+	write("README", []byte("readme\n"))
+
+	for _, archiveType := range []models.ArchiveType{models.ZIP, models.TARGZ} {
+		t.Run(string(archiveType), func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), "bundle")
+			if err := Compress(&models.CompressConfig{
+				SourcePath:  source,
+				OutputPath:  archivePath,
+				ArchiveType: archiveType,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := Analyze(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Report != nil {
+				t.Error("Analyze() built a report")
+			}
+			report, err := AnalyzeReport(archivePath, "")
+			if err != nil || report == nil {
+				t.Fatalf("AnalyzeReport() = %v, %v", report, err)
+			}
+			if report.Partial {
+				t.Error("report is partial")
+			}
+
+			if ext := report.Extensions[0]; ext.Name != ".js" || ext.Files != 1 {
+				t.Errorf("largest extension = %+v; want .js", ext)
+			}
+			if i := slices.IndexFunc(report.Extensions, func(g models.GroupStats) bool { return g.Name == ".ini" }); i < 0 || report.Extensions[i].Files != 2 {
+				t.Errorf("Extensions = %+v; want two .ini files", report.Extensions)
+			}
+			var dirs []string
+			for _, dir := range report.Directories {
+				dirs = append(dirs, dir.Name)
+			}
+			if !slices.Equal(dirs, []string{"assets", "backup", "(root)"}) {
+				t.Errorf("Directories = %v", dirs)
+			}
+
+			if len(report.Largest) != 5 || report.Largest[0].Name != "assets/bundle.js" || report.Largest[1].Name != "assets/noise.bin" {
+				t.Errorf("Largest = %+v", report.Largest)
+			}
+
+			if len(report.Duplicates) != 1 || !slices.Equal(report.Duplicates[0].Names, []string{"assets/config.ini", "backup/config.ini"}) {
+				t.Errorf("Duplicates = %+v", report.Duplicates)
+			} else if report.Duplicates[0].Size != int64(len(config)) || len(report.Duplicates[0].SHA256) != 64 {
+				t.Errorf("duplicate group = %+v", report.Duplicates[0])
+			}
+
+			// Past the read limit ZIP entries are still counted, while a
+			// tar stream stops
+			partial, err := analyzeReport(archivePath, archiveType, "", 1024)
+			if err != nil || !partial.Partial {
+				t.Fatalf("analyzeReport() with a small limit = %+v, %v; want a partial report", partial, err)
+			}
+			var files int
+			for _, ext := range partial.Extensions {
+				files += ext.Files
+			}
+			if archiveType == models.ZIP && (files != 5 || partial.Duplicates != nil) || archiveType != models.ZIP && files >= 5 {
+				t.Errorf("partial report counts %d files, duplicates %+v", files, partial.Duplicates)
+			}
+
+			if archiveType != models.ZIP {
+				if report.WorstCompressed != nil {
+					t.Errorf("WorstCompressed = %+v; want none without per-entry sizes", report.WorstCompressed)
+				}
+				return
+			}
+			// README is too small to rank; the JavaScript compresses best
+			worst := report.WorstCompressed
+			if len(worst) != 4 || worst[0].Name != "assets/noise.bin" || worst[3].Name != "assets/bundle.js" {
+				t.Errorf("WorstCompressed = %+v", worst)
+			}
+			if worst[0].CompressedSize == 0 || worst[0].CompressionRatio > 1 || worst[3].CompressionRatio < 90 {
+				t.Errorf("ratios: %+v", worst)
+			}
+			if i := slices.IndexFunc(info.Files, func(f models.FileInfo) bool { return f.Name == "assets/bundle.js" }); i < 0 || info.Files[i].CompressedSize == 0 {
+				t.Errorf("Files do not carry compressed sizes: %+v", info.Files)
+			}
+		})
+	}
+}
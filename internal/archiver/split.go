@@ -0,0 +1,306 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"zipprine/internal/models"
+)
+
+// Split archives are written as numbered segments (backup.tar.gz.000,
+// .001 …) for tar and gzip output, and in the PKWARE split layout
+// (backup.z01, .z02 … backup.zip) for ZIP. Readers are pointed at the first
+// part and see the volumes as one archive.
+
+// minSplitSize keeps every volume large enough for any ZIP header
+const minSplitSize = 64 << 10
+
+// ErrMissingVolume is returned when a volume of a multi-volume archive
+// cannot be found
+var ErrMissingVolume = errors.New("missing volume")
+
+var segmentSuffix = regexp.MustCompile(`\.(\d{3,})$`)
+
+// checkSplit validates the split settings of a compression
+func checkSplit(config *models.CompressConfig) error {
+	if config.OutputPath == StdioPath {
+		return fmt.Errorf("cannot split an archive written to stdout")
+	}
+	if config.SplitSize < minSplitSize {
+		return fmt.Errorf("split size must be at least %d KB", minSplitSize>>10)
+	}
+	return nil
+}
+
+// createArchiveOutput opens the output of a compression, cutting it into
+// numbered segments when a split size is set
+func createArchiveOutput(config *models.CompressConfig) (io.WriteCloser, error) {
+	if config.SplitSize > 0 {
+		return &segmentWriter{path: config.OutputPath, size: config.SplitSize, out: compressProgress(config)}, nil
+	}
+	return createOutput(config.OutputPath)
+}
+
+// segmentName returns the name of the n-th numbered segment of path
+func segmentName(path string, n int) string {
+	return fmt.Sprintf("%s.%03d", path, n)
+}
+
+// segmentWriter writes a stream across fixed-size numbered segments
+type segmentWriter struct {
+	path string
+	size int64
+	out  io.Writer
+
+	file *os.File
+	n    int   // segments created so far
+	pos  int64 // bytes written to the current segment
+}
+
+func (w *segmentWriter) next() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+	}
+	name := segmentName(w.path, w.n)
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "  ✂ %s\n", filepath.Base(name))
+	w.file, w.pos = file, 0
+	w.n++
+	return nil
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.file == nil || w.pos == w.size {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p[:min(int64(len(p)), w.size-w.pos)]
+		n, err := w.file.Write(chunk)
+		written += n
+		w.pos += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close finishes the last segment and removes any left over from an
+// earlier, longer run so they are not mistaken for part of this archive
+func (w *segmentWriter) Close() error {
+	if w.file == nil {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	numbers, err := segmentNumbers(w.path)
+	if err != nil {
+		return err
+	}
+	for _, n := range numbers {
+		if n >= w.n {
+			os.Remove(segmentName(w.path, n))
+		}
+	}
+	return nil
+}
+
+// segmentNumbers lists the numbers of the existing segments of base
+func segmentNumbers(base string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(base) + "."
+	var numbers []int
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !segmentSuffix.MatchString("."+suffix) {
+			continue
+		}
+		if n, err := strconv.Atoi(suffix); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// segmentVolumes returns the numbered segments of a split archive when path
+// names its first one (.000), and nil otherwise
+func segmentVolumes(path string) ([]string, error) {
+	if !strings.HasSuffix(path, ".000") {
+		return nil, nil
+	}
+	base := strings.TrimSuffix(path, ".000")
+	numbers, err := segmentNumbers(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []string
+	for i, n := range numbers {
+		if n != i {
+			return nil, fmt.Errorf("%w: %s", ErrMissingVolume, filepath.Base(segmentName(base, i)))
+		}
+		volumes = append(volumes, segmentName(base, n))
+	}
+	if len(volumes) == 0 {
+		return nil, nil
+	}
+	return volumes, nil
+}
+
+// segmentBase strips the segment number from the first segment's name
+func segmentBase(path string) string {
+	if strings.HasSuffix(path, ".000") {
+		return strings.TrimSuffix(path, ".000")
+	}
+	return path
+}
+
+// archiveVolumes returns every volume of a split archive in order, or nil
+// when path is a single-file archive
+func archiveVolumes(path string) ([]string, error) {
+	volumes, err := zipVolumes(path)
+	if volumes != nil || err != nil {
+		return volumes, err
+	}
+	return segmentVolumes(path)
+}
+
+// volumeReader reads a series of volumes as one stream
+type volumeReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *volumeReader) Close() error {
+	var firstErr error
+	for _, f := range r.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func openVolumes(volumes []string) (*volumeReader, error) {
+	r := &volumeReader{}
+	readers := make([]io.Reader, 0, len(volumes))
+	for _, volume := range volumes {
+		file, err := os.Open(volume)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, file)
+		readers = append(readers, file)
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// openArchiveFile opens a stream-read archive, joining the segments of a
+// split archive when path names the first one
+func openArchiveFile(path string) (io.ReadCloser, error) {
+	volumes, err := segmentVolumes(path)
+	if err != nil {
+		return nil, err
+	}
+	if volumes == nil {
+		return os.Open(path)
+	}
+	return openVolumes(volumes)
+}
+
+// measureArchive fills in the on-disk size, checksum and volumes of an
+// archive, covering every volume of a split one
+func measureArchive(path string, info *models.ArchiveInfo) error {
+	volumes, err := archiveVolumes(path)
+	if err != nil {
+		return err
+	}
+	if volumes == nil {
+		volumes = []string{path}
+	} else {
+		info.Volumes = volumes
+	}
+
+	r, err := openVolumes(volumes)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return err
+	}
+	info.CompressedSize = size
+	info.Checksum = fmt.Sprintf("%x", hash.Sum(nil))
+	return nil
+}
+
+// multiReaderAt presents several ReaderAts back to back
+type multiReaderAt struct {
+	parts  []io.ReaderAt
+	starts []int64 // offset of each part; one extra entry holds the total size
+}
+
+func newMultiReaderAt(parts []io.ReaderAt, sizes []int64) *multiReaderAt {
+	m := &multiReaderAt{parts: parts, starts: make([]int64, len(sizes)+1)}
+	for i, size := range sizes {
+		m.starts[i+1] = m.starts[i] + size
+	}
+	return m
+}
+
+func (m *multiReaderAt) Size() int64 {
+	return m.starts[len(m.starts)-1]
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for len(p) > 0 {
+		if off >= m.Size() {
+			return read, io.EOF
+		}
+		// The part holding off is the last one starting at or before it
+		i := sort.Search(len(m.parts), func(i int) bool { return m.starts[i+1] > off })
+		chunk := p[:min(int64(len(p)), m.starts[i+1]-off)]
+		n, err := m.parts[i].ReadAt(chunk, off-m.starts[i])
+		read += n
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+	return read, nil
+}
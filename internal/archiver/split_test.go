@@ -0,0 +1,271 @@
+package archiver
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+// createSplitSource writes files that will not compress below a few volumes
+func createSplitSource(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+
+	rng := rand.New(rand.NewSource(38))
+	files := map[string][]byte{
+		"small.txt":      []byte("Hello World"),
+		"random.bin":     make([]byte, 300<<10),
+		"nested/mid.bin": make([]byte, 90<<10),
+	}
+	rng.Read(files["random.bin"])
+	rng.Read(files["nested/mid.bin"])
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func checkExtracted(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s differs after extraction (%d bytes, %v)", name, len(got), err)
+		}
+	}
+}
+
+func TestCompressSplitZip(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	zipPath := filepath.Join(tmpDir, "out.zip")
+	err := Compress(&models.CompressConfig{
+		SourcePath:  sourceDir,
+		OutputPath:  zipPath,
+		ArchiveType: models.ZIP,
+		SplitSize:   minSplitSize,
+	})
+	if err != nil {
+		t.Fatalf("Compress() failed: %v", err)
+	}
+
+	volumes, err := zipVolumes(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) < 6 || volumes[0] != filepath.Join(tmpDir, "out.z01") {
+		t.Fatalf("volumes = %v, want out.z01 … out.zip", volumes)
+	}
+	var total int64
+	for _, v := range volumes {
+		stat, _ := os.Stat(v)
+		if stat.Size() > minSplitSize {
+			t.Errorf("%s is %d bytes, over the split size", v, stat.Size())
+		}
+		total += stat.Size()
+	}
+
+	// Pointing at either end of the set works
+	for _, start := range []string{volumes[0], zipPath} {
+		destDir := filepath.Join(tmpDir, "dest-"+filepath.Ext(start)[1:])
+		if err := Extract(&models.ExtractConfig{ArchivePath: start, DestPath: destDir, ArchiveType: models.ZIP}); err != nil {
+			t.Fatalf("Extract(%s) failed: %v", filepath.Base(start), err)
+		}
+		checkExtracted(t, destDir, files)
+	}
+
+	info, err := Analyze(volumes[0])
+	if err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+	if info.FileCount != 3 || info.CompressedSize != total || len(info.Volumes) != len(volumes) {
+		t.Errorf("Analyze() = %d files, %d bytes over %d volumes; want 3, %d over %d",
+			info.FileCount, info.CompressedSize, len(info.Volumes), total, len(volumes))
+	}
+
+	// Info-ZIP follows the directory across the volumes
+	if _, err := exec.LookPath("zip"); err == nil {
+		out, err := exec.Command("zip", "-sf", zipPath).CombinedOutput()
+		if err != nil || !strings.Contains(string(out), "Total 3 entries") {
+			t.Errorf("zip -sf failed: %v\n%s", err, out)
+		}
+	}
+}
+
+func TestExtractInfoZipSplit(t *testing.T) {
+	if _, err := exec.LookPath("zip"); err != nil {
+		t.Skip("zip not installed")
+	}
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	zipPath := filepath.Join(tmpDir, "info.zip")
+	cmd := exec.Command("zip", "-r", "-q", "-s", "64k", zipPath, ".")
+	cmd.Dir = sourceDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("zip -s failed: %v\n%s", err, out)
+	}
+
+	destDir := filepath.Join(tmpDir, "dest")
+	if err := Extract(&models.ExtractConfig{ArchivePath: filepath.Join(tmpDir, "info.z01"), DestPath: destDir, ArchiveType: models.ZIP}); err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+	checkExtracted(t, destDir, files)
+}
+
+func TestSplitZipMissingVolume(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	createSplitSource(t, sourceDir)
+
+	zipPath := filepath.Join(tmpDir, "out.zip")
+	if err := Compress(&models.CompressConfig{SourcePath: sourceDir, OutputPath: zipPath, ArchiveType: models.ZIP, SplitSize: minSplitSize}); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Remove(filepath.Join(tmpDir, "out.z03"))
+	_, err := Analyze(zipPath)
+	if !errors.Is(err, ErrMissingVolume) || !strings.Contains(err.Error(), "out.z03") {
+		t.Errorf("Analyze() error = %v, want missing out.z03", err)
+	}
+}
+
+func TestCompressSplitSmallZip(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	createTestFiles(t, sourceDir)
+
+	zipPath := filepath.Join(tmpDir, "small.zip")
+	if err := Compress(&models.CompressConfig{SourcePath: sourceDir, OutputPath: zipPath, ArchiveType: models.ZIP, SplitSize: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An archive that fits in one volume is a regular ZIP
+	if _, err := os.Stat(filepath.Join(tmpDir, "small.z01")); err == nil {
+		t.Error("a single-volume archive should not have .z01")
+	}
+	if _, err := Analyze(zipPath); err != nil {
+		t.Errorf("Analyze() failed: %v", err)
+	}
+}
+
+func TestCompressSplitTarGz(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	outPath := filepath.Join(tmpDir, "out.tar.gz")
+	// A segment left over from a longer earlier run must not be picked up
+	os.WriteFile(segmentName(outPath, 40), []byte("stale"), 0644)
+
+	err := Compress(&models.CompressConfig{
+		SourcePath:  sourceDir,
+		OutputPath:  outPath,
+		ArchiveType: models.TARGZ,
+		SplitSize:   minSplitSize,
+	})
+	if err != nil {
+		t.Fatalf("Compress() failed: %v", err)
+	}
+
+	first := segmentName(outPath, 0)
+	volumes, err := segmentVolumes(first)
+	if err != nil || len(volumes) < 6 {
+		t.Fatalf("segmentVolumes() = %v, %v", volumes, err)
+	}
+
+	archiveType, err := DetectArchiveType(first)
+	if err != nil || archiveType != models.TARGZ {
+		t.Errorf("DetectArchiveType() = %s, %v; want %s", archiveType, err, models.TARGZ)
+	}
+
+	destDir := filepath.Join(tmpDir, "dest")
+	if err := Extract(&models.ExtractConfig{ArchivePath: first, DestPath: destDir, ArchiveType: models.TARGZ}); err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+	checkExtracted(t, destDir, files)
+
+	info, err := Analyze(first)
+	if err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+	if len(info.Volumes) != len(volumes) || info.TotalSize != 390<<10+11 {
+		t.Errorf("Analyze() = %d volumes, %d bytes", len(info.Volumes), info.TotalSize)
+	}
+
+	os.Remove(volumes[2])
+	if _, err := Analyze(first); !errors.Is(err, ErrMissingVolume) || !strings.Contains(err.Error(), "out.tar.gz.002") {
+		t.Errorf("Analyze() error = %v, want missing out.tar.gz.002", err)
+	}
+}
+
+func TestCompressSplitInvalid(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestFiles(t, filepath.Join(tmpDir, "source"))
+
+	tests := []struct {
+		name   string
+		output string
+		size   int64
+	}{
+		{"too_small", filepath.Join(tmpDir, "out.tar"), 1024},
+		{"stdout", StdioPath, minSplitSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Compress(&models.CompressConfig{
+				SourcePath:  filepath.Join(tmpDir, "source"),
+				OutputPath:  tt.output,
+				ArchiveType: models.TAR,
+				SplitSize:   tt.size,
+			})
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestCompressSplitZipEncrypted(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	files := createSplitSource(t, sourceDir)
+
+	// The parallel writer stores sizes up front instead of data descriptors
+	zipPath := filepath.Join(tmpDir, "secret.zip")
+	err := Compress(&models.CompressConfig{
+		SourcePath:  sourceDir,
+		OutputPath:  zipPath,
+		ArchiveType: models.ZIP,
+		Threads:     4,
+		Password:    "pw",
+		SplitSize:   minSplitSize,
+	})
+	if err != nil {
+		t.Fatalf("Compress() failed: %v", err)
+	}
+
+	destDir := filepath.Join(tmpDir, "dest")
+	if err := Extract(&models.ExtractConfig{ArchivePath: zipPath, DestPath: destDir, ArchiveType: models.ZIP, Password: "pw"}); err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+	checkExtracted(t, destDir, files)
+}
@@ -0,0 +1,150 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"zipprine/internal/models"
+)
+
+// StdioPath stands for stdin when used as an archive or source path and for
+// stdout when used as an output path
+const StdioPath = "-"
+
+// stdin is the stream read when an archive path is StdioPath; tests replace it
+var stdin io.Reader = os.Stdin
+
+// stdout is the stream written when an output path is StdioPath; tests replace it
+var stdout io.Writer = os.Stdout
+
+// stderr is where warnings about skipped entries go; tests replace it
+var stderr io.Writer = os.Stderr
+
+// nopWriteCloser keeps archive writers from closing the process's stdout
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// createOutput opens the destination of a new archive, honouring StdioPath
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == StdioPath {
+		return nopWriteCloser{stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// openSource opens a single-file compression source, honouring StdioPath
+func openSource(path string) (io.ReadCloser, error) {
+	if path == StdioPath {
+		return io.NopCloser(stdin), nil
+	}
+	return os.Open(path)
+}
+
+// progressOut returns where per-file progress lines go. When the archive
+// itself is streamed to stdout they move to stderr so the output stays intact.
+func progressOut(outputPath string) io.Writer {
+	if outputPath == StdioPath {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// compressProgress returns where a compression reports each file it adds
+func compressProgress(config *models.CompressConfig) io.Writer {
+	if config.Progress != nil {
+		return config.Progress
+	}
+	return progressOut(config.OutputPath)
+}
+
+// extractProgress returns where an extraction reports each file it writes
+func extractProgress(config *models.ExtractConfig) io.Writer {
+	if config.Progress != nil {
+		return config.Progress
+	}
+	return os.Stdout
+}
+
+// extractStdin extracts an archive streamed on stdin. Tar-based formats are
+// extracted directly from the stream; ZIP and RAR need random access or a
+// seekable source, so they are spooled to a temporary file first.
+func extractStdin(config *models.ExtractConfig) error {
+	br := bufio.NewReaderSize(stdin, sniffSize)
+
+	archiveType := config.ArchiveType
+	if archiveType == models.AUTO || archiveType == "" {
+		detected, err := DetectArchiveTypeFromReader(br)
+		if err != nil {
+			return fmt.Errorf("failed to detect archive type from stdin: %w", err)
+		}
+		if detected == models.AUTO {
+			return fmt.Errorf("could not detect archive type from stdin")
+		}
+		archiveType = detected
+	}
+
+	switch archiveType {
+	case models.TAR:
+		return extractFromTar(tar.NewReader(br), config)
+	case models.TARGZ:
+		gzReader, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gzReader.Close()
+		return extractFromTar(tar.NewReader(gzReader), config)
+	case models.GZIP:
+		gzReader, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gzReader.Close()
+
+		name := filepath.Base(gzReader.Name)
+		if gzReader.Name == "" {
+			name = "stdin"
+		}
+		return extractGzipStream(config, gzReader, filepath.Join(config.DestPath, name))
+	case models.ZIP, models.RAR:
+		spooled, err := spoolToTemp(br)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spooled)
+
+		spoolConfig := *config
+		spoolConfig.ArchivePath = spooled
+		spoolConfig.ArchiveType = archiveType
+		return extractArchive(&spoolConfig)
+	default:
+		return fmt.Errorf("unsupported archive type: %s", archiveType)
+	}
+}
+
+// spoolToTemp copies a stream into a temporary file and returns its path
+func spoolToTemp(r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "zipprine-stdin-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to spool stdin: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,169 @@
+package archiver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+// withStdio swaps the archiver's stdin/stdout for the duration of a test
+func withStdio(t *testing.T, in *bytes.Reader, out *bytes.Buffer) {
+	oldIn, oldOut := stdin, stdout
+	if in != nil {
+		stdin = in
+	}
+	if out != nil {
+		stdout = out
+	}
+	t.Cleanup(func() {
+		stdin, stdout = oldIn, oldOut
+	})
+}
+
+func TestStdioRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	if err := os.Mkdir(sourceDir, 0755); err != nil {
+		t.Fatalf("Failed to create source dir: %v", err)
+	}
+	createTestFiles(t, sourceDir)
+
+	testCases := []struct {
+		name        string
+		archiveType models.ArchiveType
+	}{
+		{"zip", models.ZIP},
+		{"tar", models.TAR},
+		{"tar.gz", models.TARGZ},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var archive bytes.Buffer
+			withStdio(t, nil, &archive)
+
+			err := Compress(&models.CompressConfig{
+				SourcePath:       sourceDir,
+				OutputPath:       StdioPath,
+				ArchiveType:      tc.archiveType,
+				CompressionLevel: 5,
+			})
+			if err != nil {
+				t.Fatalf("Compress to stdout failed: %v", err)
+			}
+			if archive.Len() == 0 {
+				t.Fatal("Nothing was written to stdout")
+			}
+
+			withStdio(t, bytes.NewReader(archive.Bytes()), nil)
+
+			destDir := filepath.Join(tmpDir, "dest-"+tc.name)
+			err = Extract(&models.ExtractConfig{
+				ArchivePath:   StdioPath,
+				DestPath:      destDir,
+				ArchiveType:   models.AUTO,
+				OverwriteAll:  true,
+				PreservePerms: true,
+			})
+			if err != nil {
+				t.Fatalf("Extract from stdin failed: %v", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(destDir, "subdir", "test3.txt"))
+			if err != nil {
+				t.Fatalf("Nested file was not extracted: %v", err)
+			}
+			if string(content) != "Nested file" {
+				t.Errorf("Extracted content mismatch: got %q, want %q", string(content), "Nested file")
+			}
+		})
+	}
+}
+
+func TestGzipFromStdin(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var archive bytes.Buffer
+	withStdio(t, bytes.NewReader([]byte("piped content")), &archive)
+
+	err := Compress(&models.CompressConfig{
+		SourcePath:       StdioPath,
+		OutputPath:       StdioPath,
+		ArchiveType:      models.GZIP,
+		CompressionLevel: 5,
+	})
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	withStdio(t, bytes.NewReader(archive.Bytes()), nil)
+
+	destDir := filepath.Join(tmpDir, "dest")
+	if err := Extract(&models.ExtractConfig{
+		ArchivePath: StdioPath,
+		DestPath:    destDir,
+		ArchiveType: models.AUTO,
+	}); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(destDir, "stdin"))
+	if err != nil {
+		t.Fatalf("Extracted file missing: %v", err)
+	}
+	if string(content) != "piped content" {
+		t.Errorf("Extracted content mismatch: got %q, want %q", string(content), "piped content")
+	}
+}
+
+func TestExtractStdinUnknownFormat(t *testing.T) {
+	withStdio(t, bytes.NewReader([]byte("definitely not an archive")), nil)
+
+	err := Extract(&models.ExtractConfig{
+		ArchivePath: StdioPath,
+		DestPath:    t.TempDir(),
+		ArchiveType: models.AUTO,
+	})
+	if err == nil {
+		t.Error("Expected error for unrecognized stdin data, got nil")
+	}
+}
+
+// failingWriter fails every write, like a full disk
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestCompressReportsWriteErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	createTestFiles(t, sourceDir)
+
+	oldOut := stdout
+	stdout = failingWriter{}
+	t.Cleanup(func() { stdout = oldOut })
+
+	for _, config := range []models.CompressConfig{
+		{SourcePath: sourceDir, ArchiveType: models.ZIP},
+		{SourcePath: sourceDir, ArchiveType: models.TAR},
+		// Small tar.gz streams are only written out when they are closed
+		{SourcePath: sourceDir, ArchiveType: models.TARGZ},
+		{SourcePath: sourceDir, ArchiveType: models.TARGZ, Reproducible: true},
+		{SourcePath: sourceDir, ArchiveType: models.TARGZ, Threads: 1},
+		{SourcePath: filepath.Join(sourceDir, "test1.txt"), ArchiveType: models.GZIP, Reproducible: true},
+	} {
+		config.OutputPath = StdioPath
+		config.Progress = io.Discard
+		if err := Compress(&config); err == nil {
+			t.Errorf("Compress(%s, reproducible %v, threads %d) to a failing output succeeded", config.ArchiveType, config.Reproducible, config.Threads)
+		}
+	}
+}
@@ -3,118 +3,223 @@ package archiver
 import (
 	"archive/tar"
 	"compress/gzip"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 
 	"zipprine/internal/models"
-	"zipprine/pkg/fileutil"
+	"zipprine/pkg/pgzip"
 )
 
 func createTar(config *models.CompressConfig) error {
-	outFile, err := os.Create(config.OutputPath)
+	outFile, err := createArchiveOutput(config)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
 
 	tarWriter := tar.NewWriter(outFile)
-	defer tarWriter.Close()
+	err = addToTar(tarWriter, config)
 
-	return addToTar(tarWriter, config)
+	// Closing writes the end of the archive, so its errors matter as much
+	// as those of the entries
+	if closeErr := tarWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 func createTarGz(config *models.CompressConfig) error {
-	outFile, err := os.Create(config.OutputPath)
+	outFile, err := createArchiveOutput(config)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
 
-	gzWriter, err := gzip.NewWriterLevel(outFile, config.CompressionLevel)
+	// The tar stream's size is only known once the sources are walked
+	gzWriter, err := newGzipWriter(outFile, config, nil)
 	if err != nil {
+		outFile.Close()
 		return err
 	}
-	defer gzWriter.Close()
 
 	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
+	err = addToTar(tarWriter, config)
 
-	return addToTar(tarWriter, config)
+	// A small stream is only compressed and written out when gzWriter is
+	// closed, so every close has to be checked
+	if closeErr := tarWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := gzWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 func createGzip(config *models.CompressConfig) error {
-	inFile, err := os.Open(config.SourcePath)
+	inFile, err := openSource(config.SourcePath)
 	if err != nil {
 		return err
 	}
 	defer inFile.Close()
 
-	outFile, err := os.Create(config.OutputPath)
+	outFile, err := createArchiveOutput(config)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
 
-	gzWriter, err := gzip.NewWriterLevel(outFile, config.CompressionLevel)
+	gzWriter, err := newGzipWriter(outFile, config, func() int64 {
+		if info, err := os.Stat(config.SourcePath); err == nil && config.SourcePath != StdioPath {
+			return info.Size()
+		}
+		return 0
+	})
 	if err != nil {
+		outFile.Close()
 		return err
 	}
-	defer gzWriter.Close()
 
 	_, err = io.Copy(gzWriter, inFile)
+	if closeErr := gzWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
 	return err
 }
 
-func addToTar(tarWriter *tar.Writer, config *models.CompressConfig) error {
-	return filepath.Walk(config.SourcePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// parallelGzipThreshold is the input size from which gzip output is spread
+// across every CPU when the thread count is left to zipprine
+const parallelGzipThreshold = 16 << 20
+
+// newGzipWriter returns the gzip writer for a compression. Large inputs, or
+// an explicit Threads count above one, use the multi-core pgzip writer;
+// reproducible archives choose by size alone. inputSize is only consulted
+// when the choice is automatic; when it is nil the size is not known up
+// front and the choice waits until the stream passes parallelGzipThreshold
+// or ends.
+func newGzipWriter(w io.Writer, config *models.CompressConfig, inputSize func() int64) (io.WriteCloser, error) {
+	// pgzip's output does not depend on the thread count, so reproducible
+	// archives choose on the input alone and are the same on every machine
+	bySize := config.Reproducible || (config.Threads == 0 && runtime.NumCPU() > 1)
+	if !bySize {
+		return openGzipWriter(w, config, config.Threads > 1)
+	}
+	if inputSize == nil {
+		// Check the level now rather than when the stream is flushed
+		if _, err := gzip.NewWriterLevel(io.Discard, config.CompressionLevel); err != nil {
+			return nil, err
 		}
+		return &sizedGzipWriter{w: w, config: config}, nil
+	}
+	return openGzipWriter(w, config, inputSize() >= parallelGzipThreshold)
+}
 
-		if !fileutil.ShouldInclude(path, config.ExcludePaths, config.IncludePaths) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+func openGzipWriter(w io.Writer, config *models.CompressConfig, parallel bool) (io.WriteCloser, error) {
+	if !parallel {
+		return gzip.NewWriterLevel(w, config.CompressionLevel)
+	}
+	return pgzip.NewWriterLevel(w, config.CompressionLevel, config.Threads)
+}
 
-		relPath, err := filepath.Rel(config.SourcePath, path)
-		if err != nil {
-			return err
-		}
+// sizedGzipWriter holds back the start of a stream of unknown size until it
+// passes parallelGzipThreshold, then compresses it with pgzip; a stream
+// that ends sooner goes to the single-threaded writer
+type sizedGzipWriter struct {
+	w      io.Writer
+	config *models.CompressConfig
+	buf    []byte
+	gz     io.WriteCloser
+}
 
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
+func (s *sizedGzipWriter) Write(p []byte) (int, error) {
+	if s.gz != nil {
+		return s.gz.Write(p)
+	}
+	s.buf = append(s.buf, p...)
+	if len(s.buf) >= parallelGzipThreshold {
+		if err := s.open(true); err != nil {
+			return 0, err
 		}
-		header.Name = relPath
+	}
+	return len(p), nil
+}
 
-		if err := tarWriter.WriteHeader(header); err != nil {
+func (s *sizedGzipWriter) Close() error {
+	if s.gz == nil {
+		if err := s.open(false); err != nil {
 			return err
 		}
+	}
+	return s.gz.Close()
+}
 
-		if info.IsDir() {
-			return nil
-		}
+// open starts the chosen writer and hands it what was held back
+func (s *sizedGzipWriter) open(parallel bool) error {
+	gz, err := openGzipWriter(s.w, s.config, parallel)
+	if err != nil {
+		return err
+	}
+	s.gz = gz
+	_, err = gz.Write(s.buf)
+	s.buf = nil
+	return err
+}
 
-		fmt.Printf("  → %s\n", relPath)
+func addToTar(tarWriter *tar.Writer, config *models.CompressConfig) error {
+	return walkSources(config, func(path, name string, info os.FileInfo) error {
+		if !info.IsDir() {
+			fmt.Fprintf(compressProgress(config), "  → %s\n", name)
+		}
+		return writeTarEntry(tarWriter, path, name, info)
+	})
+}
 
-		file, err := os.Open(path)
-		if err != nil {
+// writeTarEntry stores a file, directory or symlink from disk in the archive
+// under name. Symlinks are stored as links, not followed.
+func writeTarEntry(tarWriter *tar.Writer, path, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
 			return err
 		}
-		defer file.Close()
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
 
-		_, err = io.Copy(tarWriter, file)
+	if err := tarWriter.WriteHeader(header); err != nil {
 		return err
-	})
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	file, err := openSourceFile(path, info)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(tarWriter, file)
+	return err
 }
 
 func extractTar(config *models.ExtractConfig) error {
-	file, err := os.Open(config.ArchivePath)
+	file, err := openArchiveFile(config.ArchivePath)
 	if err != nil {
 		return err
 	}
@@ -125,7 +230,7 @@ func extractTar(config *models.ExtractConfig) error {
 }
 
 func extractTarGz(config *models.ExtractConfig) error {
-	file, err := os.Open(config.ArchivePath)
+	file, err := openArchiveFile(config.ArchivePath)
 	if err != nil {
 		return err
 	}
@@ -142,7 +247,7 @@ func extractTarGz(config *models.ExtractConfig) error {
 }
 
 func extractGzip(config *models.ExtractConfig) error {
-	inFile, err := os.Open(config.ArchivePath)
+	inFile, err := openArchiveFile(config.ArchivePath)
 	if err != nil {
 		return err
 	}
@@ -154,8 +259,24 @@ func extractGzip(config *models.ExtractConfig) error {
 	}
 	defer gzReader.Close()
 
-	outPath := filepath.Join(config.DestPath, filepath.Base(config.ArchivePath))
-	outPath = outPath[:len(outPath)-3] // Remove .gz extension
+	return extractGzipStream(config, gzReader, gzipOutputPath(config))
+}
+
+// gzipOutputPath is where a GZIP file extracts to: its name without .gz,
+// inside the destination
+func gzipOutputPath(config *models.ExtractConfig) string {
+	outPath := filepath.Join(config.DestPath, filepath.Base(segmentBase(config.ArchivePath)))
+	return outPath[:len(outPath)-3] // Remove .gz extension
+}
+
+// extractGzipStream writes a decompressed gzip stream to outPath
+func extractGzipStream(config *models.ExtractConfig, gzReader *gzip.Reader, outPath string) error {
+	if err := limitFile(config); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), os.ModePerm); err != nil {
+		return err
+	}
 
 	outFile, err := os.Create(outPath)
 	if err != nil {
@@ -163,11 +284,14 @@ func extractGzip(config *models.ExtractConfig) error {
 	}
 	defer outFile.Close()
 
-	_, err = io.Copy(outFile, gzReader)
+	_, err = io.Copy(limitedWriter(config, outFile), gzReader)
 	return err
 }
 
+// extractFromTar writes the entries of a tar stream. Entries that would
+// land outside the destination are skipped and reported together at the end.
 func extractFromTar(tarReader *tar.Reader, config *models.ExtractConfig) error {
+	var errs []error
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -177,7 +301,14 @@ func extractFromTar(tarReader *tar.Reader, config *models.ExtractConfig) error {
 			return err
 		}
 
-		destPath := filepath.Join(config.DestPath, header.Name)
+		destPath, ok, err := entryDestPath(config, header.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
@@ -185,12 +316,15 @@ func extractFromTar(tarReader *tar.Reader, config *models.ExtractConfig) error {
 		case tar.TypeReg:
 			if !config.OverwriteAll {
 				if _, err := os.Stat(destPath); err == nil {
-					fmt.Printf("  ⚠️  Skipping: %s\n", header.Name)
+					fmt.Fprintf(extractProgress(config), "  ⚠️  Skipping: %s\n", header.Name)
 					continue
 				}
 			}
 
-			fmt.Printf("  → Extracting: %s\n", header.Name)
+			if err := limitFile(config); err != nil {
+				return err
+			}
+			fmt.Fprintf(extractProgress(config), "  → Extracting: %s\n", header.Name)
 
 			os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
 
@@ -199,7 +333,7 @@ func extractFromTar(tarReader *tar.Reader, config *models.ExtractConfig) error {
 				return err
 			}
 
-			if _, err := io.Copy(outFile, tarReader); err != nil {
+			if _, err := io.Copy(limitedWriter(config, outFile), tarReader); err != nil {
 				outFile.Close()
 				return err
 			}
@@ -210,16 +344,10 @@ func extractFromTar(tarReader *tar.Reader, config *models.ExtractConfig) error {
 			}
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func analyzeTar(path string, isGzipped bool) (*models.ArchiveInfo, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
 	info := &models.ArchiveInfo{
 		Type:  models.TAR,
 		Files: []models.FileInfo{},
@@ -229,15 +357,16 @@ func analyzeTar(path string, isGzipped bool) (*models.ArchiveInfo, error) {
 		info.Type = models.TARGZ
 	}
 
-	fileStat, _ := file.Stat()
-	info.CompressedSize = fileStat.Size()
-
-	hash := sha256.New()
-	io.Copy(hash, file)
-	info.Checksum = fmt.Sprintf("%x", hash.Sum(nil))
+	if err := measureArchive(path, info); err != nil {
+		return nil, err
+	}
 
 	// Reopen for tar reading
-	file.Seek(0, 0)
+	file, err := openArchiveFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
 	var tarReader *tar.Reader
 	if isGzipped {
@@ -278,4 +407,4 @@ func analyzeTar(path string, isGzipped bool) (*models.ArchiveInfo, error) {
 	}
 
 	return info, nil
-}
\ No newline at end of file
+}
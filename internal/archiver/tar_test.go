@@ -1,11 +1,17 @@
 package archiver
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"zipprine/internal/models"
+	"zipprine/pkg/pgzip"
 )
 
 func TestCreateTar(t *testing.T) {
@@ -460,3 +466,113 @@ func BenchmarkCreateTarGz(b *testing.B) {
 		os.Remove(targzPath)
 	}
 }
+
+func TestCreateTarGzParallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+
+	// Enough data to span several pgzip blocks
+	big := bytes.Repeat([]byte("zipprine parallel gzip block\n"), 150000)
+	os.WriteFile(filepath.Join(sourceDir, "big.txt"), big, 0644)
+	os.WriteFile(filepath.Join(sourceDir, "small.txt"), []byte("small"), 0644)
+
+	targzPath := filepath.Join(tmpDir, "parallel.tar.gz")
+	err := createTarGz(&models.CompressConfig{
+		SourcePath:       sourceDir,
+		OutputPath:       targzPath,
+		ArchiveType:      models.TARGZ,
+		CompressionLevel: 6,
+		Threads:          4,
+	})
+	if err != nil {
+		t.Fatalf("createTarGz failed: %v", err)
+	}
+
+	destDir := filepath.Join(tmpDir, "dest")
+	err = extractTarGz(&models.ExtractConfig{
+		ArchivePath: targzPath,
+		DestPath:    destDir,
+		ArchiveType: models.TARGZ,
+	})
+	if err != nil {
+		t.Fatalf("extractTarGz failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "big.txt"))
+	if err != nil || !bytes.Equal(got, big) {
+		t.Errorf("big.txt did not round-trip (%d bytes, err %v)", len(got), err)
+	}
+}
+
+func TestSizedGzipWriter(t *testing.T) {
+	for _, size := range []int{100, parallelGzipThreshold + 1} {
+		data := bytes.Repeat([]byte("z"), size)
+		var out bytes.Buffer
+		w, err := newGzipWriter(&out, &models.CompressConfig{CompressionLevel: 6, Reproducible: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Written in pieces, as a tar writer does
+		for chunk := range slices.Chunk(data, 4096) {
+			w.Write(chunk)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() failed: %v", err)
+		}
+
+		_, parallel := w.(*sizedGzipWriter).gz.(*pgzip.Writer)
+		if parallel != (size >= parallelGzipThreshold) {
+			t.Errorf("%d bytes used pgzip: %v", size, parallel)
+		}
+		gz, err := gzip.NewReader(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(gz); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%d bytes did not round-trip (%d bytes, err %v)", size, len(got), err)
+		}
+	}
+
+	if _, err := newGzipWriter(io.Discard, &models.CompressConfig{CompressionLevel: 42, Reproducible: true}, nil); err == nil {
+		t.Error("newGzipWriter() accepted an invalid level")
+	}
+}
+
+func BenchmarkCreateTarGzLarge(b *testing.B) {
+	tmpDir := b.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+
+	// 32 MiB of mildly compressible data across a few files
+	chunk := make([]byte, 8<<20)
+	for i := range chunk {
+		chunk[i] = byte(i*7/13) ^ byte(i>>11)
+	}
+	for i := 0; i < 4; i++ {
+		os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("file%d.bin", i)), chunk, 0644)
+	}
+
+	for _, bench := range []struct {
+		name    string
+		threads int
+	}{
+		{"single", 1},
+		{"parallel", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(4 * len(chunk)))
+			for i := 0; i < b.N; i++ {
+				targzPath := filepath.Join(tmpDir, "bench.tar.gz")
+				createTarGz(&models.CompressConfig{
+					SourcePath:       sourceDir,
+					OutputPath:       targzPath,
+					ArchiveType:      models.TARGZ,
+					CompressionLevel: 6,
+					Threads:          bench.threads,
+				})
+				os.Remove(targzPath)
+			}
+		})
+	}
+}
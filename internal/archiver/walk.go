@@ -0,0 +1,260 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"zipprine/internal/models"
+
+	"github.com/nwaples/rardecode"
+)
+
+// archiveEntry is one member of an archive being streamed. Its contents are
+// only readable from inside the walkArchive callback that received it.
+type archiveEntry struct {
+	Name     string
+	Mode     fs.FileMode
+	ModTime  time.Time
+	Size     int64
+	Linkname string
+	HardLink bool
+
+	open func() (io.ReadCloser, error)
+
+	// Set when the entry comes from a ZIP or tar source so writers of the
+	// same format can keep every detail
+	zipFile   *zip.File
+	tarHeader *tar.Header
+}
+
+// Open returns the entry's contents
+func (e *archiveEntry) Open() (io.ReadCloser, error) {
+	if e.open == nil {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	return e.open()
+}
+
+// IsDir reports whether the entry is a directory
+func (e *archiveEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// IsRegular reports whether the entry is a regular file with contents
+func (e *archiveEntry) IsRegular() bool {
+	return e.Mode.IsRegular() && !e.HardLink
+}
+
+// walkArchive calls fn for every entry of an archive in storage order
+func walkArchive(path string, archiveType models.ArchiveType, fn func(entry *archiveEntry) error) error {
+	return walkArchiveWithPassword(path, archiveType, "", fn)
+}
+
+// walkArchiveWithPassword is walkArchive for archives that may be
+// encrypted. RAR needs the password to read entries at all; ZIP entries are
+// listed without it and opened with openZipFile.
+func walkArchiveWithPassword(path string, archiveType models.ArchiveType, password string, fn func(entry *archiveEntry) error) error {
+	switch archiveType {
+	case models.ZIP:
+		return walkZip(path, fn)
+	case models.TARGZ:
+		return walkTarFile(path, true, fn)
+	case models.TAR:
+		return walkTarFile(path, false, fn)
+	case models.GZIP:
+		return walkGzip(path, fn)
+	case models.RAR:
+		return walkRar(path, password, fn)
+	default:
+		return fmt.Errorf("unsupported archive type: %s", archiveType)
+	}
+}
+
+func walkZip(path string, fn func(entry *archiveEntry) error) error {
+	r, err := openZipArchive(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		entry := &archiveEntry{
+			Name:    f.Name,
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+			Size:    int64(f.UncompressedSize64),
+			open:    f.Open,
+			zipFile: f,
+		}
+
+		// ZIP stores a symlink's target as the entry contents
+		if entry.Mode&fs.ModeSymlink != 0 {
+			target, err := readZipSymlink(f)
+			if err != nil {
+				return err
+			}
+			entry.Linkname = target
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZipSymlink(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	target, err := io.ReadAll(rc)
+	return string(target), err
+}
+
+func walkTarFile(path string, isGzipped bool, fn func(entry *archiveEntry) error) error {
+	file, err := openArchiveFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var src io.Reader = file
+	if isGzipped {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzReader.Close()
+		src = gzReader
+	}
+
+	return walkTar(tar.NewReader(src), fn)
+}
+
+func walkTar(tarReader *tar.Reader, fn func(entry *archiveEntry) error) error {
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := &archiveEntry{
+			Name:      header.Name,
+			Mode:      header.FileInfo().Mode(),
+			ModTime:   header.ModTime,
+			Size:      header.Size,
+			Linkname:  header.Linkname,
+			HardLink:  header.Typeflag == tar.TypeLink,
+			tarHeader: header,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tarReader), nil
+			},
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+func walkGzip(path string, fn func(entry *archiveEntry) error) error {
+	file, err := openArchiveFile(path)
+	if err != nil {
+		return err
+	}
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	defer gzReader.Close()
+
+	name := gzReader.Name
+	if name == "" {
+		base := segmentBase(path)
+		name = strings.TrimSuffix(filepath.Base(base), filepath.Ext(base))
+	}
+
+	modTime := gzReader.ModTime
+	if modTime.IsZero() {
+		if stat, err := os.Stat(path); err == nil {
+			modTime = stat.ModTime()
+		}
+	}
+
+	// The uncompressed size is not stored reliably, and tar writers need it
+	// up front, so measure it with a first pass over the stream
+	size, err := io.Copy(io.Discard, gzReader)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	file, err = openArchiveFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := gzReader.Reset(file); err != nil {
+		return err
+	}
+
+	return fn(&archiveEntry{
+		Name:    name,
+		Mode:    0644,
+		ModTime: modTime,
+		Size:    size,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(gzReader), nil
+		},
+	})
+}
+
+func walkRar(path, password string, fn func(entry *archiveEntry) error) error {
+	if encrypted, _ := IsEncrypted(path, models.RAR); encrypted && password == "" {
+		return ErrPasswordRequired
+	}
+
+	reader, err := rardecode.OpenReader(path, password)
+	if err != nil {
+		return fmt.Errorf("failed to open RAR file: %w", err)
+	}
+	defer reader.Close()
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read RAR entry: %w", rarError(err, nil, password))
+		}
+
+		if err := fn(&archiveEntry{
+			Name:    header.Name,
+			Mode:    header.Mode(),
+			ModTime: header.ModificationTime,
+			Size:    header.UnPackedSize,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(reader), nil
+			},
+		}); err != nil {
+			return err
+		}
+	}
+}
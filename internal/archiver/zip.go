@@ -3,165 +3,287 @@ package archiver
 import (
 	"archive/zip"
 	"compress/flate"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 
 	"zipprine/internal/models"
-	"zipprine/pkg/fileutil"
 )
 
 func createZip(config *models.CompressConfig) error {
-	outFile, err := os.Create(config.OutputPath)
+	if config.SplitSize > 0 {
+		return createSplitZip(config)
+	}
+
+	outFile, err := createOutput(config.OutputPath)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
 
-	zipWriter := zip.NewWriter(outFile)
-	defer zipWriter.Close()
+	// zip.Writer never seeks back to patch local headers; sizes and CRCs go
+	// in data descriptors, so the output can be streamed to a pipe. Entries
+	// of 4 GiB or more, offsets past 4 GiB and more than 65535 entries are
+	// recorded with ZIP64 extra fields and end records.
+	zipWriter := newZipWriter(outFile, config.CompressionLevel)
 
-	// Set compression level
-	if config.CompressionLevel > 0 {
-		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-			return flate.NewWriter(out, config.CompressionLevel)
-		})
+	threads := config.Threads
+	if threads == 0 {
+		threads = runtime.NumCPU()
 	}
-
-	return filepath.Walk(config.SourcePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !fileutil.ShouldInclude(path, config.ExcludePaths, config.IncludePaths) {
+	// The parallel writer's output does not depend on the thread count, so
+	// reproducible archives always use it
+	if threads > 1 || config.Password != "" || config.Reproducible {
+		err = writeZipParallel(zipWriter, config, max(threads, 1))
+	} else {
+		err = walkSources(config, func(path, name string, info os.FileInfo) error {
 			if info.IsDir() {
-				return filepath.SkipDir
+				return nil
 			}
-			return nil
-		}
 
-		relPath, err := filepath.Rel(config.SourcePath, path)
-		if err != nil {
-			return err
-		}
+			fmt.Fprintf(compressProgress(config), "  → %s\n", name)
+			return writeZipFile(zipWriter, path, name, info)
+		})
+	}
 
-		if info.IsDir() {
-			return nil
-		}
+	// Closing writes the central directory, so its errors matter as much
+	// as those of the entries
+	if closeErr := zipWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
 
-		fmt.Printf("  → %s\n", relPath)
+// newZipWriter creates a zip.Writer that deflates at the given level
+func newZipWriter(w io.Writer, level int) *zip.Writer {
+	zipWriter := zip.NewWriter(w)
 
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-		header.Name = relPath
-		header.Method = zip.Deflate
+	// Set compression level
+	if level > 0 {
+		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return zipWriter
+}
 
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
+// writeZipFile stores a file or symlink from disk in the archive under name
+func writeZipFile(zipWriter *zip.Writer, path, name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
 
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
 
-		_, err = io.Copy(writer, file)
+	file, err := openSourceFile(path, info)
+	if err != nil {
 		return err
-	})
+	}
+	defer file.Close()
+
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// zipExtractTask holds the entries that extract to one destination path.
+// Duplicates stay together so they are applied in archive order.
+type zipExtractTask struct {
+	destPath string
+	files    []*zip.File
 }
 
 func extractZip(config *models.ExtractConfig) error {
-	r, err := zip.OpenReader(config.ArchivePath)
+	r, err := openZipArchive(config.ArchivePath)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
+	// Directories are created up front so workers never race on them
+	var tasks []*zipExtractTask
+	byPath := map[string]*zipExtractTask{}
+	dirs := map[string]bool{}
+	var dirOrder []string
+	addDir := func(dir string) {
+		if !dirs[dir] {
+			dirs[dir] = true
+			dirOrder = append(dirOrder, dir)
+		}
+	}
+
+	var errs []error
 	for _, f := range r.File {
-		destPath := filepath.Join(config.DestPath, f.Name)
+		destPath, ok, err := entryDestPath(config, f.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
 
 		if f.FileInfo().IsDir() {
-			os.MkdirAll(destPath, os.ModePerm)
+			addDir(destPath)
 			continue
 		}
+		addDir(filepath.Dir(destPath))
 
-		if !config.OverwriteAll {
-			if _, err := os.Stat(destPath); err == nil {
-				fmt.Printf("  ⚠️  Skipping: %s (already exists)\n", f.Name)
-				continue
-			}
+		task := byPath[destPath]
+		if task == nil {
+			task = &zipExtractTask{destPath: destPath}
+			byPath[destPath] = task
+			tasks = append(tasks, task)
 		}
+		task.files = append(task.files, f)
+	}
 
-		fmt.Printf("  → Extracting: %s\n", f.Name)
+	if err := checkZipPassword(r.File, config.Password); err != nil {
+		return err
+	}
 
-		if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-			return err
+	for _, dir := range dirOrder {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			errs = append(errs, err)
 		}
+	}
 
-		outFile, err := os.Create(destPath)
-		if err != nil {
-			return err
-		}
+	threads := config.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
 
-		rc, err := f.Open()
-		if err != nil {
-			outFile.Close()
-			return err
+	var mu sync.Mutex
+	queue := make(chan *zipExtractTask)
+	var wg sync.WaitGroup
+	for i := 0; i < min(threads, len(tasks)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				for _, f := range task.files {
+					// Past a limit only the first failure is worth reporting
+					if config.Limits.Exceeded() {
+						continue
+					}
+					if err := extractZipFile(config, f, task.destPath); err != nil {
+						mu.Lock()
+						errs = append(errs, fmt.Errorf("%s: %w", f.Name, err))
+						mu.Unlock()
+					}
+				}
+			}
+		}()
+	}
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// extractZipFile writes one ZIP entry to destPath
+func extractZipFile(config *models.ExtractConfig, f *zip.File, destPath string) error {
+	if !config.OverwriteAll {
+		if _, err := os.Stat(destPath); err == nil {
+			fmt.Fprintf(extractProgress(config), "  ⚠️  Skipping: %s (already exists)\n", f.Name)
+			return nil
 		}
+	}
+
+	if err := limitFile(config); err != nil {
+		return err
+	}
+	fmt.Fprintf(extractProgress(config), "  → Extracting: %s\n", f.Name)
+
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
 
-		_, err = io.Copy(outFile, rc)
+	rc, err := openZipFile(f, config.Password)
+	if err != nil {
 		outFile.Close()
-		rc.Close()
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	_, err = io.Copy(limitedWriter(config, outFile), rc)
+	outFile.Close()
+	rc.Close()
 
-		if config.PreservePerms {
-			os.Chmod(destPath, f.Mode())
-		}
+	if err != nil {
+		return err
 	}
 
+	if config.PreservePerms {
+		os.Chmod(destPath, f.Mode())
+	}
 	return nil
 }
 
 func analyzeZip(path string) (*models.ArchiveInfo, error) {
-	r, err := zip.OpenReader(path)
+	return analyzeZipWithPassword(path, "")
+}
+
+func analyzeZipWithPassword(path, password string) (*models.ArchiveInfo, error) {
+	r, err := openZipArchive(path)
 	if err != nil {
 		return nil, err
 	}
 	defer r.Close()
 
+	// With a password, make sure it opens the archive before reporting on it
+	if password != "" {
+		if err := checkZipPassword(r.File, password); err != nil {
+			return nil, err
+		}
+	}
+
 	info := &models.ArchiveInfo{
 		Type:  models.ZIP,
 		Files: []models.FileInfo{},
 	}
 
-	file, _ := os.Open(path)
-	defer file.Close()
-	fileStat, _ := file.Stat()
-	info.CompressedSize = fileStat.Size()
-
-	hash := sha256.New()
-	io.Copy(hash, file)
-	info.Checksum = fmt.Sprintf("%x", hash.Sum(nil))
+	if err := measureArchive(path, info); err != nil {
+		return nil, err
+	}
 
 	for _, f := range r.File {
 		info.FileCount++
 		info.TotalSize += int64(f.UncompressedSize64)
 
+		encryption := zipEncryption(f)
+		if encryption != "" {
+			if info.Encryption == "" {
+				info.Encryption = encryption
+			} else if info.Encryption != encryption {
+				info.Encryption = "mixed"
+			}
+		}
+
 		if len(info.Files) < 100 {
 			info.Files = append(info.Files, models.FileInfo{
-				Name:    f.Name,
-				Size:    int64(f.UncompressedSize64),
-				IsDir:   f.FileInfo().IsDir(),
-				ModTime: f.Modified.Format("2006-01-02 15:04:05"),
+				Name:      f.Name,
+				Size:      int64(f.UncompressedSize64),
+				IsDir:     f.FileInfo().IsDir(),
+				ModTime:   f.Modified.Format("2006-01-02 15:04:05"),
+				Encrypted: encryption != "",
+
+				CompressedSize:   int64(f.CompressedSize64),
+				CompressionRatio: compressionRatio(int64(f.UncompressedSize64), int64(f.CompressedSize64)),
 			})
 		}
 	}
@@ -171,4 +293,4 @@ func analyzeZip(path string) (*models.ArchiveInfo, error) {
 	}
 
 	return info, nil
-}
\ No newline at end of file
+}
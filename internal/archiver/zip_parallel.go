@@ -0,0 +1,258 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zipprine/internal/models"
+)
+
+// zipSpillSize is the file size above which a compressed entry is staged in
+// a temporary file instead of memory while it waits for its turn
+const zipSpillSize = 4 << 20
+
+// errZipAborted stops the walk once writing an entry has failed
+var errZipAborted = errors.New("zip creation aborted")
+
+// zipJob is one file compressed by a worker and written in walk order
+type zipJob struct {
+	path   string
+	name   string
+	info   os.FileInfo
+	header *zip.FileHeader
+	data   io.Reader
+	spill  *os.File
+	err    error
+	done   chan struct{}
+}
+
+// release removes the job's temporary file, if any
+func (j *zipJob) release() {
+	if j.spill != nil {
+		j.spill.Close()
+		os.Remove(j.spill.Name())
+		j.spill = nil
+	}
+}
+
+// writeZipParallel compresses the selected files on several goroutines and
+// adds them with CreateRaw, keeping the order of a serial walk
+func writeZipParallel(zipWriter *zip.Writer, config *models.CompressConfig, threads int) error {
+	level := config.CompressionLevel
+	if level <= 0 {
+		// archive/zip deflates at level 5 unless told otherwise
+		level = 5
+	}
+
+	jobs := make(chan *zipJob, threads)
+	pending := make(chan *zipJob, 2*threads)
+
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deflater := &zipDeflater{level: level}
+			for job := range jobs {
+				if failed.Load() {
+					job.err = errZipAborted
+				} else {
+					job.err = compressZipJob(job, deflater, config.Password)
+				}
+				close(job.done)
+			}
+		}()
+	}
+
+	// The writer adds finished entries strictly in the order they were queued
+	var writeErr error
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for job := range pending {
+			<-job.done
+			if writeErr == nil {
+				writeErr = job.err
+			}
+			if writeErr == nil {
+				fmt.Fprintf(compressProgress(config), "  → %s\n", job.name)
+				writeErr = writeZipJob(zipWriter, job)
+			}
+			if writeErr != nil {
+				failed.Store(true)
+			}
+			job.release()
+		}
+	}()
+
+	walkErr := walkSources(config, func(path, name string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		if failed.Load() {
+			return errZipAborted
+		}
+
+		job := &zipJob{path: path, name: name, info: info, done: make(chan struct{})}
+		pending <- job
+		jobs <- job
+		return nil
+	})
+
+	close(jobs)
+	close(pending)
+	wg.Wait()
+	<-written
+
+	if writeErr != nil {
+		return writeErr
+	}
+	return walkErr
+}
+
+// zipDeflater hands out one flate.Writer per worker, reset for every entry;
+// allocating a fresh one dominates the cost of many small files
+type zipDeflater struct {
+	level int
+	fw    *flate.Writer
+}
+
+func (d *zipDeflater) writer(w io.Writer) (*flate.Writer, error) {
+	if d.fw == nil {
+		fw, err := flate.NewWriter(w, d.level)
+		if err != nil {
+			return nil, err
+		}
+		d.fw = fw
+		return fw, nil
+	}
+	d.fw.Reset(w)
+	return d.fw, nil
+}
+
+// compressZipJob deflates a file and records the CRC and sizes CreateRaw needs
+func compressZipJob(job *zipJob, deflater *zipDeflater, password string) error {
+	header, err := zip.FileInfoHeader(job.info)
+	if err != nil {
+		return err
+	}
+	header.Name = job.name
+	header.Method = zip.Deflate
+	header.Extra = append(header.Extra, zipExtendedTimestamp(header.Modified)...)
+
+	file, err := openSourceFile(job.path, job.info)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var out io.Writer
+	var buf *bytes.Buffer
+	if job.info.Size() > zipSpillSize {
+		spill, err := os.CreateTemp("", "zipprine-entry-*")
+		if err != nil {
+			return err
+		}
+		job.spill = spill
+		out = spill
+	} else {
+		buf = &bytes.Buffer{}
+		out = buf
+	}
+
+	counter := &countingWriter{w: out}
+
+	// Encrypted entries are compressed first, then encrypted with AES-256
+	var compressed io.Writer = counter
+	var aesWriter *zipAESWriter
+	if password != "" {
+		if aesWriter, err = newZipAESWriter(counter, password); err != nil {
+			return err
+		}
+		compressed = aesWriter
+	}
+
+	fw, err := deflater.writer(compressed)
+	if err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(fw, crc), file)
+	if err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	header.CRC32 = crc.Sum32()
+	if aesWriter != nil {
+		if err := aesWriter.Close(); err != nil {
+			return err
+		}
+		// AE-2 leaves the CRC out, so it cannot leak facts about the contents
+		header.CRC32 = 0
+		header.Method = zipMethodAES
+		header.Flags |= 0x1
+		header.Extra = append(header.Extra, zipAESExtraField(zip.Deflate)...)
+	}
+	header.UncompressedSize64 = uint64(size)
+	header.CompressedSize64 = uint64(counter.n)
+	job.header = header
+
+	if job.spill != nil {
+		if _, err := job.spill.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		job.data = job.spill
+	} else {
+		job.data = buf
+	}
+	return nil
+}
+
+// writeZipJob copies an already compressed entry into the archive
+func writeZipJob(zipWriter *zip.Writer, job *zipJob) error {
+	w, err := zipWriter.CreateRaw(job.header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, job.data)
+	return err
+}
+
+// zipExtendedTimestamp builds the extended timestamp extra field that
+// CreateHeader adds on its own but CreateRaw leaves out, keeping the exact
+// modification time instead of the 2-second MS-DOS one
+func zipExtendedTimestamp(modTime time.Time) []byte {
+	field := make([]byte, 9)
+	binary.LittleEndian.PutUint16(field[0:], 0x5455) // extended timestamp tag
+	binary.LittleEndian.PutUint16(field[2:], 5)      // data size
+	field[4] = 1                                     // modification time present
+	binary.LittleEndian.PutUint32(field[5:], uint32(modTime.Unix()))
+	return field
+}
+
+// countingWriter counts the bytes passed through to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
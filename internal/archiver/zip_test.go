@@ -1,8 +1,13 @@
 package archiver
 
 import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"zipprine/internal/models"
@@ -333,7 +338,6 @@ func TestZipWithExcludePatterns(t *testing.T) {
 }
 
 func TestZipWithIncludePatterns(t *testing.T) {
-	t.Skip("Include patterns with directory walking have known limitations - directories must match pattern")
 	tmpDir, err := os.MkdirTemp("", "zipprine-zip-include-test-*")
 	if err != nil {
 		t.Fatalf("Failed to create temp dir: %v", err)
@@ -347,6 +351,8 @@ func TestZipWithIncludePatterns(t *testing.T) {
 	os.WriteFile(filepath.Join(sourceDir, "utils.go"), []byte("package utils"), 0644)
 	os.WriteFile(filepath.Join(sourceDir, "readme.txt"), []byte("readme"), 0644)
 	os.WriteFile(filepath.Join(sourceDir, "config.json"), []byte("{}"), 0644)
+	os.Mkdir(filepath.Join(sourceDir, "pkg"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "pkg", "lib.go"), []byte("package pkg"), 0644)
 
 	// Create ZIP with include patterns
 	zipPath := filepath.Join(tmpDir, "test.zip")
@@ -381,9 +387,9 @@ func TestZipWithIncludePatterns(t *testing.T) {
 		}
 	}
 	
-	// Should have the 2 .go files we created
-	if goFileCount == 0 {
-		t.Error("No .go files found in archive - include pattern may not be working")
+	// Should have the 3 .go files we created, including the nested one
+	if goFileCount != 3 {
+		t.Errorf("Found %d .go files in archive; want 3 - include pattern may not be working", goFileCount)
 		t.Logf("Total files in archive: %d", info.FileCount)
 		for _, f := range info.Files {
 			t.Logf("  File: %s (IsDir: %v)", f.Name, f.IsDir)
@@ -477,3 +483,402 @@ func BenchmarkExtractZip(b *testing.B) {
 		os.RemoveAll(destDir)
 	}
 }
+
+func TestCreateZipParallelMatchesSerial(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	createTestFiles(t, sourceDir)
+	for i := 0; i < 20; i++ {
+		os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("many%02d.txt", i)), bytes.Repeat([]byte{byte('a' + i)}, 100*i), 0644)
+	}
+	// Larger than zipSpillSize, so it is staged in a temporary file
+	big := bytes.Repeat([]byte("spill to disk "), zipSpillSize/10)
+	os.WriteFile(filepath.Join(sourceDir, "subdir", "big.txt"), big, 0644)
+
+	create := func(name string, threads int) *zip.ReadCloser {
+		zipPath := filepath.Join(tmpDir, name)
+		err := createZip(&models.CompressConfig{
+			SourcePath:       sourceDir,
+			OutputPath:       zipPath,
+			ArchiveType:      models.ZIP,
+			CompressionLevel: 6,
+			Threads:          threads,
+		})
+		if err != nil {
+			t.Fatalf("createZip(threads=%d) failed: %v", threads, err)
+		}
+		r, err := zip.OpenReader(zipPath)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+
+	serial := create("serial.zip", 1)
+	parallel := create("parallel.zip", 4)
+
+	if len(parallel.File) != len(serial.File) {
+		t.Fatalf("parallel archive has %d entries, serial has %d", len(parallel.File), len(serial.File))
+	}
+	for i, f := range parallel.File {
+		want := serial.File[i]
+		if f.Name != want.Name {
+			t.Errorf("entry %d = %s, want %s (order must match the serial walk)", i, f.Name, want.Name)
+			continue
+		}
+		if f.CRC32 != want.CRC32 || f.UncompressedSize64 != want.UncompressedSize64 || f.Method != want.Method {
+			t.Errorf("entry %s differs from the serial archive", f.Name)
+		}
+		if !f.Modified.Equal(want.Modified) || f.Mode() != want.Mode() {
+			t.Errorf("entry %s lost metadata", f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			t.Errorf("reading %s failed checksum: %v", f.Name, err)
+		}
+		rc.Close()
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(os.TempDir(), "zipprine-entry-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("spill files left behind: %v", leftovers)
+	}
+}
+
+func BenchmarkCreateZipManyFiles(b *testing.B) {
+	tmpDir := b.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	for i := 0; i < 500; i++ {
+		content := bytes.Repeat([]byte(fmt.Sprintf("file %d line\n", i)), 2000)
+		os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("file%03d.txt", i)), content, 0644)
+	}
+
+	for _, bench := range []struct {
+		name    string
+		threads int
+	}{
+		{"single", 1},
+		{"parallel", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				zipPath := filepath.Join(tmpDir, "bench.zip")
+				createZip(&models.CompressConfig{
+					SourcePath:  sourceDir,
+					OutputPath:  zipPath,
+					ArchiveType: models.ZIP,
+					Threads:     bench.threads,
+				})
+				os.Remove(zipPath)
+			}
+		})
+	}
+}
+
+// writeRawZip builds a ZIP with the given entries in order, allowing
+// duplicate names
+func writeRawZip(t *testing.T, path string, entries [][2]string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for _, e := range entries {
+		w, err := zw.Create(e[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e[1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractZipParallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := filepath.Join(tmpDir, "many.zip")
+
+	var entries [][2]string
+	entries = append(entries, [2]string{"nested/", ""})
+	for i := 0; i < 50; i++ {
+		entries = append(entries, [2]string{fmt.Sprintf("nested/d%d/file%02d.txt", i%5, i), fmt.Sprintf("content %d", i)})
+	}
+	// A repeated name must end up with the last copy, as in a serial run
+	entries = append(entries, [2]string{"dup.txt", "first"}, [2]string{"dup.txt", "second"})
+	writeRawZip(t, zipPath, entries)
+
+	destDir := filepath.Join(tmpDir, "dest")
+	os.MkdirAll(destDir, 0755)
+	os.WriteFile(filepath.Join(destDir, "dup.txt"), []byte("existing"), 0644)
+
+	config := &models.ExtractConfig{
+		ArchivePath: zipPath,
+		DestPath:    destDir,
+		ArchiveType: models.ZIP,
+		Threads:     8,
+	}
+	if err := extractZip(config); err != nil {
+		t.Fatalf("extractZip failed: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		data, err := os.ReadFile(filepath.Join(destDir, "nested", fmt.Sprintf("d%d", i%5), fmt.Sprintf("file%02d.txt", i)))
+		if err != nil || string(data) != fmt.Sprintf("content %d", i) {
+			t.Errorf("file%02d.txt = %q, %v", i, data, err)
+		}
+	}
+
+	// Without OverwriteAll the existing file is skipped
+	if data, _ := os.ReadFile(filepath.Join(destDir, "dup.txt")); string(data) != "existing" {
+		t.Errorf("dup.txt = %q, want the existing file to be kept", data)
+	}
+
+	config.OverwriteAll = true
+	if err := extractZip(config); err != nil {
+		t.Fatalf("extractZip with overwrite failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "dup.txt")); string(data) != "second" {
+		t.Errorf("dup.txt = %q, want the last entry in the archive", data)
+	}
+}
+
+func TestExtractZipAggregatesErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := filepath.Join(tmpDir, "blocked.zip")
+	writeRawZip(t, zipPath, [][2]string{
+		{"blocked/a.txt", "a"},
+		{"blocked/b.txt", "b"},
+		{"ok.txt", "ok"},
+	})
+
+	// A file where a directory should be makes both blocked entries fail
+	destDir := filepath.Join(tmpDir, "dest")
+	os.MkdirAll(destDir, 0755)
+	os.WriteFile(filepath.Join(destDir, "blocked"), []byte("not a dir"), 0644)
+
+	err := extractZip(&models.ExtractConfig{
+		ArchivePath:  zipPath,
+		DestPath:     destDir,
+		ArchiveType:  models.ZIP,
+		OverwriteAll: true,
+		Threads:      4,
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, name := range []string{"blocked/a.txt", "blocked/b.txt"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not mention %s", err, name)
+		}
+	}
+
+	// The unaffected entry is still extracted
+	if data, _ := os.ReadFile(filepath.Join(destDir, "ok.txt")); string(data) != "ok" {
+		t.Errorf("ok.txt = %q", data)
+	}
+}
+
+// readTestZipEnd parses the end records of a single-file ZIP
+func readTestZipEnd(t *testing.T, path string) *zipEnd {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := readZipEnd(file, stat.Size(), func(uint32) (int64, error) { return 0, nil })
+	if err != nil {
+		t.Fatalf("readZipEnd() failed: %v", err)
+	}
+	return end
+}
+
+func TestCreateZip64ManyEntries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes 70000 entries")
+	}
+
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "empty.txt")
+	if err := os.WriteFile(source, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same empty file under many names keeps the source tree tiny
+	const count = 70000
+	sources := make([]models.SourceMapping, count)
+	for i := range sources {
+		sources[i] = models.SourceMapping{Path: source, Prefix: fmt.Sprintf("d%02d/f%05d.txt", i%50, i)}
+	}
+
+	for _, threads := range []int{1, 4} {
+		t.Run(fmt.Sprintf("threads_%d", threads), func(t *testing.T) {
+			zipPath := filepath.Join(tmpDir, fmt.Sprintf("many-%d.zip", threads))
+			err := Compress(&models.CompressConfig{
+				Sources:     sources,
+				OutputPath:  zipPath,
+				ArchiveType: models.ZIP,
+				Threads:     threads,
+			})
+			if err != nil {
+				t.Fatalf("Compress() failed: %v", err)
+			}
+
+			end := readTestZipEnd(t, zipPath)
+			if end.entries != count || !end.needsZip64() {
+				t.Errorf("end records hold %d entries (ZIP64 %v), want %d in a ZIP64 record", end.entries, end.needsZip64(), count)
+			}
+
+			info, err := Analyze(zipPath)
+			if err != nil {
+				t.Fatalf("Analyze() failed: %v", err)
+			}
+			if info.FileCount != count {
+				t.Errorf("Analyze() found %d files, want %d", info.FileCount, count)
+			}
+		})
+	}
+}
+
+func TestCreateZip64LargeEntry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compresses a 4 GiB sparse file")
+	}
+
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sparse file just past the 32-bit limit reads back as zeros
+	const size = 1<<32 + 1<<20
+	big, err := os.Create(filepath.Join(sourceDir, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := big.Truncate(size); err != nil {
+		big.Close()
+		t.Skipf("sparse files not supported: %v", err)
+	}
+	big.Close()
+	if err := os.WriteFile(filepath.Join(sourceDir, "small.txt"), []byte("Hello World"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The serial writer streams with data descriptors, the parallel one
+	// stores sizes in the local headers
+	for _, threads := range []int{1, 2} {
+		t.Run(fmt.Sprintf("threads_%d", threads), func(t *testing.T) {
+			zipPath := filepath.Join(tmpDir, fmt.Sprintf("big-%d.zip", threads))
+			err := Compress(&models.CompressConfig{
+				SourcePath:       sourceDir,
+				OutputPath:       zipPath,
+				ArchiveType:      models.ZIP,
+				CompressionLevel: 1,
+				Threads:          threads,
+			})
+			if err != nil {
+				t.Fatalf("Compress() failed: %v", err)
+			}
+
+			info, err := Analyze(zipPath)
+			if err != nil {
+				t.Fatalf("Analyze() failed: %v", err)
+			}
+			if info.FileCount != 2 || info.TotalSize != size+11 {
+				t.Errorf("Analyze() = %d files, %d bytes; want 2, %d", info.FileCount, info.TotalSize, int64(size+11))
+			}
+
+			r, err := zip.OpenReader(zipPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			for _, f := range r.File {
+				if f.Name != "big.bin" {
+					continue
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The reader checks the CRC once the whole entry is read
+				n, err := io.Copy(io.Discard, rc)
+				rc.Close()
+				if err != nil || n != size {
+					t.Errorf("read %d bytes of big.bin (%v), want %d", n, err, int64(size))
+				}
+			}
+		})
+	}
+}
+
+func TestDetectArchiveTypeZipEnd(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A ZIP64 archive whose name says nothing about it
+	var zip64Buf bytes.Buffer
+	zw := zip.NewWriter(&zip64Buf)
+	for i := 0; i < 0x10000; i++ {
+		if _, err := zw.Create(fmt.Sprintf("f%05d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A classic ZIP behind a self-extracting stub has no magic at the start
+	var stubBuf bytes.Buffer
+	stubBuf.WriteString("#!/bin/sh\necho self-extracting\nexit 0\n")
+	zw = zip.NewWriter(&stubBuf)
+	w, _ := zw.Create("hello.txt")
+	w.Write([]byte("Hello World"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want models.ArchiveType
+	}{
+		{"zip64.bin", zip64Buf.Bytes(), models.ZIP},
+		{"installer.sh", stubBuf.Bytes(), models.ZIP},
+		{"notes.txt", []byte("plain text is not an archive"), models.AUTO},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, tt.name)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := DetectArchiveType(path)
+			if err != nil || got != tt.want {
+				t.Errorf("DetectArchiveType() = %s, %v; want %s", got, err, tt.want)
+			}
+		})
+	}
+
+	info, err := Analyze(filepath.Join(tmpDir, "zip64.bin"))
+	if err != nil || info.FileCount != 0x10000 {
+		t.Fatalf("Analyze() = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,413 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// WinZip AES encryption (AE-1/AE-2): entries use method 99 and keep their
+// real compression method in the 0x9901 extra field
+const (
+	zipMethodAES      = 99
+	zipAESExtraID     = 0x9901
+	zipAESIterations  = 1000
+	zipAESVerifierLen = 2
+	zipAESMACLen      = 10
+
+	// zipAES256 is the strength byte for AES-256, the only one zipprine writes
+	zipAES256 = 3
+)
+
+// errZipAuthentication is returned when an AES entry's MAC does not match
+var errZipAuthentication = errors.New("zip: authentication failed (corrupt data)")
+
+// zipAESExtra is the decoded 0x9901 extra field
+type zipAESExtra struct {
+	version  uint16
+	strength byte
+	method   uint16
+}
+
+// keyLen returns the AES key size for the strength byte
+func (e zipAESExtra) keyLen() int {
+	return 8 + 8*int(e.strength)
+}
+
+// saltLen returns the salt size for the strength byte
+func (e zipAESExtra) saltLen() int {
+	return e.keyLen() / 2
+}
+
+func parseZipAESExtra(extra []byte) (zipAESExtra, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		if id == zipAESExtraID && size >= 7 && string(field[2:4]) == "AE" {
+			e := zipAESExtra{
+				version:  binary.LittleEndian.Uint16(field),
+				strength: field[4],
+				method:   binary.LittleEndian.Uint16(field[5:]),
+			}
+			if e.strength >= 1 && e.strength <= 3 {
+				return e, true
+			}
+		}
+		extra = extra[4+size:]
+	}
+	return zipAESExtra{}, false
+}
+
+// zipEncryption names the encryption of an entry, or "" when it is not encrypted
+func zipEncryption(f *zip.File) string {
+	if f.Flags&0x1 == 0 {
+		return ""
+	}
+	if f.Method == zipMethodAES {
+		if e, ok := parseZipAESExtra(f.Extra); ok {
+			return fmt.Sprintf("AES-%d", e.keyLen()*8)
+		}
+		return "AES"
+	}
+	return "ZipCrypto"
+}
+
+// deriveZipAESKeys derives the encryption key, MAC key and password verifier
+func deriveZipAESKeys(password string, salt []byte, keyLen int) (encKey, macKey, verifier []byte, err error) {
+	key, err := pbkdf2.Key(sha1.New, password, salt, zipAESIterations, 2*keyLen+zipAESVerifierLen)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return key[:keyLen], key[keyLen : 2*keyLen], key[2*keyLen:], nil
+}
+
+// winzipCTR is AES in counter mode with the little-endian counter, starting
+// at 1, that WinZip uses instead of the usual big-endian one
+type winzipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newWinzipCTR(key []byte) (*winzipCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &winzipCTR{block: block, used: aes.BlockSize}, nil
+}
+
+func (c *winzipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// zipAESWriter encrypts entry data as WinZip AES-256: salt and password
+// verifier first, then the encrypted data, then the authentication code
+type zipAESWriter struct {
+	w   io.Writer
+	ctr *winzipCTR
+	mac hash.Hash
+	buf []byte
+}
+
+// zipAESOverhead is the number of bytes AES-256 adds to an entry's data
+const zipAESOverhead = 16 + zipAESVerifierLen + zipAESMACLen
+
+func newZipAESWriter(w io.Writer, password string) (*zipAESWriter, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	encKey, macKey, verifier, err := deriveZipAESKeys(password, salt, 32)
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := newWinzipCTR(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(verifier); err != nil {
+		return nil, err
+	}
+	return &zipAESWriter{w: w, ctr: ctr, mac: hmac.New(sha1.New, macKey)}, nil
+}
+
+func (a *zipAESWriter) Write(p []byte) (int, error) {
+	if cap(a.buf) < len(p) {
+		a.buf = make([]byte, len(p))
+	}
+	buf := a.buf[:len(p)]
+	a.ctr.XORKeyStream(buf, p)
+	a.mac.Write(buf)
+	return a.w.Write(buf)
+}
+
+// Close writes the authentication code; it does not close the underlying writer
+func (a *zipAESWriter) Close() error {
+	_, err := a.w.Write(a.mac.Sum(nil)[:zipAESMACLen])
+	return err
+}
+
+// zipAESExtraField returns the 0x9901 extra field for an AE-2 AES-256 entry
+// whose data is compressed with method
+func zipAESExtraField(method uint16) []byte {
+	field := make([]byte, 11)
+	binary.LittleEndian.PutUint16(field[0:], zipAESExtraID)
+	binary.LittleEndian.PutUint16(field[2:], 7)
+	binary.LittleEndian.PutUint16(field[4:], 2) // AE-2: no CRC, the MAC covers integrity
+	copy(field[6:], "AE")
+	field[8] = zipAES256
+	binary.LittleEndian.PutUint16(field[9:], method)
+	return field
+}
+
+// openZipFile opens a ZIP entry, decrypting it with password when it is
+// encrypted with WinZip AES or traditional ZipCrypto
+func openZipFile(f *zip.File, password string) (io.ReadCloser, error) {
+	if f.Flags&0x1 == 0 {
+		return f.Open()
+	}
+	if password == "" {
+		return nil, fmt.Errorf("%w: %s", ErrPasswordRequired, f.Name)
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var data io.Reader
+	var method uint16
+	checkCRC := true
+	if f.Method == zipMethodAES {
+		extra, ok := parseZipAESExtra(f.Extra)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported AES header", f.Name)
+		}
+		if data, err = newZipAESReader(raw, f, extra, password); err != nil {
+			return nil, err
+		}
+		method = extra.method
+		// AE-2 stores no CRC and relies on the MAC instead
+		checkCRC = extra.version == 1
+	} else {
+		if data, err = newZipCryptoReader(raw, f, password); err != nil {
+			return nil, err
+		}
+		method = f.Method
+	}
+
+	var rc io.ReadCloser
+	switch method {
+	case zip.Store:
+		rc = io.NopCloser(data)
+	case zip.Deflate:
+		rc = flate.NewReader(data)
+	default:
+		return nil, fmt.Errorf("%s: %w", f.Name, zip.ErrAlgorithm)
+	}
+
+	return &zipCheckReader{rc: rc, f: f, crc: crc32.NewIEEE(), checkCRC: checkCRC}, nil
+}
+
+// checkZipPassword reports ErrPasswordRequired or ErrWrongPassword when the
+// first encrypted entry cannot be opened with password
+func checkZipPassword(files []*zip.File, password string) error {
+	for _, f := range files {
+		if f.Flags&0x1 == 0 {
+			continue
+		}
+		rc, err := openZipFile(f, password)
+		if err != nil {
+			return err
+		}
+		return rc.Close()
+	}
+	return nil
+}
+
+func newZipAESReader(raw io.Reader, f *zip.File, extra zipAESExtra, password string) (io.Reader, error) {
+	saltLen := extra.saltLen()
+	header := make([]byte, saltLen+zipAESVerifierLen)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+
+	encKey, macKey, verifier, err := deriveZipAESKeys(password, header[:saltLen], extra.keyLen())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(verifier, header[saltLen:]) {
+		return nil, ErrWrongPassword
+	}
+
+	ctr, err := newWinzipCTR(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(f.CompressedSize64) - int64(len(header)) - zipAESMACLen
+	if size < 0 {
+		return nil, zip.ErrFormat
+	}
+	return &zipAESReader{
+		data: io.LimitReader(raw, size),
+		raw:  raw,
+		ctr:  ctr,
+		mac:  hmac.New(sha1.New, macKey),
+	}, nil
+}
+
+// zipAESReader decrypts AES entry data and checks its MAC at the end
+type zipAESReader struct {
+	data io.Reader
+	raw  io.Reader
+	ctr  *winzipCTR
+	mac  hash.Hash
+}
+
+func (a *zipAESReader) Read(p []byte) (int, error) {
+	n, err := a.data.Read(p)
+	a.mac.Write(p[:n])
+	a.ctr.XORKeyStream(p[:n], p[:n])
+
+	if err == io.EOF {
+		want := make([]byte, zipAESMACLen)
+		if _, rerr := io.ReadFull(a.raw, want); rerr != nil {
+			return n, rerr
+		}
+		if !hmac.Equal(want, a.mac.Sum(nil)[:zipAESMACLen]) {
+			return n, errZipAuthentication
+		}
+	}
+	return n, err
+}
+
+// zipCrypto is the traditional PKWARE stream cipher
+type zipCrypto struct {
+	keys [3]uint32
+}
+
+func newZipCrypto(password string) *zipCrypto {
+	z := &zipCrypto{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	return z
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.keys[0] = crc32.IEEETable[byte(z.keys[0])^b] ^ (z.keys[0] >> 8)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crc32.IEEETable[byte(z.keys[2])^byte(z.keys[1]>>24)] ^ (z.keys[2] >> 8)
+}
+
+func (z *zipCrypto) decrypt(p []byte) {
+	for i, c := range p {
+		temp := z.keys[2] | 2
+		p[i] = c ^ byte((temp*(temp^1))>>8)
+		z.update(p[i])
+	}
+}
+
+func newZipCryptoReader(raw io.Reader, f *zip.File, password string) (io.Reader, error) {
+	z := newZipCrypto(password)
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+	z.decrypt(header)
+
+	// The last header byte repeats the high byte of the CRC, or of the
+	// modification time when the CRC follows in a data descriptor
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, ErrWrongPassword
+	}
+
+	size := int64(f.CompressedSize64) - 12
+	if size < 0 {
+		return nil, zip.ErrFormat
+	}
+	return &zipCryptoReader{r: io.LimitReader(raw, size), z: z}, nil
+}
+
+type zipCryptoReader struct {
+	r io.Reader
+	z *zipCrypto
+}
+
+func (r *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.z.decrypt(p[:n])
+	return n, err
+}
+
+// zipCheckReader verifies the size and, where stored, the CRC of a
+// decrypted entry once it has been read to the end
+type zipCheckReader struct {
+	rc       io.ReadCloser
+	f        *zip.File
+	crc      hash.Hash32
+	checkCRC bool
+	n        uint64
+}
+
+func (r *zipCheckReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.crc.Write(p[:n])
+	r.n += uint64(n)
+
+	if err == io.EOF {
+		if r.n != r.f.UncompressedSize64 {
+			return n, io.ErrUnexpectedEOF
+		}
+		if r.checkCRC && r.crc.Sum32() != r.f.CRC32 {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
+
+func (r *zipCheckReader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,248 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+func createEncryptedZip(t *testing.T, dir, password string) string {
+	t.Helper()
+
+	sourceDir := filepath.Join(dir, "source")
+	createTestFiles(t, sourceDir)
+
+	zipPath := filepath.Join(dir, "secret.zip")
+	err := Compress(&models.CompressConfig{
+		SourcePath:       sourceDir,
+		OutputPath:       zipPath,
+		ArchiveType:      models.ZIP,
+		CompressionLevel: 6,
+		Threads:          1,
+		Password:         password,
+	})
+	if err != nil {
+		t.Fatalf("Compress() with password failed: %v", err)
+	}
+	return zipPath
+}
+
+func TestZipAESRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := createEncryptedZip(t, tmpDir, "s3cret")
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		if f.Method != zipMethodAES || f.Flags&0x1 == 0 || f.CRC32 != 0 {
+			t.Errorf("%s is not stored as an AE-2 entry (method %d, flags %#x)", f.Name, f.Method, f.Flags)
+		}
+		if got := zipEncryption(f); got != "AES-256" {
+			t.Errorf("zipEncryption(%s) = %q, want AES-256", f.Name, got)
+		}
+	}
+	r.Close()
+
+	destDir := filepath.Join(tmpDir, "dest")
+	err = Extract(&models.ExtractConfig{
+		ArchivePath: zipPath,
+		DestPath:    destDir,
+		ArchiveType: models.ZIP,
+		Password:    "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "subdir", "test3.txt"))
+	if err != nil || string(data) != "Nested file" {
+		t.Errorf("test3.txt = %q, %v", data, err)
+	}
+}
+
+func TestZipPasswordErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := createEncryptedZip(t, tmpDir, "right")
+
+	tests := []struct {
+		name     string
+		password string
+		want     error
+	}{
+		{"missing", "", ErrPasswordRequired},
+		{"wrong", "wrong", ErrWrongPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Extract(&models.ExtractConfig{
+				ArchivePath: zipPath,
+				DestPath:    filepath.Join(tmpDir, "dest-"+tt.name),
+				ArchiveType: models.ZIP,
+				Password:    tt.password,
+			})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Extract() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := AnalyzeWithPassword(zipPath, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("AnalyzeWithPassword() error = %v, want %v", err, ErrWrongPassword)
+	}
+
+	if encrypted, err := IsEncrypted(zipPath, models.ZIP); err != nil || !encrypted {
+		t.Errorf("IsEncrypted() = %v, %v; want true", encrypted, err)
+	}
+
+	// Listing needs no password
+	info, err := Analyze(zipPath)
+	if err != nil {
+		t.Fatalf("Analyze() failed: %v", err)
+	}
+	if info.Encryption != "AES-256" || info.FileCount != 3 {
+		t.Errorf("Analyze() = %+v, want 3 AES-256 entries", info)
+	}
+	for _, f := range info.Files {
+		if !f.Encrypted {
+			t.Errorf("%s not reported as encrypted", f.Name)
+		}
+	}
+}
+
+func TestZipAESDetectsTampering(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := createEncryptedZip(t, tmpDir, "pw")
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := r.File[0].DataOffset()
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte of the encrypted data, after the salt and verifier
+	data, _ := os.ReadFile(zipPath)
+	data[offset+16+zipAESVerifierLen] ^= 0xff
+	os.WriteFile(zipPath, data, 0644)
+
+	err = Extract(&models.ExtractConfig{
+		ArchivePath:  zipPath,
+		DestPath:     filepath.Join(tmpDir, "dest"),
+		ArchiveType:  models.ZIP,
+		OverwriteAll: true,
+		Password:     "pw",
+	})
+	if err == nil {
+		t.Error("expected tampered data to fail authentication")
+	}
+}
+
+func TestCompressPasswordRequiresZip(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestFiles(t, filepath.Join(tmpDir, "source"))
+
+	err := Compress(&models.CompressConfig{
+		SourcePath:  filepath.Join(tmpDir, "source"),
+		OutputPath:  filepath.Join(tmpDir, "out.tar"),
+		ArchiveType: models.TAR,
+		Password:    "pw",
+	})
+	if err == nil {
+		t.Error("expected an error encrypting a TAR archive")
+	}
+}
+
+// writeZipCryptoEntry adds a traditional PKWARE-encrypted entry
+func writeZipCryptoEntry(t *testing.T, zw *zip.Writer, name, password string, content []byte) {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+	fw.Write(content)
+	fw.Close()
+
+	crc := crc32.ChecksumIEEE(content)
+	header := make([]byte, 12)
+	for i := range header {
+		header[i] = byte(i * 31)
+	}
+	header[11] = byte(crc >> 24)
+
+	// Encrypting mirrors decrypt, updating the keys with each plaintext byte
+	z := newZipCrypto(password)
+	encrypt := func(p []byte) []byte {
+		out := make([]byte, len(p))
+		for i, c := range p {
+			temp := z.keys[2] | 2
+			out[i] = c ^ byte((temp*(temp^1))>>8)
+			z.update(c)
+		}
+		return out
+	}
+	payload := append(encrypt(header), encrypt(compressed.Bytes())...)
+
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		Flags:              0x1,
+		CRC32:              crc,
+		CompressedSize64:   uint64(len(payload)),
+		UncompressedSize64: uint64(len(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(payload)
+}
+
+func TestZipCryptoExtract(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := filepath.Join(tmpDir, "legacy.zip")
+
+	file, _ := os.Create(zipPath)
+	zw := zip.NewWriter(file)
+	writeZipCryptoEntry(t, zw, "legacy.txt", "old-school", []byte("traditional encryption"))
+	zw.Close()
+	file.Close()
+
+	info, err := Analyze(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encryption != "ZipCrypto" {
+		t.Errorf("Encryption = %q, want ZipCrypto", info.Encryption)
+	}
+
+	destDir := filepath.Join(tmpDir, "dest")
+	config := &models.ExtractConfig{
+		ArchivePath: zipPath,
+		DestPath:    destDir,
+		ArchiveType: models.ZIP,
+		Password:    "nope",
+	}
+	if err := Extract(config); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("Extract() with wrong password error = %v, want %v", err, ErrWrongPassword)
+	}
+
+	config.Password = "old-school"
+	if err := Extract(config); err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(destDir, "legacy.txt"))
+	if string(data) != "traditional encryption" {
+		t.Errorf("legacy.txt = %q", data)
+	}
+}
@@ -0,0 +1,661 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"zipprine/internal/models"
+)
+
+// A split ZIP starts with a spanning signature in its first volume. Central
+// directory records name the volume (disk) holding each local header and an
+// offset relative to the start of that volume; the end records say which
+// volume the directory starts on. Go's archive/zip knows nothing of disks,
+// so split archives are written single-file and then laid out, and read
+// through a joined view whose directory has been rebased to disk 0.
+
+const (
+	zipSplitSignature = 0x08074b50
+	zipLocalSignature = 0x04034b50
+
+	zipLocalHeaderLen     = 30
+	zipDirectoryHeaderLen = 46
+	zip64ExtraID          = 0x0001
+	zipMaxComment         = 0xffff
+)
+
+var zipSplitSuffix = regexp.MustCompile(`(?i)\.z(\d{2,})$`)
+
+// zipEnd holds the end of central directory fields, merged from the ZIP64
+// record when there is one
+type zipEnd struct {
+	disk        uint32 // number of the volume holding the end records
+	cdDisk      uint32 // volume the central directory starts on
+	diskEntries uint64 // directory records on the last volume
+	entries     uint64
+	cdSize      uint64
+	cdOffset    uint64 // relative to the start of cdDisk
+	comment     []byte
+}
+
+func (e *zipEnd) needsZip64() bool {
+	return e.disk >= 0xffff || e.cdDisk >= 0xffff || e.diskEntries >= 0xffff ||
+		e.entries >= 0xffff || e.cdSize >= 0xffffffff || e.cdOffset >= 0xffffffff
+}
+
+// encodedLen is the size of the end records as written by appendTo
+func (e *zipEnd) encodedLen() int64 {
+	n := int64(zipDirectoryEndLen + len(e.comment))
+	if e.needsZip64() {
+		n += zip64DirectoryEndLen + zip64LocatorLen
+	}
+	return n
+}
+
+// appendTo writes the end records. pos is where they start on volume
+// e.disk, which the ZIP64 locator points at.
+func (e *zipEnd) appendTo(b []byte, pos uint64) []byte {
+	clamp16 := func(v uint64) uint16 { return uint16(min(v, 0xffff)) }
+	clamp32 := func(v uint64) uint32 { return uint32(min(v, 0xffffffff)) }
+
+	if e.needsZip64() {
+		le := binary.LittleEndian
+		b = le.AppendUint32(b, zip64DirectoryEndSignature)
+		b = le.AppendUint64(b, zip64DirectoryEndLen-12)
+		b = le.AppendUint16(b, 45) // version made by
+		b = le.AppendUint16(b, 45) // version needed
+		b = le.AppendUint32(b, e.disk)
+		b = le.AppendUint32(b, e.cdDisk)
+		b = le.AppendUint64(b, e.diskEntries)
+		b = le.AppendUint64(b, e.entries)
+		b = le.AppendUint64(b, e.cdSize)
+		b = le.AppendUint64(b, e.cdOffset)
+
+		b = le.AppendUint32(b, zip64LocatorSignature)
+		b = le.AppendUint32(b, e.disk)
+		b = le.AppendUint64(b, pos)
+		b = le.AppendUint32(b, e.disk+1)
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, zipDirectoryEndSignature)
+	b = binary.LittleEndian.AppendUint16(b, clamp16(uint64(e.disk)))
+	b = binary.LittleEndian.AppendUint16(b, clamp16(uint64(e.cdDisk)))
+	b = binary.LittleEndian.AppendUint16(b, clamp16(e.diskEntries))
+	b = binary.LittleEndian.AppendUint16(b, clamp16(e.entries))
+	b = binary.LittleEndian.AppendUint32(b, clamp32(e.cdSize))
+	b = binary.LittleEndian.AppendUint32(b, clamp32(e.cdOffset))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(e.comment)))
+	return append(b, e.comment...)
+}
+
+// readZipEnd locates and parses the end records of the archive in r.
+// volumeStart maps a volume number to its offset within r.
+func readZipEnd(r io.ReaderAt, size int64, volumeStart func(disk uint32) (int64, error)) (*zipEnd, error) {
+	tailLen := min(size, zipDirectoryEndLen+zipMaxComment)
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	pos := -1
+	for i := len(tail) - zipDirectoryEndLen; i >= 0; i-- {
+		if le.Uint32(tail[i:]) == zipDirectoryEndSignature && i+zipDirectoryEndLen+int(le.Uint16(tail[i+20:])) <= len(tail) {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return nil, zip.ErrFormat
+	}
+
+	rec := tail[pos:]
+	end := &zipEnd{
+		disk:        uint32(le.Uint16(rec[4:])),
+		cdDisk:      uint32(le.Uint16(rec[6:])),
+		diskEntries: uint64(le.Uint16(rec[8:])),
+		entries:     uint64(le.Uint16(rec[10:])),
+		cdSize:      uint64(le.Uint32(rec[12:])),
+		cdOffset:    uint64(le.Uint32(rec[16:])),
+	}
+	end.comment = append([]byte(nil), rec[zipDirectoryEndLen:zipDirectoryEndLen+int(le.Uint16(rec[20:]))]...)
+
+	// A ZIP64 locator sits right before the classic record
+	locatorPos := size - tailLen + int64(pos) - zip64LocatorLen
+	if locatorPos < 0 {
+		return end, nil
+	}
+	locator := make([]byte, zip64LocatorLen)
+	if _, err := r.ReadAt(locator, locatorPos); err != nil || le.Uint32(locator) != zip64LocatorSignature {
+		return end, nil
+	}
+
+	start, err := volumeStart(le.Uint32(locator[4:]))
+	if err != nil {
+		return nil, err
+	}
+	rec64 := make([]byte, zip64DirectoryEndLen)
+	if _, err := r.ReadAt(rec64, start+int64(le.Uint64(locator[8:]))); err != nil {
+		return nil, err
+	}
+	if le.Uint32(rec64) != zip64DirectoryEndSignature {
+		return nil, zip.ErrFormat
+	}
+	end.disk = le.Uint32(rec64[16:])
+	end.cdDisk = le.Uint32(rec64[20:])
+	end.diskEntries = le.Uint64(rec64[24:])
+	end.entries = le.Uint64(rec64[32:])
+	end.cdSize = le.Uint64(rec64[40:])
+	end.cdOffset = le.Uint64(rec64[48:])
+	return end, nil
+}
+
+// splitZipRecords cuts a central directory into its file header records
+func splitZipRecords(cd []byte) ([][]byte, error) {
+	le := binary.LittleEndian
+	var records [][]byte
+	for len(cd) > 0 {
+		if len(cd) < zipDirectoryHeaderLen || le.Uint32(cd) != zipDirectorySignature {
+			return nil, zip.ErrFormat
+		}
+		n := zipDirectoryHeaderLen + int(le.Uint16(cd[28:])) + int(le.Uint16(cd[30:])) + int(le.Uint16(cd[32:]))
+		if n > len(cd) {
+			return nil, zip.ErrFormat
+		}
+		records = append(records, cd[:n])
+		cd = cd[n:]
+	}
+	return records, nil
+}
+
+// zipRecordFields returns the sizes, local header offset and volume of a
+// central directory record, reading ZIP64 values where the fixed fields
+// are saturated
+func zipRecordFields(rec []byte) (usize, csize, offset uint64, disk uint32, err error) {
+	le := binary.LittleEndian
+	csize = uint64(le.Uint32(rec[20:]))
+	usize = uint64(le.Uint32(rec[24:]))
+	disk = uint32(le.Uint16(rec[34:]))
+	offset = uint64(le.Uint32(rec[42:]))
+
+	nameLen := int(le.Uint16(rec[28:]))
+	extra := rec[zipDirectoryHeaderLen+nameLen : zipDirectoryHeaderLen+nameLen+int(le.Uint16(rec[30:]))]
+	for len(extra) >= 4 {
+		id, size := le.Uint16(extra), int(le.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		if id == zip64ExtraID {
+			field := extra[4 : 4+size]
+			read64 := func(v *uint64) {
+				if *v == 0xffffffff && len(field) >= 8 {
+					*v = le.Uint64(field)
+					field = field[8:]
+				}
+			}
+			read64(&usize)
+			read64(&csize)
+			read64(&offset)
+			if disk == 0xffff && len(field) >= 4 {
+				disk = le.Uint32(field)
+			}
+			break
+		}
+		extra = extra[4+size:]
+	}
+	if offset == 0xffffffff || disk == 0xffff {
+		return 0, 0, 0, 0, zip.ErrFormat
+	}
+	return usize, csize, offset, disk, nil
+}
+
+// rebaseZipRecord returns a copy of a central directory record pointing at
+// a local header at offset on the given volume, rewriting its ZIP64 extra
+func rebaseZipRecord(rec []byte, disk uint32, offset uint64) ([]byte, error) {
+	usize, csize, _, _, err := zipRecordFields(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	nameLen := int(le.Uint16(rec[28:]))
+	extraLen := int(le.Uint16(rec[30:]))
+	name := rec[zipDirectoryHeaderLen : zipDirectoryHeaderLen+nameLen]
+	extra := rec[zipDirectoryHeaderLen+nameLen : zipDirectoryHeaderLen+nameLen+extraLen]
+	comment := rec[zipDirectoryHeaderLen+nameLen+extraLen:]
+
+	header := append([]byte(nil), rec[:zipDirectoryHeaderLen]...)
+	var zip64 []byte
+	set32 := func(at int, v uint64) {
+		if v >= 0xffffffff {
+			le.PutUint32(header[at:], 0xffffffff)
+			zip64 = le.AppendUint64(zip64, v)
+		} else {
+			le.PutUint32(header[at:], uint32(v))
+		}
+	}
+	// The ZIP64 fields appear in this order: sizes, offset, disk
+	set32(24, usize)
+	set32(20, csize)
+	set32(42, offset)
+	if disk >= 0xffff {
+		le.PutUint16(header[34:], 0xffff)
+		zip64 = le.AppendUint32(zip64, disk)
+	} else {
+		le.PutUint16(header[34:], uint16(disk))
+	}
+
+	var newExtra []byte
+	for len(extra) >= 4 {
+		id, size := le.Uint16(extra), int(le.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		if id != zip64ExtraID {
+			newExtra = append(newExtra, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	if len(zip64) > 0 {
+		field := le.AppendUint16(nil, zip64ExtraID)
+		field = le.AppendUint16(field, uint16(len(zip64)))
+		newExtra = append(append(field, zip64...), newExtra...)
+	}
+	if len(newExtra) > 0xffff {
+		return nil, fmt.Errorf("zip extra field too long")
+	}
+	le.PutUint16(header[30:], uint16(len(newExtra)))
+
+	out := append(header, name...)
+	out = append(out, newExtra...)
+	return append(out, comment...), nil
+}
+
+// zipSplitWriter writes volumes of a split ZIP, named .z01, .z02 … until
+// the last is renamed to .zip
+type zipSplitWriter struct {
+	base string
+	size int64
+
+	file  *os.File
+	disk  uint32 // current volume, counting from 0
+	pos   int64  // bytes written to the current volume
+	names []string
+}
+
+func (w *zipSplitWriter) next() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.disk++
+	}
+	name := fmt.Sprintf("%s.z%02d", w.base, w.disk+1)
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w.file, w.pos = file, 0
+	w.names = append(w.names, name)
+	return nil
+}
+
+// reserve moves to a new volume unless n more bytes fit in this one, so
+// headers never straddle two volumes
+func (w *zipSplitWriter) reserve(n int64) error {
+	if w.pos > 0 && w.pos+n > w.size {
+		return w.next()
+	}
+	return nil
+}
+
+func (w *zipSplitWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.pos == w.size {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.file.Write(p[:min(int64(len(p)), w.size-w.pos)])
+		written += n
+		w.pos += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// createSplitZip writes the archive to a temporary file and then lays it
+// out as split volumes next to config.OutputPath
+func createSplitZip(config *models.CompressConfig) error {
+	tmp, err := os.CreateTemp(filepath.Dir(config.OutputPath), ".zipprine-split-*.zip")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	single := *config
+	single.OutputPath = tmp.Name()
+	single.SplitSize = 0
+	if err := createZip(&single); err != nil {
+		return err
+	}
+
+	return splitZip(tmp.Name(), config.OutputPath, config.SplitSize, compressProgress(config))
+}
+
+// splitZip lays out the single-file archive src as split volumes ending in
+// dst. An archive that fits in one volume is simply moved to dst.
+func splitZip(src, dst string, size int64, out io.Writer) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(dst, filepath.Ext(dst))
+	removeStaleZipVolumes(base, 1)
+	if stat.Size() <= size {
+		file.Close()
+		return os.Rename(src, dst)
+	}
+
+	end, err := readZipEnd(file, stat.Size(), func(disk uint32) (int64, error) {
+		if disk != 0 {
+			return 0, zip.ErrFormat
+		}
+		return 0, nil
+	})
+	if err != nil {
+		return err
+	}
+	cd := make([]byte, end.cdSize)
+	if _, err := file.ReadAt(cd, int64(end.cdOffset)); err != nil {
+		return err
+	}
+	records, err := splitZipRecords(cd)
+	if err != nil {
+		return err
+	}
+
+	// Copy the local entries in file order; each runs up to the next one
+	offsets := make([]uint64, len(records))
+	order := make([]int, len(records))
+	for i, rec := range records {
+		if _, _, offsets[i], _, err = zipRecordFields(rec); err != nil {
+			return err
+		}
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return offsets[order[a]] < offsets[order[b]] })
+
+	w := &zipSplitWriter{base: base, size: size}
+	if err := w.next(); err != nil {
+		return err
+	}
+	defer func() {
+		if w.file != nil {
+			w.file.Close()
+		}
+	}()
+
+	if _, err := w.Write(binary.LittleEndian.AppendUint32(nil, zipSplitSignature)); err != nil {
+		return err
+	}
+
+	le := binary.LittleEndian
+	local := make([]byte, zipLocalHeaderLen)
+	for i, idx := range order {
+		start := int64(offsets[idx])
+		stop := int64(end.cdOffset)
+		if i+1 < len(order) {
+			stop = int64(offsets[order[i+1]])
+		}
+
+		if _, err := file.ReadAt(local, start); err != nil {
+			return err
+		}
+		if le.Uint32(local) != zipLocalSignature {
+			return zip.ErrFormat
+		}
+		headerLen := zipLocalHeaderLen + int64(le.Uint16(local[26:])) + int64(le.Uint16(local[28:]))
+		if err := w.reserve(headerLen); err != nil {
+			return err
+		}
+
+		if records[idx], err = rebaseZipRecord(records[idx], w.disk, uint64(w.pos)); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(file, start, stop-start)); err != nil {
+			return err
+		}
+	}
+
+	newEnd := &zipEnd{entries: uint64(len(records)), comment: end.comment}
+	recordDisks := make([]uint32, len(records))
+	for i, rec := range records {
+		if err := w.reserve(int64(len(rec))); err != nil {
+			return err
+		}
+		if i == 0 {
+			newEnd.cdDisk, newEnd.cdOffset = w.disk, uint64(w.pos)
+		}
+		recordDisks[i] = w.disk
+		if _, err := w.Write(rec); err != nil {
+			return err
+		}
+		newEnd.cdSize += uint64(len(rec))
+	}
+	if len(records) == 0 {
+		newEnd.cdDisk, newEnd.cdOffset = w.disk, uint64(w.pos)
+	}
+
+	if err := w.reserve(newEnd.encodedLen()); err != nil {
+		return err
+	}
+	newEnd.disk = w.disk
+	for _, disk := range recordDisks {
+		if disk == w.disk {
+			newEnd.diskEntries++
+		}
+	}
+	if _, err := w.Write(newEnd.appendTo(nil, uint64(w.pos))); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	// The last volume carries the .zip name
+	last := len(w.names) - 1
+	if err := os.Rename(w.names[last], dst); err != nil {
+		return err
+	}
+	w.names[last] = dst
+	for _, name := range w.names {
+		fmt.Fprintf(out, "  ✂ %s\n", filepath.Base(name))
+	}
+	return nil
+}
+
+// removeStaleZipVolumes deletes .zNN files from an earlier, longer split,
+// starting at volume number from
+func removeStaleZipVolumes(base string, from int) {
+	for n := from; ; n++ {
+		if err := os.Remove(fmt.Sprintf("%s.z%02d", base, n)); err != nil {
+			return
+		}
+	}
+}
+
+// zipVolumes returns the volumes of a split ZIP in order when path names
+// its first (.z01) or last (.zip) volume, and nil for a single-file ZIP
+func zipVolumes(path string) ([]string, error) {
+	var base, last string
+	if m := zipSplitSuffix.FindStringIndex(path); m != nil {
+		base, last = path[:m[0]], path[:m[0]]+".zip"
+	} else if strings.EqualFold(filepath.Ext(path), ".zip") {
+		base, last = strings.TrimSuffix(path, filepath.Ext(path)), path
+	} else {
+		return nil, nil
+	}
+
+	var volumes []string
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s.z%02d", base, n)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		volumes = append(volumes, name)
+	}
+	if len(volumes) == 0 {
+		return nil, nil
+	}
+	if _, err := os.Stat(last); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMissingVolume, filepath.Base(last))
+	}
+	return append(volumes, last), nil
+}
+
+// zipArchive is an open ZIP archive, possibly joined from split volumes
+type zipArchive struct {
+	*zip.Reader
+	closers []io.Closer
+}
+
+func (a *zipArchive) Close() error {
+	var firstErr error
+	for _, c := range a.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openZipArchive opens a ZIP archive, reassembling a split archive when
+// path names its first or last volume
+func openZipArchive(path string) (*zipArchive, error) {
+	volumes, err := zipVolumes(path)
+	if err != nil {
+		return nil, err
+	}
+	if volumes == nil {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		return &zipArchive{Reader: &r.Reader, closers: []io.Closer{r}}, nil
+	}
+
+	archive := &zipArchive{}
+	r, err := joinSplitZip(volumes, archive)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	archive.Reader = r
+	return archive, nil
+}
+
+// joinSplitZip reads split volumes back to back, followed by a copy of the
+// central directory rebased onto that single stream
+func joinSplitZip(volumes []string, archive *zipArchive) (*zip.Reader, error) {
+	parts := make([]io.ReaderAt, len(volumes))
+	sizes := make([]int64, len(volumes))
+	for i, volume := range volumes {
+		file, err := os.Open(volume)
+		if err != nil {
+			return nil, err
+		}
+		archive.closers = append(archive.closers, file)
+		stat, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		parts[i], sizes[i] = file, stat.Size()
+	}
+	joined := newMultiReaderAt(parts, sizes)
+
+	volumeStart := func(disk uint32) (int64, error) {
+		if int(disk) >= len(volumes) {
+			return 0, fmt.Errorf("%w: volume %d", ErrMissingVolume, disk+1)
+		}
+		return joined.starts[disk], nil
+	}
+
+	end, err := readZipEnd(joined, joined.Size(), volumeStart)
+	if err != nil {
+		return nil, err
+	}
+	if int(end.disk) != len(volumes)-1 {
+		if int(end.disk) > len(volumes)-1 {
+			base := strings.TrimSuffix(volumes[0], filepath.Ext(volumes[0]))
+			return nil, fmt.Errorf("%w: %s", ErrMissingVolume, filepath.Base(fmt.Sprintf("%s.z%02d", base, len(volumes))))
+		}
+		return nil, fmt.Errorf("split ZIP has %d volumes but its directory expects %d", len(volumes), end.disk+1)
+	}
+
+	cdStart, err := volumeStart(end.cdDisk)
+	if err != nil {
+		return nil, err
+	}
+	cd := make([]byte, end.cdSize)
+	if _, err := joined.ReadAt(cd, cdStart+int64(end.cdOffset)); err != nil {
+		return nil, err
+	}
+	records, err := splitZipRecords(cd)
+	if err != nil {
+		return nil, err
+	}
+
+	var tail bytes.Buffer
+	for _, rec := range records {
+		_, _, offset, disk, err := zipRecordFields(rec)
+		if err != nil {
+			return nil, err
+		}
+		start, err := volumeStart(disk)
+		if err != nil {
+			return nil, err
+		}
+		rebased, err := rebaseZipRecord(rec, 0, uint64(start)+offset)
+		if err != nil {
+			return nil, err
+		}
+		tail.Write(rebased)
+	}
+
+	joinedEnd := &zipEnd{
+		diskEntries: uint64(len(records)),
+		entries:     uint64(len(records)),
+		cdSize:      uint64(tail.Len()),
+		cdOffset:    uint64(joined.Size()),
+		comment:     end.comment,
+	}
+	tail.Write(joinedEnd.appendTo(nil, uint64(joined.Size())+uint64(tail.Len())))
+
+	full := newMultiReaderAt(
+		[]io.ReaderAt{joined, bytes.NewReader(tail.Bytes())},
+		[]int64{joined.Size(), int64(tail.Len())},
+	)
+	return zip.NewReader(full, full.Size())
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"zipprine/internal/manifest"
+)
+
+// runBatch dispatches "zipprine batch" subcommands
+func runBatch(args []string) error {
+	if len(args) == 0 || args[0] != "run" {
+		return fmt.Errorf("usage: zipprine batch run [options] <jobs.yaml|jobs.json>")
+	}
+	return runBatchRun(args[1:])
+}
+
+// runBatchRun executes the jobs of a manifest and prints a summary; it
+// fails when any job failed
+func runBatchRun(args []string) error {
+	fs := flag.NewFlagSet("batch run", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Show what each job would do without running it")
+	reportPath := fs.String("report", "", "Write the results as JSON to this file")
+	vars := varList{}
+	fs.Var(vars, "var", "Set a manifest variable as NAME=VALUE (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: zipprine batch run [options] <jobs.yaml|jobs.json>")
+	}
+
+	m, err := manifest.Load(fs.Arg(0), vars)
+	if err != nil {
+		return err
+	}
+
+	report := manifest.Run(m, *dryRun, os.Stdout)
+	fmt.Println()
+	report.WriteSummary(os.Stdout)
+
+	if *reportPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*reportPath, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(report.Results))
+	}
+	return nil
+}
+
+// varList collects repeated NAME=VALUE flags
+type varList map[string]string
+
+func (v varList) String() string {
+	parts := make([]string, 0, len(v))
+	for name, value := range v {
+		parts = append(parts, name+"="+value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v varList) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid variable %q: want NAME=VALUE", value)
+	}
+	v[name] = val
+	return nil
+}
@@ -1,18 +1,27 @@
 package cli
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 
 	"zipprine/internal/archiver"
 	"zipprine/internal/fetcher"
 	"zipprine/internal/models"
 	"zipprine/internal/version"
+	"zipprine/pkg/fileutil"
 )
 
 func Run() bool {
+	// Positional subcommands take precedence over flag-style operations
+	if runCommand(os.Args[1:]) {
+		return true
+	}
+
 	// Define flags
 	compress := flag.String("compress", "", "Compress files/folders (source path)")
 	extract := flag.String("extract", "", "Extract archive (archive path)")
@@ -20,11 +29,29 @@ func Run() bool {
 	output := flag.String("output", "", "Output path for compression or extraction")
 	archiveType := flag.String("type", "zip", "Archive type (zip, tar, tar.gz, gzip, rar)")
 	level := flag.Int("level", 6, "Compression level (1=fast, 6=balanced, 9=best)")
+	threads := flag.Int("threads", 0, "Worker threads for compression and ZIP extraction (0=auto, 1=single-threaded)")
 	overwrite := flag.Bool("overwrite", false, "Overwrite existing files during extraction")
 	preservePerms := flag.Bool("preserve-perms", true, "Preserve file permissions during extraction")
 	exclude := flag.String("exclude", "", "Comma-separated list of patterns to exclude")
 	include := flag.String("include", "", "Comma-separated list of patterns to include")
+	useGitignore := flag.Bool("gitignore", false, "Honour .gitignore files found in the source tree")
 	verify := flag.Bool("verify", false, "Verify archive integrity after compression")
+	encrypt := flag.Bool("encrypt", false, "Encrypt ZIP entries with AES-256 (password from --password-file or $"+passwordEnv+")")
+	passwordFile := flag.String("password-file", "", "Read the archive password from this file")
+	splitSize := flag.String("split-size", "", "Split the archive into volumes of at most this size (e.g. 500M, 1G)")
+	reproducible := flag.Bool("reproducible", false, "Give entries fixed timestamps and no owner so identical inputs give identical archives")
+	preset := flag.String("preset", "", "Apply a named preset from the config files")
+	stripComponents := flag.Int("strip-components", 0, "Strip N leading path components from entry names during extraction")
+	prefix := flag.String("prefix", "", "Extract entries under this directory inside the output path")
+	recursive := flag.Bool("recursive", false, "Also extract archives found inside the archive, in place")
+	maxDepth := flag.Int("max-depth", 0, "Nesting levels expanded by --recursive (0=built-in maximum)")
+	keepNested := flag.Bool("keep-nested", false, "Keep nested archives after --recursive expands them")
+	maxSize := flag.String("max-size", "", "Stop extracting after writing this much in total (e.g. 10G; default 16G with --recursive, 0=unlimited)")
+	maxFiles := flag.Int64("max-files", 0, "Stop extracting after writing this many files in total (0=unlimited, or 1000000 with --recursive)")
+	nested := flag.Bool("nested", false, "Also analyze archives found inside the archive")
+	jsonOutput := flag.Bool("json", false, "Print the --analyze results as JSON")
+	var sources sourceList
+	flag.Var(&sources, "add", "Add PATH[=PREFIX] to the archive (repeatable)")
 	remoteURL := flag.String("url", "", "Remote URL to download and extract archive from")
 	showVersion := flag.Bool("version", false, "Show version information")
 	help := flag.Bool("help", false, "Show help information")
@@ -62,7 +89,7 @@ func Run() bool {
 		return true
 	}
 
-	if *compress != "" {
+	if *compress != "" || len(sources) > 0 {
 		if *output == "" {
 			fmt.Println("❌ Error: --output is required for compression")
 			os.Exit(1)
@@ -74,12 +101,42 @@ func Run() bool {
 			os.Exit(1)
 		}
 
+		if *compress != "" && len(sources) > 0 {
+			sources = append(sourceList{{Path: *compress}}, sources...)
+		}
+
 		config := &models.CompressConfig{
 			SourcePath:       *compress,
+			Sources:          sources,
 			OutputPath:       *output,
 			ArchiveType:      archType,
 			CompressionLevel: *level,
+			Threads:          *threads,
+			UseGitignore:     *useGitignore,
 			VerifyIntegrity:  *verify,
+			Reproducible:     *reproducible,
+		}
+		if err := applySettings(config, *preset, flagWasSet); err != nil {
+			fmt.Fprintf(messageOut(*output), "❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *encrypt {
+			password, err := requirePassword(*passwordFile)
+			if err != nil {
+				fmt.Fprintf(messageOut(*output), "❌ Error: %v\n", err)
+				os.Exit(1)
+			}
+			config.Password = password
+		}
+
+		if *splitSize != "" {
+			size, err := fileutil.ParseBytes(*splitSize)
+			if err != nil {
+				fmt.Fprintf(messageOut(*output), "❌ Error: %v\n", err)
+				os.Exit(1)
+			}
+			config.SplitSize = size
 		}
 
 		if *exclude != "" {
@@ -89,12 +146,13 @@ func Run() bool {
 			config.IncludePaths = strings.Split(*include, ",")
 		}
 
-		fmt.Printf("📦 Compressing %s to %s (%s)...\n", *compress, *output, archType)
+		msg := messageOut(*output)
+		fmt.Fprintf(msg, "📦 Compressing %s to %s (%s)...\n", sources.describe(*compress), *output, config.ArchiveType)
 		if err := archiver.Compress(config); err != nil {
-			fmt.Printf("❌ Error: %v\n", err)
+			fmt.Fprintf(msg, "❌ Error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("✨ Compression completed successfully!")
+		fmt.Fprintln(msg, "✨ Compression completed successfully!")
 		return true
 	}
 
@@ -106,7 +164,13 @@ func Run() bool {
 
 		// Detect archive type if not specified or set to auto
 		archType := parseArchiveType(*archiveType)
-		if archType == models.AUTO || *archiveType == "" {
+		if *extract == archiver.StdioPath {
+			// Streams cannot be sniffed twice, so leave detection to the
+			// archiver unless a type was given explicitly
+			if !flagWasSet("type") {
+				archType = models.AUTO
+			}
+		} else if archType == models.AUTO || *archiveType == "" {
 			detectedType, err := archiver.DetectArchiveType(*extract)
 			if err != nil {
 				fmt.Printf("❌ Error detecting archive type: %v\n", err)
@@ -116,12 +180,31 @@ func Run() bool {
 			fmt.Printf("🔍 Detected archive type: %s\n", archType)
 		}
 
+		password, err := resolvePassword(*passwordFile)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+		limits, err := extractLimits(*maxSize, *maxFiles, *recursive)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+
 		config := &models.ExtractConfig{
-			ArchivePath:   *extract,
-			DestPath:      *output,
-			ArchiveType:   archType,
-			OverwriteAll:  *overwrite,
-			PreservePerms: *preservePerms,
+			ArchivePath:     *extract,
+			DestPath:        *output,
+			ArchiveType:     archType,
+			OverwriteAll:    *overwrite,
+			PreservePerms:   *preservePerms,
+			StripComponents: *stripComponents,
+			Prefix:          *prefix,
+			Threads:         *threads,
+			Password:        password,
+			Recursive:       *recursive,
+			MaxDepth:        *maxDepth,
+			KeepNested:      *keepNested,
+			Limits:          limits,
 		}
 
 		fmt.Printf("📂 Extracting %s to %s...\n", *extract, *output)
@@ -135,24 +218,57 @@ func Run() bool {
 
 	// Handle analysis
 	if *analyze != "" {
-		info, err := archiver.Analyze(*analyze)
+		password, err := resolvePassword(*passwordFile)
 		if err != nil {
 			fmt.Printf("❌ Error: %v\n", err)
 			os.Exit(1)
 		}
 
+		analyzeArchive := archiver.AnalyzeWithPassword
+		if *nested {
+			analyzeArchive = archiver.AnalyzeNested
+		}
+		info, err := analyzeArchive(*analyze, password)
+		if err == nil && info == nil {
+			err = fmt.Errorf("%s is not a supported archive", *analyze)
+		}
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *jsonOutput {
+			if info.Report, err = archiver.AnalyzeReport(*analyze, password); err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				os.Exit(1)
+			}
+			data, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return true
+		}
+
 		fmt.Println("\n📊 Archive Analysis")
 		fmt.Println("==================")
 		fmt.Printf("Type:              %s\n", info.Type)
 		fmt.Printf("File Count:        %d\n", info.FileCount)
 		fmt.Printf("Total Size:        %d bytes\n", info.TotalSize)
 		fmt.Printf("Compressed Size:   %d bytes\n", info.CompressedSize)
+		if len(info.Volumes) > 0 {
+			fmt.Printf("Volumes:           %d\n", len(info.Volumes))
+		}
 		if info.CompressionRatio > 0 {
 			fmt.Printf("Compression Ratio: %.2f%%\n", info.CompressionRatio*100)
 		}
 		if info.Checksum != "" {
 			fmt.Printf("Checksum (SHA256): %s\n", info.Checksum)
 		}
+		if info.Encryption != "" {
+			fmt.Printf("Encryption:        %s\n", info.Encryption)
+		}
 		fmt.Println("\n📁 Files:")
 		for i, file := range info.Files {
 			if i >= 20 {
@@ -161,6 +277,10 @@ func Run() bool {
 			}
 			fmt.Printf("  - %s (%d bytes)\n", file.Name, file.Size)
 		}
+		if len(info.Nested) > 0 {
+			fmt.Println("\n📦 Nested Archives:")
+			printNested(info, "  ")
+		}
 		return true
 	}
 
@@ -169,6 +289,32 @@ func Run() bool {
 	return true
 }
 
+// printNested lists the archives inside an analyzed archive with their
+// files, indenting each level further
+func printNested(info *models.ArchiveInfo, indent string) {
+	for _, name := range slices.Sorted(maps.Keys(info.Nested)) {
+		nested := info.Nested[name]
+		fmt.Printf("%s%s (%s, %d files, %s)\n", indent, name, nested.Type, nested.FileCount, fileutil.FormatBytes(nested.TotalSize))
+		for _, file := range nested.Files {
+			if !file.IsDir {
+				fmt.Printf("%s  - %s (%d bytes)\n", indent, file.Name, file.Size)
+			}
+		}
+		printNested(nested, indent+"    ")
+	}
+}
+
+// flagWasSet reports whether a flag was given explicitly on the command line
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func parseArchiveType(typeStr string) models.ArchiveType {
 	switch strings.ToLower(typeStr) {
 	case "zip":
@@ -196,17 +342,51 @@ func printHelp() {
 	fmt.Println("    zipprine")
 	fmt.Println("\n  Command-line mode:")
 	fmt.Println("    zipprine [OPTIONS]")
+	fmt.Println("\n  Subcommands:")
+	fmt.Println("    zipprine cat <archive> <entry>")
+	fmt.Println("    zipprine create [--preset NAME] [--type T] [--level N] [--threads N] [--split-size SIZE] [--reproducible] [--add PATH[=PREFIX]...] <output|-> [source|-]")
+	fmt.Println("    zipprine extract [--type T] [--overwrite] [--threads N] [--strip-components N] [--prefix DIR] [--recursive] <archive|-> <dest>")
+	fmt.Println("    zipprine add [--level N] <archive> <path[=prefix]>...")
+	fmt.Println("    zipprine update [--level N] <archive> <path[=prefix]>...")
+	fmt.Println("    zipprine delete <archive> <entry>...")
+	fmt.Println("    zipprine convert [--type T] [--level N] <source> <dest>")
+	fmt.Println("    zipprine repair [--extract DIR] [--report FILE] <damaged> <output>")
+	fmt.Println("    zipprine grep [-i] [--entry GLOBS] [--nested] [--binary] [--threads N] <pattern> <archive>...")
+	fmt.Println("    zipprine batch run [--dry-run] [--var NAME=VALUE]... [--report FILE] <jobs.yaml>")
+	fmt.Println("    zipprine serve [--listen ADDR] [--root DIR] [--max-upload SIZE] [--jobs N]")
+	fmt.Println("    zipprine serve-archive [--listen ADDR] [--gzip] <archive>")
 	fmt.Println("\nOPTIONS:")
 	fmt.Println("  --compress <path>       Compress files/folders at the specified path")
+	fmt.Println("                          (use - with --output/--extract for stdout/stdin)")
 	fmt.Println("  --extract <path>        Extract archive at the specified path")
 	fmt.Println("  --analyze <path>        Analyze archive at the specified path")
 	fmt.Println("  --output <path>         Output path for compression or extraction")
 	fmt.Println("  --type <type>           Archive type: zip, tar, tar.gz, gzip, rar (default: zip)")
 	fmt.Println("  --level <1-9>           Compression level: 1=fast, 6=balanced, 9=best (default: 6)")
+	fmt.Println("  --threads <n>           Worker threads for compression and ZIP extraction (default: 0=auto)")
 	fmt.Println("  --overwrite             Overwrite existing files during extraction")
 	fmt.Println("  --preserve-perms        Preserve file permissions (default: true)")
 	fmt.Println("  --exclude <patterns>    Comma-separated patterns to exclude")
 	fmt.Println("  --include <patterns>    Comma-separated patterns to include")
+	fmt.Println("  --gitignore             Honour .gitignore files in the source tree")
+	fmt.Println("  --add <path[=prefix]>   Add a source under an in-archive prefix (repeatable)")
+	fmt.Println("  --strip-components <n>  Strip N leading path components when extracting")
+	fmt.Println("  --prefix <dir>          Extract entries under this directory inside --output")
+	fmt.Println("  --recursive             Also extract archives found among the extracted files, in place")
+	fmt.Println("  --max-depth <n>         Nesting levels expanded by --recursive (default: 0=built-in maximum of 8)")
+	fmt.Println("  --keep-nested           Keep nested archives next to their expanded contents")
+	fmt.Println("  --max-size <size>       Abort extraction after writing SIZE in total, nested archives included")
+	fmt.Println("  --max-files <n>         Abort extraction after writing N files in total, nested archives included")
+	fmt.Println("  --nested                With --analyze, also list the contents of archives inside the archive")
+	fmt.Println("  --json                  With --analyze, print the analysis and its size report as JSON")
+	fmt.Println("  --encrypt               Encrypt ZIP entries with AES-256")
+	fmt.Println("  --password-file <path>  Read the archive password from a file")
+	fmt.Println("                          (or set $ZIPPRINE_PASSWORD; passwords are never passed as flags)")
+	fmt.Println("  --split-size <size>     Split the archive into volumes of at most SIZE (e.g. 500M, 1G)")
+	fmt.Println("                          (ZIP: name.z01 … name.zip; tar/gzip: name.000, name.001 …)")
+	fmt.Println("  --reproducible          Fixed timestamps and no owners, so identical inputs give identical archives")
+	fmt.Println("                          (timestamps come from $SOURCE_DATE_EPOCH when set)")
+	fmt.Println("  --preset <name>         Apply a named preset from the config files (or set $ZIPPRINE_PRESET)")
 	fmt.Println("  --verify                Verify archive integrity after compression")
 	fmt.Println("  --url <url>             Download and extract archive from remote URL")
 	fmt.Println("  --version               Show version information")
@@ -218,10 +398,41 @@ func printHelp() {
 	fmt.Println("  zipprine --extract archive.tar.gz --output /path/to/dest")
 	fmt.Println("\n  # Analyze an archive")
 	fmt.Println("  zipprine --analyze archive.zip")
+	fmt.Println("\n  # Find what bloats a bundle: sizes by extension and directory, largest files, duplicates")
+	fmt.Println("  zipprine --analyze deploy.zip --json | jq .report")
+	fmt.Println("\n  # Unpack a support bundle and every archive inside it, with bomb limits")
+	fmt.Println("  zipprine extract --recursive --max-size 10G --max-files 100000 bundle.tar support/")
+	fmt.Println("\n  # Print a single entry to stdout")
+	fmt.Println("  zipprine cat release.zip VERSION")
+	fmt.Println("\n  # Split a backup into 1 GB volumes and extract it from the first one")
+	fmt.Println("  zipprine create --split-size 1G backup.tar.gz /data")
+	fmt.Println("  zipprine extract backup.tar.gz.000 /restore")
+	fmt.Println("\n  # Salvage a ZIP whose central directory is cut off")
+	fmt.Println("  zipprine repair --extract recovered/ --report repair.txt broken.zip fixed.zip")
+	fmt.Println("\n  # Find errors in the logs of every support bundle, including bundles inside them")
+	fmt.Println("  zipprine grep -i --entry '*.log' --nested 'timeout|refused' bundles/*.zip")
+	fmt.Println("\n  # Run the jobs of a manifest, checking the plan first")
+	fmt.Println("  zipprine batch run --dry-run --var version=1.4.2 jobs.yaml")
+	fmt.Println("\n  # Run the HTTP API as a sidecar, confined to /srv/data")
+	fmt.Println("  zipprine serve --listen :8080 --root /srv/data")
+	fmt.Println("\n  # Browse the HTML documentation inside a bundle at http://localhost:8000/")
+	fmt.Println("  zipprine serve-archive docs.zip --listen :8000 --gzip")
+	fmt.Println("\n  # Stream an archive between hosts")
+	fmt.Println("  ssh host zipprine create --type tar.gz - dir | zipprine extract - out/")
 	fmt.Println("\n  # Download and extract from URL")
 	fmt.Println("  zipprine --url https://example.com/archive.zip --output /path/to/dest")
 	fmt.Println("\n  # Compress with exclusions")
 	fmt.Println("  zipprine --compress /project --output project.tar.gz --type tar.gz --exclude '*.log,*.tmp'")
+	fmt.Println("\n  # Add a changelog to an existing archive without re-creating it")
+	fmt.Println("  zipprine add release.zip CHANGELOG.md=docs/CHANGELOG.md")
+	fmt.Println("\n  # Build a release bundle from several sources")
+	fmt.Println("  zipprine --add src/=app/src --add README.md=app/README.md --output release.tar.gz --type tar.gz")
+	fmt.Println("\n  # Build a release archive with the settings of the \"release\" preset")
+	fmt.Println("  zipprine create --preset release dist/app.tar.gz build/")
+	fmt.Println("\nCONFIG FILES:")
+	fmt.Println("  ~/.config/zipprine/config.yaml (or $ZIPPRINE_CONFIG) and .zipprine.yaml in the project hold")
+	fmt.Println("  compression defaults and named presets. Later sources win: built-in defaults, user config,")
+	fmt.Println("  project config, the chosen preset, $ZIPPRINE_TYPE/LEVEL/THREADS/EXCLUDE, then flags.")
 	fmt.Println("\nSUPPORTED FORMATS:")
 	fmt.Println("  Compression: ZIP, TAR, TAR.GZ, GZIP")
 	fmt.Println("  Extraction:  ZIP, TAR, TAR.GZ, GZIP, RAR")
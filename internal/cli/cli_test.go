@@ -1,9 +1,14 @@
 package cli
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"zipprine/internal/archiver"
 	"zipprine/internal/models"
+	"zipprine/internal/settings"
 )
 
 func TestParseArchiveType(t *testing.T) {
@@ -88,3 +93,228 @@ func TestParseArchiveTypeGzipVariants(t *testing.T) {
 		}
 	}
 }
+
+func TestRunCat(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "VERSION"), []byte(`{"version":"1.2.3"}`), 0644)
+
+	zipPath := filepath.Join(tmpDir, "release.zip")
+	if err := archiver.Compress(&models.CompressConfig{
+		SourcePath:       sourceDir,
+		OutputPath:       zipPath,
+		ArchiveType:      models.ZIP,
+		CompressionLevel: 5,
+	}); err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	stdout = &buf
+	defer func() { stdout = os.Stdout }()
+
+	if err := runCat([]string{zipPath, "VERSION"}); err != nil {
+		t.Fatalf("runCat failed: %v", err)
+	}
+	if buf.String() != `{"version":"1.2.3"}` {
+		t.Errorf("runCat output = %q; want %q", buf.String(), `{"version":"1.2.3"}`)
+	}
+
+	if err := runCat([]string{zipPath}); err == nil {
+		t.Error("Expected usage error for missing entry argument, got nil")
+	}
+}
+
+func TestRunRepair(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "notes.txt"), []byte("keep me"), 0644)
+
+	zipPath := filepath.Join(tmpDir, "broken.zip")
+	if err := archiver.Compress(&models.CompressConfig{
+		SourcePath:  sourceDir,
+		OutputPath:  zipPath,
+		ArchiveType: models.ZIP,
+	}); err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	data, _ := os.ReadFile(zipPath)
+	os.WriteFile(zipPath, data[:len(data)-10], 0644)
+
+	reportPath := filepath.Join(tmpDir, "report.txt")
+	destDir := filepath.Join(tmpDir, "dest")
+	err := runRepair([]string{"-extract", destDir, "-report", reportPath, zipPath, filepath.Join(tmpDir, "fixed.zip")})
+	if err != nil {
+		t.Fatalf("runRepair failed: %v", err)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(destDir, "notes.txt")); string(content) != "keep me" {
+		t.Errorf("recovered notes.txt = %q; want %q", content, "keep me")
+	}
+	if report, _ := os.ReadFile(reportPath); !bytes.Contains(report, []byte("✓ notes.txt")) {
+		t.Errorf("report does not list notes.txt:\n%s", report)
+	}
+
+	if err := runRepair([]string{zipPath}); err == nil {
+		t.Error("Expected usage error for missing output argument, got nil")
+	}
+}
+
+func TestRunCreatePreset(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "main.go"), []byte("package main"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "debug.log"), []byte("noise"), 0644)
+
+	t.Setenv(settings.EnvConfig, filepath.Join(tmpDir, "none.yaml"))
+	t.Setenv(settings.EnvPreset, "")
+	t.Setenv(settings.EnvExclude, "")
+	os.WriteFile(filepath.Join(tmpDir, settings.ProjectFile), []byte(`
+presets:
+  release:
+    type: tar
+    exclude: ["*.log"]
+    reproducible: true
+`), 0644)
+	t.Chdir(tmpDir)
+
+	// The preset's type applies when neither --type nor the name decides it
+	output := filepath.Join(tmpDir, "release.out")
+	if err := runCreate([]string{"-preset", "release", output, sourceDir}); err != nil {
+		t.Fatalf("runCreate failed: %v", err)
+	}
+	info, err := archiver.Analyze(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != models.TAR {
+		t.Errorf("preset archive is %s, want TAR", info.Type)
+	}
+	for _, file := range info.Files {
+		if file.Name == "debug.log" {
+			t.Error("the preset's exclude pattern was not applied")
+		}
+	}
+
+	// An explicit flag beats the preset
+	if err := runCreate([]string{"-preset", "release", "-exclude", "", filepath.Join(tmpDir, "all.zip"), sourceDir}); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := archiver.Analyze(filepath.Join(tmpDir, "all.zip")); info == nil || info.Type != models.ZIP || info.FileCount != 2 {
+		t.Errorf("--exclude '' and a .zip name should override the preset: %+v", info)
+	}
+
+	if err := runCreate([]string{"-preset", "nightly", output, sourceDir}); err == nil {
+		t.Error("Expected an error for an undefined preset")
+	}
+}
+
+func TestParseSourceMapping(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    models.SourceMapping
+		expectError bool
+	}{
+		{"src/=app/src", models.SourceMapping{Path: "src/", Prefix: "app/src"}, false},
+		{"README.md=app/README.md", models.SourceMapping{Path: "README.md", Prefix: "app/README.md"}, false},
+		{"LICENSE", models.SourceMapping{Path: "LICENSE"}, false},
+		{"=app", models.SourceMapping{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := parseSourceMapping(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("parseSourceMapping(%q) expected error, got nil", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSourceMapping(%q) failed: %v", tt.input, err)
+			}
+			if result != tt.expected {
+				t.Errorf("parseSourceMapping(%q) = %+v; want %+v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolvePassword(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	os.WriteFile(passwordFile, []byte("from-file\r\n"), 0600)
+
+	t.Setenv(passwordEnv, "from-env")
+
+	if got, err := resolvePassword(passwordFile); err != nil || got != "from-file" {
+		t.Errorf("resolvePassword(file) = %q, %v; want from-file", got, err)
+	}
+	if got, err := resolvePassword(""); err != nil || got != "from-env" {
+		t.Errorf("resolvePassword(\"\") = %q, %v; want from-env", got, err)
+	}
+	if _, err := resolvePassword(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("resolvePassword with a missing file should fail")
+	}
+
+	t.Setenv(passwordEnv, "")
+	if _, err := requirePassword(""); err == nil {
+		t.Error("requirePassword without any source should fail")
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	tests := []struct {
+		maxSize   string
+		maxFiles  int64
+		recursive bool
+		want      *models.ExtractLimits
+	}{
+		{"", 0, false, nil},
+		{"1K", 0, false, &models.ExtractLimits{MaxSize: 1024}},
+		{"", 0, true, &models.ExtractLimits{MaxSize: models.DefaultMaxExtractSize, MaxFiles: models.DefaultMaxExtractFiles}},
+		{"0", 5, true, &models.ExtractLimits{MaxFiles: 5}},
+	}
+	for _, tt := range tests {
+		got, err := extractLimits(tt.maxSize, tt.maxFiles, tt.recursive)
+		if err != nil {
+			t.Fatalf("extractLimits(%q, %d, %v) failed: %v", tt.maxSize, tt.maxFiles, tt.recursive, err)
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && (got.MaxSize != tt.want.MaxSize || got.MaxFiles != tt.want.MaxFiles) {
+			t.Errorf("extractLimits(%q, %d, %v) = %+v; want %+v", tt.maxSize, tt.maxFiles, tt.recursive, got, tt.want)
+		}
+	}
+}
+
+func TestRunGrep(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.Mkdir(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "app.log"), []byte("ok\nWARN low disk\n"), 0644)
+
+	zipPath := filepath.Join(tmpDir, "logs.zip")
+	if err := archiver.Compress(&models.CompressConfig{
+		SourcePath:  sourceDir,
+		OutputPath:  zipPath,
+		ArchiveType: models.ZIP,
+	}); err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	stdout = &buf
+	defer func() { stdout = os.Stdout }()
+
+	if err := runGrep([]string{"-i", "--entry", "*.log", "warn", zipPath}); err != nil {
+		t.Fatalf("runGrep failed: %v", err)
+	}
+	if want := zipPath + ":app.log:2:WARN low disk\n"; buf.String() != want {
+		t.Errorf("runGrep output = %q; want %q", buf.String(), want)
+	}
+
+	if err := runGrep([]string{"warn", zipPath}); err == nil {
+		t.Error("Expected an error when nothing matches, got nil")
+	}
+}
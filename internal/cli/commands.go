@@ -0,0 +1,554 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"zipprine/internal/archiver"
+	"zipprine/internal/models"
+	"zipprine/internal/settings"
+	"zipprine/pkg/fileutil"
+)
+
+// stdout is where commands write archive data; tests swap it for a buffer
+var stdout io.Writer = os.Stdout
+
+// commands maps positional subcommands such as "zipprine cat" to their handlers
+var commands = map[string]func(args []string) error{
+	"cat":           runCat,
+	"create":        runCreate,
+	"extract":       runExtract,
+	"add":           runAdd,
+	"update":        runUpdate,
+	"delete":        runDelete,
+	"convert":       runConvert,
+	"repair":        runRepair,
+	"grep":          runGrep,
+	"batch":         runBatch,
+	"serve":         runServe,
+	"serve-archive": runServeArchive,
+}
+
+// runCommand dispatches to a subcommand when the first argument names one.
+// It reports whether a subcommand was found.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	run, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+
+	if err := run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// runCat streams a single archive member to stdout
+func runCat(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: zipprine cat <archive> <entry>")
+	}
+
+	rc, err := archiver.OpenEntry(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(stdout, rc)
+	return err
+}
+
+// messageOut returns where status messages go, keeping stdout free when an
+// archive is being streamed through it
+func messageOut(path string) io.Writer {
+	if path == archiver.StdioPath {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// runCreate builds an archive; the output and source may each be "-" for
+// stdout and stdin respectively
+func runCreate(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	archiveType := fs.String("type", "", "Archive type (zip, tar, tar.gz, gzip); inferred from the output name when omitted")
+	level := fs.Int("level", 6, "Compression level (1=fast, 6=balanced, 9=best)")
+	threads := fs.Int("threads", 0, "Compression threads for zip, tar.gz and gzip (0=auto, 1=single-threaded)")
+	exclude := fs.String("exclude", "", "Comma-separated list of patterns to exclude")
+	include := fs.String("include", "", "Comma-separated list of patterns to include")
+	useGitignore := fs.Bool("gitignore", false, "Honour .gitignore files found in the source tree")
+	encrypt := fs.Bool("encrypt", false, "Encrypt ZIP entries with AES-256 (password from -password-file or $"+passwordEnv+")")
+	passwordFile := fs.String("password-file", "", "Read the archive password from this file")
+	splitSize := fs.String("split-size", "", "Split the archive into volumes of at most this size (e.g. 500M, 1G)")
+	reproducible := fs.Bool("reproducible", false, "Give entries fixed timestamps and no owner so identical inputs give identical archives")
+	preset := fs.String("preset", "", "Apply a named preset from the config files")
+	var sources sourceList
+	fs.Var(&sources, "add", "Add PATH[=PREFIX] to the archive (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || (fs.NArg() == 1 && len(sources) == 0) {
+		return fmt.Errorf("usage: zipprine create [options] <output|-> [source]")
+	}
+	output, source := fs.Arg(0), fs.Arg(1)
+	if source != "" && len(sources) > 0 {
+		sources = append(sourceList{{Path: source}}, sources...)
+	}
+
+	archType := models.ZIP
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if *archiveType != "" {
+		archType = parseArchiveType(*archiveType)
+	} else if detected, ok := archiver.DetectArchiveTypeByExtension(output); ok {
+		// The output name settles the type as firmly as --type would
+		archType = detected
+		set["type"] = true
+	}
+	if archType == models.RAR {
+		return fmt.Errorf("RAR compression is not supported (proprietary format)")
+	}
+
+	config := &models.CompressConfig{
+		SourcePath:       source,
+		Sources:          sources,
+		OutputPath:       output,
+		ArchiveType:      archType,
+		CompressionLevel: *level,
+		Threads:          *threads,
+		UseGitignore:     *useGitignore,
+		Reproducible:     *reproducible,
+	}
+	if err := applySettings(config, *preset, func(name string) bool { return set[name] }); err != nil {
+		return err
+	}
+	if *exclude != "" {
+		config.ExcludePaths = strings.Split(*exclude, ",")
+	}
+	if *include != "" {
+		config.IncludePaths = strings.Split(*include, ",")
+	}
+	if *encrypt {
+		password, err := requirePassword(*passwordFile)
+		if err != nil {
+			return err
+		}
+		config.Password = password
+	}
+	if *splitSize != "" {
+		size, err := fileutil.ParseBytes(*splitSize)
+		if err != nil {
+			return err
+		}
+		config.SplitSize = size
+	}
+
+	msg := messageOut(output)
+	fmt.Fprintf(msg, "📦 Compressing %s to %s (%s)...\n", sources.describe(source), output, config.ArchiveType)
+	if err := archiver.Compress(config); err != nil {
+		return err
+	}
+	fmt.Fprintln(msg, "✨ Compression completed successfully!")
+	return nil
+}
+
+// applySettings fills in what the config files, the chosen preset and the
+// environment say about a compression, leaving explicitly set flags alone
+func applySettings(config *models.CompressConfig, preset string, flagSet func(name string) bool) error {
+	cfg, err := settings.Load()
+	if err != nil {
+		return err
+	}
+	opts, err := cfg.Resolve(preset)
+	if err != nil {
+		return err
+	}
+	return opts.Apply(config, flagSet)
+}
+
+// runExtract extracts an archive into a directory; the archive may be "-"
+// to read it from stdin
+func runExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	archiveType := fs.String("type", "auto", "Archive type (zip, tar, tar.gz, gzip, rar, auto)")
+	overwrite := fs.Bool("overwrite", false, "Overwrite existing files during extraction")
+	preservePerms := fs.Bool("preserve-perms", true, "Preserve file permissions during extraction")
+	stripComponents := fs.Int("strip-components", 0, "Strip N leading path components from entry names")
+	prefix := fs.String("prefix", "", "Extract entries under this directory inside the destination")
+	threads := fs.Int("threads", 0, "ZIP extraction workers (0=auto, 1=serial)")
+	passwordFile := fs.String("password-file", "", "Read the archive password from this file")
+	recursive := fs.Bool("recursive", false, "Also extract archives found inside the archive, in place")
+	maxDepth := fs.Int("max-depth", 0, "Nesting levels expanded by --recursive (0=built-in maximum)")
+	keepNested := fs.Bool("keep-nested", false, "Keep nested archives after --recursive expands them")
+	maxSize := fs.String("max-size", "", "Stop extracting after writing this much in total (e.g. 10G; default 16G with --recursive, 0=unlimited)")
+	maxFiles := fs.Int64("max-files", 0, "Stop extracting after writing this many files in total (0=unlimited, or 1000000 with --recursive)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: zipprine extract [options] <archive|-> <dest>")
+	}
+	archivePath, dest := fs.Arg(0), fs.Arg(1)
+
+	password, err := resolvePassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	limits, err := extractLimits(*maxSize, *maxFiles, *recursive)
+	if err != nil {
+		return err
+	}
+
+	archType := parseArchiveType(*archiveType)
+	if archType == models.AUTO && archivePath != archiver.StdioPath {
+		detected, err := archiver.DetectArchiveType(archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to detect archive type: %w", err)
+		}
+		archType = detected
+	}
+
+	config := &models.ExtractConfig{
+		ArchivePath:     archivePath,
+		DestPath:        dest,
+		ArchiveType:     archType,
+		OverwriteAll:    *overwrite,
+		PreservePerms:   *preservePerms,
+		StripComponents: *stripComponents,
+		Prefix:          *prefix,
+		Threads:         *threads,
+		Password:        password,
+		Recursive:       *recursive,
+		MaxDepth:        *maxDepth,
+		KeepNested:      *keepNested,
+		Limits:          limits,
+	}
+
+	fmt.Printf("📂 Extracting %s to %s...\n", archivePath, dest)
+	if err := archiver.Extract(config); err != nil {
+		return err
+	}
+	fmt.Println("✨ Extraction completed successfully!")
+	return nil
+}
+
+// extractLimits builds the bomb limits from the --max-size and --max-files
+// flags, or returns nil when neither is set. A recursive extraction starts
+// from the default limits and the flags override them; --max-size 0 lifts
+// the size limit.
+func extractLimits(maxSize string, maxFiles int64, recursive bool) (*models.ExtractLimits, error) {
+	if maxSize == "" && maxFiles <= 0 && !recursive {
+		return nil, nil
+	}
+	limits := &models.ExtractLimits{MaxFiles: maxFiles}
+	if recursive {
+		limits.MaxSize = models.DefaultMaxExtractSize
+		if maxFiles <= 0 {
+			limits.MaxFiles = models.DefaultMaxExtractFiles
+		}
+	}
+	if maxSize != "" {
+		size, err := fileutil.ParseBytes(maxSize)
+		if err != nil {
+			return nil, err
+		}
+		limits.MaxSize = size
+	}
+	return limits, nil
+}
+
+// runConvert rewrites an archive in another format, streaming entries from
+// the source straight into the destination
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	archiveType := fs.String("type", "", "Destination type (zip, tar, tar.gz, gzip); inferred from the destination name when omitted")
+	level := fs.Int("level", 0, "Compression level (1=fast, 6=balanced, 9=best; 0=default)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: zipprine convert [options] <source> <dest>")
+	}
+	source, dest := fs.Arg(0), fs.Arg(1)
+
+	destType := models.AUTO
+	if *archiveType != "" {
+		destType = parseArchiveType(*archiveType)
+	}
+	if destType == models.RAR {
+		return fmt.Errorf("RAR compression is not supported (proprietary format)")
+	}
+
+	msg := messageOut(dest)
+	fmt.Fprintf(msg, "🔄 Converting %s to %s...\n", source, dest)
+	if err := archiver.Convert(&models.ConvertConfig{
+		SourcePath:       source,
+		DestPath:         dest,
+		SourceType:       models.AUTO,
+		DestType:         destType,
+		CompressionLevel: *level,
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintln(msg, "✨ Conversion completed successfully!")
+	return nil
+}
+
+// runRepair salvages a damaged archive into a new one, optionally extracts
+// it and reports what was recovered
+func runRepair(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	archiveType := fs.String("type", "", "Archive type (zip, tar, tar.gz, gzip); detected when omitted")
+	extractTo := fs.String("extract", "", "Extract the recovered entries into this directory")
+	overwrite := fs.Bool("overwrite", false, "Overwrite existing files when extracting")
+	passwordFile := fs.String("password-file", "", "Read the password for extracting encrypted entries from this file")
+	reportPath := fs.String("report", "", "Write the full repair report to this file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: zipprine repair [options] <damaged> <output>")
+	}
+	damaged, output := fs.Arg(0), fs.Arg(1)
+
+	config := &models.RepairConfig{
+		ArchivePath: damaged,
+		OutputPath:  output,
+		ArchiveType: models.AUTO,
+	}
+	if *archiveType != "" {
+		config.ArchiveType = parseArchiveType(*archiveType)
+	}
+	if *extractTo != "" {
+		password, err := resolvePassword(*passwordFile)
+		if err != nil {
+			return err
+		}
+		config.Extract = &models.ExtractConfig{
+			DestPath:     *extractTo,
+			OverwriteAll: *overwrite,
+			Password:     password,
+		}
+	}
+
+	fmt.Printf("🩹 Repairing %s into %s...\n", damaged, output)
+	report, err := archiver.Repair(config)
+	if report != nil {
+		fmt.Println(report.Summary)
+		if *reportPath != "" {
+			if writeErr := writeReport(*reportPath, report); writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(report.Damaged) > 0 {
+		fmt.Printf("⚠️  %d damaged entries could not be recovered\n", len(report.Damaged))
+	} else {
+		fmt.Println("✨ Repair completed successfully!")
+	}
+	return nil
+}
+
+func writeReport(path string, report *archiver.RepairReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := report.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// passwordEnv names the environment variable that can hold an archive password
+const passwordEnv = "ZIPPRINE_PASSWORD"
+
+// resolvePassword returns the archive password from a file when one is given,
+// otherwise from $ZIPPRINE_PASSWORD. There is deliberately no flag taking the
+// password itself, which would leak it into shell history and process lists.
+func resolvePassword(file string) (string, error) {
+	if file == "" {
+		return os.Getenv(passwordEnv), nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// requirePassword is resolvePassword for operations that cannot go without one
+func requirePassword(file string) (string, error) {
+	password, err := resolvePassword(file)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("encryption needs a password from --password-file or $%s", passwordEnv)
+	}
+	return password, nil
+}
+
+// sourceList collects repeated --add PATH[=PREFIX] flags
+type sourceList []models.SourceMapping
+
+func (s *sourceList) String() string {
+	return s.describe("")
+}
+
+func (s *sourceList) Set(value string) error {
+	mapping, err := parseSourceMapping(value)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, mapping)
+	return nil
+}
+
+// describe renders the sources for status messages, falling back to a
+// single plain source path
+func (s sourceList) describe(fallback string) string {
+	if len(s) == 0 {
+		return fallback
+	}
+	parts := make([]string, len(s))
+	for i, m := range s {
+		parts[i] = m.Path
+		if m.Prefix != "" {
+			parts[i] += "=" + m.Prefix
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseSourceMapping parses PATH[=PREFIX] as accepted by --add
+func parseSourceMapping(value string) (models.SourceMapping, error) {
+	path, prefix, _ := strings.Cut(value, "=")
+	if path == "" {
+		return models.SourceMapping{}, fmt.Errorf("invalid source %q: path cannot be empty", value)
+	}
+	return models.SourceMapping{Path: path, Prefix: prefix}, nil
+}
+
+// runAdd adds files to an existing archive, replacing same-named entries
+func runAdd(args []string) error {
+	return runModify("add", args, archiver.AddToArchive)
+}
+
+// runUpdate adds new files and replaces entries only when the file changed
+func runUpdate(args []string) error {
+	return runModify("update", args, archiver.UpdateArchive)
+}
+
+func runModify(name string, args []string, modify func(*models.UpdateConfig) error) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	archiveType := fs.String("type", "auto", "Archive type (zip, tar, tar.gz, auto)")
+	level := fs.Int("level", 6, "Compression level for new entries (1=fast, 6=balanced, 9=best)")
+	exclude := fs.String("exclude", "", "Comma-separated list of patterns to exclude")
+	include := fs.String("include", "", "Comma-separated list of patterns to include")
+	useGitignore := fs.Bool("gitignore", false, "Honour .gitignore files found in the source tree")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: zipprine %s [options] <archive> <path[=prefix]>...", name)
+	}
+
+	config := &models.UpdateConfig{
+		ArchivePath:      fs.Arg(0),
+		ArchiveType:      parseArchiveType(*archiveType),
+		CompressionLevel: *level,
+		UseGitignore:     *useGitignore,
+	}
+	for _, arg := range fs.Args()[1:] {
+		mapping, err := parseSourceMapping(arg)
+		if err != nil {
+			return err
+		}
+		config.Sources = append(config.Sources, mapping)
+	}
+	if *exclude != "" {
+		config.ExcludePaths = strings.Split(*exclude, ",")
+	}
+	if *include != "" {
+		config.IncludePaths = strings.Split(*include, ",")
+	}
+
+	fmt.Printf("📦 Updating %s...\n", config.ArchivePath)
+	if err := modify(config); err != nil {
+		return err
+	}
+	fmt.Println("✨ Archive updated successfully!")
+	return nil
+}
+
+// runDelete removes entries from an existing archive
+func runDelete(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	archiveType := fs.String("type", "auto", "Archive type (zip, tar, tar.gz, auto)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: zipprine delete [options] <archive> <entry>...")
+	}
+
+	fmt.Printf("🗑️  Deleting from %s...\n", fs.Arg(0))
+	if err := archiver.DeleteFromArchive(fs.Arg(0), parseArchiveType(*archiveType), fs.Args()[1:]); err != nil {
+		return err
+	}
+	fmt.Println("✨ Entries deleted successfully!")
+	return nil
+}
+
+// runGrep searches the contents of archives for a regular expression and
+// fails, like grep, when nothing matches
+func runGrep(args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	ignoreCase := fs.Bool("i", false, "Ignore case when matching")
+	entries := fs.String("entry", "", "Comma-separated globs selecting the entries to search (e.g. *.log,config/*)")
+	nested := fs.Bool("nested", false, "Also search archives found inside the archives")
+	binary := fs.Bool("binary", false, "Search entries that look binary instead of skipping them")
+	threads := fs.Int("threads", 0, "Archives searched at once (0=number of CPUs)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: zipprine grep [options] <pattern> <archive>...")
+	}
+
+	config := &models.GrepConfig{
+		Archives:   fs.Args()[1:],
+		Pattern:    fs.Arg(0),
+		IgnoreCase: *ignoreCase,
+		Nested:     *nested,
+		Binary:     *binary,
+		Threads:    *threads,
+	}
+	if *entries != "" {
+		config.Entries = strings.Split(*entries, ",")
+	}
+	matches, err := archiver.Grep(config, stdout)
+	if err != nil {
+		return err
+	}
+	if matches == 0 {
+		return fmt.Errorf("no matches for %q", config.Pattern)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"zipprine/internal/archiver"
+	"zipprine/internal/server"
+	"zipprine/pkg/fileutil"
+)
+
+// runServe runs the HTTP API until interrupted, then waits for running jobs
+// and removes the archives it kept
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", ":8080", "Address to listen on")
+	root := fs.String("root", ".", "Directory that server-side paths are confined to")
+	maxUpload := fs.String("max-upload", "1G", "Largest accepted upload (e.g. 500M, 1G)")
+	jobs := fs.Int("jobs", 0, "Jobs running at once (0=number of CPUs)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: zipprine serve [--listen ADDR] [--root DIR] [--max-upload SIZE] [--jobs N]")
+	}
+
+	limit, err := fileutil.ParseBytes(*maxUpload)
+	if err != nil {
+		return err
+	}
+	s, err := server.New(server.Config{Root: *root, MaxUpload: limit, MaxJobs: *jobs})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	fmt.Printf("🌐 Serving the zipprine API on %s (root %s)\n", *listen, s.Root())
+	if err := listenUntilInterrupted(*listen, s); err != nil {
+		return err
+	}
+	fmt.Println("👋 Shutting down after running jobs finish...")
+	return nil
+}
+
+// runServeArchive serves the files inside an archive for browsing, without
+// extracting it
+func runServeArchive(args []string) error {
+	fs := flag.NewFlagSet("serve-archive", flag.ContinueOnError)
+	listen := fs.String("listen", ":8000", "Address to listen on")
+	compress := fs.Bool("gzip", false, "Gzip-encode text responses for clients that accept it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// Flags may also follow the archive, as in "serve-archive docs.zip --listen :8000"
+	archivePath := fs.Arg(0)
+	if fs.NArg() > 1 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 {
+			archivePath = ""
+		}
+	}
+	if archivePath == "" {
+		return fmt.Errorf("usage: zipprine serve-archive [--listen ADDR] [--gzip] <archive>")
+	}
+
+	info, err := archiver.Analyze(archivePath)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("%s is not a supported archive", archivePath)
+	}
+	archiveFS, err := archiver.OpenFS(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archiveFS.(io.Closer).Close()
+
+	fmt.Printf("📦 Serving %s (%s, %d entries, %s) on %s\n", archivePath, info.Type, info.FileCount,
+		fileutil.FormatBytes(info.TotalSize), *listen)
+	return listenUntilInterrupted(*listen, server.NewArchiveHandler(archiveFS, *compress))
+}
+
+// listenUntilInterrupted serves HTTP until SIGINT or SIGTERM, then lets
+// requests in progress finish
+func listenUntilInterrupted(addr string, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+// Package manifest loads batch job files that describe archive operations
+// declaratively, so recurring work such as nightly packaging can live in
+// version control and be run with "zipprine batch run".
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"zipprine/internal/archiver"
+	"zipprine/internal/models"
+	"zipprine/pkg/fileutil"
+)
+
+// Operations a job can perform
+const (
+	OpCompress = "compress"
+	OpExtract  = "extract"
+	OpConvert  = "convert"
+	OpTest     = "test"
+)
+
+// Manifest is a list of jobs with shared variables and defaults
+type Manifest struct {
+	Vars     map[string]string `yaml:"vars" json:"vars"`
+	Defaults Options           `yaml:"defaults" json:"defaults"`
+	Jobs     []*Job            `yaml:"jobs" json:"jobs"`
+}
+
+// Job is one operation. Compress jobs take any number of sources written as
+// PATH[=PREFIX]; the other operations take a single archive.
+type Job struct {
+	Name    string   `yaml:"name" json:"name"`
+	Op      string   `yaml:"op" json:"op"`
+	Source  string   `yaml:"source" json:"source"`
+	Sources []string `yaml:"sources" json:"sources"`
+	Output  string   `yaml:"output" json:"output"`
+	Options `yaml:",inline"`
+}
+
+// Options are the settings a job can override. Unset fields fall back to the
+// manifest defaults and then to the usual zipprine defaults.
+type Options struct {
+	Format          string   `yaml:"format,omitempty" json:"format,omitempty"`
+	Level           *int     `yaml:"level,omitempty" json:"level,omitempty"`
+	Threads         *int     `yaml:"threads,omitempty" json:"threads,omitempty"`
+	Exclude         []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	Include         []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Gitignore       *bool    `yaml:"gitignore,omitempty" json:"gitignore,omitempty"`
+	Overwrite       *bool    `yaml:"overwrite,omitempty" json:"overwrite,omitempty"`
+	StripComponents *int     `yaml:"strip_components,omitempty" json:"strip_components,omitempty"`
+	Prefix          string   `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	SplitSize       string   `yaml:"split_size,omitempty" json:"split_size,omitempty"`
+	Reproducible    *bool    `yaml:"reproducible,omitempty" json:"reproducible,omitempty"`
+}
+
+// merge returns o with every unset field taken from defaults
+func (o Options) merge(defaults Options) Options {
+	if o.Format == "" {
+		o.Format = defaults.Format
+	}
+	if o.Level == nil {
+		o.Level = defaults.Level
+	}
+	if o.Threads == nil {
+		o.Threads = defaults.Threads
+	}
+	if o.Exclude == nil {
+		o.Exclude = defaults.Exclude
+	}
+	if o.Include == nil {
+		o.Include = defaults.Include
+	}
+	if o.Gitignore == nil {
+		o.Gitignore = defaults.Gitignore
+	}
+	if o.Overwrite == nil {
+		o.Overwrite = defaults.Overwrite
+	}
+	if o.StripComponents == nil {
+		o.StripComponents = defaults.StripComponents
+	}
+	if o.Prefix == "" {
+		o.Prefix = defaults.Prefix
+	}
+	if o.SplitSize == "" {
+		o.SplitSize = defaults.SplitSize
+	}
+	if o.Reproducible == nil {
+		o.Reproducible = defaults.Reproducible
+	}
+	return o
+}
+
+// Load reads a manifest from a YAML or JSON file (chosen by extension),
+// substitutes ${name} variables and checks every job. Variables come from
+// overrides first, then the manifest's vars, then the environment; ${date}
+// expands to today's date unless defined. Relative paths are resolved
+// against the manifest's directory.
+func Load(path string, overrides map[string]string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(m)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(m.Jobs) == 0 {
+		return nil, fmt.Errorf("%s defines no jobs", path)
+	}
+
+	vars := map[string]string{"date": time.Now().Format("2006-01-02")}
+	for k, v := range m.Vars {
+		vars[k] = v
+	}
+	for k, v := range overrides {
+		vars[k] = v
+	}
+
+	base := filepath.Dir(path)
+	for i, job := range m.Jobs {
+		if err := job.resolve(m.Defaults, vars, base); err != nil {
+			name := job.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("%s #%d", job.Op, i+1)
+		}
+	}
+	return m, nil
+}
+
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.-]*)\}`)
+
+// expand substitutes ${name} references, failing on unknown names
+func expand(s string, vars map[string]string) (string, error) {
+	var missing string
+	out := varPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := varPattern.FindStringSubmatch(ref)[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		if missing == "" {
+			missing = name
+		}
+		return ref
+	})
+	if missing != "" {
+		return "", fmt.Errorf("undefined variable ${%s}", missing)
+	}
+	return out, nil
+}
+
+// resolve applies the defaults and variables to a job, makes its paths
+// absolute and checks that it is complete
+func (j *Job) resolve(defaults Options, vars map[string]string, base string) error {
+	j.Options = j.Options.merge(defaults)
+	if j.Source != "" {
+		j.Sources = append([]string{j.Source}, j.Sources...)
+		j.Source = ""
+	}
+
+	fields := []*string{&j.Name, &j.Output, &j.Format, &j.Prefix, &j.SplitSize}
+	for i := range j.Sources {
+		fields = append(fields, &j.Sources[i])
+	}
+	// Copy the filter lists so substitutions never leak into the defaults
+	j.Exclude = append([]string(nil), j.Exclude...)
+	j.Include = append([]string(nil), j.Include...)
+	for i := range j.Exclude {
+		fields = append(fields, &j.Exclude[i])
+	}
+	for i := range j.Include {
+		fields = append(fields, &j.Include[i])
+	}
+	for _, field := range fields {
+		expanded, err := expand(*field, vars)
+		if err != nil {
+			return err
+		}
+		*field = expanded
+	}
+
+	switch j.Op {
+	case OpCompress:
+		if len(j.Sources) == 0 || j.Output == "" {
+			return fmt.Errorf("compress needs sources and an output")
+		}
+	case OpExtract, OpConvert:
+		if len(j.Sources) != 1 || j.Output == "" {
+			return fmt.Errorf("%s needs one source archive and an output", j.Op)
+		}
+	case OpTest:
+		if len(j.Sources) != 1 {
+			return fmt.Errorf("test needs one source archive")
+		}
+	case "":
+		return fmt.Errorf("missing op (compress, extract, convert or test)")
+	default:
+		return fmt.Errorf("unknown op %q (want compress, extract, convert or test)", j.Op)
+	}
+
+	if j.Format != "" {
+		if _, err := parseFormat(j.Format); err != nil {
+			return err
+		}
+	}
+	if j.SplitSize != "" {
+		if _, err := fileutil.ParseBytes(j.SplitSize); err != nil {
+			return err
+		}
+	}
+
+	for i, source := range j.Sources {
+		path, prefix, hasPrefix := strings.Cut(source, "=")
+		if j.Op != OpCompress {
+			path, hasPrefix = source, false
+		}
+		source = absPath(base, path)
+		if hasPrefix {
+			source += "=" + prefix
+		}
+		j.Sources[i] = source
+	}
+	if j.Output != "" {
+		j.Output = absPath(base, j.Output)
+	}
+	return nil
+}
+
+func absPath(base, path string) string {
+	if path == "" || path == archiver.StdioPath || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
+
+// parseFormat maps a format name to its archive type
+func parseFormat(format string) (models.ArchiveType, error) {
+	switch strings.ToLower(format) {
+	case "zip":
+		return models.ZIP, nil
+	case "tar":
+		return models.TAR, nil
+	case "tar.gz", "tgz", "targz":
+		return models.TARGZ, nil
+	case "gzip", "gz":
+		return models.GZIP, nil
+	case "rar":
+		return models.RAR, nil
+	case "auto":
+		return models.AUTO, nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+// archiveType is the job's format, or what its path suggests
+func (j *Job) archiveType(path string) models.ArchiveType {
+	if j.Format != "" {
+		archiveType, _ := parseFormat(j.Format)
+		return archiveType
+	}
+	if archiveType, ok := archiver.DetectArchiveTypeByExtension(path); ok {
+		return archiveType
+	}
+	return models.AUTO
+}
+
+// CompressConfig builds the compression a compress job describes
+func (j *Job) CompressConfig() (*models.CompressConfig, error) {
+	config := &models.CompressConfig{
+		OutputPath:       j.Output,
+		ArchiveType:      j.archiveType(j.Output),
+		ExcludePaths:     j.Exclude,
+		IncludePaths:     j.Include,
+		CompressionLevel: 6,
+	}
+	if config.ArchiveType == models.AUTO {
+		config.ArchiveType = models.ZIP
+	}
+	if config.ArchiveType == models.RAR {
+		return nil, fmt.Errorf("RAR compression is not supported (proprietary format)")
+	}
+	for _, source := range j.Sources {
+		path, prefix, _ := strings.Cut(source, "=")
+		config.Sources = append(config.Sources, models.SourceMapping{Path: path, Prefix: prefix})
+	}
+	if j.Level != nil {
+		config.CompressionLevel = *j.Level
+	}
+	if j.Threads != nil {
+		config.Threads = *j.Threads
+	}
+	if j.Gitignore != nil {
+		config.UseGitignore = *j.Gitignore
+	}
+	if j.Reproducible != nil {
+		config.Reproducible = *j.Reproducible
+	}
+	if j.SplitSize != "" {
+		size, err := fileutil.ParseBytes(j.SplitSize)
+		if err != nil {
+			return nil, err
+		}
+		config.SplitSize = size
+	}
+	return config, nil
+}
+
+// ExtractConfig builds the extraction an extract job describes
+func (j *Job) ExtractConfig() (*models.ExtractConfig, error) {
+	config := &models.ExtractConfig{
+		ArchivePath:   j.Sources[0],
+		DestPath:      j.Output,
+		ArchiveType:   j.archiveType(j.Sources[0]),
+		PreservePerms: true,
+		Prefix:        j.Prefix,
+	}
+	if config.ArchiveType == models.AUTO {
+		detected, err := archiver.DetectArchiveType(config.ArchivePath)
+		if err != nil {
+			return nil, err
+		}
+		config.ArchiveType = detected
+	}
+	if j.Overwrite != nil {
+		config.OverwriteAll = *j.Overwrite
+	}
+	if j.StripComponents != nil {
+		config.StripComponents = *j.StripComponents
+	}
+	if j.Threads != nil {
+		config.Threads = *j.Threads
+	}
+	return config, nil
+}
+
+// ConvertConfig builds the conversion a convert job describes; its format is
+// the destination format
+func (j *Job) ConvertConfig() *models.ConvertConfig {
+	config := &models.ConvertConfig{
+		SourcePath: j.Sources[0],
+		DestPath:   j.Output,
+		SourceType: models.AUTO,
+		DestType:   j.archiveType(j.Output),
+	}
+	if j.Level != nil {
+		config.CompressionLevel = *j.Level
+	}
+	return config
+}
@@ -0,0 +1,221 @@
+package manifest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zipprine/internal/models"
+)
+
+func writeManifest(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("ZIPPRINE_TEST_CHANNEL", "nightly")
+
+	path := writeManifest(t, tmpDir, "jobs.yaml", `
+vars:
+  version: "1.0.0"
+  out: dist
+defaults:
+  format: tar.gz
+  level: 9
+  exclude: ["*.log"]
+jobs:
+  - name: app-${version}
+    op: compress
+    sources: [src, README.md=app/README.md]
+    output: ${out}/app-${version}-${ZIPPRINE_TEST_CHANNEL}.tar.gz
+  - op: compress
+    source: docs
+    output: ${out}/docs-${date}.zip
+    format: zip
+    level: 1
+    exclude: []
+  - op: extract
+    source: /srv/vendor.zip
+    output: build/vendor
+    strip_components: 1
+`)
+
+	m, err := Load(path, map[string]string{"version": "2.0.0"})
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(m.Jobs) != 3 {
+		t.Fatalf("Load() returned %d jobs, want 3", len(m.Jobs))
+	}
+
+	app := m.Jobs[0]
+	if app.Name != "app-2.0.0" {
+		t.Errorf("overridden variable not applied: name = %q", app.Name)
+	}
+	if want := filepath.Join(tmpDir, "dist", "app-2.0.0-nightly.tar.gz"); app.Output != want {
+		t.Errorf("Output = %q, want %q", app.Output, want)
+	}
+	wantSources := []string{filepath.Join(tmpDir, "src"), filepath.Join(tmpDir, "README.md") + "=app/README.md"}
+	if strings.Join(app.Sources, "|") != strings.Join(wantSources, "|") {
+		t.Errorf("Sources = %v, want %v", app.Sources, wantSources)
+	}
+	config, err := app.CompressConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ArchiveType != models.TARGZ || config.CompressionLevel != 9 || len(config.ExcludePaths) != 1 {
+		t.Errorf("defaults not applied: %+v", config)
+	}
+	if len(config.Sources) != 2 || config.Sources[1].Prefix != "app/README.md" {
+		t.Errorf("Sources = %+v", config.Sources)
+	}
+
+	docs := m.Jobs[1]
+	if docs.Name != "compress #2" {
+		t.Errorf("default name = %q, want %q", docs.Name, "compress #2")
+	}
+	if !strings.HasSuffix(docs.Output, "docs-"+time.Now().Format("2006-01-02")+".zip") {
+		t.Errorf("${date} not expanded: %q", docs.Output)
+	}
+	config, _ = docs.CompressConfig()
+	if config.ArchiveType != models.ZIP || config.CompressionLevel != 1 || len(config.ExcludePaths) != 0 {
+		t.Errorf("job overrides not applied: %+v", config)
+	}
+
+	extract := m.Jobs[2]
+	if extract.Sources[0] != "/srv/vendor.zip" || *extract.StripComponents != 1 {
+		t.Errorf("extract job = %+v", extract)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := writeManifest(t, tmpDir, "jobs.json", `{
+  "vars": {"name": "site"},
+  "jobs": [{"op": "convert", "source": "${name}.tar.gz", "output": "${name}.zip"}]
+}`)
+
+	m, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	config := m.Jobs[0].ConvertConfig()
+	if config.SourcePath != filepath.Join(tmpDir, "site.tar.gz") || config.DestType != models.ZIP {
+		t.Errorf("ConvertConfig() = %+v", config)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown_op", "jobs:\n  - op: shred\n    source: a.zip\n", "unknown op"},
+		{"missing_output", "jobs:\n  - op: extract\n    source: a.zip\n", "needs one source archive and an output"},
+		{"undefined_variable", "jobs:\n  - op: test\n    source: ${nope}.zip\n", "undefined variable ${nope}"},
+		{"unknown_field", "jobs:\n  - op: test\n    source: a.zip\n    levle: 3\n", "levle"},
+		{"unknown_format", "jobs:\n  - op: compress\n    source: a\n    output: a.7z\n    format: 7z\n", "unknown format"},
+		{"no_jobs", "vars:\n  a: b\n", "defines no jobs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeManifest(t, t.TempDir(), "jobs.yaml", tt.content)
+			_, err := Load(path, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "site", "css"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "site", "index.html"), []byte("<h1>Hello</h1>"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "site", "css", "main.css"), []byte("h1 {}"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "site", "debug.log"), []byte("noise"), 0644)
+
+	path := writeManifest(t, tmpDir, "jobs.yaml", `
+vars:
+  out: dist
+jobs:
+  - name: package
+    op: compress
+    source: site
+    output: ${out}/site.tar.gz
+    exclude: ["*.log"]
+  - name: verify
+    op: test
+    source: ${out}/site.tar.gz
+  - name: zip
+    op: convert
+    source: ${out}/site.tar.gz
+    output: ${out}/site.zip
+  - name: missing
+    op: extract
+    source: nowhere.zip
+    output: unpacked
+  - name: unpack
+    op: extract
+    source: ${out}/site.zip
+    output: unpacked
+`)
+	os.Mkdir(filepath.Join(tmpDir, "dist"), 0755)
+
+	m, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run only describes the jobs
+	var out bytes.Buffer
+	report := Run(m, true, &out)
+	if report.Failed() != 0 || !strings.Contains(out.String(), "package: compress") {
+		t.Errorf("dry run output:\n%s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "dist", "site.tar.gz")); err == nil {
+		t.Fatal("a dry run must not create archives")
+	}
+
+	out.Reset()
+	report = Run(m, false, &out)
+	statuses := map[string]string{}
+	for _, result := range report.Results {
+		statuses[result.Name] = result.Status
+	}
+	want := map[string]string{"package": StatusOK, "verify": StatusOK, "zip": StatusOK, "missing": StatusFailed, "unpack": StatusOK}
+	for name, status := range want {
+		if statuses[name] != status {
+			t.Errorf("job %s: status %q, want %q", name, statuses[name], status)
+		}
+	}
+	if report.Results[1].Files != 2 {
+		t.Errorf("test job checked %d files, want 2", report.Results[1].Files)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "unpacked", "index.html")); string(data) != "<h1>Hello</h1>" {
+		t.Errorf("unpacked index.html = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "unpacked", "debug.log")); err == nil {
+		t.Error("excluded debug.log was packaged")
+	}
+
+	var summary bytes.Buffer
+	report.WriteSummary(&summary)
+	for _, line := range []string{"✓ package (compress", "✗ missing (extract)", "4/5 jobs succeeded"} {
+		if !strings.Contains(summary.String(), line) {
+			t.Errorf("summary is missing %q:\n%s", line, summary.String())
+		}
+	}
+}
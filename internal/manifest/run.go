@@ -0,0 +1,183 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"zipprine/internal/archiver"
+	"zipprine/pkg/fileutil"
+)
+
+// Job outcomes
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusPlanned = "planned"
+)
+
+// Result is the outcome of one job
+type Result struct {
+	Name     string        `json:"name"`
+	Op       string        `json:"op"`
+	Output   string        `json:"output,omitempty"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Bytes    int64         `json:"bytes,omitempty"` // size of the archive written or tested
+	Files    int           `json:"files,omitempty"` // files checked by a test job
+}
+
+// Report collects the results of a manifest run, in job order
+type Report struct {
+	Results  []*Result     `json:"results"`
+	Duration time.Duration `json:"duration_ns"`
+	DryRun   bool          `json:"dry_run"`
+}
+
+// Failed returns the number of jobs that failed
+func (r *Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if result.Status == StatusFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// WriteSummary prints one line per job followed by the totals
+func (r *Report) WriteSummary(w io.Writer) {
+	fmt.Fprintln(w, "📋 Batch Summary:")
+	for _, result := range r.Results {
+		switch result.Status {
+		case StatusOK:
+			line := fmt.Sprintf("  ✓ %s (%s, %s)", result.Name, result.Op, result.Duration.Round(time.Millisecond))
+			if result.Files > 0 {
+				line += fmt.Sprintf(" - %d files verified", result.Files)
+			} else if result.Bytes > 0 {
+				line += fmt.Sprintf(" - %s", fileutil.FormatBytes(result.Bytes))
+			}
+			fmt.Fprintln(w, line)
+		case StatusFailed:
+			fmt.Fprintf(w, "  ✗ %s (%s): %s\n", result.Name, result.Op, result.Error)
+		default:
+			fmt.Fprintf(w, "  • %s\n", result.Name)
+		}
+	}
+	if r.DryRun {
+		fmt.Fprintf(w, "  %d jobs planned (dry run)\n", len(r.Results))
+		return
+	}
+	fmt.Fprintf(w, "  %d/%d jobs succeeded in %s\n", len(r.Results)-r.Failed(), len(r.Results), r.Duration.Round(time.Millisecond))
+}
+
+// Run executes the jobs in order. A failed job is recorded and the run moves
+// on to the next one. With dryRun set, nothing is touched and each job's
+// plan is written to out instead.
+func Run(m *Manifest, dryRun bool, out io.Writer) *Report {
+	report := &Report{DryRun: dryRun}
+	start := time.Now()
+	for i, job := range m.Jobs {
+		result := &Result{Name: job.Name, Op: job.Op, Output: job.Output}
+		report.Results = append(report.Results, result)
+
+		if dryRun {
+			result.Status = StatusPlanned
+			fmt.Fprintf(out, "[%d/%d] %s\n", i+1, len(m.Jobs), job.Describe())
+			continue
+		}
+
+		fmt.Fprintf(out, "▶ [%d/%d] %s: %s\n", i+1, len(m.Jobs), job.Name, job.Op)
+		jobStart := time.Now()
+		err := runJob(job, result)
+		result.Duration = time.Since(jobStart)
+		if err != nil {
+			result.Status = StatusFailed
+			result.Error = err.Error()
+		} else {
+			result.Status = StatusOK
+		}
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+func runJob(job *Job, result *Result) error {
+	switch job.Op {
+	case OpCompress:
+		config, err := job.CompressConfig()
+		if err != nil {
+			return err
+		}
+		if err := archiver.Compress(config); err != nil {
+			return err
+		}
+	case OpExtract:
+		config, err := job.ExtractConfig()
+		if err != nil {
+			return err
+		}
+		return archiver.Extract(config)
+	case OpConvert:
+		if err := archiver.Convert(job.ConvertConfig()); err != nil {
+			return err
+		}
+	case OpTest:
+		files, err := archiver.VerifyArchive(job.Sources[0], job.archiveType(job.Sources[0]))
+		if err != nil {
+			return err
+		}
+		result.Files = files
+		if stat, err := os.Stat(job.Sources[0]); err == nil {
+			result.Bytes = stat.Size()
+		}
+		return nil
+	}
+
+	if stat, err := os.Stat(job.Output); err == nil {
+		result.Bytes = stat.Size()
+	}
+	return nil
+}
+
+// Describe summarises what a job will do, for dry runs
+func (j *Job) Describe() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s %s", j.Name, j.Op, strings.Join(j.Sources, ", "))
+	if j.Output != "" {
+		fmt.Fprintf(&b, " → %s", j.Output)
+	}
+
+	var details []string
+	switch j.Op {
+	case OpCompress:
+		if config, err := j.CompressConfig(); err == nil {
+			details = append(details, string(config.ArchiveType), fmt.Sprintf("level %d", config.CompressionLevel))
+			if config.SplitSize > 0 {
+				details = append(details, "split "+fileutil.FormatBytes(config.SplitSize))
+			}
+		}
+		if len(j.Exclude) > 0 {
+			details = append(details, "exclude "+strings.Join(j.Exclude, ","))
+		}
+		if len(j.Include) > 0 {
+			details = append(details, "include "+strings.Join(j.Include, ","))
+		}
+	case OpConvert:
+		details = append(details, string(j.archiveType(j.Output)))
+	case OpExtract:
+		if j.Overwrite != nil && *j.Overwrite {
+			details = append(details, "overwrite")
+		}
+		if j.StripComponents != nil && *j.StripComponents > 0 {
+			details = append(details, fmt.Sprintf("strip %d", *j.StripComponents))
+		}
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+	}
+	return b.String()
+}
@@ -1,47 +1,213 @@
 package models
 
+import (
+	"io"
+	"sync/atomic"
+)
+
 type ArchiveType string
 
 const (
-	ZIP    ArchiveType = "ZIP"
-	TARGZ  ArchiveType = "TAR.GZ"
-	TAR    ArchiveType = "TAR"
-	GZIP   ArchiveType = "GZIP"
-	RAR    ArchiveType = "RAR"
-	AUTO   ArchiveType = "AUTO"
+	ZIP   ArchiveType = "ZIP"
+	TARGZ ArchiveType = "TAR.GZ"
+	TAR   ArchiveType = "TAR"
+	GZIP  ArchiveType = "GZIP"
+	RAR   ArchiveType = "RAR"
+	AUTO  ArchiveType = "AUTO"
 )
 
 type CompressConfig struct {
-	SourcePath      string
-	OutputPath      string
-	ArchiveType     ArchiveType
-	ExcludePaths    []string
-	IncludePaths    []string
-	VerifyIntegrity bool
+	SourcePath       string
+	Sources          []SourceMapping
+	OutputPath       string
+	ArchiveType      ArchiveType
+	ExcludePaths     []string
+	IncludePaths     []string
+	UseGitignore     bool
+	VerifyIntegrity  bool
 	CompressionLevel int
+	Threads          int       // compression worker goroutines; 0 picks automatically, 1 disables parallel compression
+	Password         string    // encrypts ZIP entries with AES-256 when set
+	SplitSize        int64     // maximum volume size in bytes; 0 writes a single file
+	Progress         io.Writer // receives per-file progress lines; nil prints them to stdout
+	Reproducible     bool      // fixed timestamps and no owners, so identical inputs give identical bytes
 }
 
 type ExtractConfig struct {
-	ArchivePath   string
-	DestPath      string
-	ArchiveType   ArchiveType
-	OverwriteAll  bool
-	PreservePerms bool
+	ArchivePath     string
+	DestPath        string
+	ArchiveType     ArchiveType
+	OverwriteAll    bool
+	PreservePerms   bool
+	StripComponents int
+	Prefix          string
+	Threads         int       // ZIP extraction workers; 0 uses every CPU, 1 extracts serially
+	Password        string    // decrypts encrypted entries
+	Progress        io.Writer // receives per-file progress lines; nil prints them to stdout
+	Recursive       bool      // also expand archives found among the extracted files, in place
+	MaxDepth        int       // nesting levels expanded when Recursive; 0 uses the built-in maximum
+	KeepNested      bool      // keep nested archives next to their expanded contents
+	Limits          *ExtractLimits
+}
+
+// ExtractLimits guards extraction against archive bombs. Everything
+// extracted with the same ExtractLimits counts towards one total, so a
+// recursive extraction is bounded as a whole, not per nested archive.
+type ExtractLimits struct {
+	MaxSize  int64 // bytes written in total; 0 is unlimited
+	MaxFiles int64 // files written in total; 0 is unlimited
+
+	size  atomic.Int64
+	files atomic.Int64
+}
+
+// Limits a recursive extraction gets when it sets none of its own
+const (
+	DefaultMaxExtractSize  int64 = 16 << 30
+	DefaultMaxExtractFiles int64 = 1_000_000
+)
+
+// AddFile counts a file about to be written and reports whether it is within
+// MaxFiles. A nil ExtractLimits allows everything.
+func (l *ExtractLimits) AddFile() bool {
+	if l == nil {
+		return true
+	}
+	return l.files.Add(1) <= l.MaxFiles || l.MaxFiles <= 0
+}
+
+// AddSize counts n written bytes and reports whether the total is within MaxSize
+func (l *ExtractLimits) AddSize(n int64) bool {
+	if l == nil {
+		return true
+	}
+	return l.size.Add(n) <= l.MaxSize || l.MaxSize <= 0
+}
+
+// Exceeded reports whether either limit has been passed
+func (l *ExtractLimits) Exceeded() bool {
+	if l == nil {
+		return false
+	}
+	return (l.MaxSize > 0 && l.size.Load() > l.MaxSize) || (l.MaxFiles > 0 && l.files.Load() > l.MaxFiles)
+}
+
+// UpdateConfig describes files to add to an existing archive. With
+// OnlyNewer set, existing entries are replaced only when the source file is
+// newer or different.
+type UpdateConfig struct {
+	ArchivePath      string
+	ArchiveType      ArchiveType
+	Sources          []SourceMapping
+	ExcludePaths     []string
+	IncludePaths     []string
+	UseGitignore     bool
+	CompressionLevel int
+	OnlyNewer        bool
+}
+
+// ConvertConfig describes an archive format conversion. A SourceType of AUTO
+// (or empty) is detected from the source; CompressionLevel 0 uses the default.
+type ConvertConfig struct {
+	SourcePath       string
+	DestPath         string
+	SourceType       ArchiveType
+	DestType         ArchiveType
+	CompressionLevel int
+	Progress         io.Writer // receives per-entry progress lines; nil prints them to stdout
+}
+
+// GrepConfig describes a search of archive contents for lines matching
+// Pattern, a regular expression
+type GrepConfig struct {
+	Archives   []string
+	Pattern    string
+	IgnoreCase bool
+	Entries    []string // globs matched against entry paths or base names; empty searches every entry
+	Nested     bool     // also search archives found inside the archives
+	Binary     bool     // search entries that look binary instead of skipping them
+	Threads    int      // archives searched at once; 0 uses every CPU
+}
+
+// RepairConfig describes salvaging a damaged archive into a new one at
+// OutputPath. An ArchiveType of AUTO (or empty) is detected from the damaged
+// archive. When Extract is set, the repaired archive is extracted with it;
+// its ArchivePath and ArchiveType are filled in.
+type RepairConfig struct {
+	ArchivePath string
+	OutputPath  string
+	ArchiveType ArchiveType
+	Extract     *ExtractConfig
+}
+
+// SourceMapping places a file or directory in an archive under Prefix.
+// With an empty prefix a directory's contents go at the archive root and a
+// file keeps its base name; otherwise a directory's contents go under Prefix
+// and a file is stored as Prefix itself.
+type SourceMapping struct {
+	Path   string
+	Prefix string
 }
 
 type ArchiveInfo struct {
-	Type            ArchiveType
-	FileCount       int
-	TotalSize       int64
-	CompressedSize  int64
-	CompressionRatio float64
-	Files           []FileInfo
-	Checksum        string
+	Type             ArchiveType `json:"type"`
+	FileCount        int         `json:"file_count"`
+	TotalSize        int64       `json:"total_size"`
+	CompressedSize   int64       `json:"compressed_size"`
+	CompressionRatio float64     `json:"compression_ratio"`
+	Files            []FileInfo  `json:"files"`
+	Checksum         string      `json:"checksum,omitempty"`
+	Encryption       string      `json:"encryption,omitempty"` // e.g. "AES-256" or "ZipCrypto"; empty when not encrypted
+	Volumes          []string    `json:"volumes,omitempty"`    // volume files of a multi-volume archive, in order
+
+	// Nested holds the analysis of archives stored inside this one, by entry
+	// name; it is only filled by AnalyzeNested
+	Nested map[string]*ArchiveInfo `json:"nested,omitempty"`
+
+	// Report breaks the contents down to show what takes up the space.
+	// Analysis leaves it nil, as it reads every entry; archiver.AnalyzeReport
+	// builds it where it is shown.
+	Report *AnalysisReport `json:"report,omitempty"`
+}
+
+// AnalysisReport covers every file in an archive, while ArchiveInfo.Files
+// only lists the first ones
+type AnalysisReport struct {
+	Extensions  []GroupStats `json:"extensions"`  // by lower-case extension, largest first
+	Directories []GroupStats `json:"directories"` // by top-level directory, largest first
+	Largest     []FileInfo   `json:"largest"`
+	// WorstCompressed ranks files by compression ratio, lowest first. Only
+	// formats storing per-entry sizes (ZIP) have one.
+	WorstCompressed []FileInfo       `json:"worst_compressed,omitempty"`
+	Duplicates      []DuplicateGroup `json:"duplicates,omitempty"` // most wasted space first
+	// Partial is set when the archive was too big to read in full, so the
+	// report only covers part of it
+	Partial bool `json:"partial,omitempty"`
+}
+
+// GroupStats totals the files of one extension or directory
+type GroupStats struct {
+	Name           string `json:"name"`
+	Files          int    `json:"files"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size,omitempty"`
+}
+
+// DuplicateGroup lists entries with identical contents
+type DuplicateGroup struct {
+	SHA256 string   `json:"sha256"`
+	Size   int64    `json:"size"` // of each copy
+	Names  []string `json:"names"`
 }
 
 type FileInfo struct {
-	Name string
-	Size int64
-	IsDir bool
-	ModTime string
-}
\ No newline at end of file
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	IsDir     bool   `json:"is_dir"`
+	ModTime   string `json:"mod_time"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+
+	// Set for formats that record each entry's stored size (ZIP)
+	CompressedSize   int64   `json:"compressed_size,omitempty"`
+	CompressionRatio float64 `json:"compression_ratio,omitempty"` // percent saved, as in ArchiveInfo
+}
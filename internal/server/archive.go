@@ -0,0 +1,98 @@
+package server
+
+import (
+	"compress/gzip"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// NewArchiveHandler serves the files of an archive opened with
+// archiver.OpenFS: directory listings, index.html pages, MIME types and
+// Range requests come from http.FileServer. With compress set, text
+// responses are gzip-encoded for clients that accept it.
+func NewArchiveHandler(fsys fs.FS, compress bool) http.Handler {
+	handler := http.FileServerFS(fsys)
+	if !compress {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		handler.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(coding) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// compressible reports whether a content type is worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "image/svg+xml", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses full responses of compressible types. The
+// choice is made when the headers are written; partial content from Range
+// requests is passed through, since its ranges refer to the plain bytes.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if !w.decided {
+		w.decided = true
+		h := w.Header()
+		h.Add("Vary", "Accept-Encoding")
+		if status == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			h.Set("Content-Encoding", "gzip")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Close flushes the compressed stream
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
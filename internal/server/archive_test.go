@@ -0,0 +1,107 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zipprine/internal/archiver"
+	"zipprine/internal/models"
+)
+
+func TestArchiveHandler(t *testing.T) {
+	source := t.TempDir()
+	page := "<html><body>" + strings.Repeat("documentation ", 200) + "</body></html>"
+	os.MkdirAll(filepath.Join(source, "guide", "img"), 0755)
+	os.WriteFile(filepath.Join(source, "guide", "index.html"), []byte(page), 0644)
+	os.WriteFile(filepath.Join(source, "guide", "style.css"), []byte("body { margin: 0 }"), 0644)
+	os.WriteFile(filepath.Join(source, "guide", "img", "logo.png"), []byte("\x89PNG\r\n\x1a\nnot really"), 0644)
+
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		t.Run(ext, func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), "docs"+ext)
+			archiveType, _ := archiver.DetectArchiveTypeByExtension(archivePath)
+			if err := archiver.Compress(&models.CompressConfig{
+				SourcePath:  source,
+				OutputPath:  archivePath,
+				ArchiveType: archiveType,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			fsys, err := archiver.OpenFS(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fsys.(io.Closer).Close()
+
+			ts := httptest.NewServer(NewArchiveHandler(fsys, true))
+			defer ts.Close()
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			get := func(path string, header map[string]string) (*http.Response, string) {
+				t.Helper()
+				req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+				for k, v := range header {
+					req.Header.Set(k, v)
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				return resp, string(body)
+			}
+
+			// Directory listing
+			resp, body := get("/guide/img/", nil)
+			if resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="logo.png"`) {
+				t.Errorf("listing: %d %q", resp.StatusCode, body)
+			}
+
+			// index.html stands in for its directory
+			resp, body = get("/guide/", nil)
+			if body != page || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+				t.Errorf("index: %q, %d bytes", resp.Header.Get("Content-Type"), len(body))
+			}
+
+			resp, _ = get("/guide/style.css", nil)
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+				t.Errorf("style.css Content-Type = %q", ct)
+			}
+
+			// Ranges are served as plain bytes even when gzip is accepted
+			resp, body = get("/guide/index.html", map[string]string{"Range": "bytes=6-11", "Accept-Encoding": "gzip"})
+			if resp.StatusCode != http.StatusPartialContent || body != "<body>" || resp.Header.Get("Content-Encoding") != "" {
+				t.Errorf("range: %d %q %q", resp.StatusCode, body, resp.Header.Get("Content-Encoding"))
+			}
+
+			resp, body = get("/guide/index.html", map[string]string{"Accept-Encoding": "gzip, deflate"})
+			if resp.Header.Get("Content-Encoding") != "gzip" {
+				t.Fatalf("index.html was not compressed: %v", resp.Header)
+			}
+			gz, err := gzip.NewReader(strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain, _ := io.ReadAll(gz); string(plain) != page || len(body) >= len(page) {
+				t.Errorf("gzip body: %d bytes compressed, %d plain", len(body), len(plain))
+			}
+
+			// Images are already compressed
+			resp, _ = get("/guide/img/logo.png", map[string]string{"Accept-Encoding": "gzip"})
+			if resp.Header.Get("Content-Type") != "image/png" || resp.Header.Get("Content-Encoding") != "" {
+				t.Errorf("logo.png: %v", resp.Header)
+			}
+
+			resp, _ = get("/guide/missing.html", nil)
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("missing file: %d", resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zipprine/internal/archiver"
+	"zipprine/internal/models"
+	"zipprine/internal/settings"
+)
+
+// JobStatus is the state of a job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// JobInfo is a job as reported by the API
+type JobInfo struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Status   JobStatus `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Archive  string    `json:"archive,omitempty"` // ID of the stored archive the job produced
+	Output   string    `json:"output,omitempty"`  // root-relative path written instead of the store
+	Progress int       `json:"progress"`          // progress lines reported so far
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+func (i *JobInfo) done() bool {
+	return i.Status == JobSucceeded || i.Status == JobFailed
+}
+
+// job tracks a background operation and the progress lines it reports.
+// Watchers wait on changed, which is closed and replaced on every update.
+type job struct {
+	mu      sync.Mutex
+	info    JobInfo
+	lines   []string
+	partial []byte
+	changed chan struct{}
+}
+
+// Write collects progress output line by line
+func (j *job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.partial = append(j.partial, p...)
+	for {
+		i := bytes.IndexByte(j.partial, '\n')
+		if i < 0 {
+			break
+		}
+		j.addLineLocked(string(j.partial[:i]))
+		j.partial = j.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (j *job) addLineLocked(line string) {
+	if line = strings.TrimSpace(line); line != "" {
+		j.lines = append(j.lines, line)
+		j.info.Progress = len(j.lines)
+		j.notifyLocked()
+	}
+}
+
+func (j *job) notifyLocked() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *job) update(fn func(info *JobInfo)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.info)
+	j.notifyLocked()
+}
+
+// status returns the job as reported by the API
+func (j *job) status() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+// snapshot returns the job's status, its progress lines from index from on
+// and a channel that is closed on the next change
+func (j *job) snapshot(from int) (JobInfo, []string, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var lines []string
+	if from < len(j.lines) {
+		lines = append(lines, j.lines[from:]...)
+	}
+	return j.info, lines, j.changed
+}
+
+// startJob registers a job and runs it in the background once one of the
+// server's slots is free. run writes an archive to dest; without an output
+// path under the root, dest is in the store and the archive is kept there
+// under a new ID named name.
+func (s *Server) startJob(w http.ResponseWriter, kind, output, name string, run func(dest string, progress io.Writer) error) error {
+	j := &job{
+		info:    JobInfo{ID: newID(), Kind: kind, Status: JobQueued, Created: time.Now()},
+		changed: make(chan struct{}),
+	}
+
+	var dest, archiveID string
+	var err error
+	if output != "" {
+		if dest, err = s.resolve(output); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		j.info.Output = output
+	} else {
+		archiveID = newID()
+		if dest, err = s.storePath(archiveID, name); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.jobs[j.info.ID] = j
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.slots <- struct{}{}
+		defer func() { <-s.slots }()
+
+		j.update(func(info *JobInfo) {
+			info.Status = JobRunning
+			info.Started = time.Now()
+		})
+
+		err := run(dest, j)
+		if err == nil && archiveID != "" {
+			_, err = s.addArchive(archiveID, dest)
+		}
+		if err != nil && archiveID != "" {
+			os.RemoveAll(filepath.Dir(dest))
+		}
+
+		j.mu.Lock()
+		if len(j.partial) > 0 {
+			j.addLineLocked(string(j.partial))
+			j.partial = nil
+		}
+		j.info.Finished = time.Now()
+		j.info.Status = JobSucceeded
+		if err != nil {
+			j.info.Status = JobFailed
+			j.info.Error = err.Error()
+		} else {
+			j.info.Archive = archiveID
+		}
+		j.notifyLocked()
+		j.mu.Unlock()
+	}()
+
+	w.Header().Set("Location", "/api/jobs/"+j.info.ID)
+	return writeJSON(w, http.StatusAccepted, j.status())
+}
+
+// convertRequest converts a stored archive, or one under the root, into
+// another format
+type convertRequest struct {
+	Archive string `json:"archive"` // ID of a stored archive
+	Path    string `json:"path"`    // root-relative path of a server-side archive
+	Type    string `json:"type"`    // destination type; inferred from Output when empty
+	Level   int    `json:"level"`
+	Output  string `json:"output"` // root-relative destination; the store when empty
+}
+
+func (s *Server) startConvert(w http.ResponseWriter, r *http.Request) error {
+	var req convertRequest
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+
+	var source, sourceName string
+	switch {
+	case req.Archive != "" && req.Path != "":
+		return errorf(http.StatusBadRequest, "give either archive or path, not both")
+	case req.Archive != "":
+		s.mu.Lock()
+		a := s.archives[req.Archive]
+		s.mu.Unlock()
+		if a == nil {
+			return errorf(http.StatusNotFound, "archive %s not found", req.Archive)
+		}
+		source, sourceName = a.path, a.Name
+	case req.Path != "":
+		resolved, err := s.resolveExisting(req.Path)
+		if err != nil {
+			return err
+		}
+		source, sourceName = resolved, filepath.Base(resolved)
+	default:
+		return errorf(http.StatusBadRequest, "archive or path is required")
+	}
+
+	destType, err := requestType(req.Type, req.Output)
+	if err != nil {
+		return err
+	}
+
+	return s.startJob(w, "convert", req.Output, archiveStem(sourceName)+typeExt(destType), func(dest string, progress io.Writer) error {
+		return archiver.Convert(&models.ConvertConfig{
+			SourcePath:       source,
+			DestPath:         dest,
+			SourceType:       models.AUTO,
+			DestType:         destType,
+			CompressionLevel: req.Level,
+			Progress:         progress,
+		})
+	})
+}
+
+// createRequest compresses a directory or file under the root
+type createRequest struct {
+	Source       string   `json:"source"` // root-relative path to compress
+	Type         string   `json:"type"`   // inferred from Output when empty, else ZIP
+	Level        int      `json:"level"`  // 0 uses the default of 6
+	Exclude      []string `json:"exclude"`
+	Include      []string `json:"include"`
+	Gitignore    bool     `json:"gitignore"`
+	Reproducible bool     `json:"reproducible"`
+	Output       string   `json:"output"` // root-relative destination; the store when empty
+}
+
+func (s *Server) startCreate(w http.ResponseWriter, r *http.Request) error {
+	var req createRequest
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+
+	source, err := s.resolveExisting(req.Source)
+	if err != nil {
+		return err
+	}
+
+	archiveType := models.ZIP
+	if req.Type != "" || req.Output != "" {
+		if archiveType, err = requestType(req.Type, req.Output); err != nil {
+			return err
+		}
+	}
+	if req.Level < 0 || req.Level > 9 {
+		return errorf(http.StatusBadRequest, "level %d is out of range (0-9, 0 = default)", req.Level)
+	}
+	level := req.Level
+	if level == 0 {
+		level = 6
+	}
+
+	return s.startJob(w, "create", req.Output, filepath.Base(source)+typeExt(archiveType), func(dest string, progress io.Writer) error {
+		return archiver.Compress(&models.CompressConfig{
+			SourcePath:       source,
+			OutputPath:       dest,
+			ArchiveType:      archiveType,
+			ExcludePaths:     req.Exclude,
+			IncludePaths:     req.Include,
+			UseGitignore:     req.Gitignore,
+			CompressionLevel: level,
+			Reproducible:     req.Reproducible,
+			Progress:         progress,
+		})
+	})
+}
+
+// requestType resolves the archive type a job writes, from its name or
+// else from the extension of its output path
+func requestType(name, output string) (models.ArchiveType, error) {
+	if name != "" {
+		archiveType, err := settings.ParseType(name)
+		if err != nil {
+			return "", errorf(http.StatusBadRequest, "%v", err)
+		}
+		return archiveType, nil
+	}
+	if archiveType, ok := archiver.DetectArchiveTypeByExtension(output); ok && archiveType != models.RAR {
+		return archiveType, nil
+	}
+	return "", errorf(http.StatusBadRequest, "type is required when it cannot be inferred from the output")
+}
+
+// typeExt returns the file extension of an archive type
+func typeExt(archiveType models.ArchiveType) string {
+	switch archiveType {
+	case models.TARGZ:
+		return ".tar.gz"
+	case models.TAR:
+		return ".tar"
+	case models.GZIP:
+		return ".gz"
+	default:
+		return ".zip"
+	}
+}
+
+// archiveStem strips the archive extension from a file name
+func archiveStem(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".tar", ".gz", ".rar"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// job looks up a job by the {id} in the request path
+func (s *Server) job(r *http.Request) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[r.PathValue("id")]
+	if j == nil {
+		return nil, errorf(http.StatusNotFound, "job %s not found", r.PathValue("id"))
+	}
+	return j, nil
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) error {
+	s.mu.Lock()
+	list := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		list = append(list, j.status())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) jobStatus(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, j.status())
+}
+
+// jobEvents streams a job's progress as server-sent events: a "progress"
+// event per line, numbered so clients can resume with Last-Event-ID, a
+// "status" event whenever the status changes and a final "done" event
+func (s *Server) jobEvents(w http.ResponseWriter, r *http.Request) error {
+	j, err := s.job(r)
+	if err != nil {
+		return err
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errorf(http.StatusInternalServerError, "streaming is not supported")
+	}
+
+	sent, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	sent = max(sent, 0)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var lastStatus JobStatus
+	for {
+		info, lines, changed := j.snapshot(sent)
+		for _, line := range lines {
+			sent++
+			fmt.Fprintf(w, "id: %d\nevent: progress\ndata: %s\n\n", sent, line)
+		}
+
+		data, _ := json.Marshal(info)
+		if info.done() {
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			flusher.Flush()
+			return nil
+		}
+		if info.Status != lastStatus {
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+			lastStatus = info.Status
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
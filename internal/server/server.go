@@ -0,0 +1,449 @@
+// Package server exposes zipprine's archive operations as an HTTP JSON API,
+// so it can run as a sidecar next to other services.
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"zipprine/internal/archiver"
+	"zipprine/internal/models"
+)
+
+// DefaultMaxUpload is the largest upload accepted when Config.MaxUpload is 0
+const DefaultMaxUpload = 1 << 30
+
+// Config describes where a Server may read and write
+type Config struct {
+	Root      string // server-side paths in requests are confined to this directory
+	MaxUpload int64  // largest accepted upload in bytes; 0 uses DefaultMaxUpload
+	MaxJobs   int    // jobs running at once; 0 uses the number of CPUs
+}
+
+// Server handles the API. Uploaded archives and the archives jobs produce
+// are kept in a private directory until they are deleted or the server is
+// closed. Paths naming server-side files are resolved inside the root and
+// may not leave it, through ".." or through symlinks.
+type Server struct {
+	root      string
+	store     string
+	maxUpload int64
+	mux       *http.ServeMux
+
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu       sync.Mutex
+	archives map[string]*storedArchive
+	jobs     map[string]*job
+}
+
+// storedArchive is an archive kept in the server's store
+type storedArchive struct {
+	ID      string             `json:"id"`
+	Name    string             `json:"name"`
+	Type    models.ArchiveType `json:"type"`
+	Size    int64              `json:"size"`
+	Created time.Time          `json:"created"`
+
+	path string
+}
+
+// New creates a Server for the given config
+func New(config Config) (*Server, error) {
+	root := config.Root
+	if root == "" {
+		root = "."
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	// Symlinks in the root itself are resolved once, so that the
+	// containment checks compare real paths
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("root %s is not a directory", root)
+	}
+
+	store, err := os.MkdirTemp("", "zipprine-serve-*")
+	if err != nil {
+		return nil, err
+	}
+
+	maxJobs := config.MaxJobs
+	if maxJobs <= 0 {
+		maxJobs = runtime.NumCPU()
+	}
+
+	s := &Server{
+		root:      root,
+		store:     store,
+		maxUpload: config.MaxUpload,
+		mux:       http.NewServeMux(),
+		slots:     make(chan struct{}, maxJobs),
+		archives:  map[string]*storedArchive{},
+		jobs:      map[string]*job{},
+	}
+	if s.maxUpload <= 0 {
+		s.maxUpload = DefaultMaxUpload
+	}
+
+	s.mux.HandleFunc("POST /api/archives", s.handle(s.uploadArchive))
+	s.mux.HandleFunc("GET /api/archives", s.handle(s.listArchives))
+	s.mux.HandleFunc("GET /api/archives/{id}", s.handle(s.analyzeArchive))
+	s.mux.HandleFunc("DELETE /api/archives/{id}", s.handle(s.deleteArchive))
+	s.mux.HandleFunc("GET /api/archives/{id}/download", s.handle(s.downloadArchive))
+	s.mux.HandleFunc("GET /api/archives/{id}/entries", s.handle(s.listEntries))
+	s.mux.HandleFunc("GET /api/archives/{id}/entries/{name...}", s.handle(s.downloadEntry))
+	s.mux.HandleFunc("POST /api/jobs/convert", s.handle(s.startConvert))
+	s.mux.HandleFunc("POST /api/jobs/create", s.handle(s.startCreate))
+	s.mux.HandleFunc("GET /api/jobs", s.handle(s.listJobs))
+	s.mux.HandleFunc("GET /api/jobs/{id}", s.handle(s.jobStatus))
+	s.mux.HandleFunc("GET /api/jobs/{id}/events", s.handle(s.jobEvents))
+	return s, nil
+}
+
+// Root returns the directory server-side paths are confined to
+func (s *Server) Root() string {
+	return s.root
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close waits for running jobs and removes every stored archive
+func (s *Server) Close() error {
+	s.wg.Wait()
+	return os.RemoveAll(s.store)
+}
+
+// httpError is an error with the status code it should be reported with
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func errorf(status int, format string, args ...any) error {
+	return &httpError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// handle adapts a handler that returns an error, reporting the error as
+// JSON with a matching status code
+func (s *Server) handle(fn func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := fn(w, r)
+		if err == nil {
+			return
+		}
+
+		status := http.StatusInternalServerError
+		var httpErr *httpError
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &httpErr):
+			status = httpErr.status
+		case errors.As(err, &maxErr):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, archiver.ErrEntryNotFound), errors.Is(err, os.ErrNotExist):
+			status = http.StatusNotFound
+		case errors.Is(err, archiver.ErrPasswordRequired):
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// readJSON decodes a request body, rejecting unknown fields so typos in
+// option names are not silently ignored
+func readJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// resolve maps a slash-separated path relative to the root onto the file
+// system. Cleaning it as if it were absolute drops any "..", and symlinks
+// are followed as far as the path exists, so none of them lead out either.
+func (s *Server) resolve(name string) (string, error) {
+	if name == "" {
+		return "", errorf(http.StatusBadRequest, "path is required")
+	}
+	full := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+
+	// Split off the part that does not exist yet, such as a new output file
+	existing, rest := full, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == s.root {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", errorf(http.StatusNotFound, "%s cannot be resolved", name)
+	}
+
+	rel, err := filepath.Rel(s.root, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errorf(http.StatusForbidden, "%s is outside the server root", name)
+	}
+	return filepath.Join(real, rest), nil
+}
+
+// resolveExisting is resolve for paths that must already exist. Errors name
+// the path as the client gave it rather than where it is on the server.
+func (s *Server) resolveExisting(name string) (string, error) {
+	resolved, err := s.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(resolved); err != nil {
+		return "", errorf(http.StatusNotFound, "%s does not exist", name)
+	}
+	return resolved, nil
+}
+
+// archive looks up a stored archive by the {id} in the request path
+func (s *Server) archive(r *http.Request) (*storedArchive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.archives[r.PathValue("id")]
+	if a == nil {
+		return nil, errorf(http.StatusNotFound, "archive %s not found", r.PathValue("id"))
+	}
+	return a, nil
+}
+
+// storePath returns a fresh path in the store for an archive called name
+func (s *Server) storePath(id, name string) (string, error) {
+	dir := filepath.Join(s.store, id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// addArchive registers a file in the store as an archive
+func (s *Server) addArchive(id, filePath string) (*storedArchive, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	archiveType, err := archiver.DetectArchiveType(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &storedArchive{
+		ID:      id,
+		Name:    filepath.Base(filePath),
+		Type:    archiveType,
+		Size:    info.Size(),
+		Created: time.Now(),
+		path:    filePath,
+	}
+	s.mu.Lock()
+	s.archives[id] = a
+	s.mu.Unlock()
+	return a, nil
+}
+
+// uploadName picks the stored name of an upload. The name matters: it
+// settles the type of a gzip stream and names the file inside it.
+func uploadName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "upload"
+	}
+	return name
+}
+
+// uploadArchive stores the archive in the request body and responds with
+// its analysis. The body is either the raw archive, named by ?name=, or a
+// multipart form with the archive in its "file" field.
+func (s *Server) uploadArchive(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+
+	body, name := io.Reader(r.Body), r.URL.Query().Get("name")
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return errorf(http.StatusBadRequest, "multipart upload needs a \"file\" field: %v", err)
+		}
+		defer file.Close()
+		body = file
+		if name == "" {
+			name = header.Filename
+		}
+	}
+
+	id := newID()
+	filePath, err := s.storePath(id, uploadName(name))
+	if err != nil {
+		return err
+	}
+	discard := func() { os.RemoveAll(filepath.Dir(filePath)) }
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		discard()
+		return err
+	}
+	_, err = io.Copy(out, body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		discard()
+		return err
+	}
+
+	info, err := archiver.Analyze(filePath)
+	if err == nil && info == nil {
+		err = errors.New("unrecognised format")
+	}
+	if err != nil {
+		discard()
+		if errors.Is(err, archiver.ErrPasswordRequired) {
+			return err
+		}
+		return errorf(http.StatusUnprocessableEntity, "not a readable archive: %v", err)
+	}
+	a, err := s.addArchive(id, filePath)
+	if err != nil {
+		discard()
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, map[string]any{"archive": a, "analysis": info})
+}
+
+func (s *Server) listArchives(w http.ResponseWriter, r *http.Request) error {
+	s.mu.Lock()
+	list := make([]*storedArchive, 0, len(s.archives))
+	for _, a := range s.archives {
+		list = append(list, a)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) analyzeArchive(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.archive(r)
+	if err != nil {
+		return err
+	}
+	info, err := archiver.Analyze(a.path)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, map[string]any{"archive": a, "analysis": info})
+}
+
+func (s *Server) deleteArchive(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.archive(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.archives, a.ID)
+	s.mu.Unlock()
+
+	if err := os.RemoveAll(filepath.Dir(a.path)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) downloadArchive(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.archive(r)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	http.ServeFile(w, r, a.path)
+	return nil
+}
+
+// listEntries lists every entry of a stored archive
+func (s *Server) listEntries(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.archive(r)
+	if err != nil {
+		return err
+	}
+	files, err := archiver.ListEntries(a.path)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, files)
+}
+
+// downloadEntry streams a single entry of a stored archive
+func (s *Server) downloadEntry(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.archive(r)
+	if err != nil {
+		return err
+	}
+	name := r.PathValue("name")
+	rc, err := archiver.OpenEntry(a.path, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	// Headers are gone once the copy starts, so a failure midway can only
+	// cut the response short
+	io.Copy(w, rc)
+	return nil
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zipprine/internal/archiver"
+	"zipprine/internal/models"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"src/a.txt":     "alpha",
+		"src/sub/b.txt": "beta",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := New(Config{Root: root, MaxJobs: 1})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		ts.Close()
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return ts, s.Root()
+}
+
+func decode(t *testing.T, resp *http.Response, wantStatus int, v any) {
+	t.Helper()
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, wantStatus, body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatalf("bad JSON %q: %v", body, err)
+		}
+	}
+}
+
+// postJob starts a job and follows its events until it is done
+func postJob(t *testing.T, ts *httptest.Server, kind string, req any) (JobInfo, []string) {
+	t.Helper()
+	data, _ := json.Marshal(req)
+	resp, err := http.Post(ts.URL+"/api/jobs/"+kind, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started JobInfo
+	decode(t, resp, http.StatusAccepted, &started)
+
+	resp, err = http.Get(ts.URL + "/api/jobs/" + started.ID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("events Content-Type = %q", ct)
+	}
+
+	var progress []string
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == "progress":
+			progress = append(progress, strings.TrimPrefix(line, "data: "))
+		case strings.HasPrefix(line, "data: ") && event == "done":
+			var info JobInfo
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &info); err != nil {
+				t.Fatal(err)
+			}
+			return info, progress
+		}
+	}
+	t.Fatalf("event stream ended without a done event: %v", scanner.Err())
+	return JobInfo{}, nil
+}
+
+func TestUploadListAndDownload(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	source := t.TempDir()
+	os.MkdirAll(filepath.Join(source, "docs"), 0755)
+	os.WriteFile(filepath.Join(source, "docs", "readme.txt"), []byte("read me"), 0644)
+	os.WriteFile(filepath.Join(source, "main.go"), []byte("package main"), 0644)
+	archivePath := filepath.Join(t.TempDir(), "bundle.zip")
+	if err := archiver.Compress(&models.CompressConfig{
+		SourcePath:  source,
+		OutputPath:  archivePath,
+		ArchiveType: models.ZIP,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(archivePath)
+
+	// Multipart upload, the way a browser form sends it
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", "bundle.zip")
+	part.Write(data)
+	mw.Close()
+	resp, err := http.Post(ts.URL+"/api/archives", mw.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded struct {
+		Archive  storedArchive      `json:"archive"`
+		Analysis models.ArchiveInfo `json:"analysis"`
+	}
+	decode(t, resp, http.StatusCreated, &uploaded)
+	if uploaded.Archive.Name != "bundle.zip" || uploaded.Analysis.Type != models.ZIP || uploaded.Analysis.TotalSize != 19 {
+		t.Errorf("upload = %+v", uploaded)
+	}
+	id := uploaded.Archive.ID
+
+	resp, _ = http.Get(ts.URL + "/api/archives/" + id + "/entries")
+	var entries []models.FileInfo
+	decode(t, resp, http.StatusOK, &entries)
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir {
+			names = append(names, e.Name)
+		}
+	}
+	if strings.Join(names, ",") != "docs/readme.txt,main.go" {
+		t.Errorf("entries = %v", names)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/archives/" + id + "/entries/docs/readme.txt")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "read me" || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("entry download: %d %q %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/archives/" + id + "/entries/missing.txt")
+	decode(t, resp, http.StatusNotFound, nil)
+
+	// A raw body that is not an archive is rejected and not kept
+	resp, _ = http.Post(ts.URL+"/api/archives?name=notes.txt", "application/octet-stream", strings.NewReader("just text"))
+	decode(t, resp, http.StatusUnprocessableEntity, nil)
+
+	resp, _ = http.Get(ts.URL + "/api/archives")
+	var list []storedArchive
+	decode(t, resp, http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != id {
+		t.Errorf("archives = %+v", list)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/archives/"+id, nil)
+	resp, _ = http.DefaultClient.Do(req)
+	decode(t, resp, http.StatusNoContent, nil)
+	resp, _ = http.Get(ts.URL + "/api/archives/" + id)
+	decode(t, resp, http.StatusNotFound, nil)
+}
+
+func TestCreateAndConvertJobs(t *testing.T) {
+	ts, root := newTestServer(t)
+
+	info, progress := postJob(t, ts, "create", map[string]any{"source": "src", "type": "tar.gz"})
+	if info.Status != JobSucceeded || info.Archive == "" {
+		t.Fatalf("create job = %+v", info)
+	}
+	if len(progress) != 2 || info.Progress != 2 {
+		t.Errorf("progress = %q, reported %d", progress, info.Progress)
+	}
+
+	resp, _ := http.Get(ts.URL + "/api/archives/" + info.Archive)
+	var created struct {
+		Archive  storedArchive      `json:"archive"`
+		Analysis models.ArchiveInfo `json:"analysis"`
+	}
+	decode(t, resp, http.StatusOK, &created)
+	if created.Archive.Name != "src.tar.gz" || created.Analysis.TotalSize != 9 {
+		t.Errorf("created archive = %+v", created)
+	}
+
+	// Convert the stored archive into a file under the root
+	info, _ = postJob(t, ts, "convert", map[string]any{"archive": info.Archive, "output": "out/src.zip"})
+	if info.Status != JobSucceeded || info.Output != "out/src.zip" || info.Archive != "" {
+		t.Fatalf("convert job = %+v", info)
+	}
+	rc, err := archiver.OpenEntry(filepath.Join(root, "out", "src.zip"), "sub/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(content) != "beta" {
+		t.Errorf("converted entry = %q", content)
+	}
+
+	// Failures are reported on the job, not the request
+	os.WriteFile(filepath.Join(root, "broken.zip"), []byte("PK not really"), 0644)
+	info, _ = postJob(t, ts, "convert", map[string]any{"path": "broken.zip", "type": "tar"})
+	if info.Status != JobFailed || info.Error == "" {
+		t.Errorf("broken convert job = %+v", info)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/jobs")
+	var jobs []JobInfo
+	decode(t, resp, http.StatusOK, &jobs)
+	if len(jobs) != 3 || jobs[0].Kind != "create" || jobs[2].Status != JobFailed {
+		t.Errorf("jobs = %+v", jobs)
+	}
+
+	resp, _ = http.Post(ts.URL+"/api/jobs/create", "application/json", strings.NewReader(`{"source": "src", "formt": "zip"}`))
+	decode(t, resp, http.StatusBadRequest, nil)
+
+	var failure map[string]string
+	resp, _ = http.Post(ts.URL+"/api/jobs/create", "application/json", strings.NewReader(`{"source": "missing"}`))
+	decode(t, resp, http.StatusNotFound, &failure)
+	if strings.Contains(failure["error"], root) {
+		t.Errorf("error reveals the server root: %q", failure["error"])
+	}
+}
+
+func TestRootSandbox(t *testing.T) {
+	ts, root := newTestServer(t)
+
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	for _, req := range []map[string]any{
+		{"source": "escape"},
+		{"source": "escape/secret.txt"},
+		{"source": "src", "output": "escape/stolen.zip"},
+	} {
+		data, _ := json.Marshal(req)
+		resp, err := http.Post(ts.URL+"/api/jobs/create", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decode(t, resp, http.StatusForbidden, nil)
+	}
+
+	// ".." is resolved against the root, so it cannot climb out of it
+	info, _ := postJob(t, ts, "create", map[string]any{"source": "../../src", "output": "../../../src.zip"})
+	if info.Status != JobSucceeded {
+		t.Fatalf("create job = %+v", info)
+	}
+	if _, err := os.Stat(filepath.Join(root, "src.zip")); err != nil {
+		t.Errorf("output did not land in the root: %v", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Errorf("files were written outside the root: %v", entries)
+	}
+
+	// Symlinks inside a source are archived as links, not followed out of
+	// the root
+	linked := filepath.Join(root, "linked")
+	os.Mkdir(linked, 0755)
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(linked, "secret.txt"))
+	os.Symlink(outside, filepath.Join(linked, "outside"))
+	for _, archiveType := range []string{"zip", "tar"} {
+		output := "linked." + archiveType
+		info, _ := postJob(t, ts, "create", map[string]any{"source": "linked", "type": archiveType, "output": output})
+		if info.Status != JobSucceeded {
+			t.Fatalf("create %s job = %+v", archiveType, info)
+		}
+		contents := readAll(t, filepath.Join(root, output), archiveType)
+		if target := filepath.Join(outside, "secret.txt"); contents["secret.txt"] != target {
+			t.Errorf("%s: secret.txt = %q; want the link %q", archiveType, contents["secret.txt"], target)
+		}
+		for name, content := range contents {
+			if content == "secret" {
+				t.Errorf("%s: %s holds the file outside the root", archiveType, name)
+			}
+		}
+	}
+}
+
+// readAll maps the entries of a ZIP or tar to their contents, or to the
+// target of a tar symlink
+func readAll(t *testing.T, path, archiveType string) map[string]string {
+	t.Helper()
+	contents := map[string]string{}
+	if archiveType == "zip" {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			contents[f.Name] = string(data)
+		}
+		return contents
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		contents[header.Name] = string(data) + header.Linkname
+	}
+}
@@ -0,0 +1,323 @@
+// Package settings loads zipprine's config files: the user's
+// ~/.config/zipprine/config.yaml and a project's .zipprine.yaml. Both hold
+// compression defaults and named presets such as "release".
+//
+// Settings are applied in this order, later ones winning:
+//
+//  1. built-in defaults
+//  2. defaults from the user config, then from the project config
+//  3. the selected preset (--preset, or $ZIPPRINE_PRESET)
+//  4. environment variables ($ZIPPRINE_TYPE, $ZIPPRINE_LEVEL, $ZIPPRINE_THREADS, $ZIPPRINE_EXCLUDE)
+//  5. flags given on the command line
+package settings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"zipprine/internal/models"
+	"zipprine/pkg/fileutil"
+)
+
+// ProjectFile is the name of the per-project config file, looked up in the
+// working directory and its parents
+const ProjectFile = ".zipprine.yaml"
+
+// Environment variables read by Resolve
+const (
+	EnvConfig  = "ZIPPRINE_CONFIG" // path of the user config file
+	EnvPreset  = "ZIPPRINE_PRESET"
+	EnvType    = "ZIPPRINE_TYPE"
+	EnvLevel   = "ZIPPRINE_LEVEL"
+	EnvThreads = "ZIPPRINE_THREADS"
+	EnvExclude = "ZIPPRINE_EXCLUDE" // comma-separated
+)
+
+// Options are compression settings; unset fields leave the value below them
+// in the precedence order alone
+type Options struct {
+	Type         string   `yaml:"type,omitempty"`
+	Level        *int     `yaml:"level,omitempty"`
+	Threads      *int     `yaml:"threads,omitempty"`
+	Exclude      []string `yaml:"exclude,omitempty"`
+	Include      []string `yaml:"include,omitempty"`
+	Gitignore    *bool    `yaml:"gitignore,omitempty"`
+	Verify       *bool    `yaml:"verify,omitempty"`
+	Reproducible *bool    `yaml:"reproducible,omitempty"`
+	SplitSize    string   `yaml:"split_size,omitempty"`
+}
+
+// merge returns o with every field set in over replaced
+func (o Options) merge(over Options) Options {
+	if over.Type != "" {
+		o.Type = over.Type
+	}
+	if over.Level != nil {
+		o.Level = over.Level
+	}
+	if over.Threads != nil {
+		o.Threads = over.Threads
+	}
+	if over.Exclude != nil {
+		o.Exclude = over.Exclude
+	}
+	if over.Include != nil {
+		o.Include = over.Include
+	}
+	if over.Gitignore != nil {
+		o.Gitignore = over.Gitignore
+	}
+	if over.Verify != nil {
+		o.Verify = over.Verify
+	}
+	if over.Reproducible != nil {
+		o.Reproducible = over.Reproducible
+	}
+	if over.SplitSize != "" {
+		o.SplitSize = over.SplitSize
+	}
+	return o
+}
+
+// check validates the values that need parsing
+func (o Options) check() error {
+	if o.Type != "" {
+		if _, err := ParseType(o.Type); err != nil {
+			return err
+		}
+	}
+	if o.Level != nil && (*o.Level < 0 || *o.Level > 9) {
+		return fmt.Errorf("level %d is out of range (0-9)", *o.Level)
+	}
+	if o.SplitSize != "" {
+		if _, err := fileutil.ParseBytes(o.SplitSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// File is the content of one config file
+type File struct {
+	Defaults Options            `yaml:"defaults"`
+	Presets  map[string]Options `yaml:"presets"`
+}
+
+// Config is the combination of the user and project config files
+type Config struct {
+	Defaults Options
+	Presets  map[string]Options
+	Files    []string // the files that were read, user file first
+}
+
+// Load reads the user config file and the nearest project config file
+// above the working directory. Missing files are not an error.
+func Load() (*Config, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFrom(UserPath(), FindProjectFile(dir))
+}
+
+// UserPath returns $ZIPPRINE_CONFIG, or config.yaml under
+// $XDG_CONFIG_HOME/zipprine (~/.config/zipprine by default)
+func UserPath() string {
+	if path := os.Getenv(EnvConfig); path != "" {
+		return path
+	}
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "zipprine", "config.yaml")
+}
+
+// FindProjectFile returns the ProjectFile in dir or its closest parent, or
+// "" when there is none
+func FindProjectFile(dir string) string {
+	for {
+		path := filepath.Join(dir, ProjectFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadFrom reads the given user and project config files; either may be ""
+// or missing. Project defaults override user defaults field by field, and a
+// project preset replaces a user preset of the same name.
+func LoadFrom(userPath, projectPath string) (*Config, error) {
+	config := &Config{Presets: map[string]Options{}}
+	for _, path := range []string{userPath, projectPath} {
+		if path == "" {
+			continue
+		}
+		file, err := readFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		config.Files = append(config.Files, path)
+		config.Defaults = config.Defaults.merge(file.Defaults)
+		for name, preset := range file.Presets {
+			config.Presets[name] = preset
+		}
+	}
+	return config, nil
+}
+
+func readFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := file.Defaults.check(); err != nil {
+		return nil, fmt.Errorf("%s: defaults: %w", path, err)
+	}
+	for name, preset := range file.Presets {
+		if err := preset.check(); err != nil {
+			return nil, fmt.Errorf("%s: preset %s: %w", path, name, err)
+		}
+	}
+	return file, nil
+}
+
+// PresetNames returns the defined presets in alphabetical order
+func (c *Config) PresetNames() []string {
+	names := make([]string, 0, len(c.Presets))
+	for name := range c.Presets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Resolve combines the defaults, the named preset and the environment.
+// An empty preset name falls back to $ZIPPRINE_PRESET.
+func (c *Config) Resolve(preset string) (Options, error) {
+	opts := c.Defaults
+	if preset == "" {
+		preset = os.Getenv(EnvPreset)
+	}
+	if preset != "" {
+		p, ok := c.Presets[preset]
+		if !ok {
+			if len(c.Presets) == 0 {
+				return Options{}, fmt.Errorf("unknown preset %q: no presets are defined", preset)
+			}
+			return Options{}, fmt.Errorf("unknown preset %q (defined: %s)", preset, strings.Join(c.PresetNames(), ", "))
+		}
+		opts = opts.merge(p)
+	}
+
+	env, err := envOptions()
+	if err != nil {
+		return Options{}, err
+	}
+	return opts.merge(env), nil
+}
+
+// envOptions reads the settings given as environment variables
+func envOptions() (Options, error) {
+	var s Options
+	s.Type = os.Getenv(EnvType)
+	for name, field := range map[string]**int{EnvLevel: &s.Level, EnvThreads: &s.Threads} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return Options{}, fmt.Errorf("$%s: %q is not a number", name, value)
+		}
+		*field = &n
+	}
+	if exclude := os.Getenv(EnvExclude); exclude != "" {
+		s.Exclude = strings.Split(exclude, ",")
+	}
+	if err := s.check(); err != nil {
+		return Options{}, fmt.Errorf("environment: %w", err)
+	}
+	return s, nil
+}
+
+// Apply copies the options into a compression config, skipping any whose
+// flag was given explicitly; flagSet reports that for a flag name such as
+// "level" or "split-size"
+func (o Options) Apply(config *models.CompressConfig, flagSet func(name string) bool) error {
+	if o.Type != "" && !flagSet("type") {
+		config.ArchiveType, _ = ParseType(o.Type)
+	}
+	if o.Level != nil && !flagSet("level") {
+		config.CompressionLevel = *o.Level
+	}
+	if o.Threads != nil && !flagSet("threads") {
+		config.Threads = *o.Threads
+	}
+	if o.Exclude != nil && !flagSet("exclude") {
+		config.ExcludePaths = o.Exclude
+	}
+	if o.Include != nil && !flagSet("include") {
+		config.IncludePaths = o.Include
+	}
+	if o.Gitignore != nil && !flagSet("gitignore") {
+		config.UseGitignore = *o.Gitignore
+	}
+	if o.Verify != nil && !flagSet("verify") {
+		config.VerifyIntegrity = *o.Verify
+	}
+	if o.Reproducible != nil && !flagSet("reproducible") {
+		config.Reproducible = *o.Reproducible
+	}
+	if o.SplitSize != "" && !flagSet("split-size") {
+		size, err := fileutil.ParseBytes(o.SplitSize)
+		if err != nil {
+			return err
+		}
+		config.SplitSize = size
+	}
+	return nil
+}
+
+// ParseType maps a format name to its archive type
+func ParseType(name string) (models.ArchiveType, error) {
+	switch strings.ToLower(name) {
+	case "zip":
+		return models.ZIP, nil
+	case "tar":
+		return models.TAR, nil
+	case "tar.gz", "tgz", "targz":
+		return models.TARGZ, nil
+	case "gzip", "gz":
+		return models.GZIP, nil
+	}
+	return "", fmt.Errorf("unknown archive type %q (want zip, tar, tar.gz or gzip)", name)
+}
@@ -0,0 +1,157 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zipprine/internal/models"
+)
+
+func writeFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	tmpDir := t.TempDir()
+	userPath := writeFile(t, filepath.Join(tmpDir, "home", "config.yaml"), `
+defaults:
+  type: zip
+  level: 5
+  exclude: ["*.log"]
+presets:
+  release:
+    type: tar.gz
+    level: 9
+    exclude: [".git/", "*.tmp"]
+    reproducible: true
+  backup:
+    type: tar
+`)
+	projectPath := writeFile(t, filepath.Join(tmpDir, "project", ProjectFile), `
+defaults:
+  level: 7
+  gitignore: true
+presets:
+  backup:
+    split_size: 1G
+`)
+	for _, name := range []string{EnvPreset, EnvType, EnvLevel, EnvThreads, EnvExclude} {
+		t.Setenv(name, "")
+	}
+
+	cfg, err := LoadFrom(userPath, projectPath)
+	if err != nil {
+		t.Fatalf("LoadFrom() failed: %v", err)
+	}
+	if len(cfg.Files) != 2 || strings.Join(cfg.PresetNames(), ",") != "backup,release" {
+		t.Fatalf("loaded %v with presets %v", cfg.Files, cfg.PresetNames())
+	}
+
+	// Project defaults override the user's field by field
+	opts, err := cfg.Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Type != "zip" || *opts.Level != 7 || !*opts.Gitignore || len(opts.Exclude) != 1 {
+		t.Errorf("defaults = %+v", opts)
+	}
+
+	// A preset overrides the defaults, and the environment the preset
+	t.Setenv(EnvLevel, "3")
+	opts, err = cfg.Resolve("release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &models.CompressConfig{ArchiveType: models.ZIP, CompressionLevel: 6}
+	if err := opts.Apply(config, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if config.ArchiveType != models.TARGZ || config.CompressionLevel != 3 || !config.Reproducible || !config.UseGitignore {
+		t.Errorf("release preset gave %+v", config)
+	}
+	if strings.Join(config.ExcludePaths, ",") != ".git/,*.tmp" {
+		t.Errorf("ExcludePaths = %v", config.ExcludePaths)
+	}
+
+	// Explicit flags win over everything
+	config = &models.CompressConfig{ArchiveType: models.ZIP, CompressionLevel: 1}
+	opts.Apply(config, func(name string) bool { return name == "level" || name == "type" })
+	if config.ArchiveType != models.ZIP || config.CompressionLevel != 1 {
+		t.Errorf("flags were overridden: %+v", config)
+	}
+
+	// The project's backup preset replaces the user's entirely
+	t.Setenv(EnvPreset, "backup")
+	opts, err = cfg.Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Type != "zip" || opts.SplitSize != "1G" {
+		t.Errorf("$%s backup preset = %+v", EnvPreset, opts)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown_field", "defaults:\n  levle: 3\n", "levle"},
+		{"bad_type", "presets:\n  odd:\n    type: 7z\n", "preset odd: unknown archive type"},
+		{"bad_level", "defaults:\n  level: 12\n", "out of range"},
+		{"bad_split", "defaults:\n  split_size: lots\n", "defaults"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, filepath.Join(t.TempDir(), "config.yaml"), tt.content)
+			_, err := LoadFrom(path, "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadFrom() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	cfg, err := LoadFrom(filepath.Join(t.TempDir(), "missing.yaml"), "")
+	if err != nil || len(cfg.Files) != 0 {
+		t.Fatalf("a missing file should be ignored: %v", err)
+	}
+	t.Setenv(EnvPreset, "")
+	if _, err := cfg.Resolve("release"); err == nil || !strings.Contains(err.Error(), "unknown preset") {
+		t.Errorf("Resolve() of an undefined preset: %v", err)
+	}
+	t.Setenv(EnvLevel, "high")
+	if _, err := cfg.Resolve(""); err == nil || !strings.Contains(err.Error(), EnvLevel) {
+		t.Errorf("Resolve() with a bad $%s: %v", EnvLevel, err)
+	}
+}
+
+func TestFindProjectFile(t *testing.T) {
+	root := t.TempDir()
+	path := writeFile(t, filepath.Join(root, ProjectFile), "defaults:\n  level: 9\n")
+	nested := filepath.Join(root, "src", "pkg")
+	os.MkdirAll(nested, 0755)
+
+	if got := FindProjectFile(nested); got != path {
+		t.Errorf("FindProjectFile() = %q, want %q", got, path)
+	}
+
+	t.Setenv(EnvConfig, "")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	if got := UserPath(); got != filepath.Join("/xdg", "zipprine", "config.yaml") {
+		t.Errorf("UserPath() = %q", got)
+	}
+	t.Setenv(EnvConfig, "/etc/zipprine.yaml")
+	if got := UserPath(); got != "/etc/zipprine.yaml" {
+		t.Errorf("UserPath() = %q, want $%s", got, EnvConfig)
+	}
+}
@@ -1,11 +1,13 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"zipprine/internal/archiver"
 	"zipprine/internal/models"
+	"zipprine/pkg/fileutil"
 
 	"github.com/charmbracelet/huh"
 )
@@ -40,12 +42,27 @@ func RunAnalyzeFlow() error {
 	fmt.Println()
 	fmt.Println(InfoStyle.Render("🔍 Analyzing archive..."))
 
+	var password string
 	info, err := archiver.Analyze(archivePath)
+	if errors.Is(err, archiver.ErrPasswordRequired) {
+		var promptErr error
+		password, promptErr = promptPassword(archivePath)
+		if promptErr != nil {
+			return promptErr
+		}
+		info, err = archiver.AnalyzeWithPassword(archivePath, password)
+	}
 	if err != nil {
 		return err
 	}
 
 	displayArchiveInfo(info)
+
+	report, err := archiver.AnalyzeReport(archivePath, password)
+	if err != nil {
+		return err
+	}
+	displayReport(report)
 	return nil
 }
 
@@ -56,8 +73,14 @@ func displayArchiveInfo(info *models.ArchiveInfo) {
 	fmt.Println(InfoStyle.Render(fmt.Sprintf("  📁 Files: %d", info.FileCount)))
 	fmt.Println(InfoStyle.Render(fmt.Sprintf("  💾 Uncompressed: %.2f MB", float64(info.TotalSize)/(1024*1024))))
 	fmt.Println(InfoStyle.Render(fmt.Sprintf("  📦 Compressed: %.2f MB", float64(info.CompressedSize)/(1024*1024))))
+	if len(info.Volumes) > 0 {
+		fmt.Println(InfoStyle.Render(fmt.Sprintf("  🧩 Volumes: %d", len(info.Volumes))))
+	}
 	fmt.Println(InfoStyle.Render(fmt.Sprintf("  🎯 Ratio: %.1f%%", info.CompressionRatio)))
 	fmt.Println(InfoStyle.Render(fmt.Sprintf("  🔒 SHA256: %s...", info.Checksum[:16])))
+	if info.Encryption != "" {
+		fmt.Println(InfoStyle.Render(fmt.Sprintf("  🔑 Encryption: %s", info.Encryption)))
+	}
 
 	if len(info.Files) > 0 && len(info.Files) <= 20 {
 		fmt.Println()
@@ -66,8 +89,65 @@ func displayArchiveInfo(info *models.ArchiveInfo) {
 			icon := "📄"
 			if f.IsDir {
 				icon = "📁"
+			} else if f.Encrypted {
+				icon = "🔐"
 			}
 			fmt.Println(InfoStyle.Render(fmt.Sprintf("  %s %s (%.2f KB)", icon, f.Name, float64(f.Size)/1024)))
 		}
 	}
-}
\ No newline at end of file
+}
+
+// reportRows is how many rows each section of the analysis report shows
+const reportRows = 5
+
+// displayReport shows where an archive's size goes: the biggest extensions
+// and directories, the largest and worst-compressing files and duplicates
+func displayReport(report *models.AnalysisReport) {
+	if report == nil {
+		return
+	}
+	if report.Partial {
+		fmt.Println()
+		fmt.Println(WarningStyle.Render("⚠️  The archive is too large to read in full; this report covers part of it"))
+	}
+
+	showGroups := func(title string, groups []models.GroupStats) {
+		fmt.Println()
+		fmt.Println(HeaderStyle.Render(title))
+		for _, g := range groups[:min(reportRows, len(groups))] {
+			fmt.Println(InfoStyle.Render(fmt.Sprintf("  %-16s %5d files  %10s", g.Name, g.Files, fileutil.FormatBytes(g.Size))))
+		}
+	}
+	showGroups("🧩 By Extension", report.Extensions)
+	showGroups("🗂️  By Top-Level Directory", report.Directories)
+
+	fmt.Println()
+	fmt.Println(HeaderStyle.Render("🐘 Largest Files"))
+	for _, f := range report.Largest[:min(reportRows, len(report.Largest))] {
+		fmt.Println(InfoStyle.Render(fmt.Sprintf("  %10s  %s", fileutil.FormatBytes(f.Size), f.Name)))
+	}
+
+	if len(report.WorstCompressed) > 0 {
+		fmt.Println()
+		fmt.Println(HeaderStyle.Render("🧱 Worst Compressing"))
+		for _, f := range report.WorstCompressed[:min(reportRows, len(report.WorstCompressed))] {
+			fmt.Println(InfoStyle.Render(fmt.Sprintf("  %6.1f%%  %10s → %-10s %s", f.CompressionRatio,
+				fileutil.FormatBytes(f.Size), fileutil.FormatBytes(f.CompressedSize), f.Name)))
+		}
+	}
+
+	if len(report.Duplicates) > 0 {
+		fmt.Println()
+		fmt.Println(HeaderStyle.Render("♊ Duplicate Contents"))
+		for _, d := range report.Duplicates[:min(reportRows, len(report.Duplicates))] {
+			wasted := d.Size * int64(len(d.Names)-1)
+			fmt.Println(WarningStyle.Render(fmt.Sprintf("  %d copies of %s (%s wasted)", len(d.Names), fileutil.FormatBytes(d.Size), fileutil.FormatBytes(wasted))))
+			for _, name := range d.Names {
+				fmt.Println(InfoStyle.Render("    " + name))
+			}
+		}
+		if len(report.Duplicates) > reportRows {
+			fmt.Println(InfoStyle.Render(fmt.Sprintf("  ... and %d more groups", len(report.Duplicates)-reportRows)))
+		}
+	}
+}
@@ -57,7 +57,7 @@ func RunBatchCompressFlow() error {
 				Title("🎨 Archive Type").
 				Options(
 					huh.NewOption("ZIP", "ZIP"),
-					huh.NewOption("TAR.GZ", "TARGZ"),
+					huh.NewOption("TAR.GZ", string(models.TARGZ)),
 					huh.NewOption("TAR", "TAR"),
 				).
 				Value(&archiveTypeStr),
@@ -121,6 +121,7 @@ func RunBatchCompressFlow() error {
 		Configs:    configs,
 		Parallel:   parallel,
 		MaxWorkers: 4,
+		Ordered:    parallel,
 		OnProgress: func(index, total int, filename string) {
 			fmt.Printf("  [%d/%d] Processing: %s\n", index, total, filepath.Base(filename))
 		},
@@ -132,12 +133,15 @@ func RunBatchCompressFlow() error {
 		},
 	}
 
-	errors := archiver.BatchCompress(batchConfig)
+	results, err := archiver.BatchCompress(batchConfig)
+	if err != nil {
+		return err
+	}
 
 	// Count successes
 	successCount := 0
-	for _, err := range errors {
-		if err == nil {
+	for _, result := range results {
+		if !result.Failed() {
 			successCount++
 		}
 	}
@@ -232,6 +236,7 @@ func RunBatchExtractFlow() error {
 		Configs:    configs,
 		Parallel:   parallel,
 		MaxWorkers: 4,
+		Ordered:    parallel,
 		OnProgress: func(index, total int, filename string) {
 			fmt.Printf("  [%d/%d] Extracting: %s\n", index, total, filepath.Base(filename))
 		},
@@ -243,11 +248,14 @@ func RunBatchExtractFlow() error {
 		},
 	}
 
-	errors := archiver.BatchExtract(batchConfig)
+	results, err := archiver.BatchExtract(batchConfig)
+	if err != nil {
+		return err
+	}
 
 	successCount := 0
-	for _, err := range errors {
-		if err == nil {
+	for _, result := range results {
+		if !result.Failed() {
 			successCount++
 		}
 	}
@@ -155,7 +155,7 @@ func RunConvertFlow() error {
 				Title("🎨 Destination Format").
 				Options(
 					huh.NewOption("ZIP", "ZIP"),
-					huh.NewOption("TAR.GZ", "TARGZ"),
+					huh.NewOption("TAR.GZ", string(models.TARGZ)),
 					huh.NewOption("TAR", "TAR"),
 				).
 				Value(&destTypeStr),
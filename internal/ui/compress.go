@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"zipprine/internal/archiver"
 	"zipprine/internal/models"
+	"zipprine/internal/settings"
 
 	"github.com/charmbracelet/huh"
 )
@@ -18,11 +20,64 @@ func RunCompressFlow() error {
 	var sourcePath, outputPath string
 	var archiveTypeStr string
 	var excludeInput, includeInput string
-	var verify bool
+	var verify, useGitignore, encrypt bool
+	var password, confirmPassword string
 	var compressionLevel string
 
 	cwd, _ := os.Getwd()
 
+	// Presets from the config files fill in the form's starting values
+	cfg, err := settings.Load()
+	if err != nil {
+		return err
+	}
+	presetName := os.Getenv(settings.EnvPreset)
+	if len(cfg.Presets) > 0 {
+		options := []huh.Option[string]{huh.NewOption("None - start from the defaults", "")}
+		for _, name := range cfg.PresetNames() {
+			options = append(options, huh.NewOption(name, name))
+		}
+		err := huh.NewForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Title("🎛️  Preset").
+					Description("Named settings from your zipprine config files").
+					Options(options...).
+					Value(&presetName),
+			),
+		).WithTheme(huh.ThemeCatppuccin()).Run()
+		if err != nil {
+			return err
+		}
+	}
+	opts, err := cfg.Resolve(presetName)
+	if err != nil {
+		return err
+	}
+	if opts.Type != "" {
+		archiveType, _ := settings.ParseType(opts.Type)
+		archiveTypeStr = string(archiveType)
+	}
+	levelOptions := []huh.Option[string]{
+		huh.NewOption("Fast (Level 1)", "1"),
+		huh.NewOption("Balanced (Level 5)", "5"),
+		huh.NewOption("Best (Level 9)", "9"),
+	}
+	if opts.Level != nil {
+		compressionLevel = strconv.Itoa(*opts.Level)
+		if compressionLevel != "1" && compressionLevel != "5" && compressionLevel != "9" {
+			levelOptions = append(levelOptions, huh.NewOption(fmt.Sprintf("Preset (Level %d)", *opts.Level), compressionLevel))
+		}
+	}
+	excludeInput = strings.Join(opts.Exclude, ",")
+	includeInput = strings.Join(opts.Include, ",")
+	if opts.Gitignore != nil {
+		useGitignore = *opts.Gitignore
+	}
+	if opts.Verify != nil {
+		verify = *opts.Verify
+	}
+
 	form := huh.NewForm(
 		huh.NewGroup(
 			huh.NewInput().
@@ -62,7 +117,7 @@ func RunCompressFlow() error {
 				Description("Choose your compression format").
 				Options(
 					huh.NewOption("ZIP - Universal & Compatible 📦", "ZIP"),
-					huh.NewOption("TAR.GZ - Linux Classic (Best Compression) 🐧", "TARGZ"),
+					huh.NewOption("TAR.GZ - Linux Classic (Best Compression) 🐧", string(models.TARGZ)),
 					huh.NewOption("TAR - No Compression 📄", "TAR"),
 					huh.NewOption("GZIP - Single File Compression 🔧", "GZIP"),
 				).
@@ -71,18 +126,14 @@ func RunCompressFlow() error {
 			huh.NewSelect[string]().
 				Title("⚡ Compression Level").
 				Description("Higher = smaller but slower").
-				Options(
-					huh.NewOption("Fast (Level 1)", "1"),
-					huh.NewOption("Balanced (Level 5)", "5"),
-					huh.NewOption("Best (Level 9)", "9"),
-				).
+				Options(levelOptions...).
 				Value(&compressionLevel),
 		),
 
 		huh.NewGroup(
 			huh.NewText().
 				Title("🚫 Exclude Patterns").
-				Description("Comma-separated patterns to exclude (e.g., *.log,node_modules,*.tmp)").
+				Description("Comma-separated gitignore-style patterns to exclude (e.g., *.log,node_modules/,/build)").
 				Placeholder("*.log,temp/*,.git,__pycache__").
 				Value(&excludeInput),
 
@@ -91,8 +142,35 @@ func RunCompressFlow() error {
 				Description("Comma-separated patterns to include (leave empty for all)").
 				Placeholder("*.go,*.md,src/*").
 				Value(&includeInput),
+
+			huh.NewConfirm().
+				Title("🙈 Respect .gitignore").
+				Description("Skip files ignored by .gitignore files in the source tree?").
+				Value(&useGitignore),
 		),
 
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("🔏 Encrypt Archive").
+				Description("Protect the entries with AES-256 and a password?").
+				Value(&encrypt),
+		).WithHideFunc(func() bool {
+			return models.ArchiveType(archiveTypeStr) != models.ZIP
+		}),
+
+		huh.NewGroup(
+			passwordInput("🔑 Password", "Needed to extract the archive later", &password),
+			passwordInput("🔑 Confirm Password", "Type the password again", &confirmPassword).
+				Validate(func(s string) error {
+					if s != password {
+						return fmt.Errorf("passwords do not match")
+					}
+					return nil
+				}),
+		).WithHideFunc(func() bool {
+			return !encrypt || models.ArchiveType(archiveTypeStr) != models.ZIP
+		}),
+
 		huh.NewGroup(
 			huh.NewConfirm().
 				Title("🔐 Verify Archive Integrity").
@@ -167,8 +245,18 @@ func RunCompressFlow() error {
 	config.OutputPath = outputPath
 	config.ArchiveType = models.ArchiveType(archiveTypeStr)
 	config.VerifyIntegrity = verify
+	config.UseGitignore = useGitignore
+	if encrypt && config.ArchiveType == models.ZIP {
+		config.Password = password
+	}
 	fmt.Sscanf(compressionLevel, "%d", &config.CompressionLevel)
 
+	// The form already covered these; the preset supplies the rest
+	formFields := map[string]bool{"type": true, "level": true, "exclude": true, "include": true, "gitignore": true, "verify": true}
+	if err := opts.Apply(config, func(name string) bool { return formFields[name] }); err != nil {
+		return err
+	}
+
 	if excludeInput != "" {
 		config.ExcludePaths = strings.Split(excludeInput, ",")
 		for i := range config.ExcludePaths {
@@ -208,7 +296,7 @@ func RunCompressFlow() error {
 
 	if config.VerifyIntegrity {
 		fmt.Println(InfoStyle.Render("🔍 Verifying archive integrity..."))
-		info, err := archiver.Analyze(config.OutputPath)
+		info, err := archiver.AnalyzeWithPassword(config.OutputPath, config.Password)
 		if err != nil {
 			return err
 		}
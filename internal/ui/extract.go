@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -84,9 +85,23 @@ func RunExtractFlow() error {
 	config.ArchiveType = detectedType
 
 	fmt.Println(SuccessStyle.Render(fmt.Sprintf("✅ Detected: %s", detectedType)))
+
+	if encrypted, err := archiver.IsEncrypted(archivePath, detectedType); err == nil && encrypted {
+		if config.Password, err = promptPassword(archivePath); err != nil {
+			return err
+		}
+	}
 	fmt.Println(InfoStyle.Render("📂 Extracting files..."))
 
-	if err := archiver.Extract(config); err != nil {
+	err = archiver.Extract(config)
+	for attempt := 1; errors.Is(err, archiver.ErrWrongPassword) && attempt < maxPasswordAttempts; attempt++ {
+		fmt.Println(WarningStyle.Render("⚠️  Incorrect password, try again"))
+		if config.Password, err = promptPassword(archivePath); err != nil {
+			return err
+		}
+		err = archiver.Extract(config)
+	}
+	if err != nil {
 		return err
 	}
 
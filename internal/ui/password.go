@@ -0,0 +1,41 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/huh"
+)
+
+// maxPasswordAttempts limits how often a wrong password is asked again
+const maxPasswordAttempts = 3
+
+// passwordInput is a masked input for archive passwords
+func passwordInput(title, description string, value *string) *huh.Input {
+	return huh.NewInput().
+		Title(title).
+		Description(description).
+		EchoMode(huh.EchoModePassword).
+		Value(value).
+		Validate(func(s string) error {
+			if s == "" {
+				return fmt.Errorf("password cannot be empty")
+			}
+			return nil
+		})
+}
+
+// promptPassword asks for the password of an encrypted archive
+func promptPassword(archivePath string) (string, error) {
+	var password string
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			passwordInput("🔑 Password", fmt.Sprintf("%s is encrypted", archivePath), &password),
+		),
+	).WithTheme(huh.ThemeCatppuccin())
+
+	if err := form.Run(); err != nil {
+		return "", err
+	}
+	return password, nil
+}
@@ -2,51 +2,15 @@ package fileutil
 
 import (
 	"fmt"
-	"path/filepath"
+	"math"
+	"strconv"
 	"strings"
 )
 
-// ShouldInclude determines if a file should be included based on exclude/include patterns
+// ShouldInclude determines if a file should be included based on gitignore-style
+// exclude/include patterns. The path is matched relative to the archive root.
 func ShouldInclude(path string, excludePaths, includePaths []string) bool {
-	// Check exclude patterns first
-	for _, pattern := range excludePaths {
-		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
-			return false
-		}
-		// Also check if pattern is in the path
-		if strings.Contains(path, pattern) {
-			return false
-		}
-		// Handle directory patterns
-		if strings.HasSuffix(pattern, "/*") {
-			dirPattern := strings.TrimSuffix(pattern, "/*")
-			if strings.Contains(path, dirPattern) {
-				return false
-			}
-		}
-	}
-
-	// If include patterns are specified, check them
-	if len(includePaths) > 0 {
-		for _, pattern := range includePaths {
-			if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
-				return true
-			}
-			if strings.Contains(path, pattern) {
-				return true
-			}
-			// Handle directory patterns
-			if strings.HasSuffix(pattern, "/*") {
-				dirPattern := strings.TrimSuffix(pattern, "/*")
-				if strings.Contains(path, dirPattern) {
-					return true
-				}
-			}
-		}
-		return false
-	}
-
-	return true
+	return NewFilter(excludePaths, includePaths).Include(path, false)
 }
 
 // FormatBytes converts bytes to human-readable format
@@ -61,4 +25,25 @@ func FormatBytes(bytes int64) string {
 		exp++
 	}
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
\ No newline at end of file
+}
+
+// ParseBytes parses a size such as "512", "64K", "1.5G" or "2GB" into bytes.
+// Units are binary multiples, matching FormatBytes.
+func ParseBytes(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(str); n > 0 {
+		if i := strings.IndexByte("KMGTPE", str[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			str = str[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
@@ -35,7 +35,7 @@ func TestShouldInclude(t *testing.T) {
 		},
 		{
 			name:         "exclude with wildcard directory",
-			path:         "/path/temp/file.txt",
+			path:         "temp/file.txt",
 			excludePaths: []string{"temp/*"},
 			includePaths: []string{},
 			expected:     false,
@@ -68,9 +68,16 @@ func TestShouldInclude(t *testing.T) {
 			includePaths: []string{},
 			expected:     false,
 		},
+		{
+			name:         "exclude does not match substrings",
+			path:         "pkg/catalog.go",
+			excludePaths: []string{"log"},
+			includePaths: []string{},
+			expected:     true,
+		},
 		{
 			name:         "include directory pattern",
-			path:         "/path/src/main.go",
+			path:         "src/main.go",
 			excludePaths: []string{},
 			includePaths: []string{"src/*"},
 			expected:     true,
@@ -115,6 +122,38 @@ func TestFormatBytes(t *testing.T) {
 	}
 }
 
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"512", 512, false},
+		{"64K", 65536, false},
+		{"64KB", 65536, false},
+		{"64KiB", 65536, false},
+		{"1.5m", 1572864, false},
+		{"1G", 1073741824, false},
+		{" 2 GB ", 2147483648, false},
+		{"", 0, true},
+		{"G", 0, true},
+		{"-1M", 0, true},
+		{"ten", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseBytes(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBytes(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("ParseBytes(%q) = %d; want %d", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
 func BenchmarkShouldInclude(b *testing.B) {
 	excludePaths := []string{"*.log", "*.tmp", "node_modules", ".git"}
 	includePaths := []string{"*.go", "*.md"}
@@ -0,0 +1,263 @@
+package fileutil
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore file names recognised inside source trees
+const (
+	GitIgnoreFile      = ".gitignore"
+	ZipprineIgnoreFile = ".zipprineignore"
+)
+
+// ignorePattern is a single compiled gitignore-style pattern
+type ignorePattern struct {
+	segments []string // glob per path segment, "**" spans any number of segments
+	base     string   // directory the pattern is relative to, "" for the root
+	negate   bool
+	dirOnly  bool
+}
+
+// parsePattern compiles one line of an ignore file. It reports false for
+// blank lines and comments. Backslash escapes are left in the segments,
+// where path.Match reads them: "\#" and "\!" start a pattern with a literal
+// character and "\ " keeps a trailing space.
+func parsePattern(line, base string) (ignorePattern, bool) {
+	line = trimTrailingSpaces(strings.TrimSuffix(line, "\r"))
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// A slash anywhere but at the end anchors the pattern to its base;
+	// otherwise it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	p.segments = strings.Split(line, "/")
+	if !anchored {
+		p.segments = append([]string{"**"}, p.segments...)
+	}
+	return p, true
+}
+
+// trimTrailingSpaces drops the trailing spaces of a pattern line, stopping at
+// one escaped by an odd number of backslashes
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") {
+		backslashes := 0
+		for i := len(line) - 2; i >= 0 && line[i] == '\\'; i-- {
+			backslashes++
+		}
+		if backslashes%2 == 1 {
+			break
+		}
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// match reports whether the pattern matches a slash-separated path relative
+// to the filter root
+func (p ignorePattern) match(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		if !strings.HasPrefix(relPath, p.base+"/") {
+			return false
+		}
+		relPath = relPath[len(p.base)+1:]
+	}
+	return matchSegments(p.segments, strings.Split(relPath, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	if pattern[0] == "**" {
+		// A trailing "**" matches everything inside, but not the directory itself
+		if len(pattern) == 1 {
+			return len(parts) > 0
+		}
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
+
+// Matcher evaluates gitignore-style patterns. Later patterns take precedence
+// over earlier ones, and a path inside a matched directory is matched too.
+type Matcher struct {
+	patterns []ignorePattern
+}
+
+// NewMatcher compiles patterns relative to the root
+func NewMatcher(patterns []string) *Matcher {
+	m := &Matcher{}
+	m.AddPatterns("", patterns)
+	return m
+}
+
+// AddPatterns compiles patterns relative to base, a slash-separated directory
+// under the root ("" for the root itself)
+func (m *Matcher) AddPatterns(base string, patterns []string) {
+	for _, line := range patterns {
+		if p, ok := parsePattern(line, base); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+}
+
+// Empty reports whether the matcher has no patterns
+func (m *Matcher) Empty() bool {
+	return len(m.patterns) == 0
+}
+
+// Match reports whether relPath, a slash-separated path relative to the root,
+// is matched either directly or through one of its parent directories
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	if m.Empty() {
+		return false
+	}
+
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matchPath(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.matchPath(relPath, isDir)
+}
+
+func (m *Matcher) matchPath(relPath string, isDir bool) bool {
+	matched := false
+	for _, p := range m.patterns {
+		if p.match(relPath, isDir) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+// ReadIgnoreFile returns the lines of an ignore file
+func ReadIgnoreFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// Filter decides which paths under a source root are archived. Exclude
+// patterns and ignore files prune paths; when include patterns are given,
+// only files matching them are kept, while directories are always walked.
+type Filter struct {
+	exclude     *Matcher
+	include     *Matcher
+	ignored     *Matcher
+	root        string
+	ignoreFiles []string
+	loaded      map[string]bool
+}
+
+// NewFilter builds a filter from exclude and include pattern lists
+func NewFilter(excludePaths, includePaths []string) *Filter {
+	return &Filter{
+		exclude: NewMatcher(excludePaths),
+		include: NewMatcher(includePaths),
+		ignored: &Matcher{},
+		loaded:  map[string]bool{},
+	}
+}
+
+// LoadIgnoreFiles makes the filter honour the named ignore files (such as
+// .gitignore) found in root and its subdirectories. Each file applies to the
+// directory that contains it.
+func (f *Filter) LoadIgnoreFiles(root string, names ...string) {
+	f.root = root
+	f.ignoreFiles = names
+}
+
+// Include reports whether relPath, relative to the filter root, is archived
+func (f *Filter) Include(relPath string, isDir bool) bool {
+	relPath = strings.Trim(filepath.ToSlash(relPath), "/")
+	if relPath == "" || relPath == "." {
+		return true
+	}
+
+	f.loadIgnoreFilesFor(relPath)
+
+	if f.exclude.Match(relPath, isDir) || f.ignored.Match(relPath, isDir) {
+		return false
+	}
+	if isDir || f.include.Empty() {
+		return true
+	}
+	return f.include.Match(relPath, isDir)
+}
+
+// loadIgnoreFilesFor reads the ignore files of every directory above relPath
+// that has not been visited yet
+func (f *Filter) loadIgnoreFilesFor(relPath string) {
+	if len(f.ignoreFiles) == 0 {
+		return
+	}
+
+	dirs := []string{""}
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+
+	for _, dir := range dirs {
+		if f.loaded[dir] {
+			continue
+		}
+		f.loaded[dir] = true
+
+		for _, name := range f.ignoreFiles {
+			lines, err := ReadIgnoreFile(filepath.Join(f.root, filepath.FromSlash(dir), name))
+			if err != nil {
+				continue
+			}
+			f.ignored.AddPatterns(dir, lines)
+		}
+	}
+}
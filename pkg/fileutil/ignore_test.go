@@ -0,0 +1,142 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"basename glob", []string{"*.log"}, "a/b/debug.log", false, true},
+		{"basename glob no match", []string{"*.log"}, "a/b/debug.txt", false, false},
+		{"plain name matches whole segment", []string{"log"}, "pkg/catalog.go", false, false},
+		{"plain name matches directory at any depth", []string{"log"}, "var/log/app.txt", false, true},
+		{"plain name matches file", []string{"log"}, "log", false, true},
+		{"question mark", []string{"file?.txt"}, "file1.txt", false, true},
+		{"character class", []string{"file[0-9].txt"}, "filex.txt", false, false},
+		{"dir-only pattern matches directory", []string{"build/"}, "build", true, true},
+		{"dir-only pattern skips file", []string{"build/"}, "build", false, false},
+		{"dir-only pattern matches contents", []string{"build/"}, "build/out.bin", false, true},
+		{"dir-only pattern at depth", []string{"build/"}, "sub/build/out.bin", false, true},
+		{"anchored pattern at root", []string{"/TODO"}, "TODO", false, true},
+		{"anchored pattern not at depth", []string{"/TODO"}, "sub/TODO", false, false},
+		{"middle slash anchors", []string{"doc/frotz"}, "doc/frotz", false, true},
+		{"middle slash anchors not at depth", []string{"doc/frotz"}, "a/doc/frotz", false, false},
+		{"leading double star", []string{"**/foo"}, "a/b/foo", false, true},
+		{"leading double star at root", []string{"**/foo"}, "foo", false, true},
+		{"leading double star with dir", []string{"**/foo/bar"}, "x/foo/bar", false, true},
+		{"trailing double star", []string{"abc/**"}, "abc/x/y", false, true},
+		{"trailing double star not dir itself", []string{"abc/**"}, "abc", true, false},
+		{"middle double star zero dirs", []string{"a/**/b"}, "a/b", false, true},
+		{"middle double star many dirs", []string{"a/**/b"}, "a/x/y/b", false, true},
+		{"negation re-includes", []string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{"negation order matters", []string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{"negation cannot escape excluded dir", []string{"logs/", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{"comment ignored", []string{"# *.go"}, "main.go", false, false},
+		{"escaped hash", []string{"\\#notes"}, "#notes", false, true},
+		{"escaped hash negated", []string{"\\#*", "!\\#keep"}, "#keep", false, false},
+		{"escaped bang is literal", []string{"\\!important"}, "!important", false, true},
+		{"escaped bang does not negate", []string{"*.txt", "\\!a.txt"}, "a.txt", false, true},
+		{"escaped trailing space kept", []string{"name\\ "}, "name ", false, true},
+		{"escaped trailing space is required", []string{"name\\ "}, "name", false, false},
+		{"only last escaped space kept", []string{"name\\   "}, "name ", false, true},
+		{"escaped backslash before trailing space", []string{"name\\\\ "}, "name\\", false, true},
+		{"blank line ignored", []string{"", "   "}, "main.go", false, false},
+		{"trailing spaces trimmed", []string{"*.tmp   "}, "x.tmp", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewMatcher(tt.patterns).Match(tt.path, tt.isDir)
+			if result != tt.expected {
+				t.Errorf("Match(%v, %q, dir=%v) = %v; want %v", tt.patterns, tt.path, tt.isDir, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFilterInclude(t *testing.T) {
+	tests := []struct {
+		name         string
+		excludePaths []string
+		includePaths []string
+		path         string
+		isDir        bool
+		expected     bool
+	}{
+		{"root always included", []string{"*"}, nil, ".", true, true},
+		{"directories walked despite includes", nil, []string{"*.go"}, "pkg", true, true},
+		{"file matching include", nil, []string{"*.go"}, "pkg/main.go", false, true},
+		{"file not matching include", nil, []string{"*.go"}, "pkg/README.md", false, false},
+		{"include directory contents", nil, []string{"docs/"}, "docs/guide/intro.md", false, true},
+		{"excluded directory pruned", []string{"node_modules"}, nil, "node_modules", true, false},
+		{"exclude beats include", []string{"vendor/"}, []string{"*.go"}, "vendor/x.go", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewFilter(tt.excludePaths, tt.includePaths).Include(tt.path, tt.isDir)
+			if result != tt.expected {
+				t.Errorf("Include(%q, dir=%v) = %v; want %v", tt.path, tt.isDir, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFilterIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "sub", "deep"), 0755)
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.log\n/build/\n"), 0644)
+	os.WriteFile(filepath.Join(root, "sub", ".zipprineignore"), []byte("!important.log\n/local.txt\n"), 0644)
+
+	filter := NewFilter(nil, nil)
+	filter.LoadIgnoreFiles(root, GitIgnoreFile, ZipprineIgnoreFile)
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"app.log", false, false},
+		{"build", true, false},
+		{"sub/build", true, true},
+		{"sub/debug.log", false, false},
+		{"sub/important.log", false, true},
+		{"sub/local.txt", false, false},
+		{"local.txt", false, true},
+		{"sub/deep/local.txt", false, true},
+		{"sub/deep/trace.log", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if result := filter.Include(tt.path, tt.isDir); result != tt.expected {
+				t.Errorf("Include(%q, dir=%v) = %v; want %v", tt.path, tt.isDir, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".zipprineignore")
+	os.WriteFile(path, []byte("# comment\n*.tmp\r\nbuild/\n"), 0644)
+
+	lines, err := ReadIgnoreFile(path)
+	if err != nil {
+		t.Fatalf("ReadIgnoreFile failed: %v", err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("ReadIgnoreFile returned %d lines; want 3", len(lines))
+	}
+
+	if _, err := ReadIgnoreFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error for missing ignore file, got nil")
+	}
+}
@@ -0,0 +1,275 @@
+// Package pgzip writes gzip streams using several cores, in the style of pigz.
+//
+// Input is split into fixed-size blocks that are deflated independently, each
+// primed with the last 32 KiB of the block before it so the ratio stays close
+// to a single-threaded stream. Every block ends on a byte boundary with a sync
+// flush, so the blocks concatenate into one ordinary deflate stream that any
+// gzip reader, including gzip -d, can decompress.
+package pgzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBlockSize is the amount of input compressed by each worker
+	DefaultBlockSize = 1 << 20
+
+	// dictSize is the deflate window carried from one block into the next
+	dictSize = 32 << 10
+)
+
+// block is one chunk of input on its way through the workers
+type block struct {
+	data []byte
+	dict []byte
+	out  []byte
+	err  error
+	done chan struct{}
+}
+
+// Writer is an io.WriteCloser that compresses in parallel. Like gzip.Writer,
+// the Header fields are written with the first block and must be set before
+// the first call to Write.
+type Writer struct {
+	gzip.Header
+
+	w         io.Writer
+	level     int
+	blockSize int
+
+	buf     []byte
+	prev    []byte
+	crc     uint32
+	size    uint32
+	started bool
+	closed  bool
+
+	jobs    chan *block
+	pending chan *block
+	wg      sync.WaitGroup
+	written chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewWriterLevel returns a Writer compressing at level with the given number
+// of worker goroutines; threads <= 0 uses every available CPU
+func NewWriterLevel(w io.Writer, level, threads int) (*Writer, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	z := &Writer{
+		w:         w,
+		level:     level,
+		blockSize: DefaultBlockSize,
+		jobs:      make(chan *block, threads),
+		pending:   make(chan *block, 2*threads),
+		written:   make(chan struct{}),
+	}
+	z.OS = 255 // unknown, as written by gzip.Writer
+
+	for i := 0; i < threads; i++ {
+		z.wg.Add(1)
+		go z.compressBlocks()
+	}
+	go z.writeBlocks()
+
+	return z, nil
+}
+
+// SetBlockSize changes how much input each worker compresses at a time. It
+// must be called before the first Write.
+func (z *Writer) SetBlockSize(size int) {
+	if size < dictSize {
+		size = dictSize
+	}
+	z.blockSize = size
+}
+
+// Write buffers p and hands full blocks to the workers
+func (z *Writer) Write(p []byte) (int, error) {
+	if err := z.error(); err != nil {
+		return 0, err
+	}
+	if z.closed {
+		return 0, fmt.Errorf("gzip: write to closed writer")
+	}
+	if err := z.writeHeader(); err != nil {
+		return 0, err
+	}
+
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+
+	n := len(p)
+	for len(p) > 0 {
+		if z.buf == nil {
+			z.buf = make([]byte, 0, z.blockSize)
+		}
+		take := min(z.blockSize-len(z.buf), len(p))
+		z.buf = append(z.buf, p[:take]...)
+		p = p[take:]
+
+		if len(z.buf) == z.blockSize {
+			z.dispatch()
+		}
+	}
+	return n, z.error()
+}
+
+// Close compresses any buffered input, waits for the workers and writes the
+// gzip trailer. It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.error()
+	}
+	z.closed = true
+
+	if z.writeHeader() == nil && len(z.buf) > 0 {
+		z.dispatch()
+	}
+	close(z.jobs)
+	close(z.pending)
+	z.wg.Wait()
+	<-z.written
+
+	if err := z.error(); err != nil {
+		return err
+	}
+
+	// An empty final block ends the deflate stream, followed by the
+	// CRC-32 and length of the uncompressed data
+	trailer := []byte{0x03, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(trailer[2:], z.crc)
+	binary.LittleEndian.PutUint32(trailer[6:], z.size)
+	_, err := z.w.Write(trailer)
+	return err
+}
+
+// dispatch queues the current buffer for compression, remembering its tail
+// as the dictionary for the next block
+func (z *Writer) dispatch() {
+	b := &block{
+		data: z.buf,
+		dict: z.prev,
+		done: make(chan struct{}),
+	}
+
+	if len(z.buf) > dictSize {
+		z.prev = z.buf[len(z.buf)-dictSize:]
+	} else {
+		z.prev = z.buf
+	}
+	z.buf = nil
+
+	// pending is bounded, which keeps memory in check when the output is slow
+	z.pending <- b
+	z.jobs <- b
+}
+
+// compressBlocks is a worker deflating blocks as they arrive
+func (z *Writer) compressBlocks() {
+	defer z.wg.Done()
+
+	var out bytes.Buffer
+	for b := range z.jobs {
+		out.Reset()
+		fw, err := flate.NewWriterDict(&out, z.level, b.dict)
+		if err == nil {
+			if _, err = fw.Write(b.data); err == nil {
+				// A sync flush ends the block on a byte boundary without
+				// marking it final, so the next block can follow directly
+				err = fw.Flush()
+			}
+		}
+
+		b.out = append([]byte(nil), out.Bytes()...)
+		b.err = err
+		close(b.done)
+	}
+}
+
+// writeBlocks writes compressed blocks to the output in their input order
+func (z *Writer) writeBlocks() {
+	defer close(z.written)
+
+	for b := range z.pending {
+		<-b.done
+		if z.error() != nil {
+			continue
+		}
+		if b.err != nil {
+			z.setError(b.err)
+			continue
+		}
+		if _, err := z.w.Write(b.out); err != nil {
+			z.setError(err)
+		}
+	}
+}
+
+func (z *Writer) writeHeader() error {
+	if z.started {
+		return nil
+	}
+	z.started = true
+
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, z.OS}
+	if z.Name != "" {
+		header[3] |= 0x08
+	}
+	if z.Comment != "" {
+		header[3] |= 0x10
+	}
+	if z.ModTime.After(time.Unix(0, 0)) {
+		binary.LittleEndian.PutUint32(header[4:8], uint32(z.ModTime.Unix()))
+	}
+	switch z.level {
+	case gzip.BestCompression:
+		header[8] = 2
+	case gzip.BestSpeed:
+		header[8] = 4
+	}
+
+	if z.Name != "" {
+		header = append(append(header, z.Name...), 0)
+	}
+	if z.Comment != "" {
+		header = append(append(header, z.Comment...), 0)
+	}
+
+	_, err := z.w.Write(header)
+	if err != nil {
+		z.setError(err)
+	}
+	return err
+}
+
+func (z *Writer) error() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+func (z *Writer) setError(err error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+}
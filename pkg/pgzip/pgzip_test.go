@@ -0,0 +1,203 @@
+package pgzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testData returns compressible but non-trivial input
+func testData(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon\n", "zipprine "}
+
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		if rng.Intn(8) == 0 {
+			buf.WriteByte(byte(rng.Intn(256)))
+			continue
+		}
+		buf.WriteString(words[rng.Intn(len(words))])
+	}
+	return buf.Bytes()[:size]
+}
+
+func compress(t *testing.T, data []byte, level, threads, blockSize int) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	zw, err := NewWriterLevel(&out, level, threads)
+	if err != nil {
+		t.Fatalf("NewWriterLevel() error = %v", err)
+	}
+	zw.SetBlockSize(blockSize)
+	zw.Name = "data.txt"
+
+	// Write in uneven pieces so blocks straddle Write calls
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 12345)
+		if _, err := zw.Write(rest[:n]); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		level     int
+		threads   int
+		blockSize int
+	}{
+		{"empty", 0, gzip.DefaultCompression, 4, DefaultBlockSize},
+		{"smaller than a block", 1000, gzip.DefaultCompression, 4, DefaultBlockSize},
+		{"exact blocks", 4 * dictSize, gzip.BestSpeed, 3, dictSize},
+		{"many blocks", 3<<20 + 17, gzip.DefaultCompression, 4, 64 << 10},
+		{"single thread", 1 << 20, gzip.BestCompression, 1, 100 << 10},
+		{"no compression", 300 << 10, gzip.NoCompression, 2, 64 << 10},
+		{"huffman only", 300 << 10, gzip.HuffmanOnly, 2, 64 << 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testData(tt.size)
+			compressed := compress(t, data, tt.level, tt.threads, tt.blockSize)
+
+			zr, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("gzip.NewReader() error = %v", err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("decompress error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(data))
+			}
+			if zr.Name != "data.txt" {
+				t.Errorf("Name = %q, want data.txt", zr.Name)
+			}
+		})
+	}
+}
+
+func TestWriterRatioCloseToGzip(t *testing.T) {
+	data := testData(4 << 20)
+	parallel := compress(t, data, gzip.DefaultCompression, 4, DefaultBlockSize)
+
+	var serial bytes.Buffer
+	zw := gzip.NewWriter(&serial)
+	zw.Write(data)
+	zw.Close()
+
+	// Carrying the dictionary across blocks keeps the size within a few percent
+	if float64(len(parallel)) > float64(serial.Len())*1.05 {
+		t.Errorf("parallel output %d bytes, serial %d bytes", len(parallel), serial.Len())
+	}
+}
+
+func TestWriterReadableByGzipTool(t *testing.T) {
+	gzipPath, err := exec.LookPath("gzip")
+	if err != nil {
+		t.Skip("gzip not installed")
+	}
+
+	data := testData(2<<20 + 5)
+	path := filepath.Join(t.TempDir(), "data.txt.gz")
+	if err := os.WriteFile(path, compress(t, data, gzip.DefaultCompression, 4, 256<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(gzipPath, "-dc", path).Output()
+	if err != nil {
+		t.Fatalf("gzip -d failed: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("gzip -d produced %d bytes, want %d", len(out), len(data))
+	}
+}
+
+func TestWriterHeader(t *testing.T) {
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var out bytes.Buffer
+	zw, _ := NewWriterLevel(&out, gzip.BestCompression, 2)
+	zw.Name = "notes.txt"
+	zw.Comment = "hello"
+	zw.ModTime = modTime
+	zw.Write([]byte("contents"))
+	zw.Close()
+
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.Name != "notes.txt" || zr.Comment != "hello" || !zr.ModTime.Equal(modTime) {
+		t.Errorf("header = %+v", zr.Header)
+	}
+}
+
+func TestNewWriterLevelInvalid(t *testing.T) {
+	if _, err := NewWriterLevel(io.Discard, 10, 1); err == nil {
+		t.Error("expected an error for level 10")
+	}
+}
+
+type failingWriter struct{ after int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.after <= 0 {
+		return 0, io.ErrShortWrite
+	}
+	w.after--
+	return len(p), nil
+}
+
+func TestWriterPropagatesWriteErrors(t *testing.T) {
+	zw, _ := NewWriterLevel(&failingWriter{after: 2}, gzip.DefaultCompression, 2)
+	zw.SetBlockSize(dictSize)
+	zw.Write(testData(1 << 20))
+	if err := zw.Close(); err == nil {
+		t.Error("expected the underlying write error from Close")
+	}
+}
+
+func benchmarkData() []byte {
+	return testData(32 << 20)
+}
+
+func BenchmarkGzipWriter(b *testing.B) {
+	data := benchmarkData()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		zw := gzip.NewWriter(io.Discard)
+		zw.Write(data)
+		zw.Close()
+	}
+}
+
+func BenchmarkParallelWriter(b *testing.B) {
+	data := benchmarkData()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		zw, _ := NewWriterLevel(io.Discard, gzip.DefaultCompression, 0)
+		zw.Write(data)
+		zw.Close()
+	}
+}